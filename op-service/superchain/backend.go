@@ -0,0 +1,1260 @@
+// Package superchain implements a backend to verify the safety of cross-chain
+// messages that are initiated on the peer chains of the superchain.
+package superchain
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"math/big"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+
+	"github.com/ethereum-optimism/optimism/op-service/client"
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+	"github.com/ethereum-optimism/optimism/op-service/metrics"
+	"github.com/ethereum-optimism/optimism/op-service/sources"
+	"github.com/ethereum-optimism/optimism/op-service/sources/caching"
+)
+
+const (
+	metricsNamespace = "op_superchain"
+
+	unsafePollInterval = time.Second * 2
+	safePollInterval   = time.Second * 12
+
+	defaultFinalizedPollInterval = time.Second * 12 * 32
+	defaultPollTimeout           = time.Second * 10
+
+	defaultRPCTimeout    = time.Second * 10
+	defaultRPCRetryDelay = time.Millisecond * 100
+
+	defaultBatchConcurrency = 8
+	defaultMaxBatchSize     = 10
+)
+
+// MessageIdentifier uniquely identifies a log emitted on a peer chain of the superchain.
+type MessageIdentifier struct {
+	Origin      common.Address `json:"origin"`
+	BlockNumber *big.Int       `json:"blockNumber"`
+	LogIndex    uint64         `json:"logIndex"`
+	Timestamp   uint64         `json:"timestamp"`
+	ChainId     *big.Int       `json:"chainId"`
+
+	// BlockHash optionally pins the message to a block. When set, the message is Invalid
+	// if the block at the number has a different hash.
+	BlockHash *common.Hash `json:"blockHash,omitempty"`
+
+	// LogCount optionally extends the message to the contiguous span of logs from LogIndex, of which
+	// the payload is the concatenation of the payloads of the logs. Zero references the single log.
+	LogCount uint64 `json:"logCount,omitempty"`
+}
+
+// logCount returns the number of logs referenced by the message, at least one.
+func (id MessageIdentifier) logCount() uint64 {
+	if id.LogCount == 0 {
+		return 1
+	}
+	return id.LogCount
+}
+
+// MessageSafetyLabel describes the safety of an initiating message, in ascending order of safety.
+type MessageSafetyLabel string
+
+const (
+	Invalid MessageSafetyLabel = "invalid"
+	Unsafe  MessageSafetyLabel = "unsafe"
+	// Safe messages are included by the safe head of their chain, but not yet cross-safe.
+	Safe MessageSafetyLabel = "safe"
+	// CrossSafe messages are included by the safe head of their chain, and the safe heads of all the
+	// chains in the dependency set are at or beyond the timestamp of the message. The messages that the
+	// message may depend on can then only be included by safe blocks.
+	CrossSafe MessageSafetyLabel = "cross_safe"
+	Finalized MessageSafetyLabel = "finalized"
+)
+
+// messageSafetyLabels are the labels in ascending order of safety.
+var messageSafetyLabels = []MessageSafetyLabel{Invalid, Unsafe, Safe, CrossSafe, Finalized}
+
+// String returns the canonical name of the label.
+func (l MessageSafetyLabel) String() string {
+	return string(l)
+}
+
+// MarshalJSON encodes the label as its canonical name.
+func (l MessageSafetyLabel) MarshalJSON() ([]byte, error) {
+	return json.Marshal(string(l))
+}
+
+// UnmarshalJSON decodes the label from its canonical name, and rejects unknown names.
+func (l *MessageSafetyLabel) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+	if !slices.Contains(messageSafetyLabels, MessageSafetyLabel(name)) {
+		return fmt.Errorf("unknown message safety label %q", name)
+	}
+	*l = MessageSafetyLabel(name)
+	return nil
+}
+
+// MessagePayloadBytes returns the payload of the message emitted by the log:
+// the concatenation of all the log topics, followed by the log data.
+func MessagePayloadBytes(log *types.Log) []byte {
+	msg := []byte{}
+	for _, topic := range log.Topics {
+		msg = append(msg, topic.Bytes()...)
+	}
+	return append(msg, log.Data...)
+}
+
+// maxLogIndex is the maximum block-global index of a log. As every log consumes gas, the number of
+// logs of a block is far below this bound.
+const maxLogIndex = math.MaxUint32
+
+// originAllowlists returns the set of allowlisted origins of every peer chain with a non-empty allowlist.
+func originAllowlists(allowlists map[ChainID][]common.Address) map[ChainID]map[common.Address]bool {
+	sets := make(map[ChainID]map[common.Address]bool, len(allowlists))
+	for chainId, origins := range allowlists {
+		if len(origins) == 0 {
+			continue
+		}
+		sets[chainId] = make(map[common.Address]bool, len(origins))
+		for _, origin := range origins {
+			sets[chainId][origin] = true
+		}
+	}
+	return sets
+}
+
+// checkOriginAllowed checks the origin is allowlisted to emit messages on the peer chain.
+func (b *backend) checkOriginAllowed(chainId ChainID, origin common.Address) error {
+	if allowlist, ok := b.originAllowlists[chainId]; ok && !allowlist[origin] {
+		return fmt.Errorf("origin %s is not allowlisted on chain id %s", origin, chainId)
+	}
+	return nil
+}
+
+// originProxies returns the proxy of every implementation of every peer chain, inverting the configured
+// implementations of the proxies.
+func originProxies(implementations map[ChainID]map[common.Address]common.Address) map[ChainID]map[common.Address]common.Address {
+	proxies := make(map[ChainID]map[common.Address]common.Address, len(implementations))
+	for chainId, impls := range implementations {
+		proxies[chainId] = make(map[common.Address]common.Address, len(impls))
+		for proxy, impl := range impls {
+			proxies[chainId][impl] = proxy
+		}
+	}
+	return proxies
+}
+
+// withEmitter returns the message with the origin replaced by its proxy, if the origin is the implementation of
+// a proxy on the peer chain. As the implementation runs in the context of the proxy, the logs of the message
+// are emitted by the proxy, and the message is checked like a message of the proxy.
+func (b *backend) withEmitter(id MessageIdentifier) MessageIdentifier {
+	if len(b.originProxies) == 0 {
+		return id
+	}
+	chainId, ok := ChainIDFromBig(id.ChainId)
+	if !ok {
+		return id
+	}
+	if proxy, ok := b.originProxies[chainId][id.Origin]; ok {
+		id.Origin = proxy
+	}
+	return id
+}
+
+// maxTimestampDrift is how far the timestamp of a message may be ahead of the current time. Blocks are not
+// produced ahead of time, so a message further in the future references a block that cannot exist yet.
+const maxTimestampDrift = time.Hour
+
+// checkBlockNumber checks the message references a block, by a non-negative number, before any block is fetched.
+func checkBlockNumber(id MessageIdentifier) error {
+	if id.BlockNumber == nil {
+		return errors.New("invalid block number: missing")
+	}
+	if id.BlockNumber.Sign() < 0 {
+		return fmt.Errorf("invalid block number %d: negative", id.BlockNumber)
+	}
+	return nil
+}
+
+// checkTimestamp checks the timestamp of the message is plausible, before it is compared against any head or
+// block. A zero timestamp would otherwise be included by every finalized head.
+func checkTimestamp(id MessageIdentifier, now time.Time) error {
+	if id.Timestamp == 0 {
+		return errors.New("invalid timestamp: zero")
+	}
+	if latest := uint64(now.Add(maxTimestampDrift).Unix()); id.Timestamp > latest {
+		return fmt.Errorf("invalid timestamp: %d is more than %s ahead of the current time %d", id.Timestamp, maxTimestampDrift, now.Unix())
+	}
+	return nil
+}
+
+// checkPayloadSize checks the payload of the message does not exceed the maximum payload size, if any,
+// before the payload is compared against any log.
+func (b *backend) checkPayloadSize(payload hexutil.Bytes) error {
+	if b.maxPayloadSize > 0 && len(payload) > b.maxPayloadSize {
+		return fmt.Errorf("%w: %d bytes, at most %d", ErrPayloadTooLarge, len(payload), b.maxPayloadSize)
+	}
+	return nil
+}
+
+// checkLogIndex checks the log index of the message is within the realistic range of log indices,
+// before any log is fetched.
+func checkLogIndex(id MessageIdentifier) error {
+	if id.LogIndex > maxLogIndex {
+		return fmt.Errorf("invalid log index: %d exceeds the maximum log index %d", id.LogIndex, maxLogIndex)
+	}
+	if id.logCount()-1 > maxLogIndex-id.LogIndex {
+		return fmt.Errorf("invalid log count: span of %d logs from index %d exceeds the maximum log index %d", id.logCount(), id.LogIndex, maxLogIndex)
+	}
+	return nil
+}
+
+// maxLogTopics is the maximum number of topics of a log, emitted with LOG0 to LOG4.
+const maxLogTopics = 4
+
+// ValidateMessageLog checks the log is well-formed for a cross-chain message: a message is
+// identified by the event selector in the first topic, so the log must have at least one topic.
+func ValidateMessageLog(log *types.Log) error {
+	if len(log.Topics) == 0 {
+		return errors.New("malformed message log: no topics")
+	}
+	if len(log.Topics) > maxLogTopics {
+		return fmt.Errorf("malformed message log: %d topics exceed the maximum of %d", len(log.Topics), maxLogTopics)
+	}
+	return nil
+}
+
+type SuperchainBackend interface {
+	// MessageSafety checks the integrity of the message against the log emitted on the
+	// referenced peer chain, and returns the safety label of the message.
+	MessageSafety(ctx context.Context, id MessageIdentifier, payload hexutil.Bytes) (MessageSafetyLabel, error)
+
+	// MessageSafetyDetails checks the message like MessageSafety, and returns the outcome of the check,
+	// including the reason the message is Invalid.
+	MessageSafetyDetails(ctx context.Context, id MessageIdentifier, payload hexutil.Bytes) (MessageSafetyResult, error)
+
+	// MessageSafetyBatch returns the safety label of every message, in the order of the identifiers.
+	// The labels of all the messages are returned even if some messages failed: the errors of the
+	// failed messages, which are labeled Invalid, are joined into the error. A non-nil error thus does
+	// not invalidate the labels of the other messages.
+	MessageSafetyBatch(ctx context.Context, ids []MessageIdentifier, payloads []hexutil.Bytes) ([]MessageSafetyLabel, error)
+
+	// SubscribeFinalizedHead subscribes to the updates of the finalized head of the peer chain.
+	SubscribeFinalizedHead(chainId ChainID) (<-chan eth.L1BlockRef, ethereum.Subscription)
+
+	// MessageSafetyAt checks the message like MessageSafety, but labels it against a finalized head with
+	// the given timestamp, instead of the tracked heads, to evaluate the message at a past finality point.
+	MessageSafetyAt(ctx context.Context, id MessageIdentifier, payload hexutil.Bytes, finalizedTimestamp uint64) (MessageSafetyLabel, error)
+
+	// MessageIntegrity checks the integrity of the message like MessageSafety, without labeling it: the message
+	// is valid if it matches the log emitted on the peer chain, regardless of the finality of its block. It is
+	// meant for the consumers applying their own finality policy. The error of an invalid message is returned.
+	MessageIntegrity(ctx context.Context, id MessageIdentifier, payload hexutil.Bytes) (bool, error)
+
+	// MessageSafetyFromLog checks the message like MessageSafety against the given log, emitted in a block with
+	// the given timestamp, without fetching the log from the peer chain.
+	MessageSafetyFromLog(ctx context.Context, id MessageIdentifier, payload hexutil.Bytes, log *types.Log, blockTime uint64) (MessageSafetyLabel, error)
+
+	// WatchFinalized registers a one-shot callback, fired with the finalized head of the peer chain once it
+	// includes the message. The returned function cancels the watch.
+	WatchFinalized(id MessageIdentifier, fn func(head eth.L1BlockRef)) (func(), error)
+
+	// MessageSafetyVia checks the message like MessageSafety, but fetches the block and logs of the message from
+	// the endpoint instead of the configured peer of the chain, e.g. to compare the views of two nodes of the chain.
+	MessageSafetyVia(ctx context.Context, id MessageIdentifier, payload hexutil.Bytes, endpoint string) (MessageSafetyLabel, error)
+
+	// MessageSafetyByTx checks the message like MessageSafety, identified by the hash of the transaction that
+	// emitted it and the index of its log among the logs of the transaction, as located by the receipt.
+	MessageSafetyByTx(ctx context.Context, id TxMessageIdentifier, payload hexutil.Bytes) (MessageSafetyLabel, error)
+
+	// MessageSafetyExplain checks the message like MessageSafety, and returns every check performed,
+	// with the values observed on the peer chain and the values expected by the message.
+	MessageSafetyExplain(ctx context.Context, id MessageIdentifier, payload hexutil.Bytes) (*ValidationTrace, error)
+
+	// Prefetch fetches the blocks of the peer chain in the inclusive range, with their logs, and caches the
+	// finalized blocks, so that checking the messages of the blocks does not reach the peer.
+	Prefetch(ctx context.Context, chainId ChainID, fromBlock, toBlock *big.Int) error
+
+	// FinalizingHead returns the earliest block of the peer chain that, as finalized head, includes a message
+	// with the timestamp. The message must be finalized by the tracked finalized head.
+	FinalizingHead(ctx context.Context, chainId ChainID, timestamp uint64) (eth.L1BlockRef, error)
+
+	// DependencySet returns the sorted chain ids of the peer chains the backend validates messages of.
+	DependencySet() []ChainID
+
+	// RefreshFinalizedHead fetches the finalized head of the peer chain immediately, and updates the tracked finalized
+	// head like a poll of the head, e.g. when the caller knows the peer just finalized a block.
+	RefreshFinalizedHead(ctx context.Context, chainId ChainID) error
+
+	// TrackedHeads returns a snapshot of the unsafe, safe and finalized heads currently tracked for every peer chain.
+	TrackedHeads() map[ChainID]HeadSnapshot
+
+	// HealthCheck checks the L2 node and every peer are reachable, and that every peer serves
+	// the chain it is configured for.
+	HealthCheck(ctx context.Context) error
+
+	// MetricsRegistry returns the registry the backend records its metrics to when created without metrics factory,
+	// with the process and Go metrics, to serve the metrics of a standalone backend. It is nil if the backend records
+	// its metrics with the factory of the caller, who serves them.
+	MetricsRegistry() *prometheus.Registry
+
+	// MetricsHandler serves the metrics of MetricsRegistry in the Prometheus exposition format, or responds with
+	// 404 Not Found if the registry is nil.
+	MetricsHandler() http.Handler
+
+	// SetConservativeMode enables or disables the conservative mode, in which every message that would be
+	// labeled Safe, CrossSafe or Finalized is labeled Unsafe instead.
+	SetConservativeMode(enabled bool)
+
+	// Close stops tracking the heads and closes all the RPC connections. It is safe to call Close more than once.
+	Close() error
+}
+
+var (
+	ErrBackendClosed = errors.New("superchain backend is closed")
+
+	// ErrRPCTimeout is returned when a peer did not respond in time. Unlike validation
+	// failures, the check of the message can be retried.
+	ErrRPCTimeout = errors.New("peer rpc request timed out")
+
+	// ErrEmptyPayload is returned for a message without payload. As the payload of a message log
+	// starts with at least one topic, the message can never be valid.
+	ErrEmptyPayload = errors.New("empty message payload")
+
+	// ErrPayloadTooLarge is returned for a message with a payload larger than the configured maximum.
+	ErrPayloadTooLarge = errors.New("message payload too large")
+)
+
+type backend struct {
+	log     log.Logger
+	metrics *Metrics
+	// registry of the metrics, nil if recorded with the factory of the caller
+	registry *prometheus.Registry
+
+	// tracked heads of every peer chain, with the sources the heads are polled from
+	mu        sync.Mutex
+	l2Heads   map[ChainID]*chainHeads
+	l2Sources map[ChainID]eth.L1BlockRefsSource
+
+	// peer chains permitted by the dependency set registry, nil if not queried from a registry
+	dependencySet     map[ChainID]bool
+	dependencySetAddr common.Address
+
+	l2HeadSubs []ethereum.Subscription
+
+	// current time, to check the timestamps of messages
+	now func() time.Time
+
+	// tracer of the message checks, spans are not recorded if nil
+	tracer trace.Tracer
+
+	// persists the finalized heads across restarts, if non-nil
+	headStore HeadStore
+
+	finalizedPollInterval time.Duration
+	// labels polled as the finalized heads of devnet peer chains, instead of eth.Finalized
+	devnetFinalityLabels map[ChainID]eth.BlockLabel
+	// finalized heads that reorg the tracked finalized head are dropped, instead of only logged
+	rejectFinalizedReorgs bool
+	// fraction of the finalized poll interval by which the polls are randomly offset
+	finalizedPollJitter float64
+	pollTimeout         time.Duration
+	// interval of the batch requests polling all the heads of a peer, the heads are polled separately if zero
+	batchedHeadPollInterval time.Duration
+
+	finalizedHeadSubs headSubscribers
+	finalizedWatches  finalizedWatches
+
+	l2Node      client.RPC
+	l2PeerNodes map[ChainID]*peer
+	// pooled connections to the endpoints of MessageSafetyVia
+	via        viaConns
+	rpcTimeout time.Duration
+	// timeouts of the requests to the peers overriding the rpc and poll timeouts
+	peerTimeouts map[ChainID]time.Duration
+	// bounds the in-flight requests to all the peers, unbounded if nil
+	rpcSlots *semaphore.Weighted
+
+	// failed peer requests are retried, with exponential backoff from the retry delay
+	rpcRetries    int
+	rpcRetryDelay time.Duration
+
+	// maximum number of peer chains fetched concurrently by a batch
+	batchConcurrency int
+	// maximum number of requests in a batch request to a peer
+	maxBatchSize int
+	// maximum size of message payloads, unlimited if zero
+	maxPayloadSize int
+	// number of blocks ahead of the latest head of a peer chain of which messages are pending rather than Invalid
+	futureBlockTolerance uint64
+
+	// whether the block hash of the log of every message is checked against the hash of the header
+	verifyLogBlockHashes bool
+
+	// first topic of the message logs fetched from the peers, any topic if zero
+	messageTopic common.Hash
+
+	// origins permitted to emit messages on every peer chain, any origin is permitted on chains without allowlist
+	originAllowlists map[ChainID]map[common.Address]bool
+	// proxy of every implementation of every peer chain with proxies
+	originProxies map[ChainID]map[common.Address]common.Address
+	// block time of the peer chains of which the header timestamps are checked
+	blockTimes map[ChainID]BlockTimeConfig
+
+	// messages are labeled against the heads by block number instead of timestamp
+	finalityByBlockNumber bool
+	// labels valid messages from the facts of their blocks
+	safetyPolicy SafetyPolicy
+	// labels are capped at unsafe, see SetConservativeMode
+	conservativeMode atomic.Bool
+
+	// messages older than the latest head minus the window are expired, if non-zero
+	expiryWindow time.Duration
+
+	// terminal results of checked messages
+	messageCache *caching.LRUCache[messageKey, messageResult]
+	// headers of finalized blocks of the peer chains
+	headerCache *caching.LRUCache[headerKey, *types.Header]
+	// prefetched finalized blocks of the peers
+	blockCache     *caching.LRUCache[headerKey, blockData]
+	blockCacheSize int
+
+	closeOnce sync.Once
+	closed    atomic.Bool
+}
+
+// NewSuperchainBackend dials the L2 node and all the configured peers, and starts
+// tracking the unsafe, safe and finalized heads of every peer chain.
+func NewSuperchainBackend(ctx context.Context, log log.Logger, m metrics.Factory, cfg *SuperchainConfig) (SuperchainBackend, error) {
+	if err := cfg.Check(); err != nil {
+		return nil, fmt.Errorf("invalid superchain config: %w", err)
+	}
+
+	l2Node, err := client.NewRPC(ctx, log, cfg.L2NodeAddr, client.WithDialBackoff(10))
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial l2 node: %w", err)
+	}
+
+	rpcTimeout := cfg.RPCTimeout
+	if rpcTimeout == 0 {
+		rpcTimeout = defaultRPCTimeout
+	}
+	if cfg.ValidateSelfChain {
+		selfChainId, err := fetchChainID(ctx, l2Node, rpcTimeout)
+		if err != nil {
+			l2Node.Close()
+			return nil, fmt.Errorf("failed to fetch chain id of l2 node: %w", err)
+		}
+		if _, ok := cfg.PeerL2NodeAddrs[selfChainId]; ok {
+			l2Node.Close()
+			return nil, fmt.Errorf("chain id %s of the l2 node is also configured as a peer", selfChainId)
+		}
+		log.Info("validating messages of the chain of the l2 node", "chain_id", selfChainId)
+		// The chain of the L2 node is tracked like any peer chain, without changing the config of the caller
+		selfCfg := *cfg
+		selfCfg.PeerL2NodeAddrs = maps.Clone(cfg.PeerL2NodeAddrs)
+		selfCfg.PeerL2NodeAddrs[selfChainId] = cfg.L2NodeAddr
+		cfg = &selfCfg
+	}
+
+	// Without factory, the backend records its metrics to its own registry, to be served by a standalone backend
+	var registry *prometheus.Registry
+	if m == nil {
+		registry = metrics.NewRegistry()
+		m = metrics.With(registry)
+	}
+	sourceCacheMetrics := metrics.NewCacheMetrics(m, metricsNamespace, "l2_source_cache", "L2 Source cache")
+
+	closeAll := func(peers map[ChainID]*peer) {
+		l2Node.Close()
+		for _, peerNode := range peers {
+			peerNode.Close()
+		}
+	}
+	l2PeerNodes := make(map[ChainID]*peer, len(cfg.PeerL2NodeAddrs))
+	l2Sources := make(map[ChainID]*sources.L1Client, len(cfg.PeerL2NodeAddrs))
+	maxBatchSize := cfg.MaxBatchSize
+	if maxBatchSize == 0 {
+		maxBatchSize = defaultMaxBatchSize
+	}
+	sourceCaches := cfg.SourceCaches.withDefaults()
+	conns := newSharedConns(log)
+	// Peers with the same TLS config share the loaded config, and so their connections
+	tlsConfigs := make(map[PeerTLSConfig]*tls.Config)
+	for chainId := range cfg.PeerL2NodeAddrs {
+		headers := cfg.PeerHTTPHeaders[chainId]
+		addrs := cfg.peerAddrs(chainId)
+		log.Info("dialing peer", "chain_id", chainId, "endpoints", len(addrs), "headers", redactHeaders(headers))
+		tlsConfig, ok := tlsConfigs[cfg.PeerTLS[chainId]]
+		if !ok {
+			if tlsConfig, err = cfg.PeerTLS[chainId].clientConfig(); err != nil {
+				closeAll(l2PeerNodes)
+				return nil, fmt.Errorf("invalid tls config of peer with chain id %s: %w", chainId, err)
+			}
+			tlsConfigs[cfg.PeerTLS[chainId]] = tlsConfig
+		}
+		poolSize := max(cfg.PeerConnPoolSizes[chainId], 1)
+		dial := pooledDialer(newPeerDialer(log, headers, tlsConfig), poolSize)
+		peerNode, err := dialPeer(ctx, chainId, addrs, conns.dialer(headers, tlsConfig, poolSize, dial))
+		if err != nil {
+			closeAll(l2PeerNodes)
+			return nil, fmt.Errorf("failed to dial peer with chain id %s: %w", chainId, err)
+		}
+		// The chain ids of peers sharing a connection are still verified per peer, and broken connections are
+		// re-dialed by every peer for itself
+		peerNode.dial = dial
+		peerNode.blockReceipts = slices.Contains(cfg.BlockReceiptsRPCKinds, cfg.peerRPCKind(chainId))
+		peerNode.logFetcher = cfg.PeerLogFetchers[chainId]
+		l2PeerNodes[chainId] = peerNode
+		if !cfg.SkipChainIDVerification {
+			timeout := rpcTimeout
+			if peerTimeout, ok := cfg.PeerTimeouts[chainId]; ok {
+				timeout = peerTimeout
+			}
+			if err := checkChainID(ctx, peerNode, chainId, timeout); err != nil {
+				closeAll(l2PeerNodes)
+				return nil, fmt.Errorf("failed to verify peer with chain id %s: %w", chainId, err)
+			}
+		}
+
+		// The source is backed by the peer, to keep polling the heads after the peer is re-dialed
+		l2Source, err := sources.NewL1Client(l2PeerNodes[chainId], log, sourceCacheMetrics, &sources.L1ClientConfig{
+			EthClientConfig: sources.EthClientConfig{
+				ReceiptsCacheSize:     sourceCaches.Receipts,
+				TransactionsCacheSize: sourceCaches.Transactions,
+				HeadersCacheSize:      sourceCaches.Headers,
+				PayloadsCacheSize:     sourceCaches.Payloads,
+				MaxRequestsPerBatch:   maxBatchSize,
+				MaxConcurrentRequests: 10,
+				TrustRPC:              false,
+				MustBePostMerge:       false,
+				RPCProviderKind:       cfg.peerRPCKind(chainId),
+				MethodResetDuration:   time.Minute,
+			},
+			L1BlockRefsCacheSize: sourceCaches.BlockRefs,
+		})
+		if err != nil {
+			closeAll(l2PeerNodes)
+			return nil, fmt.Errorf("failed to create l2 source of peer with chain id %s: %w", chainId, err)
+		}
+		l2Sources[chainId] = l2Source
+	}
+
+	messageCacheSize := cfg.MessageCacheSize
+	if messageCacheSize == 0 {
+		messageCacheSize = defaultMessageCacheSize
+	}
+	rpcRetryDelay := cfg.RPCRetryDelay
+	if rpcRetryDelay == 0 {
+		rpcRetryDelay = defaultRPCRetryDelay
+	}
+	batchConcurrency := cfg.BatchConcurrency
+	if batchConcurrency == 0 {
+		batchConcurrency = defaultBatchConcurrency
+	}
+	finalizedPollInterval := cfg.FinalizedPollInterval
+	if finalizedPollInterval == 0 {
+		finalizedPollInterval = defaultFinalizedPollInterval
+	}
+	pollTimeout := cfg.PollTimeout
+	if pollTimeout == 0 {
+		pollTimeout = defaultPollTimeout
+	}
+	maxFinalizedWatches := cfg.MaxFinalizedWatches
+	if maxFinalizedWatches == 0 {
+		maxFinalizedWatches = defaultMaxFinalizedWatches
+	}
+	headerCacheSize := cfg.HeaderCacheSize
+	if headerCacheSize == 0 {
+		headerCacheSize = defaultHeaderCacheSize
+	}
+	blockCacheSize := cfg.BlockCacheSize
+	if blockCacheSize == 0 {
+		blockCacheSize = defaultBlockCacheSize
+	}
+	safetyPolicy := cfg.SafetyPolicy
+	if safetyPolicy == nil {
+		safetyPolicy = DefaultSafetyPolicy
+	}
+	messageCacheMetrics := metrics.NewCacheMetrics(m, metricsNamespace, "message_cache", "Message safety cache")
+	headerCacheMetrics := metrics.NewCacheMetrics(m, metricsNamespace, "header_cache", "Finalized header cache")
+	blockCacheMetrics := metrics.NewCacheMetrics(m, metricsNamespace, "block_cache", "Prefetched block cache")
+	b := &backend{
+		log:       log,
+		metrics:   NewMetrics(m),
+		registry:  registry,
+		l2Heads:   make(map[ChainID]*chainHeads, len(l2PeerNodes)),
+		l2Sources: make(map[ChainID]eth.L1BlockRefsSource, len(l2PeerNodes)),
+
+		finalizedPollInterval: finalizedPollInterval,
+		finalizedPollJitter:   cfg.FinalizedPollJitter,
+		rejectFinalizedReorgs: cfg.RejectFinalizedReorgs,
+		devnetFinalityLabels:  cfg.DevnetFinalityLabels,
+		pollTimeout:           pollTimeout,
+
+		batchedHeadPollInterval: cfg.BatchedHeadPollInterval,
+		finalizedWatches:        finalizedWatches{max: maxFinalizedWatches},
+
+		l2Node:        l2Node,
+		l2PeerNodes:   l2PeerNodes,
+		rpcTimeout:    rpcTimeout,
+		peerTimeouts:  maps.Clone(cfg.PeerTimeouts),
+		rpcRetries:    cfg.RPCRetries,
+		rpcRetryDelay: rpcRetryDelay,
+		expiryWindow:  cfg.ExpiryWindow,
+
+		finalityByBlockNumber: cfg.FinalityByBlockNumber,
+
+		originAllowlists: originAllowlists(cfg.PeerOriginAllowlists),
+		originProxies:    originProxies(cfg.PeerOriginProxies),
+		safetyPolicy:     safetyPolicy,
+		blockTimes:       maps.Clone(cfg.PeerBlockTimes),
+
+		batchConcurrency:     batchConcurrency,
+		maxBatchSize:         maxBatchSize,
+		maxPayloadSize:       cfg.MaxPayloadSize,
+		futureBlockTolerance: cfg.FutureBlockTolerance,
+		verifyLogBlockHashes: cfg.VerifyLogBlockHashes,
+		messageTopic:         cfg.MessageTopic,
+		messageCache:         caching.NewLRUCache[messageKey, messageResult](messageCacheMetrics, "messages", messageCacheSize),
+		headerCache:          caching.NewLRUCache[headerKey, *types.Header](headerCacheMetrics, "headers", headerCacheSize),
+		blockCache:           caching.NewLRUCache[headerKey, blockData](blockCacheMetrics, "blocks", blockCacheSize),
+		blockCacheSize:       blockCacheSize,
+
+		dependencySetAddr: cfg.DependencySetAddr,
+		tracer:            cfg.Tracer,
+		headStore:         cfg.HeadStore,
+		now:               time.Now,
+	}
+	if cfg.ConservativeMode {
+		b.SetConservativeMode(true)
+	}
+	if cfg.MaxConcurrentRPCs > 0 {
+		b.rpcSlots = semaphore.NewWeighted(int64(cfg.MaxConcurrentRPCs))
+	}
+	for chainId, label := range cfg.DevnetFinalityLabels {
+		log.Warn("finality of peer chain overridden for devnet use, messages are labeled finalized before they are",
+			"chain_id", chainId, "label", label)
+	}
+	if cfg.PeerBreakerThreshold > 0 {
+		cooldown := cfg.PeerBreakerCooldown
+		if cooldown == 0 {
+			cooldown = defaultBreakerCooldown
+		}
+		for _, peer := range l2PeerNodes {
+			peer.breaker = b.newPeerBreaker(peer.chainId, cfg.PeerBreakerThreshold, cooldown)
+		}
+	}
+	if cfg.DependencySetAddr != (common.Address{}) {
+		if err := b.refreshDependencySet(ctx); err != nil {
+			closeAll(l2PeerNodes)
+			return nil, fmt.Errorf("failed to fetch dependency set: %w", err)
+		}
+		interval := cfg.DependencySetRefreshInterval
+		if interval == 0 {
+			interval = defaultDependencySetRefreshInterval
+		}
+		b.l2HeadSubs = append(b.l2HeadSubs, b.pollDependencySet(interval))
+	}
+	restored := b.restoreFinalizedHeads()
+	for chainId, l2Source := range l2Sources {
+		var finalized *eth.L1BlockRef
+		if head, ok := restored[chainId]; ok {
+			log.Info("restored finalized head of peer", "chain_id", chainId, "head", head)
+			finalized = &head
+		}
+		b.trackHeads(chainId, l2Source, finalized)
+		if cfg.UseSubscriptions {
+			b.l2HeadSubs = append(b.l2HeadSubs, b.watchFinalizedHead(l2PeerNodes[chainId], l2Source))
+		}
+	}
+	return b, nil
+}
+
+func (b *backend) MessageSafety(ctx context.Context, id MessageIdentifier, payload hexutil.Bytes) (MessageSafetyLabel, error) {
+	res, err := b.MessageSafetyDetails(ctx, id, payload)
+	return res.Label, err
+}
+
+func (b *backend) MessageSafetyDetails(ctx context.Context, id MessageIdentifier, payload hexutil.Bytes) (MessageSafetyResult, error) {
+	return b.labeledMessageSafety(ctx, id, payload, checkOptions{})
+}
+
+// MessageSafetyAt checks the message like MessageSafety, but labels it against a finalized head with the
+// given timestamp instead of the tracked heads: the message is Finalized if it is not newer than the
+// timestamp, and Unsafe otherwise. All other checks are performed against the peer chain as is.
+func (b *backend) MessageSafetyAt(ctx context.Context, id MessageIdentifier, payload hexutil.Bytes, finalizedTimestamp uint64) (MessageSafetyLabel, error) {
+	res, err := b.labeledMessageSafety(ctx, id, payload, checkOptions{finalizedAt: &finalizedTimestamp})
+	return res.Label, err
+}
+
+// labeledMessageSafety checks the message with the options, and caps and records the label of the message, so that
+// the live and the historical checks of a message are recorded alike.
+func (b *backend) labeledMessageSafety(ctx context.Context, id MessageIdentifier, payload hexutil.Bytes, opts checkOptions) (MessageSafetyResult, error) {
+	res, err := b.messageSafety(ctx, id, payload, opts)
+	res.Label = b.capLabel(id, res.Label)
+	b.metrics.RecordMessageSafety(b.chainIdLabel(id), res.Label)
+	return res, err
+}
+
+// MessageIntegrity checks the message like MessageSafety, without comparing its block against the tracked heads.
+func (b *backend) MessageIntegrity(ctx context.Context, id MessageIdentifier, payload hexutil.Bytes) (bool, error) {
+	_, err := b.messageSafety(ctx, id, payload, checkOptions{integrityOnly: true})
+	return err == nil, err
+}
+
+// MessageSafetyFromLog checks the message like MessageSafety against the given log of the peer chain, emitted in
+// a block with the given timestamp, instead of fetching the log from the peer. The log is trusted to be emitted
+// on the peer chain, e.g. as received from a log subscription of the peer: only the integrity of the message
+// against the log is checked, and the message is labeled against the tracked heads. As the log is not verified
+// against the peer chain, the result is never cached.
+func (b *backend) MessageSafetyFromLog(ctx context.Context, id MessageIdentifier, payload hexutil.Bytes, log *types.Log, blockTime uint64) (MessageSafetyLabel, error) {
+	res, err := b.messageSafetyFromLog(id, payload, log, blockTime)
+	res.Label = b.capLabel(id, res.Label)
+	b.metrics.RecordMessageSafety(b.chainIdLabel(id), res.Label)
+	return res.Label, err
+}
+
+func (b *backend) messageSafetyFromLog(id MessageIdentifier, payload hexutil.Bytes, log *types.Log, blockTime uint64) (MessageSafetyResult, error) {
+	b.log.Info("checking message safety against log", "chain_id", id.ChainId, "block_number", id.BlockNumber, "log_index", id.LogIndex)
+	id = b.withEmitter(id)
+	chainId, _, res, err := b.checkPreconditions(id, payload, nil)
+	if err != nil {
+		return res, err
+	}
+	var logs []types.Log
+	if log != nil {
+		logs = []types.Log{*log}
+		if id.BlockHash != nil && *id.BlockHash != log.BlockHash {
+			res := invalidResult(ReasonBlockHashMismatch)
+			res.BlockHash = log.BlockHash
+			err := categorize(ErrIntegrityMismatch, fmt.Errorf("block hash mismatch: expected %s, got %s for block %d", id.BlockHash, log.BlockHash, id.BlockNumber))
+			b.logInvalidMessage(id, payload, ReasonBlockHashMismatch, err, "expected_block_hash", id.BlockHash, "actual_block_hash", log.BlockHash)
+			return res, err
+		}
+	}
+	if reason, err := checkIntegrity(id, payload, logs, blockTime, nil); err != nil {
+		b.logInvalidMessage(id, payload, reason, err)
+		res := invalidResult(reason)
+		if log != nil {
+			res.BlockHash = log.BlockHash
+		}
+		return res, err
+	}
+	label, finalized := b.safetyLabel(chainId, log.BlockNumber, id.Timestamp)
+	return MessageSafetyResult{Label: label, BlockHash: log.BlockHash, FinalizedTimestamp: finalizedTime(finalized), Finalized: newFinalizedHead(finalized)}, nil
+}
+
+// checkOptions alter how a message is checked.
+type checkOptions struct {
+	// trace records every check performed, if non-nil
+	trace *ValidationTrace
+	// finalizedAt replaces the tracked heads of the peer chain with a finalized head of the timestamp, if non-nil
+	finalizedAt *uint64
+	// via is the endpoint the block of the message is fetched from instead of the peer, if non-empty
+	via string
+	// integrityOnly skips labeling the message against the heads of the peer chain: valid messages are Unsafe
+	integrityOnly bool
+}
+
+// readCache returns whether the message may be served from the cache of terminal results.
+// The cache is bypassed when tracing, so every check is performed, and when fetching from another endpoint.
+func (o checkOptions) readCache() bool {
+	return o.trace == nil && o.finalizedAt == nil && o.via == ""
+}
+
+// writeCache returns whether the result of the message may be cached, which is only the case if the
+// message is labeled against the tracked heads, with the block fetched from the peer.
+func (o checkOptions) writeCache() bool {
+	return o.finalizedAt == nil && o.via == ""
+}
+
+// checkPreconditions checks the message before any block of the peer chain is fetched: the payload, the block
+// number and the log index, the peer of the chain, and the permission of the chain and the origin to send the message. The chain
+// id and the peer of the message are returned, unless the message failed a check.
+func (b *backend) checkPreconditions(id MessageIdentifier, payload hexutil.Bytes, trace *ValidationTrace) (ChainID, *peer, MessageSafetyResult, error) {
+	if b.closed.Load() {
+		return ChainID{}, nil, invalidResult(ReasonNone), ErrBackendClosed
+	}
+	if res, err := b.checkPayloadPreconditions(id, payload, trace); err != nil {
+		return ChainID{}, nil, res, err
+	}
+	if err := checkBlockNumber(id); err != nil {
+		trace.record(CheckBlockNumber, false, "non-negative block number", id.BlockNumber)
+		b.logInvalidMessage(id, payload, ReasonInvalidBlockNumber, err)
+		return ChainID{}, nil, invalidResult(ReasonInvalidBlockNumber), err
+	}
+	if err := checkLogIndex(id); err != nil {
+		trace.record(CheckLogIndex, false, fmt.Sprintf("at most %d", maxLogIndex), id.LogIndex)
+		b.logInvalidMessage(id, payload, ReasonLogIndexOutOfRange, err)
+		return ChainID{}, nil, invalidResult(ReasonLogIndexOutOfRange), err
+	}
+	return b.checkChainPreconditions(id, payload, trace)
+}
+
+// checkPayloadPreconditions checks the payload of the message is neither empty nor too large.
+func (b *backend) checkPayloadPreconditions(id MessageIdentifier, payload hexutil.Bytes, trace *ValidationTrace) (MessageSafetyResult, error) {
+	if len(payload) == 0 {
+		trace.record(CheckPayload, false, "non-empty payload", "empty payload")
+		b.logInvalidMessage(id, payload, ReasonEmptyPayload, ErrEmptyPayload)
+		return invalidResult(ReasonEmptyPayload), ErrEmptyPayload
+	}
+	if err := b.checkPayloadSize(payload); err != nil {
+		trace.record(CheckPayload, false, fmt.Sprintf("at most %d bytes", b.maxPayloadSize), len(payload))
+		b.logInvalidMessage(id, payload, ReasonPayloadTooLarge, err)
+		return invalidResult(ReasonPayloadTooLarge), err
+	}
+	return MessageSafetyResult{}, nil
+}
+
+// checkChainPreconditions checks the preconditions of the message that do not depend on its block: the timestamp,
+// the peer of the chain, and the permission of the chain and the origin to send the message.
+func (b *backend) checkChainPreconditions(id MessageIdentifier, payload hexutil.Bytes, trace *ValidationTrace) (ChainID, *peer, MessageSafetyResult, error) {
+	if err := checkTimestamp(id, b.now()); err != nil {
+		trace.record(CheckTimestamp, false, "plausible timestamp", id.Timestamp)
+		b.logInvalidMessage(id, payload, ReasonInvalidTimestamp, err)
+		return ChainID{}, nil, invalidResult(ReasonInvalidTimestamp), err
+	}
+
+	chainId, ok := ChainIDFromBig(id.ChainId)
+	if !ok {
+		trace.record(CheckChainLookup, false, "configured peer", id.ChainId)
+		err := fmt.Errorf("invalid chain id %v", id.ChainId)
+		b.logInvalidMessage(id, payload, ReasonInvalidChainId, err)
+		return ChainID{}, nil, invalidResult(ReasonInvalidChainId), err
+	}
+	peer, ok := b.l2PeerNodes[chainId]
+	trace.record(CheckChainLookup, ok, "configured peer", chainId)
+	if !ok {
+		err := &PeerNotConfiguredError{ChainID: chainId}
+		b.logInvalidMessage(id, payload, ReasonPeerNotConfigured, err)
+		return ChainID{}, nil, invalidResult(ReasonPeerNotConfigured), err
+	}
+	// Checked before the cache, as a cached finalized message can expire, and the dependency set can change
+	err := b.checkDependencySet(chainId)
+	trace.record(CheckDependencySet, err == nil, "permitted chain", chainId)
+	if err != nil {
+		b.logInvalidMessage(id, payload, ReasonNotInDependencySet, err)
+		return ChainID{}, nil, invalidResult(ReasonNotInDependencySet), err
+	}
+	err = b.checkOriginAllowed(chainId, id.Origin)
+	trace.record(CheckOriginAllowed, err == nil, "allowlisted origin", id.Origin)
+	if err != nil {
+		b.logInvalidMessage(id, payload, ReasonOriginNotAllowed, err)
+		return ChainID{}, nil, invalidResult(ReasonOriginNotAllowed), err
+	}
+	err = b.checkExpiry(chainId, id.Timestamp)
+	trace.record(CheckExpiry, err == nil, "within expiry window", id.Timestamp)
+	if err != nil {
+		b.logInvalidMessage(id, payload, ReasonExpired, err)
+		return ChainID{}, nil, invalidResult(ReasonExpired), err
+	}
+	return chainId, peer, MessageSafetyResult{}, nil
+}
+
+// messageSafety checks the message with the options.
+func (b *backend) messageSafety(ctx context.Context, id MessageIdentifier, payload hexutil.Bytes, opts checkOptions) (res MessageSafetyResult, err error) {
+	ctx, span := b.startSpan(ctx, spanMessageSafety, func() []attribute.KeyValue { return b.messageAttributes(id, opts) })
+	defer func() { span.endMessage(res, err) }()
+	trace := opts.trace
+	b.log.Info("checking message safety", "chain_id", id.ChainId, "block_number", id.BlockNumber, "log_index", id.LogIndex)
+	id = b.withEmitter(id)
+	chainId, peer, res, err := b.checkPreconditions(id, payload, trace)
+	if err != nil {
+		return res, err
+	}
+	if opts.readCache() {
+		if res, ok := b.cachedResult(chainId, id, payload); ok {
+			return res.result, res.err
+		}
+	}
+
+	queries := []blockQuery{{number: id.BlockNumber, origins: []common.Address{id.Origin}}}
+	var blocks []blockData
+	if opts.via != "" {
+		blocks, err = b.fetchBlocksVia(ctx, chainId, opts.via, queries)
+	} else {
+		blocks, err = b.fetchBlocks(ctx, peer, queries)
+	}
+	if err != nil {
+		trace.record(CheckBlockFetch, false, id.BlockNumber, err)
+		reason := ReasonFetchFailed
+		if errors.Is(err, ErrPeerUnavailable) {
+			reason = ReasonPeerUnavailable
+		}
+		b.logInvalidMessage(id, payload, reason, err)
+		return invalidResult(reason), err
+	}
+	return b.checkMessage(chainId, id, payload, &blocks[0], opts)
+}
+
+// MessageSafetyBatch checks the safety of all the messages, fetching every referenced block once
+// with a single batch request per peer chain. The peer chains are fetched concurrently, and identical
+// messages are only checked once. The labels are returned in the order of the identifiers.
+// A message that fails to validate is labeled Invalid, without affecting the other messages, and the
+// errors of all the failed messages are joined into the returned error.
+func (b *backend) MessageSafetyBatch(ctx context.Context, ids []MessageIdentifier, payloads []hexutil.Bytes) (_ []MessageSafetyLabel, err error) {
+	ctx, span := b.startSpan(ctx, spanMessageSafetyBatch, func() []attribute.KeyValue {
+		return []attribute.KeyValue{attribute.Int("superchain.messages", len(ids))}
+	})
+	defer func() { span.end(err) }()
+	if len(ids) != len(payloads) {
+		return nil, fmt.Errorf("mismatched number of identifiers (%d) and payloads (%d)", len(ids), len(payloads))
+	}
+	b.log.Info("checking message safety batch", "messages", len(ids))
+	if b.closed.Load() {
+		return nil, ErrBackendClosed
+	}
+	if len(b.originProxies) > 0 {
+		emitters := make([]MessageIdentifier, len(ids))
+		for i := range ids {
+			emitters[i] = b.withEmitter(ids[i])
+		}
+		ids = emitters
+	}
+
+	type chainGroup struct {
+		peer    *peer
+		queries []blockQuery
+		blocks  map[string]int // block number -> index of the block query
+		msgs    []int          // index of the message -> index of the block query
+	}
+	var chainIds []ChainID
+	groups := make(map[ChainID]*chainGroup)
+	msgBlocks := make([]int, len(ids))
+	labels := make([]MessageSafetyLabel, len(ids))
+	errs := make([]error, len(ids))
+	// Identical messages are checked once, and the label fanned out to the duplicates
+	firstMsgs := make(map[messageKey]int)
+	duplicates := make(map[int]int) // index of the duplicate message -> index of the first message
+	for i, id := range ids {
+		// The preconditions are shared with MessageSafety, and checked for every duplicate like for a single message
+		chainId, peer, _, err := b.checkPreconditions(id, payloads[i], nil)
+		if err != nil {
+			labels[i], errs[i] = Invalid, err
+			continue
+		}
+		if key, ok := newMessageKey(chainId, id, payloads[i]); ok {
+			if first, ok := firstMsgs[key]; ok {
+				duplicates[i] = first
+				continue
+			}
+			firstMsgs[key] = i
+		}
+		if res, ok := b.cachedResult(chainId, id, payloads[i]); ok {
+			labels[i], errs[i] = res.result.Label, res.err
+			continue
+		}
+		group, ok := groups[chainId]
+		if !ok {
+			group = &chainGroup{peer: peer, blocks: make(map[string]int)}
+			groups[chainId] = group
+			chainIds = append(chainIds, chainId)
+		}
+		key := id.BlockNumber.String()
+		blockIdx, ok := group.blocks[key]
+		if !ok {
+			blockIdx = len(group.queries)
+			group.blocks[key] = blockIdx
+			group.queries = append(group.queries, blockQuery{number: id.BlockNumber})
+		}
+		group.queries[blockIdx].addOrigin(id.Origin)
+		group.msgs = append(group.msgs, i)
+		msgBlocks[i] = blockIdx
+	}
+
+	// The messages of every chain are checked concurrently, each goroutine filling the labels and errors of its chain
+	var g errgroup.Group
+	g.SetLimit(b.batchConcurrency)
+	for _, chainId := range chainIds {
+		chainId, group := chainId, groups[chainId]
+		g.Go(func() error {
+			blocks, err := b.fetchBlocks(ctx, group.peer, group.queries)
+			if err != nil {
+				b.log.Warn("failed to fetch blocks", "chain_id", chainId, "err", err)
+				for _, i := range group.msgs {
+					labels[i], errs[i] = Invalid, err
+				}
+				return nil
+			}
+			for _, i := range group.msgs {
+				res, err := b.checkMessage(chainId, ids[i], payloads[i], &blocks[msgBlocks[i]], checkOptions{})
+				labels[i], errs[i] = res.Label, err
+			}
+			return nil
+		})
+	}
+	_ = g.Wait() // failures of a chain are labeled, and joined into the error of the batch
+	for i, first := range duplicates {
+		labels[i], errs[i] = labels[first], errs[first]
+	}
+	for i := range labels {
+		labels[i] = b.capLabel(ids[i], labels[i])
+		b.metrics.RecordMessageSafety(b.chainIdLabel(ids[i]), labels[i])
+	}
+	return labels, joinMessageErrors(errs)
+}
+
+// joinMessageErrors joins the errors of the messages of a batch, prefixed with the index of the message.
+// It returns nil if no message failed.
+func joinMessageErrors(errs []error) error {
+	var joined []error
+	for i, err := range errs {
+		if err != nil {
+			joined = append(joined, fmt.Errorf("message %d: %w", i, err))
+		}
+	}
+	return errors.Join(joined...)
+}
+
+// Close unsubscribes from the tracked heads and closes the L2 node and peer connections.
+// Calls that are in-flight while closing fail on the closed connections.
+func (b *backend) Close() error {
+	b.closeOnce.Do(func() {
+		b.closed.Store(true)
+		for _, sub := range b.l2HeadSubs {
+			sub.Unsubscribe()
+		}
+		b.finalizedHeadSubs.unsubscribeAll()
+		b.finalizedWatches.clear()
+		for _, peerNode := range b.l2PeerNodes {
+			peerNode.Close()
+		}
+		b.via.close()
+		if b.l2Node != nil {
+			b.l2Node.Close()
+		}
+	})
+	return nil
+}
+
+// checkMessage checks the integrity of the message against the fetched block, and labels it against the tracked heads.
+// Results that can no longer change are cached. Every check is recorded to the trace of the options, if non-nil.
+func (b *backend) checkMessage(chainId ChainID, id MessageIdentifier, payload hexutil.Bytes, block *blockData, opts checkOptions) (MessageSafetyResult, error) {
+	trace := opts.trace
+	if block.err != nil {
+		trace.record(CheckBlockFetch, false, id.BlockNumber, block.err)
+		b.logInvalidMessage(id, payload, ReasonFetchFailed, block.err)
+		return invalidResult(ReasonFetchFailed), block.err
+	}
+	if block.header == nil {
+		trace.record(CheckBlockFetch, false, id.BlockNumber, "no block")
+		// A block beyond the latest head is not yet produced, while a missing block below it was reorged out
+		if latest := b.latestHeadNumber(chainId); latest == nil || id.BlockNumber.Cmp(new(big.Int).SetUint64(*latest)) > 0 {
+			// A block shortly ahead of the latest head may not have propagated to the peer yet, unless the
+			// integrity of the message must be established
+			if !opts.integrityOnly && latest != nil && new(big.Int).Sub(id.BlockNumber, new(big.Int).SetUint64(*latest)).Cmp(new(big.Int).SetUint64(b.futureBlockTolerance)) <= 0 {
+				b.log.Debug("block of message is not yet available", "chain_id", chainId, "block_number", id.BlockNumber, "latest", *latest)
+				return MessageSafetyResult{Label: Unsafe}, nil
+			}
+			err := fmt.Errorf("block %d is ahead of the latest head of the peer chain", id.BlockNumber)
+			b.logInvalidMessage(id, payload, ReasonFutureBlock, err)
+			return invalidResult(ReasonFutureBlock), err
+		}
+		err := fmt.Errorf("block %d does not exist", id.BlockNumber)
+		b.logInvalidMessage(id, payload, ReasonBlockNotFound, err)
+		return invalidResult(ReasonBlockNotFound), err
+	}
+	trace.record(CheckBlockFetch, true, id.BlockNumber, block.header.Number)
+	blockHash := block.header.Hash()
+	if id.BlockHash != nil {
+		trace.record(CheckBlockHash, *id.BlockHash == blockHash, id.BlockHash, blockHash)
+		if *id.BlockHash != blockHash {
+			// The block was reorged, or the peer serves a sibling block. The logs cannot be trusted.
+			res := invalidResult(ReasonBlockHashMismatch)
+			res.BlockHash = blockHash
+			err := categorize(ErrIntegrityMismatch, fmt.Errorf("block hash mismatch: expected %s, got %s for block %d", id.BlockHash, blockHash, id.BlockNumber))
+			b.logInvalidMessage(id, payload, ReasonBlockHashMismatch, err, "expected_block_hash", id.BlockHash, "actual_block_hash", blockHash)
+			return res, err
+		}
+	}
+	if blockTime, ok := b.blockTimes[chainId]; ok {
+		expected := blockTime.expectedTime(block.header.Number.Uint64())
+		drift := time.Duration(int64(block.header.Time)-expected) * time.Second
+		plausible := drift.Abs() <= blockTime.Tolerance
+		trace.record(CheckHeaderTimestamp, plausible, expected, block.header.Time)
+		if !plausible {
+			// The peer serves a header inconsistent with its chain, of which the logs cannot be trusted either.
+			// The result is not cached, as the peer may serve the consistent header once fixed.
+			res := invalidResult(ReasonImplausibleHeaderTimestamp)
+			res.BlockHash = blockHash
+			err := categorize(ErrIntegrityMismatch, fmt.Errorf("implausible timestamp %d of block %d: expected %d ± %s",
+				block.header.Time, id.BlockNumber, expected, blockTime.Tolerance))
+			b.logInvalidMessage(id, payload, ReasonImplausibleHeaderTimestamp, err, "expected_header_timestamp", expected, "actual_header_timestamp", block.header.Time)
+			return res, err
+		}
+	}
+
+	if reason, err := checkIntegrity(id, payload, block.logs, block.header.Time, trace); err != nil {
+		var actual []any
+		if log := findLog(block.logs, id.LogIndex); log != nil {
+			actual = []any{"actual_origin", log.Address, "actual_timestamp", block.header.Time,
+				"actual_payload_hash", crypto.Keccak256Hash(MessagePayloadBytes(log))}
+		}
+		b.logInvalidMessage(id, payload, reason, err, actual...)
+		res := invalidResult(reason)
+		res.BlockHash = blockHash
+		// A mismatch against a block that can still be reorged out is not terminal, nor is a removed log,
+		// as the peer serves the canonical logs once it caught up with the reorg
+		label, finalized := b.labelMessage(chainId, block.header.Number.Uint64(), block.header.Time, opts)
+		res.FinalizedTimestamp, res.Finalized = finalizedTime(finalized), newFinalizedHead(finalized)
+		if label == Finalized && reason != ReasonLogRemoved && opts.writeCache() {
+			b.cacheResult(chainId, id, payload, messageResult{result: res, err: err})
+		}
+		return res, err
+	}
+
+	log := findLog(block.logs, id.LogIndex)
+	for i := uint64(0); b.verifyLogBlockHashes && i < id.logCount(); i++ {
+		spanLog := findLog(block.logs, id.LogIndex+i)
+		trace.record(CheckLogBlockHash, spanLog.BlockHash == blockHash, blockHash, spanLog.BlockHash)
+		if spanLog.BlockHash != blockHash {
+			// The header and the log were served from different blocks at the number. The result is not cached,
+			// as the peer serves a consistent block once fetched again.
+			res := invalidResult(ReasonLogBlockHashMismatch)
+			res.BlockHash = blockHash
+			err := categorize(ErrIntegrityMismatch, fmt.Errorf("log block hash mismatch: peer served a log of block %s for header %s of block %d",
+				spanLog.BlockHash, blockHash, id.BlockNumber))
+			b.logInvalidMessage(id, payload, ReasonLogBlockHashMismatch, err, "expected_block_hash", blockHash, "actual_block_hash", spanLog.BlockHash)
+			return res, err
+		}
+	}
+	label, finalized := b.labelMessage(chainId, block.header.Number.Uint64(), id.Timestamp, opts)
+	trace.record(CheckFinality, label != Invalid, "included by a tracked head", fmt.Sprintf("%s, finalized head timestamp %d", label, finalizedTime(finalized)))
+	res := MessageSafetyResult{Label: label, BlockHash: blockHash, FinalizedTimestamp: finalizedTime(finalized),
+		Finalized: newFinalizedHead(finalized), Log: copyLog(log)}
+	if label == Finalized && opts.writeCache() {
+		b.cacheResult(chainId, id, payload, messageResult{result: res})
+	}
+	return res, nil
+}
+
+// labelMessage labels a valid message in the block with the number and timestamp against the tracked heads of the
+// peer chain, or against the finalized timestamp of the options if set. Integrity-only checks are labeled Unsafe.
+func (b *backend) labelMessage(chainId ChainID, number, timestamp uint64, opts checkOptions) (MessageSafetyLabel, *eth.L1BlockRef) {
+	if opts.integrityOnly {
+		return Unsafe, nil
+	}
+	if opts.finalizedAt == nil {
+		return b.safetyLabel(chainId, number, timestamp)
+	}
+	// Only the timestamp of the supplied finalized head is known, regardless of how finality is compared
+	finalized := &eth.L1BlockRef{Time: *opts.finalizedAt}
+	if timestamp <= finalized.Time {
+		return Finalized, finalized
+	}
+	return Unsafe, finalized
+}
+
+// checkIntegrity checks the message matches the log, at the referenced index, of the logs of the block with the
+// timestamp. The reason identifies the first mismatch, and the error is an ErrIntegrityMismatch. Every check is
+// recorded to the trace, if non-nil.
+func checkIntegrity(id MessageIdentifier, payload hexutil.Bytes, logs []types.Log, blockTime uint64, trace *ValidationTrace) (MessageFailureReason, error) {
+	reason, err := checkLogIntegrity(id, payload, logs, blockTime, trace)
+	return reason, categorize(ErrIntegrityMismatch, err)
+}
+
+func checkLogIntegrity(id MessageIdentifier, payload hexutil.Bytes, logs []types.Log, blockTime uint64, trace *ValidationTrace) (MessageFailureReason, error) {
+	if len(logs) == 0 {
+		trace.record(CheckLogIndex, false, id.LogIndex, fmt.Sprintf("no logs emitted by %s", id.Origin))
+		return ReasonNoLogs, fmt.Errorf("no logs emitted by %s in block %d", id.Origin, id.BlockNumber)
+	}
+
+	// The logs of a span are checked one by one, and their payloads are matched against the payload as a unit
+	var spanPayload hexutil.Bytes
+	for i := uint64(0); i < id.logCount(); i++ {
+		log, reason, err := checkMessageLog(id, id.LogIndex+i, logs, blockTime, trace)
+		if err != nil {
+			return reason, err
+		}
+		spanPayload = append(spanPayload, MessagePayloadBytes(log)...)
+	}
+	trace.record(CheckPayload, bytes.Equal(spanPayload, payload), payload, spanPayload)
+	if !bytes.Equal(spanPayload, payload) {
+		return ReasonPayloadMismatch, fmt.Errorf("payload bytes mismatch")
+	}
+	return ReasonNone, nil
+}
+
+// checkMessageLog checks the log with the block-global index, of the logs of the block with the timestamp,
+// is a well-formed message log of the origin of the message.
+func checkMessageLog(id MessageIdentifier, index uint64, logs []types.Log, blockTime uint64, trace *ValidationTrace) (*types.Log, MessageFailureReason, error) {
+	log := findLog(logs, index)
+	if log == nil {
+		trace.record(CheckLogIndex, false, index, fmt.Sprintf("no log with index %d emitted by %s", index, id.Origin))
+		return nil, ReasonLogIndexOutOfRange, fmt.Errorf("invalid log index: no log with index %d emitted by %s in block %d", index, id.Origin, id.BlockNumber)
+	}
+	trace.record(CheckLogIndex, true, index, log.Index)
+	// The index of a log is only meaningful in the block of the log, which the peer may not have filtered by
+	logBlock := new(big.Int).SetUint64(log.BlockNumber)
+	trace.record(CheckLogBlock, logBlock.Cmp(id.BlockNumber) == 0, id.BlockNumber, log.BlockNumber)
+	if logBlock.Cmp(id.BlockNumber) != 0 {
+		return nil, ReasonLogBlockMismatch, fmt.Errorf("log block mismatch: peer served a log of block %d for block %d", log.BlockNumber, id.BlockNumber)
+	}
+	// A removed log was emitted in a block that was reorged out, and is no longer on the canonical chain
+	trace.record(CheckLogRemoved, !log.Removed, "canonical log", fmt.Sprintf("removed: %t", log.Removed))
+	if log.Removed {
+		return nil, ReasonLogRemoved, fmt.Errorf("log removed: the log with index %d of block %d was removed by a reorg", log.Index, log.BlockNumber)
+	}
+	trace.record(CheckOrigin, log.Address == id.Origin, id.Origin, log.Address)
+	if log.Address != id.Origin {
+		return nil, ReasonOriginMismatch, fmt.Errorf("origin mismatch")
+	}
+	trace.record(CheckTimestamp, blockTime == id.Timestamp, id.Timestamp, blockTime)
+	if blockTime != id.Timestamp {
+		return nil, ReasonTimestampMismatch, fmt.Errorf("timestamp mismatch")
+	}
+	if err := ValidateMessageLog(log); err != nil {
+		trace.record(CheckLogStructure, false, "well-formed message log", err)
+		return nil, ReasonMalformedLog, err
+	}
+	trace.record(CheckLogStructure, true, "well-formed message log", fmt.Sprintf("%d topics", len(log.Topics)))
+	return log, ReasonNone, nil
+}
+
+// findLog returns the log with the block-global index, or nil if there is no such log.
+func findLog(logs []types.Log, index uint64) *types.Log {
+	for i := range logs {
+		if uint64(logs[i].Index) == index {
+			return &logs[i]
+		}
+	}
+	return nil
+}
+
+// logInvalidMessage logs the failed check of the message, with the values expected by the message, and the
+// given values observed on the peer chain. The log keys are stable, to search the failures of a message.
+// Payloads are only logged as keccak hash.
+func (b *backend) logInvalidMessage(id MessageIdentifier, payload []byte, reason MessageFailureReason, err error, actual ...any) {
+	ctx := []any{
+		"chain_id", id.ChainId, "block_number", id.BlockNumber, "log_index", id.LogIndex, "reason", reason,
+		"expected_origin", id.Origin, "expected_timestamp", id.Timestamp, "expected_payload_hash", crypto.Keccak256Hash(payload),
+	}
+	ctx = append(ctx, actual...)
+	b.log.Warn("invalid message", append(ctx, "err", err)...)
+}
@@ -0,0 +1,2278 @@
+package superchain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+	"golang.org/x/sync/semaphore"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"github.com/ethereum-optimism/optimism/op-service/client"
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+	"github.com/ethereum-optimism/optimism/op-service/metrics"
+	"github.com/ethereum-optimism/optimism/op-service/sources/caching"
+	"github.com/ethereum-optimism/optimism/op-service/testlog"
+)
+
+// stubRPC serves the headers and logs of a fake chain. Results are JSON round-tripped
+// to match the decoding behavior of a real RPC.
+type stubRPC struct {
+	mu      sync.Mutex
+	chainId uint64
+	headers map[uint64]*types.Header
+	// headers served as is instead of the headers, e.g. with fields unknown to types.Header
+	rawHeaders map[uint64]json.RawMessage
+	// numbers of the blocks served by label, e.g. "finalized"
+	labels map[string]uint64
+	// chain ids in the dependency set of the registry
+	dependencySet []uint64
+	logs          map[uint64][]types.Log
+
+	batchCalls int
+	batchSizes []int
+	// number of eth_getBlockReceipts requests
+	receiptsCalls int
+	// number of eth_getTransactionReceipt requests
+	txReceiptCalls int
+	// current and maximum number of concurrent batch requests
+	inFlight, maxInFlight int
+	closed                int
+	// topics of every logs filter
+	filterTopics [][][]common.Hash
+
+	// delay of every batch request
+	delay time.Duration
+	// error of every request
+	err error
+	// errors of the next batch requests, taking precedence over err
+	failNext []error
+}
+
+var _ client.RPC = (*stubRPC)(nil)
+
+func newStubRPC() *stubRPC {
+	return &stubRPC{headers: make(map[uint64]*types.Header), logs: make(map[uint64][]types.Log)}
+}
+
+// addBlock adds a block with the given timestamp and logs, assigning the block-global log indices.
+func (s *stubRPC) addBlock(num uint64, time uint64, logs ...types.Log) *types.Header {
+	header := &types.Header{Number: new(big.Int).SetUint64(num), Difficulty: common.Big0, Time: time}
+	for i := range logs {
+		logs[i].BlockNumber = num
+		logs[i].BlockHash = header.Hash()
+		logs[i].Index = uint(i)
+	}
+	s.headers[num] = header
+	s.logs[num] = logs
+	return header
+}
+
+func (s *stubRPC) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed++
+}
+
+func (s *stubRPC) CallContext(ctx context.Context, result any, method string, args ...any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	return s.call(result, method, args...)
+}
+
+func (s *stubRPC) BatchCallContext(ctx context.Context, b []rpc.BatchElem) error {
+	s.mu.Lock()
+	s.inFlight++
+	s.maxInFlight = max(s.maxInFlight, s.inFlight)
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.inFlight--
+		s.mu.Unlock()
+	}()
+	select {
+	case <-time.After(s.delay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batchCalls++
+	s.batchSizes = append(s.batchSizes, len(b))
+	if len(s.failNext) > 0 {
+		err := s.failNext[0]
+		s.failNext = s.failNext[1:]
+		return err
+	}
+	if s.err != nil {
+		return s.err
+	}
+	for i := range b {
+		b[i].Error = s.call(b[i].Result, b[i].Method, b[i].Args...)
+	}
+	return nil
+}
+
+func (s *stubRPC) EthSubscribe(ctx context.Context, channel any, args ...any) (ethereum.Subscription, error) {
+	return nil, fmt.Errorf("subscriptions are not supported")
+}
+
+func (s *stubRPC) call(result any, method string, args ...any) error {
+	var out any
+	switch method {
+	case "eth_chainId":
+		out = hexutil.Uint64(s.chainId)
+	case "eth_call":
+		var call struct {
+			Data hexutil.Bytes `json:"data"`
+		}
+		data, err := json.Marshal(args[0])
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &call); err != nil {
+			return err
+		}
+		chainId := new(big.Int).SetBytes(call.Data[4:]).Uint64()
+		result := make(hexutil.Bytes, 32)
+		if slices.Contains(s.dependencySet, chainId) {
+			result[31] = 1
+		}
+		out = result
+	case "eth_getBlockByNumber":
+		num, ok := s.labels[args[0].(string)]
+		if !ok {
+			var err error
+			if num, err = hexutil.DecodeUint64(args[0].(string)); err != nil {
+				return err
+			}
+		}
+		if raw, ok := s.rawHeaders[num]; ok {
+			return json.Unmarshal(raw, result)
+		}
+		out = s.headers[num]
+	case "eth_getLogs":
+		var filter struct {
+			FromBlock hexutil.Uint64   `json:"fromBlock"`
+			Addresses []common.Address `json:"address"`
+			Topics    [][]common.Hash  `json:"topics"`
+		}
+		data, err := json.Marshal(args[0])
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &filter); err != nil {
+			return err
+		}
+		s.filterTopics = append(s.filterTopics, filter.Topics)
+		logs := []types.Log{}
+		for _, log := range s.logs[uint64(filter.FromBlock)] {
+			if len(filter.Addresses) > 0 && !slices.Contains(filter.Addresses, log.Address) {
+				continue
+			}
+			if len(filter.Topics) > 0 && (len(log.Topics) == 0 || !slices.Contains(filter.Topics[0], log.Topics[0])) {
+				continue
+			}
+			logs = append(logs, log)
+		}
+		out = logs
+	case "eth_getTransactionReceipt":
+		s.txReceiptCalls++
+		txHash := args[0].(common.Hash)
+		// the receipt of the transaction, holding the logs of the transaction, nil if no log was emitted by it
+		var receipt *types.Receipt
+		for num, logs := range s.logs {
+			for _, log := range logs {
+				log := log
+				if log.TxHash != txHash {
+					continue
+				}
+				if receipt == nil {
+					receipt = &types.Receipt{Status: types.ReceiptStatusSuccessful, TxHash: log.TxHash,
+						TransactionIndex: log.TxIndex, BlockHash: log.BlockHash, BlockNumber: new(big.Int).SetUint64(num)}
+				}
+				receipt.Logs = append(receipt.Logs, &log)
+			}
+		}
+		out = receipt
+	case "eth_getBlockReceipts":
+		num, err := hexutil.DecodeUint64(args[0].(string))
+		if err != nil {
+			return err
+		}
+		s.receiptsCalls++
+		// a receipt for every transaction, holding the logs of the transaction
+		receipts := []*types.Receipt{}
+		for _, log := range s.logs[num] {
+			log := log
+			if len(receipts) == 0 || receipts[len(receipts)-1].TransactionIndex != log.TxIndex {
+				receipts = append(receipts, &types.Receipt{Status: types.ReceiptStatusSuccessful, TxHash: log.TxHash,
+					TransactionIndex: log.TxIndex, BlockHash: log.BlockHash, BlockNumber: new(big.Int).SetUint64(num)})
+			}
+			receipt := receipts[len(receipts)-1]
+			receipt.Logs = append(receipt.Logs, &log)
+		}
+		out = receipts
+	default:
+		return fmt.Errorf("unsupported method %q", method)
+	}
+	data, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, result)
+}
+
+func newTestBackend(t testing.TB, peers map[ChainID]client.RPC) *backend {
+	l2PeerNodes := make(map[ChainID]*peer, len(peers))
+	l2Heads := make(map[ChainID]*chainHeads, len(peers))
+	for chainId, rpc := range peers {
+		l2PeerNodes[chainId] = newPeer(chainId, nil, rpc, nil)
+		l2Heads[chainId] = &chainHeads{}
+	}
+	return &backend{
+		log:         testlog.Logger(t, log.LevelInfo),
+		metrics:     NewMetrics(metrics.With(prometheus.NewRegistry())),
+		l2Heads:     l2Heads,
+		l2Sources:   make(map[ChainID]eth.L1BlockRefsSource),
+		l2PeerNodes: l2PeerNodes,
+		rpcTimeout:  defaultRPCTimeout,
+		now:         time.Now,
+
+		finalizedPollInterval: defaultFinalizedPollInterval,
+		pollTimeout:           defaultPollTimeout,
+		finalizedWatches:      finalizedWatches{max: defaultMaxFinalizedWatches},
+		messageCache:          caching.NewLRUCache[messageKey, messageResult](nil, "messages", defaultMessageCacheSize),
+		headerCache:           caching.NewLRUCache[headerKey, *types.Header](nil, "headers", defaultHeaderCacheSize),
+		blockCache:            caching.NewLRUCache[headerKey, blockData](nil, "blocks", defaultBlockCacheSize),
+		blockCacheSize:        defaultBlockCacheSize,
+
+		batchConcurrency: defaultBatchConcurrency,
+		maxBatchSize:     defaultMaxBatchSize,
+		safetyPolicy:     DefaultSafetyPolicy,
+	}
+}
+
+// setHeads sets the tracked heads of the peer chain, nil heads are unknown.
+func setHeads(b *backend, chainId ChainID, finalized, safe, unsafe *eth.L1BlockRef) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.l2Heads[chainId] = &chainHeads{unsafe: unsafe, safe: safe, finalized: finalized}
+}
+
+func testLog(origin common.Address, data []byte) types.Log {
+	return types.Log{Address: origin, Topics: []common.Hash{{0x01}}, Data: data}
+}
+
+func testMessage(chainId uint64, peer *stubRPC, blockNum uint64, logIndex uint64) (MessageIdentifier, hexutil.Bytes) {
+	log := peer.logs[blockNum][logIndex]
+	id := MessageIdentifier{
+		Origin:      log.Address,
+		BlockNumber: new(big.Int).SetUint64(blockNum),
+		LogIndex:    logIndex,
+		Timestamp:   peer.headers[blockNum].Time,
+		ChainId:     new(big.Int).SetUint64(chainId),
+	}
+	return id, MessagePayloadBytes(&log)
+}
+
+// chainIdService serves the eth_chainId method of a node of the chain.
+type chainIdService struct {
+	chainId uint64
+}
+
+func (s *chainIdService) ChainId() hexutil.Uint64 {
+	return hexutil.Uint64(s.chainId)
+}
+
+func newChainIdServer(t *testing.T, chainId uint64) string {
+	srv := rpc.NewServer()
+	require.NoError(t, srv.RegisterName("eth", &chainIdService{chainId: chainId}))
+	httpSrv := httptest.NewServer(srv)
+	t.Cleanup(httpSrv.Close)
+	t.Cleanup(srv.Stop)
+	return httpSrv.URL
+}
+
+// stubService serves the chain id, blocks and logs of the stub over RPC. The latest block of the stub is
+// served as the latest, safe and finalized block.
+type stubService struct {
+	stub *stubRPC
+}
+
+func (s *stubService) ChainId() hexutil.Uint64 {
+	return hexutil.Uint64(s.stub.chainId)
+}
+
+func (s *stubService) GetBlockByNumber(number string, full bool) (*types.Header, error) {
+	var header *types.Header
+	if _, err := hexutil.DecodeUint64(number); err != nil {
+		for _, h := range s.stub.headers {
+			if header == nil || h.Number.Cmp(header.Number) > 0 {
+				header = h
+			}
+		}
+		return header, nil
+	}
+	err := s.stub.CallContext(context.Background(), &header, "eth_getBlockByNumber", number, full)
+	return header, err
+}
+
+func (s *stubService) GetLogs(filter map[string]any) ([]types.Log, error) {
+	var logs []types.Log
+	err := s.stub.CallContext(context.Background(), &logs, "eth_getLogs", filter)
+	return logs, err
+}
+
+func newStubServer(t *testing.T, stub *stubRPC) string {
+	srv := rpc.NewServer()
+	require.NoError(t, srv.RegisterName("eth", &stubService{stub: stub}))
+	httpSrv := httptest.NewServer(srv)
+	t.Cleanup(httpSrv.Close)
+	t.Cleanup(srv.Stop)
+	return httpSrv.URL
+}
+
+func TestNewSuperchainBackendSelfChain(t *testing.T) {
+	self := newStubRPC()
+	self.chainId = 10
+	self.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x01}))
+	l2Node := newStubServer(t, self)
+	peers := map[ChainID]string{ChainIDFromUInt64(900): newChainIdServer(t, 900)}
+	logger := testlog.Logger(t, log.LevelInfo)
+
+	cfg := NewSuperchainConfig(l2Node, peers)
+	cfg.ValidateSelfChain = true
+	cfg.FinalizedPollInterval = 10 * time.Millisecond
+	sb, err := NewSuperchainBackend(context.Background(), logger, nil, cfg)
+	require.NoError(t, err)
+	defer sb.Close()
+	// the config of the caller lists the peers only
+	require.Len(t, cfg.PeerL2NodeAddrs, 1)
+
+	require.Eventually(t, func() bool {
+		return sb.TrackedHeads()[ChainIDFromUInt64(10)].Finalized != nil
+	}, 10*time.Second, 10*time.Millisecond)
+	id, payload := testMessage(10, self, 10, 0)
+	label, err := sb.MessageSafety(context.Background(), id, payload)
+	require.NoError(t, err)
+	require.Equal(t, Finalized, label)
+
+	t.Run("ConflictingPeer", func(t *testing.T) {
+		cfg := NewSuperchainConfig(l2Node, map[ChainID]string{ChainIDFromUInt64(10): peers[ChainIDFromUInt64(900)]})
+		cfg.ValidateSelfChain = true
+		_, err := NewSuperchainBackend(context.Background(), logger, nil, cfg)
+		require.ErrorContains(t, err, "chain id 10 of the l2 node is also configured as a peer")
+	})
+}
+
+func TestNewSuperchainBackendVerifyChainIDs(t *testing.T) {
+	l2Node := newChainIdServer(t, 10)
+	peers := map[ChainID]string{
+		ChainIDFromUInt64(900): newChainIdServer(t, 900),
+		ChainIDFromUInt64(901): newChainIdServer(t, 902),
+	}
+	logger := testlog.Logger(t, log.LevelInfo)
+
+	t.Run("Mismatch", func(t *testing.T) {
+		cfg := NewSuperchainConfig(l2Node, peers)
+		_, err := NewSuperchainBackend(context.Background(), logger, nil, cfg)
+		require.ErrorContains(t, err, "failed to verify peer with chain id 901: chain id mismatch: node serves chain id 902")
+	})
+
+	t.Run("Literal", func(t *testing.T) {
+		// the chain ids are verified by default, not only by the configs of NewSuperchainConfig
+		cfg := &SuperchainConfig{L2NodeAddr: l2Node, PeerL2NodeAddrs: peers}
+		_, err := NewSuperchainBackend(context.Background(), logger, nil, cfg)
+		require.ErrorContains(t, err, "failed to verify peer with chain id 901: chain id mismatch: node serves chain id 902")
+	})
+
+	t.Run("Match", func(t *testing.T) {
+		cfg := NewSuperchainConfig(l2Node, map[ChainID]string{ChainIDFromUInt64(900): peers[ChainIDFromUInt64(900)]})
+		b, err := NewSuperchainBackend(context.Background(), logger, nil, cfg)
+		require.NoError(t, err)
+		require.NoError(t, b.Close())
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		cfg := NewSuperchainConfig(l2Node, peers)
+		cfg.SkipChainIDVerification = true
+		b, err := NewSuperchainBackend(context.Background(), logger, nil, cfg)
+		require.NoError(t, err)
+		require.NoError(t, b.Close())
+	})
+}
+
+func TestNewSuperchainBackendSharedAddress(t *testing.T) {
+	l2Node := newChainIdServer(t, 10)
+	gateway := newChainIdServer(t, 900)
+	peers := map[ChainID]string{ChainIDFromUInt64(900): gateway, ChainIDFromUInt64(901): gateway}
+	logger := testlog.Logger(t, log.LevelInfo)
+
+	// the chain id is verified for every chain sharing the connection
+	cfg := NewSuperchainConfig(l2Node, peers)
+	_, err := NewSuperchainBackend(context.Background(), logger, nil, cfg)
+	require.ErrorContains(t, err, "failed to verify peer with chain id 901: chain id mismatch: node serves chain id 900")
+
+	cfg.SkipChainIDVerification = true
+	sb, err := NewSuperchainBackend(context.Background(), logger, nil, cfg)
+	require.NoError(t, err)
+	b := sb.(*backend)
+	first := b.l2PeerNodes[ChainIDFromUInt64(900)].client().(*sharedRPC)
+	second := b.l2PeerNodes[ChainIDFromUInt64(901)].client().(*sharedRPC)
+	require.Same(t, first.conn, second.conn)
+	require.NoError(t, b.Close())
+	require.Zero(t, first.conn.refs.Load())
+}
+
+func TestNewSuperchainBackendPeerTLS(t *testing.T) {
+	l2Node := newChainIdServer(t, 10)
+	first, caFile := newTLSChainIdServer(t, 900)
+	second, _ := newTLSChainIdServer(t, 901)
+	peers := map[ChainID]string{ChainIDFromUInt64(900): first.URL, ChainIDFromUInt64(901): second.URL}
+	logger := testlog.Logger(t, log.LevelInfo)
+
+	cfg := NewSuperchainConfig(l2Node, peers)
+	_, err := NewSuperchainBackend(context.Background(), logger, nil, cfg)
+	require.ErrorContains(t, err, "certificate signed by unknown authority")
+
+	// the test servers share the CA
+	cfg.PeerTLS = map[ChainID]PeerTLSConfig{ChainIDFromUInt64(900): {CAFile: caFile}}
+	_, err = NewSuperchainBackend(context.Background(), logger, nil, cfg)
+	require.ErrorContains(t, err, "failed to verify peer with chain id 901")
+
+	cfg.PeerTLS[ChainIDFromUInt64(901)] = PeerTLSConfig{CAFile: filepath.Join(t.TempDir(), "missing.pem")}
+	_, err = NewSuperchainBackend(context.Background(), logger, nil, cfg)
+	require.ErrorContains(t, err, "invalid tls config of peer with chain id 901: failed to read CA bundle")
+
+	cfg.PeerTLS[ChainIDFromUInt64(901)] = PeerTLSConfig{CAFile: caFile}
+	sb, err := NewSuperchainBackend(context.Background(), logger, nil, cfg)
+	require.NoError(t, err)
+	require.NoError(t, sb.Close())
+}
+
+func TestMessageSafety(t *testing.T) {
+	origin := common.Address{0xaa}
+	peer := newStubRPC()
+	peer.addBlock(10, 100, testLog(common.Address{0xbb}, []byte{0x01}), testLog(origin, []byte{0x02}))
+	peer.addBlock(11, 102, testLog(origin, []byte{0x03}))
+	peer.addBlock(12, 104, testLog(origin, []byte{0x04}))
+	peer.addBlock(13, 106, testLog(origin, []byte{0x05}))
+
+	b := newTestBackend(t, map[ChainID]client.RPC{ChainIDFromUInt64(900): peer})
+	setHeads(b, ChainIDFromUInt64(900), &eth.L1BlockRef{Number: 10, Time: 100}, &eth.L1BlockRef{Number: 11, Time: 102}, &eth.L1BlockRef{Number: 12, Time: 104})
+
+	msg := func(blockNum uint64, logIndex uint64) (MessageIdentifier, hexutil.Bytes) {
+		return testMessage(900, peer, blockNum, logIndex)
+	}
+
+	t.Run("Finalized", func(t *testing.T) {
+		id, payload := msg(10, 1)
+		label, err := b.MessageSafety(context.Background(), id, payload)
+		require.NoError(t, err)
+		require.Equal(t, Finalized, label)
+	})
+
+	t.Run("CrossSafe", func(t *testing.T) {
+		// above the finalized head, but included by the safe head of the only chain
+		id, payload := msg(11, 0)
+		label, err := b.MessageSafety(context.Background(), id, payload)
+		require.NoError(t, err)
+		require.Equal(t, CrossSafe, label)
+	})
+
+	t.Run("Unsafe", func(t *testing.T) {
+		id, payload := msg(12, 0)
+		label, err := b.MessageSafety(context.Background(), id, payload)
+		require.NoError(t, err)
+		require.Equal(t, Unsafe, label)
+	})
+
+	t.Run("AboveUnsafe", func(t *testing.T) {
+		// served by the peer, although the unsafe head was polled before the block was produced
+		id, payload := msg(13, 0)
+		label, err := b.MessageSafety(context.Background(), id, payload)
+		require.NoError(t, err)
+		require.Equal(t, Unsafe, label)
+	})
+
+	t.Run("UnknownPeer", func(t *testing.T) {
+		id, payload := msg(10, 1)
+		id.ChainId = big.NewInt(901)
+		label, err := b.MessageSafety(context.Background(), id, payload)
+		require.ErrorContains(t, err, "not configured")
+		require.Equal(t, Invalid, label)
+	})
+
+	t.Run("NoLogsFromOrigin", func(t *testing.T) {
+		id, payload := msg(10, 1)
+		id.Origin = common.Address{0xcc}
+		label, err := b.MessageSafety(context.Background(), id, payload)
+		require.ErrorContains(t, err, "no logs emitted")
+		require.Equal(t, Invalid, label)
+	})
+
+	t.Run("LogIndexOfOtherOrigin", func(t *testing.T) {
+		// the log at index 0 is emitted by a different address, and filtered out
+		id, payload := msg(10, 1)
+		id.LogIndex = 0
+		label, err := b.MessageSafety(context.Background(), id, payload)
+		require.ErrorContains(t, err, "invalid log index")
+		require.Equal(t, Invalid, label)
+	})
+
+	t.Run("TimestampMismatch", func(t *testing.T) {
+		id, payload := msg(10, 1)
+		id.Timestamp++
+		label, err := b.MessageSafety(context.Background(), id, payload)
+		require.ErrorContains(t, err, "timestamp mismatch")
+		require.Equal(t, Invalid, label)
+	})
+
+	t.Run("PayloadMismatch", func(t *testing.T) {
+		id, _ := msg(10, 1)
+		label, err := b.MessageSafety(context.Background(), id, hexutil.Bytes{0xff})
+		require.ErrorContains(t, err, "payload bytes mismatch")
+		require.Equal(t, Invalid, label)
+	})
+
+	t.Run("LogIndexOutOfRange", func(t *testing.T) {
+		id, payload := msg(10, 1)
+		id.LogIndex = 2
+		label, err := b.MessageSafety(context.Background(), id, payload)
+		require.ErrorContains(t, err, "invalid log index")
+		require.Equal(t, Invalid, label)
+	})
+
+	t.Run("FutureBlock", func(t *testing.T) {
+		id, payload := msg(10, 1)
+		id.BlockNumber = big.NewInt(20)
+		label, err := b.MessageSafety(context.Background(), id, payload)
+		require.ErrorContains(t, err, "block 20 is ahead of the latest head")
+		require.Equal(t, Invalid, label)
+	})
+
+	t.Run("UnknownBlock", func(t *testing.T) {
+		// below the unsafe head, but not served by the peer
+		id, payload := msg(10, 1)
+		id.BlockNumber = big.NewInt(5)
+		label, err := b.MessageSafety(context.Background(), id, payload)
+		require.ErrorContains(t, err, "block 5 does not exist")
+		require.Equal(t, Invalid, label)
+	})
+}
+
+func TestMessageSafetyDetails(t *testing.T) {
+	origin := common.Address{0xaa}
+	peer := newStubRPC()
+	finalizedBlock := peer.addBlock(10, 100, testLog(origin, []byte{0x01}))
+	peer.addBlock(11, 102, testLog(origin, []byte{0x02}))
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	finalized := &eth.L1BlockRef{Hash: finalizedBlock.Hash(), Number: 10, Time: 100}
+	setHeads(b, chainId, finalized, finalized, &eth.L1BlockRef{Number: 11, Time: 102})
+
+	t.Run("Valid", func(t *testing.T) {
+		id, payload := testMessage(900, peer, 11, 0)
+		res, err := b.MessageSafetyDetails(context.Background(), id, payload)
+		require.NoError(t, err)
+		require.Equal(t, MessageSafetyResult{
+			Label:              Unsafe,
+			BlockHash:          peer.headers[11].Hash(),
+			FinalizedTimestamp: 100,
+			Finalized:          &FinalizedHead{Number: 10, Hash: finalizedBlock.Hash(), Timestamp: 100},
+			Log:                &peer.logs[11][0],
+		}, res)
+	})
+
+	t.Run("MatchingBlockHash", func(t *testing.T) {
+		id, payload := testMessage(900, peer, 11, 0)
+		hash := peer.headers[11].Hash()
+		id.BlockHash = &hash
+		res, err := b.MessageSafetyDetails(context.Background(), id, payload)
+		require.NoError(t, err)
+		require.Equal(t, Unsafe, res.Label)
+	})
+
+	tests := []struct {
+		name   string
+		modify func(id *MessageIdentifier, payload *hexutil.Bytes)
+		reason MessageFailureReason
+	}{
+		{"NoLogs", func(id *MessageIdentifier, _ *hexutil.Bytes) { id.Origin = common.Address{0xcc} }, ReasonNoLogs},
+		{"LogIndexOutOfRange", func(id *MessageIdentifier, _ *hexutil.Bytes) { id.LogIndex = 1 }, ReasonLogIndexOutOfRange},
+		{"TimestampMismatch", func(id *MessageIdentifier, _ *hexutil.Bytes) { id.Timestamp++ }, ReasonTimestampMismatch},
+		{"PayloadMismatch", func(_ *MessageIdentifier, payload *hexutil.Bytes) { *payload = hexutil.Bytes{0xff} }, ReasonPayloadMismatch},
+		{"BlockHashMismatch", func(id *MessageIdentifier, _ *hexutil.Bytes) { id.BlockHash = &common.Hash{0xde} }, ReasonBlockHashMismatch},
+		{"FutureBlock", func(id *MessageIdentifier, _ *hexutil.Bytes) { id.BlockNumber = big.NewInt(20) }, ReasonFutureBlock},
+		{"BlockNotFound", func(id *MessageIdentifier, _ *hexutil.Bytes) { id.BlockNumber = big.NewInt(5) }, ReasonBlockNotFound},
+		{"PeerNotConfigured", func(id *MessageIdentifier, _ *hexutil.Bytes) { id.ChainId = big.NewInt(901) }, ReasonPeerNotConfigured},
+		{"InvalidChainId", func(id *MessageIdentifier, _ *hexutil.Bytes) { id.ChainId = big.NewInt(-1) }, ReasonInvalidChainId},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			id, payload := testMessage(900, peer, 10, 0)
+			test.modify(&id, &payload)
+			res, err := b.MessageSafetyDetails(context.Background(), id, payload)
+			require.Error(t, err)
+			require.Equal(t, Invalid, res.Label)
+			require.Equal(t, test.reason, res.Reason)
+		})
+	}
+
+	t.Run("CachedMismatch", func(t *testing.T) {
+		// the mismatch against the finalized block is served from the cache, with the same details
+		id, _ := testMessage(900, peer, 10, 0)
+		expected := MessageSafetyResult{Label: Invalid, BlockHash: finalizedBlock.Hash(), FinalizedTimestamp: 100,
+			Finalized: &FinalizedHead{Number: 10, Hash: finalizedBlock.Hash(), Timestamp: 100}, Reason: ReasonPayloadMismatch}
+		for i := 0; i < 2; i++ {
+			res, err := b.MessageSafetyDetails(context.Background(), id, hexutil.Bytes{0xfe})
+			require.ErrorContains(t, err, "payload bytes mismatch")
+			require.Equal(t, expected, res)
+		}
+	})
+}
+
+func TestMessageSafetyFinalityByBlockNumber(t *testing.T) {
+	peer := newStubRPC()
+	peer.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x01}))
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	id, payload := testMessage(900, peer, 10, 0)
+
+	// the heads include the message by number, or by timestamp, but not both
+	byNumber := &eth.L1BlockRef{Number: 10, Time: 99}
+	byTimestamp := &eth.L1BlockRef{Number: 9, Time: 100}
+	for _, test := range []struct {
+		name                  string
+		finalityByBlockNumber bool
+		finalized, safe       *eth.L1BlockRef
+		label                 MessageSafetyLabel
+	}{
+		{"TimestampFinalized", false, byTimestamp, nil, Finalized},
+		{"TimestampNotFinalized", false, byNumber, nil, Unsafe},
+		{"TimestampSafe", false, nil, byTimestamp, CrossSafe},
+		{"NumberFinalized", true, byNumber, nil, Finalized},
+		{"NumberNotFinalized", true, byTimestamp, nil, Unsafe},
+		{"NumberSafe", true, nil, byNumber, Safe},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			b.finalityByBlockNumber = test.finalityByBlockNumber
+			setHeads(b, chainId, test.finalized, test.safe, nil)
+			b.messageCache = caching.NewLRUCache[messageKey, messageResult](nil, "messages", defaultMessageCacheSize)
+			label, err := b.MessageSafety(context.Background(), id, payload)
+			require.NoError(t, err)
+			require.Equal(t, test.label, label)
+		})
+	}
+}
+
+func TestMessageSafetyAt(t *testing.T) {
+	peer := newStubRPC()
+	peer.addBlock(11, 102, testLog(common.Address{0xaa}, []byte{0x01}))
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	setHeads(b, chainId, &eth.L1BlockRef{Number: 10, Time: 100}, nil, &eth.L1BlockRef{Number: 12, Time: 104})
+
+	id, payload := testMessage(900, peer, 11, 0)
+	for _, test := range []struct {
+		finalizedTimestamp uint64
+		label              MessageSafetyLabel
+	}{
+		{0, Unsafe},
+		{101, Unsafe},
+		{102, Finalized},
+		{200, Finalized},
+	} {
+		label, err := b.MessageSafetyAt(context.Background(), id, payload, test.finalizedTimestamp)
+		require.NoError(t, err)
+		require.Equal(t, test.label, label, "finalized timestamp %d", test.finalizedTimestamp)
+	}
+
+	// the result against the supplied finalized head is not cached
+	label, err := b.MessageSafety(context.Background(), id, payload)
+	require.NoError(t, err)
+	require.Equal(t, Unsafe, label)
+	label, err = b.MessageSafetyAt(context.Background(), id, payload, 101)
+	require.NoError(t, err)
+	require.Equal(t, Unsafe, label)
+
+	// the integrity of the message is still checked
+	label, err = b.MessageSafetyAt(context.Background(), id, hexutil.Bytes{0xff}, 200)
+	require.ErrorContains(t, err, "payload bytes mismatch")
+	require.Equal(t, Invalid, label)
+
+	// the historical checks are recorded like the live checks
+	require.Equal(t, 2.0, testutil.ToFloat64(b.metrics.MessageSafetyTotal.WithLabelValues("900", string(Finalized))))
+	require.Equal(t, 4.0, testutil.ToFloat64(b.metrics.MessageSafetyTotal.WithLabelValues("900", string(Unsafe))))
+	require.Equal(t, 1.0, testutil.ToFloat64(b.metrics.MessageSafetyTotal.WithLabelValues("900", string(Invalid))))
+}
+
+func TestValidateMessageLog(t *testing.T) {
+	log := testLog(common.Address{0xaa}, []byte{0x01})
+	require.NoError(t, ValidateMessageLog(&log))
+
+	log.Topics = []common.Hash{{0x01}, {0x02}, {0x03}, {0x04}}
+	require.NoError(t, ValidateMessageLog(&log))
+
+	log.Topics = nil
+	require.ErrorContains(t, ValidateMessageLog(&log), "malformed message log: no topics")
+
+	log.Topics = make([]common.Hash, 5)
+	require.ErrorContains(t, ValidateMessageLog(&log), "5 topics exceed the maximum of 4")
+}
+
+func TestMessageSafetyUnknownFinalizedHead(t *testing.T) {
+	peer := newStubRPC()
+	peer.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x01}))
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	id, payload := testMessage(900, peer, 10, 0)
+
+	// the heads of the peer are tracked, but none was polled yet
+	setHeads(b, chainId, nil, nil, nil)
+	res, err := b.MessageSafetyDetails(context.Background(), id, payload)
+	require.NoError(t, err)
+	require.Equal(t, MessageSafetyResult{Label: Unsafe, BlockHash: peer.headers[10].Hash(), Log: &peer.logs[10][0]}, res)
+
+	// only the finalized head is unknown
+	setHeads(b, chainId, nil, &eth.L1BlockRef{Number: 10, Time: 100}, &eth.L1BlockRef{Number: 11, Time: 102})
+	res, err = b.MessageSafetyDetails(context.Background(), id, payload)
+	require.NoError(t, err)
+	require.Equal(t, CrossSafe, res.Label)
+	require.Zero(t, res.FinalizedTimestamp)
+}
+
+func TestCheckIntegrityLogIndex(t *testing.T) {
+	originA, originB := common.Address{0xaa}, common.Address{0xbb}
+	peer := newStubRPC()
+	peer.addBlock(10, 100,
+		testLog(originA, []byte{0x00}),
+		testLog(originB, []byte{0x01}),
+		types.Log{Address: originA, Topics: []common.Hash{{0x02}}, Data: []byte{0x02}})
+	all := peer.logs[10]
+	byOrigin := []types.Log{all[0], all[2]}
+	byTopic := []types.Log{all[2]}
+	unordered := []types.Log{all[2], all[1], all[0]}
+
+	message := func(logIndex uint64) (MessageIdentifier, hexutil.Bytes) {
+		log := all[logIndex]
+		return MessageIdentifier{Origin: log.Address, BlockNumber: big.NewInt(10), LogIndex: logIndex, Timestamp: 100, ChainId: big.NewInt(900)},
+			MessagePayloadBytes(&log)
+	}
+	for _, test := range []struct {
+		name     string
+		logs     []types.Log
+		logIndex uint64
+		reason   MessageFailureReason
+	}{
+		{"Unfiltered", all, 2, ReasonNone},
+		{"UnfilteredOtherOrigin", all, 1, ReasonNone},
+		{"FilteredByOrigin", byOrigin, 2, ReasonNone},
+		// the log at position 1 of the filtered logs has index 2
+		{"FilteredOutByOrigin", byOrigin, 1, ReasonLogIndexOutOfRange},
+		{"FilteredByTopic", byTopic, 2, ReasonNone},
+		{"FilteredOutByTopic", byTopic, 0, ReasonLogIndexOutOfRange},
+		{"Unordered", unordered, 0, ReasonNone},
+		{"NoLogs", nil, 0, ReasonNoLogs},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			id, payload := message(test.logIndex)
+			reason, err := checkIntegrity(id, payload, test.logs, 100, nil)
+			require.Equal(t, test.reason, reason)
+			if test.reason == ReasonNone {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestMessageSafetyLogBlockMismatch(t *testing.T) {
+	peer := newStubRPC()
+	peer.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x01}))
+	peer.addBlock(11, 102, testLog(common.Address{0xaa}, []byte{0x02}))
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	setHeads(b, chainId, &eth.L1BlockRef{Number: 11, Time: 102}, nil, nil)
+
+	// the peer serves the logs of block 11 for block 10
+	id, payload := testMessage(900, peer, 11, 0)
+	id.BlockNumber, id.Timestamp = big.NewInt(10), 100
+	peer.logs[10] = peer.logs[11]
+	res, err := b.MessageSafetyDetails(context.Background(), id, payload)
+	require.ErrorContains(t, err, "log block mismatch: peer served a log of block 11 for block 10")
+	require.Equal(t, Invalid, res.Label)
+	require.Equal(t, ReasonLogBlockMismatch, res.Reason)
+}
+
+func TestMessageSafetyLogBlockHashMismatch(t *testing.T) {
+	peer := newStubRPC()
+	header := peer.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x01}))
+	// the log is served from another block at the number than the header
+	peer.logs[10][0].BlockHash = common.Hash{0xff}
+
+	chainId := ChainIDFromUInt64(900)
+	newBackend := func(verify bool) *backend {
+		b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+		b.verifyLogBlockHashes = verify
+		setHeads(b, chainId, &eth.L1BlockRef{Number: 10, Time: 100}, nil, nil)
+		return b
+	}
+	id, payload := testMessage(900, peer, 10, 0)
+
+	// the log block hash is not checked by default
+	label, err := newBackend(false).MessageSafety(context.Background(), id, payload)
+	require.NoError(t, err)
+	require.Equal(t, Finalized, label)
+
+	b := newBackend(true)
+	res, err := b.MessageSafetyDetails(context.Background(), id, payload)
+	require.ErrorIs(t, err, ErrIntegrityMismatch)
+	require.ErrorContains(t, err, fmt.Sprintf("log block hash mismatch: peer served a log of block %s for header %s of block 10",
+		common.Hash{0xff}, header.Hash()))
+	require.Equal(t, Invalid, res.Label)
+	require.Equal(t, ReasonLogBlockHashMismatch, res.Reason)
+	require.Equal(t, header.Hash(), res.BlockHash)
+
+	trace, err := b.MessageSafetyExplain(context.Background(), id, payload)
+	require.Error(t, err)
+	last := trace.Checks[len(trace.Checks)-1]
+	require.Equal(t, CheckLogBlockHash, last.Name)
+	require.False(t, last.Passed)
+
+	// the mismatch is not cached
+	peer.logs[10][0].BlockHash = header.Hash()
+	label, err = b.MessageSafety(context.Background(), id, payload)
+	require.NoError(t, err)
+	require.Equal(t, Finalized, label)
+}
+
+func TestMessageSafetyFutureBlockTolerance(t *testing.T) {
+	peer := newStubRPC()
+	peer.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x01}))
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	b.futureBlockTolerance = 2
+	setHeads(b, chainId, &eth.L1BlockRef{Number: 10, Time: 100}, nil, &eth.L1BlockRef{Number: 11, Time: 102})
+	msg := func(number uint64) MessageIdentifier {
+		return MessageIdentifier{Origin: common.Address{0xaa}, BlockNumber: new(big.Int).SetUint64(number), Timestamp: 100, ChainId: big.NewInt(900)}
+	}
+
+	t.Run("WithinTolerance", func(t *testing.T) {
+		res, err := b.MessageSafetyDetails(context.Background(), msg(13), hexutil.Bytes{0x01})
+		require.NoError(t, err)
+		require.Equal(t, MessageSafetyResult{Label: Unsafe}, res)
+	})
+
+	t.Run("BeyondTolerance", func(t *testing.T) {
+		res, err := b.MessageSafetyDetails(context.Background(), msg(14), hexutil.Bytes{0x01})
+		require.ErrorContains(t, err, "block 14 is ahead of the latest head")
+		require.Equal(t, ReasonFutureBlock, res.Reason)
+	})
+
+	t.Run("BelowLatestHead", func(t *testing.T) {
+		// a missing block below the latest head was reorged out, regardless of the tolerance
+		res, err := b.MessageSafetyDetails(context.Background(), msg(9), hexutil.Bytes{0x01})
+		require.ErrorContains(t, err, "block 9 does not exist")
+		require.Equal(t, ReasonBlockNotFound, res.Reason)
+	})
+
+	t.Run("Batch", func(t *testing.T) {
+		labels, err := b.MessageSafetyBatch(context.Background(), []MessageIdentifier{msg(12), msg(14)}, []hexutil.Bytes{{0x01}, {0x01}})
+		require.ErrorContains(t, err, "message 1: block 14 is ahead of the latest head")
+		require.Equal(t, []MessageSafetyLabel{Unsafe, Invalid}, labels)
+	})
+
+	t.Run("UnknownLatestHead", func(t *testing.T) {
+		setHeads(b, chainId, nil, nil, nil)
+		res, err := b.MessageSafetyDetails(context.Background(), msg(12), hexutil.Bytes{0x01})
+		require.ErrorContains(t, err, "block 12 is ahead of the latest head")
+		require.Equal(t, ReasonFutureBlock, res.Reason)
+	})
+}
+
+func TestMessageSafetyEmptyBlock(t *testing.T) {
+	peer := newStubRPC()
+	empty := peer.addBlock(10, 100)
+	peer.addBlock(11, 102, testLog(common.Address{0xaa}, []byte{0x01}))
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	setHeads(b, chainId, &eth.L1BlockRef{Number: 11, Time: 102}, nil, nil)
+
+	// the block exists, but the peer serves no logs for it
+	id := MessageIdentifier{Origin: common.Address{0xaa}, BlockNumber: big.NewInt(10), Timestamp: 100, ChainId: big.NewInt(900)}
+	res, err := b.MessageSafetyDetails(context.Background(), id, hexutil.Bytes{0x01})
+	require.ErrorIs(t, err, ErrIntegrityMismatch)
+	require.ErrorContains(t, err, "no logs emitted by 0xaa00000000000000000000000000000000000000 in block 10")
+	require.Equal(t, ReasonNoLogs, res.Reason)
+	require.Equal(t, empty.Hash(), res.BlockHash)
+
+	// an index beyond the logs of a populated block is out of range instead
+	id, payload := testMessage(900, peer, 11, 0)
+	id.LogIndex = 1
+	res, err = b.MessageSafetyDetails(context.Background(), id, payload)
+	require.ErrorContains(t, err, "invalid log index: no log with index 1")
+	require.Equal(t, ReasonLogIndexOutOfRange, res.Reason)
+}
+
+func TestMessageSafetyBlockReceipts(t *testing.T) {
+	originA, originB := common.Address{0xaa}, common.Address{0xbb}
+	logs := []types.Log{testLog(originB, []byte{0x01}), testLog(originB, []byte{0x02}), testLog(originA, []byte{0x03})}
+	logs[2].TxIndex = 1
+	peer := newStubRPC()
+	peer.addBlock(10, 100, logs...)
+	// the peer serves receipt-local log indices, the block-global indices are restored from the receipts
+	peer.logs[10][2].Index = 0
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	b.l2PeerNodes[chainId].blockReceipts = true
+	b.messageTopic = common.Hash{0x01}
+	setHeads(b, chainId, &eth.L1BlockRef{Number: 10, Time: 100}, nil, nil)
+
+	log := logs[2]
+	id := MessageIdentifier{Origin: originA, BlockNumber: big.NewInt(10), LogIndex: 2, Timestamp: 100, ChainId: big.NewInt(900)}
+	label, err := b.MessageSafety(context.Background(), id, MessagePayloadBytes(&log))
+	require.NoError(t, err)
+	require.Equal(t, Finalized, label)
+	require.Equal(t, 1, peer.receiptsCalls)
+	require.Empty(t, peer.filterTopics, "logs must not be requested with eth_getLogs")
+
+	// logs of other origins in the receipts are not matched
+	id.Origin, id.LogIndex = originB, 1
+	label, err = b.MessageSafety(context.Background(), id, MessagePayloadBytes(&logs[1]))
+	require.NoError(t, err)
+	require.Equal(t, Finalized, label)
+	id.Origin = originA
+	res, err := b.MessageSafetyDetails(context.Background(), id, MessagePayloadBytes(&logs[1]))
+	require.Error(t, err)
+	require.Equal(t, ReasonLogIndexOutOfRange, res.Reason)
+}
+
+func TestReceiptsLogs(t *testing.T) {
+	origin, topic := common.Address{0xaa}, common.Hash{0x01}
+	receipts := []*types.Receipt{
+		{Logs: []*types.Log{{Address: origin, Topics: []common.Hash{topic}}, {Address: common.Address{0xbb}, Topics: []common.Hash{topic}}}},
+		nil,
+		{Logs: []*types.Log{{Address: origin, Topics: []common.Hash{{0x02}}, Index: 0}, {Address: origin, Topics: []common.Hash{topic}, Index: 1}}},
+	}
+	indices := func(logs []types.Log) []uint {
+		var indices []uint
+		for _, log := range logs {
+			indices = append(indices, log.Index)
+		}
+		return indices
+	}
+	require.Equal(t, []uint{0, 1, 2, 3}, indices(receiptsLogs(receipts, nil, common.Hash{})))
+	require.Equal(t, []uint{0, 2, 3}, indices(receiptsLogs(receipts, []common.Address{origin}, common.Hash{})))
+	require.Equal(t, []uint{0, 3}, indices(receiptsLogs(receipts, []common.Address{origin}, topic)))
+	require.Empty(t, receiptsLogs(nil, nil, topic))
+	// the served logs are not modified
+	require.Equal(t, uint(1), receipts[2].Logs[1].Index)
+}
+
+func TestMessageSafetyDetailsLog(t *testing.T) {
+	peer := newStubRPC()
+	log := testLog(common.Address{0xaa}, []byte{0x01, 0x02})
+	log.Topics = append(log.Topics, common.Hash{0x02})
+	peer.addBlock(10, 100, testLog(common.Address{0xbb}, nil), log)
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	setHeads(b, chainId, &eth.L1BlockRef{Number: 10, Time: 100}, nil, nil)
+	id, payload := testMessage(900, peer, 10, 1)
+
+	res, err := b.MessageSafetyDetails(context.Background(), id, payload)
+	require.NoError(t, err)
+	require.Equal(t, Finalized, res.Label)
+	require.Equal(t, &peer.logs[10][1], res.Log)
+
+	// the log of the cached result is not affected by changes to a returned log
+	res.Log.Topics[1] = common.Hash{0xff}
+	res.Log.Data[0] = 0xff
+	cached, err := b.MessageSafetyDetails(context.Background(), id, payload)
+	require.NoError(t, err)
+	require.Equal(t, &peer.logs[10][1], cached.Log)
+	require.Equal(t, 1, peer.batchCalls)
+
+	// invalid messages matched no log
+	res, err = b.MessageSafetyDetails(context.Background(), id, hexutil.Bytes{0xff})
+	require.Error(t, err)
+	require.Nil(t, res.Log)
+}
+
+func TestMessageSafetyRemovedLog(t *testing.T) {
+	peer := newStubRPC()
+	peer.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x01}))
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	setHeads(b, chainId, &eth.L1BlockRef{Number: 10, Time: 100}, nil, nil)
+	id, payload := testMessage(900, peer, 10, 0)
+
+	// the peer serves the log as removed by a reorg
+	peer.logs[10][0].Removed = true
+	res, err := b.MessageSafetyDetails(context.Background(), id, payload)
+	require.ErrorContains(t, err, "log removed: the log with index 0 of block 10 was removed by a reorg")
+	require.Equal(t, Invalid, res.Label)
+	require.Equal(t, ReasonLogRemoved, res.Reason)
+
+	labels, err := b.MessageSafetyBatch(context.Background(), []MessageIdentifier{id}, []hexutil.Bytes{payload})
+	require.ErrorContains(t, err, "message 0: log removed")
+	require.Equal(t, []MessageSafetyLabel{Invalid}, labels)
+
+	// the removed log is not cached as invalid, the canonical log is checked once served
+	peer.logs[10][0].Removed = false
+	label, err := b.MessageSafety(context.Background(), id, payload)
+	require.NoError(t, err)
+	require.Equal(t, Finalized, label)
+}
+
+func TestMessageSafetyImplausibleHeaderTimestamp(t *testing.T) {
+	peer := newStubRPC()
+	peer.addBlock(10, 1020, testLog(common.Address{0xaa}, []byte{0x01}))
+	peer.addBlock(11, 5000, testLog(common.Address{0xaa}, []byte{0x01}))
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	b.blockTimes = map[ChainID]BlockTimeConfig{chainId: {AnchorNumber: 0, AnchorTime: 1000, BlockTime: 2, Tolerance: 10 * time.Second}}
+	setHeads(b, chainId, &eth.L1BlockRef{Number: 11, Time: 5000}, nil, nil)
+
+	// the timestamp of block 10 is as expected
+	id, payload := testMessage(900, peer, 10, 0)
+	label, err := b.MessageSafety(context.Background(), id, payload)
+	require.NoError(t, err)
+	require.Equal(t, Finalized, label)
+
+	// the timestamp of block 11 is thousands of seconds ahead of the block time
+	id, payload = testMessage(900, peer, 11, 0)
+	res, err := b.MessageSafetyDetails(context.Background(), id, payload)
+	require.ErrorIs(t, err, ErrIntegrityMismatch)
+	require.ErrorContains(t, err, "implausible timestamp 5000 of block 11: expected 1022 ± 10s")
+	require.Equal(t, Invalid, res.Label)
+	require.Equal(t, ReasonImplausibleHeaderTimestamp, res.Reason)
+
+	trace, err := b.MessageSafetyExplain(context.Background(), id, payload)
+	require.Error(t, err)
+	last := trace.Checks[len(trace.Checks)-1]
+	require.Equal(t, ValidationCheck{Name: CheckHeaderTimestamp, Passed: false, Expected: "1022", Observed: "5000"}, last)
+
+	// timestamps are not checked without a block time for the chain
+	b.blockTimes = nil
+	label, err = b.MessageSafety(context.Background(), id, payload)
+	require.NoError(t, err)
+	require.Equal(t, Finalized, label)
+}
+
+// cancunHeader is a post-Cancun block as served by eth_getBlockByNumber, with the blob gas, withdrawals root and
+// parent beacon block root fields of the header, and the transactions, withdrawals, size and total difficulty of
+// the block, which are not fields of types.Header.
+const cancunHeader = `{
+  "parentHash": "0x2b8c6dbd79e1e7d1d3c1f0bafe6b37f9ea8cd1f3f4a6b0c5d7e9f1a3b5c7d9e1",
+  "sha3Uncles": "0x1dcc4de8dec75d7aab85b567b6ccd41ad312451b948a7413f0a142fd40d49347",
+  "miner": "0x4200000000000000000000000000000000000011",
+  "stateRoot": "0x9c3a05b6b4e5dcb3f8a1b6c2d7e4f9a0b1c2d3e4f5a6b7c8d9e0f1a2b3c4d5e6",
+  "transactionsRoot": "0x1ad1c5b2f0e9d8c7b6a5f4e3d2c1b0a9f8e7d6c5b4a3f2e1d0c9b8a7f6e5d4c3",
+  "receiptsRoot": "0x3f2e1d0c9b8a7f6e5d4c3b2a1f0e9d8c7b6a5f4e3d2c1b0a9f8e7d6c5b4a3f2e",
+  "logsBloom": "0x00000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000",
+  "difficulty": "0x0",
+  "totalDifficulty": "0x0",
+  "number": "0xbc614e",
+  "gasLimit": "0x1c9c380",
+  "gasUsed": "0x1315cb",
+  "timestamp": "0x65f1b057",
+  "extraData": "0x",
+  "mixHash": "0x0000000000000000000000000000000000000000000000000000000000000000",
+  "nonce": "0x0000000000000000",
+  "baseFeePerGas": "0xfc",
+  "withdrawalsRoot": "0x7ea1e1a1b87d1e0a3fbb3ec4e4c02d1d5b47e0c3c6b9e7d1c5e2d4b3a2f1e0d9",
+  "blobGasUsed": "0x60000",
+  "excessBlobGas": "0x4bc0000",
+  "parentBeaconBlockRoot": "0x5b1c2c2fdd7a3dca5e0b0a9bd7fa8b3e08e9def6d1c2f3a4b5c6d7e8f9a0b1c2",
+  "hash": "0xc20788be295c802e5a76f328224a37a7b816cedb6467999e9452c5d44fc262b7",
+  "size": "0x3e8",
+  "transactions": ["0x5e9b2c1f0a3d4e6b7c8d9e0f1a2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b"],
+  "uncles": [],
+  "withdrawals": []
+}`
+
+func TestMessageSafetyCancunHeader(t *testing.T) {
+	var header *types.Header
+	require.NoError(t, json.Unmarshal([]byte(cancunHeader), &header))
+	require.Equal(t, uint64(1710338135), header.Time)
+	require.Equal(t, uint64(0x60000), *header.BlobGasUsed)
+	// the hash covers the post-Cancun fields, which are all decoded
+	blockHash := common.HexToHash("0xc20788be295c802e5a76f328224a37a7b816cedb6467999e9452c5d44fc262b7")
+	require.Equal(t, blockHash, header.Hash())
+
+	peer := newStubRPC()
+	peer.rawHeaders = map[uint64]json.RawMessage{12345678: json.RawMessage(cancunHeader)}
+	log := testLog(common.Address{0xaa}, []byte{0x01})
+	log.BlockNumber, log.BlockHash = 12345678, blockHash
+	peer.logs[12345678] = []types.Log{log}
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	setHeads(b, chainId, &eth.L1BlockRef{Number: 12345678, Time: 1710338135}, nil, nil)
+	id := MessageIdentifier{Origin: common.Address{0xaa}, BlockNumber: big.NewInt(12345678), Timestamp: 1710338135,
+		ChainId: big.NewInt(900), BlockHash: &blockHash}
+	label, err := b.MessageSafety(context.Background(), id, MessagePayloadBytes(&log))
+	require.NoError(t, err)
+	require.Equal(t, Finalized, label)
+}
+
+func TestMessageSafetyFromLog(t *testing.T) {
+	peer := newStubRPC()
+	peer.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x01}))
+	log := peer.logs[10][0]
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	setHeads(b, chainId, &eth.L1BlockRef{Number: 10, Time: 100}, nil, nil)
+	id, payload := testMessage(900, peer, 10, 0)
+
+	label, err := b.MessageSafetyFromLog(context.Background(), id, payload, &log, 100)
+	require.NoError(t, err)
+	require.Equal(t, Finalized, label)
+	pinned := id
+	pinned.BlockHash = &log.BlockHash
+	label, err = b.MessageSafetyFromLog(context.Background(), pinned, payload, &log, 100)
+	require.NoError(t, err)
+	require.Equal(t, Finalized, label)
+
+	for _, test := range []struct {
+		name      string
+		id        MessageIdentifier
+		payload   hexutil.Bytes
+		log       *types.Log
+		blockTime uint64
+		err       string
+	}{
+		{"PayloadMismatch", id, hexutil.Bytes{0xff}, &log, 100, "payload bytes mismatch"},
+		{"TimestampMismatch", id, payload, &log, 102, "timestamp mismatch"},
+		{"NoLog", id, payload, nil, 100, "no logs emitted"},
+		{"UnknownChain", MessageIdentifier{ChainId: big.NewInt(901), BlockNumber: big.NewInt(10), Timestamp: 100}, payload, &log, 100, "not configured"},
+		{"BlockHashMismatch", MessageIdentifier{ChainId: id.ChainId, BlockNumber: id.BlockNumber, Timestamp: 100, BlockHash: &common.Hash{0x01}}, payload, &log, 100, "block hash mismatch"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			label, err := b.MessageSafetyFromLog(context.Background(), test.id, test.payload, test.log, test.blockTime)
+			require.ErrorContains(t, err, test.err)
+			require.Equal(t, Invalid, label)
+		})
+	}
+
+	// no request reaches the peer, and the results against the given logs are not cached
+	require.Zero(t, peer.batchCalls)
+	label, err = b.MessageSafety(context.Background(), id, payload)
+	require.NoError(t, err)
+	require.Equal(t, Finalized, label)
+	require.Equal(t, 1, peer.batchCalls)
+}
+
+func TestMessageSafetyMalformedLog(t *testing.T) {
+	origin := common.Address{0xaa}
+	peer := newStubRPC()
+	peer.addBlock(10, 100, types.Log{Address: origin, Topics: []common.Hash{}, Data: []byte{0x01}})
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	setHeads(b, chainId, &eth.L1BlockRef{Number: 10, Time: 100}, nil, nil)
+
+	// the payload of a log without topics is just the data
+	id, payload := testMessage(900, peer, 10, 0)
+	require.Equal(t, hexutil.Bytes{0x01}, payload)
+	res, err := b.MessageSafetyDetails(context.Background(), id, payload)
+	require.ErrorContains(t, err, "malformed message log")
+	require.Equal(t, Invalid, res.Label)
+	require.Equal(t, ReasonMalformedLog, res.Reason)
+}
+
+func TestMessageSafetyMessageTopic(t *testing.T) {
+	origin := common.Address{0xaa}
+	peer := newStubRPC()
+	other := types.Log{Address: origin, Topics: []common.Hash{{0x02}}, Data: []byte{0x02}}
+	peer.addBlock(10, 100, testLog(origin, []byte{0x01}), other)
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	setHeads(b, chainId, nil, nil, &eth.L1BlockRef{Number: 10, Time: 100})
+
+	t.Run("Unset", func(t *testing.T) {
+		id, payload := testMessage(900, peer, 10, 1)
+		label, err := b.MessageSafety(context.Background(), id, payload)
+		require.NoError(t, err)
+		require.Equal(t, Unsafe, label)
+		require.Nil(t, peer.filterTopics[len(peer.filterTopics)-1])
+	})
+
+	b.messageTopic = common.Hash{0x01}
+	t.Run("Matching", func(t *testing.T) {
+		id, payload := testMessage(900, peer, 10, 0)
+		label, err := b.MessageSafety(context.Background(), id, payload)
+		require.NoError(t, err)
+		require.Equal(t, Unsafe, label)
+		require.Equal(t, [][]common.Hash{{{0x01}}}, peer.filterTopics[len(peer.filterTopics)-1])
+	})
+
+	t.Run("OtherTopic", func(t *testing.T) {
+		// the log with another first topic is not fetched
+		id, payload := testMessage(900, peer, 10, 1)
+		res, err := b.MessageSafetyDetails(context.Background(), id, payload)
+		require.Error(t, err)
+		require.Equal(t, ReasonLogIndexOutOfRange, res.Reason)
+	})
+}
+
+func TestMessageSafetyOriginAllowlist(t *testing.T) {
+	allowed, other := common.Address{0xaa}, common.Address{0xbb}
+	peerA := newStubRPC()
+	peerA.addBlock(10, 100, testLog(allowed, []byte{0x01}), testLog(other, []byte{0x02}))
+	peerB := newStubRPC()
+	peerB.addBlock(10, 100, testLog(other, []byte{0x03}))
+
+	chainA, chainB := ChainIDFromUInt64(900), ChainIDFromUInt64(901)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainA: peerA, chainB: peerB})
+	b.originAllowlists = originAllowlists(map[ChainID][]common.Address{chainA: {allowed}, chainB: {}})
+	for _, chainId := range []ChainID{chainA, chainB} {
+		setHeads(b, chainId, &eth.L1BlockRef{Number: 10, Time: 100}, nil, nil)
+	}
+
+	t.Run("Allowed", func(t *testing.T) {
+		id, payload := testMessage(900, peerA, 10, 0)
+		label, err := b.MessageSafety(context.Background(), id, payload)
+		require.NoError(t, err)
+		require.Equal(t, Finalized, label)
+	})
+
+	t.Run("NotAllowed", func(t *testing.T) {
+		batchCalls := peerA.batchCalls
+		id, payload := testMessage(900, peerA, 10, 1)
+		res, err := b.MessageSafetyDetails(context.Background(), id, payload)
+		require.ErrorContains(t, err, "is not allowlisted on chain id 900")
+		require.Equal(t, invalidResult(ReasonOriginNotAllowed), res)
+		labels, err := b.MessageSafetyBatch(context.Background(), []MessageIdentifier{id}, []hexutil.Bytes{payload})
+		require.ErrorContains(t, err, "message 0: origin")
+		require.Equal(t, []MessageSafetyLabel{Invalid}, labels)
+		require.Equal(t, batchCalls, peerA.batchCalls)
+	})
+
+	t.Run("EmptyAllowlist", func(t *testing.T) {
+		// any origin is permitted on the chain
+		id, payload := testMessage(901, peerB, 10, 0)
+		label, err := b.MessageSafety(context.Background(), id, payload)
+		require.NoError(t, err)
+		require.Equal(t, Finalized, label)
+	})
+}
+
+func TestMessageSafetyEmptyPayload(t *testing.T) {
+	peer := newStubRPC()
+	peer.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x01}))
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	setHeads(b, chainId, &eth.L1BlockRef{Number: 10, Time: 100}, nil, nil)
+
+	id, _ := testMessage(900, peer, 10, 0)
+	for _, payload := range []hexutil.Bytes{nil, {}} {
+		res, err := b.MessageSafetyDetails(context.Background(), id, payload)
+		require.ErrorIs(t, err, ErrEmptyPayload)
+		require.Equal(t, invalidResult(ReasonEmptyPayload), res)
+	}
+	labels, err := b.MessageSafetyBatch(context.Background(), []MessageIdentifier{id}, []hexutil.Bytes{nil})
+	require.ErrorIs(t, err, ErrEmptyPayload)
+	require.Equal(t, []MessageSafetyLabel{Invalid}, labels)
+
+	// the message is rejected without a request to the peer
+	require.Zero(t, peer.batchCalls)
+}
+
+func TestMessageSafetyInvalidTimestamp(t *testing.T) {
+	peer := newStubRPC()
+	peer.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x01}))
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	setHeads(b, chainId, &eth.L1BlockRef{Number: 10, Time: 100}, nil, nil)
+	b.now = func() time.Time { return time.Unix(1000, 0) }
+	id, payload := testMessage(900, peer, 10, 0)
+
+	for _, test := range []struct {
+		name      string
+		timestamp uint64
+		err       string
+	}{
+		{"Zero", 0, "invalid timestamp: zero"},
+		{"FarFuture", 1000 + 3600 + 1, "invalid timestamp: 4601 is more than 1h0m0s ahead of the current time 1000"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			id := id
+			id.Timestamp = test.timestamp
+			res, err := b.MessageSafetyDetails(context.Background(), id, payload)
+			require.EqualError(t, err, test.err)
+			require.Equal(t, invalidResult(ReasonInvalidTimestamp), res)
+
+			labels, err := b.MessageSafetyBatch(context.Background(), []MessageIdentifier{id}, []hexutil.Bytes{payload})
+			require.ErrorContains(t, err, test.err)
+			require.Equal(t, []MessageSafetyLabel{Invalid}, labels)
+		})
+	}
+	// the timestamps are rejected before any request to the peer
+	require.Zero(t, peer.batchCalls)
+
+	// a timestamp within the drift is compared against the block
+	id.Timestamp = 1000 + 3600
+	res, err := b.MessageSafetyDetails(context.Background(), id, payload)
+	require.ErrorContains(t, err, "timestamp mismatch")
+	require.Equal(t, ReasonTimestampMismatch, res.Reason)
+}
+
+func TestMessageSafetyOriginProxy(t *testing.T) {
+	proxy, impl, other := common.Address{0xaa}, common.Address{0xa1}, common.Address{0xbb}
+	peer := newStubRPC()
+	peer.addBlock(10, 100, testLog(proxy, []byte{0x01}), testLog(other, []byte{0x02}))
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	setHeads(b, chainId, &eth.L1BlockRef{Number: 10, Time: 100}, nil, nil)
+	id, payload := testMessage(900, peer, 10, 0)
+	implId := id
+	implId.Origin = impl
+
+	t.Run("ExactMatchWithoutProxies", func(t *testing.T) {
+		res, err := b.MessageSafetyDetails(context.Background(), implId, payload)
+		require.ErrorContains(t, err, "no logs emitted by 0xA100000000000000000000000000000000000000")
+		require.Equal(t, ReasonNoLogs, res.Reason)
+	})
+
+	b.originProxies = originProxies(map[ChainID]map[common.Address]common.Address{chainId: {proxy: impl}})
+	b.originAllowlists = originAllowlists(map[ChainID][]common.Address{chainId: {proxy}})
+	for _, test := range []struct {
+		name string
+		id   MessageIdentifier
+	}{
+		{"Proxy", id},
+		{"Implementation", implId},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			label, err := b.MessageSafety(context.Background(), test.id, payload)
+			require.NoError(t, err)
+			require.Equal(t, Finalized, label)
+
+			labels, err := b.MessageSafetyBatch(context.Background(), []MessageIdentifier{test.id}, []hexutil.Bytes{payload})
+			require.NoError(t, err)
+			require.Equal(t, []MessageSafetyLabel{Finalized}, labels)
+
+			log := peer.logs[10][0]
+			label, err = b.MessageSafetyFromLog(context.Background(), test.id, payload, &log, 100)
+			require.NoError(t, err)
+			require.Equal(t, Finalized, label)
+		})
+	}
+
+	t.Run("OtherOrigin", func(t *testing.T) {
+		// the logs of unrelated origins are not matched by the implementation
+		id, payload := testMessage(900, peer, 10, 1)
+		id.Origin = impl
+		res, err := b.MessageSafetyDetails(context.Background(), id, payload)
+		require.Error(t, err)
+		require.Equal(t, ReasonLogIndexOutOfRange, res.Reason)
+	})
+}
+
+func TestMessageSafetyMaxPayloadSize(t *testing.T) {
+	peer := newStubRPC()
+	peer.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x01}))
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	setHeads(b, chainId, &eth.L1BlockRef{Number: 10, Time: 100}, nil, nil)
+	id, payload := testMessage(900, peer, 10, 0)
+	b.maxPayloadSize = len(payload)
+
+	label, err := b.MessageSafety(context.Background(), id, payload)
+	require.NoError(t, err)
+	require.Equal(t, Finalized, label)
+	calls := peer.batchCalls
+
+	oversized := make(hexutil.Bytes, 1<<20)
+	res, err := b.MessageSafetyDetails(context.Background(), id, oversized)
+	require.ErrorIs(t, err, ErrPayloadTooLarge)
+	require.ErrorContains(t, err, fmt.Sprintf("1048576 bytes, at most %d", len(payload)))
+	require.Equal(t, invalidResult(ReasonPayloadTooLarge), res)
+
+	labels, err := b.MessageSafetyBatch(context.Background(), []MessageIdentifier{id, id}, []hexutil.Bytes{oversized, payload})
+	require.ErrorIs(t, err, ErrPayloadTooLarge)
+	require.Equal(t, []MessageSafetyLabel{Invalid, Finalized}, labels)
+	// the oversized payloads are rejected before any request to the peer
+	require.Equal(t, calls, peer.batchCalls)
+}
+
+func TestMessageSafetyMaxLogIndex(t *testing.T) {
+	peer := newStubRPC()
+	peer.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x01}))
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	setHeads(b, chainId, &eth.L1BlockRef{Number: 10, Time: 100}, nil, nil)
+
+	id, payload := testMessage(900, peer, 10, 0)
+	for _, index := range []uint64{maxLogIndex + 1, math.MaxUint64} {
+		id.LogIndex = index
+		res, err := b.MessageSafetyDetails(context.Background(), id, payload)
+		require.ErrorContains(t, err, "exceeds the maximum log index")
+		require.Equal(t, invalidResult(ReasonLogIndexOutOfRange), res)
+	}
+	labels, err := b.MessageSafetyBatch(context.Background(), []MessageIdentifier{id}, []hexutil.Bytes{payload})
+	require.ErrorContains(t, err, "message 0: invalid log index")
+	require.Equal(t, []MessageSafetyLabel{Invalid}, labels)
+
+	// the message is rejected without a request to the peer
+	require.Zero(t, peer.batchCalls)
+}
+
+func TestMessageSafetyLogsInvalidMessage(t *testing.T) {
+	origin := common.Address{0xaa}
+	peer := newStubRPC()
+	peer.addBlock(10, 100, testLog(origin, []byte{0x01}))
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	logger, logs := testlog.CaptureLogger(t, log.LevelInfo)
+	b.log = logger
+	setHeads(b, chainId, &eth.L1BlockRef{Number: 10, Time: 100}, nil, nil)
+
+	t.Run("PayloadMismatch", func(t *testing.T) {
+		logs.Clear()
+		id, payload := testMessage(900, peer, 10, 0)
+		wrongPayload := hexutil.Bytes(make([]byte, 256))
+		_, err := b.MessageSafety(context.Background(), id, wrongPayload)
+		require.Error(t, err)
+
+		record := logs.FindLog(testlog.NewLevelFilter(log.LevelWarn), testlog.NewMessageFilter("invalid message"))
+		require.NotNil(t, record)
+		require.Equal(t, ReasonPayloadMismatch, record.AttrValue("reason"))
+		require.Equal(t, origin, record.AttrValue("expected_origin"))
+		require.Equal(t, origin, record.AttrValue("actual_origin"))
+		require.Equal(t, uint64(100), record.AttrValue("expected_timestamp"))
+		require.Equal(t, uint64(100), record.AttrValue("actual_timestamp"))
+		require.Equal(t, crypto.Keccak256Hash(wrongPayload), record.AttrValue("expected_payload_hash"))
+		require.Equal(t, crypto.Keccak256Hash(payload), record.AttrValue("actual_payload_hash"))
+		record.Attrs(func(attr slog.Attr) bool {
+			require.NotContains(t, attr.Value.String(), wrongPayload.String()[2:], attr.Key)
+			return true
+		})
+	})
+
+	t.Run("TimestampMismatch", func(t *testing.T) {
+		logs.Clear()
+		id, payload := testMessage(900, peer, 10, 0)
+		id.Timestamp = 101
+		_, err := b.MessageSafety(context.Background(), id, payload)
+		require.Error(t, err)
+		record := logs.FindLog(testlog.NewMessageFilter("invalid message"))
+		require.NotNil(t, record)
+		require.Equal(t, ReasonTimestampMismatch, record.AttrValue("reason"))
+		require.Equal(t, uint64(101), record.AttrValue("expected_timestamp"))
+		require.Equal(t, uint64(100), record.AttrValue("actual_timestamp"))
+	})
+
+	t.Run("UnknownPeer", func(t *testing.T) {
+		logs.Clear()
+		id, payload := testMessage(900, peer, 10, 0)
+		id.ChainId = big.NewInt(901)
+		_, err := b.MessageSafety(context.Background(), id, payload)
+		require.Error(t, err)
+		record := logs.FindLog(testlog.NewMessageFilter("invalid message"))
+		require.NotNil(t, record)
+		require.Equal(t, ReasonPeerNotConfigured, record.AttrValue("reason"))
+		require.Nil(t, record.AttrValue("actual_origin"))
+	})
+}
+
+func TestMessageSafetyExpiry(t *testing.T) {
+	origin := common.Address{0xaa}
+	peer := newStubRPC()
+	peer.addBlock(10, 100, testLog(origin, []byte{0x01}))
+	peer.addBlock(11, 101, testLog(origin, []byte{0x02}))
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	b.expiryWindow = 100 * time.Second
+	finalized := &eth.L1BlockRef{Number: 11, Time: 101}
+	setHeads(b, chainId, finalized, finalized, &eth.L1BlockRef{Number: 111, Time: 201})
+
+	t.Run("InWindow", func(t *testing.T) {
+		id, payload := testMessage(900, peer, 11, 0)
+		res, err := b.MessageSafetyDetails(context.Background(), id, payload)
+		require.NoError(t, err)
+		require.Equal(t, Finalized, res.Label)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		id, payload := testMessage(900, peer, 10, 0)
+		res, err := b.MessageSafetyDetails(context.Background(), id, payload)
+		require.ErrorContains(t, err, "expired message")
+		require.Equal(t, Invalid, res.Label)
+		require.Equal(t, ReasonExpired, res.Reason)
+
+		labels, err := b.MessageSafetyBatch(context.Background(), []MessageIdentifier{id}, []hexutil.Bytes{payload})
+		require.ErrorContains(t, err, "message 0: expired message")
+		require.Equal(t, []MessageSafetyLabel{Invalid}, labels)
+	})
+
+	t.Run("CachedThenExpired", func(t *testing.T) {
+		// the finalized message in the cache expires once the chain advances
+		setHeads(b, chainId, finalized, finalized, &eth.L1BlockRef{Number: 112, Time: 202})
+		id, payload := testMessage(900, peer, 11, 0)
+		res, err := b.MessageSafetyDetails(context.Background(), id, payload)
+		require.ErrorContains(t, err, "expired message")
+		require.Equal(t, ReasonExpired, res.Reason)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		b.expiryWindow = 0
+		id, payload := testMessage(900, peer, 10, 0)
+		label, err := b.MessageSafety(context.Background(), id, payload)
+		require.NoError(t, err)
+		require.Equal(t, Finalized, label)
+	})
+}
+
+func TestMessageSafetyBatch(t *testing.T) {
+	originA, originB := common.Address{0xaa}, common.Address{0xbb}
+	peerA := newStubRPC()
+	peerA.addBlock(10, 100, testLog(originA, []byte{0x01}), testLog(originB, []byte{0x02}))
+	peerA.addBlock(11, 102, testLog(originA, []byte{0x03}))
+	peerB := newStubRPC()
+	peerB.addBlock(20, 101, testLog(originB, []byte{0x04}))
+
+	b := newTestBackend(t, map[ChainID]client.RPC{ChainIDFromUInt64(900): peerA, ChainIDFromUInt64(901): peerB})
+	for _, chainId := range []uint64{900, 901} {
+		setHeads(b, ChainIDFromUInt64(chainId), &eth.L1BlockRef{Time: 100}, &eth.L1BlockRef{Time: 101}, &eth.L1BlockRef{Time: 102})
+	}
+
+	var ids []MessageIdentifier
+	var payloads []hexutil.Bytes
+	add := func(id MessageIdentifier, payload hexutil.Bytes) {
+		ids = append(ids, id)
+		payloads = append(payloads, payload)
+	}
+	add(testMessage(900, peerA, 11, 0))
+	add(testMessage(901, peerB, 20, 0))
+	add(testMessage(900, peerA, 10, 1))
+	id, _ := testMessage(900, peerA, 10, 0)
+	add(id, hexutil.Bytes{0xff})
+	id, payload := testMessage(901, peerB, 20, 0)
+	id.ChainId = big.NewInt(902)
+	add(id, payload)
+	add(testMessage(900, peerA, 10, 0))
+
+	labels, err := b.MessageSafetyBatch(context.Background(), ids, payloads)
+	require.ErrorContains(t, err, "message 3: payload bytes mismatch")
+	require.ErrorContains(t, err, "message 4: peer with chain id 902 is not configured")
+	require.Equal(t, []MessageSafetyLabel{Unsafe, CrossSafe, Finalized, Invalid, Invalid, Finalized}, labels)
+
+	// a single batch request per peer chain
+	require.Equal(t, 1, peerA.batchCalls)
+	require.Equal(t, 1, peerB.batchCalls)
+
+	_, err = b.MessageSafetyBatch(context.Background(), ids, payloads[1:])
+	require.ErrorContains(t, err, "mismatched number")
+}
+
+func TestMessageSafetyBatchPartialResults(t *testing.T) {
+	origin := common.Address{0xaa}
+	good := newStubRPC()
+	good.addBlock(10, 100, testLog(origin, []byte{0x01}))
+	unreachable := newStubRPC()
+	unreachable.addBlock(20, 100, testLog(origin, []byte{0x02}))
+	unreachable.err = errors.New("connection refused")
+
+	b := newTestBackend(t, map[ChainID]client.RPC{ChainIDFromUInt64(900): good, ChainIDFromUInt64(901): unreachable})
+	for _, chainId := range []uint64{900, 901} {
+		setHeads(b, ChainIDFromUInt64(chainId), &eth.L1BlockRef{Time: 100}, nil, nil)
+	}
+
+	idA, payloadA := testMessage(900, good, 10, 0)
+	idB, payloadB := testMessage(901, unreachable, 20, 0)
+	labels, err := b.MessageSafetyBatch(context.Background(),
+		[]MessageIdentifier{idB, idA, idB}, []hexutil.Bytes{payloadB, payloadA, payloadB})
+	// the results of the good chain are returned alongside the errors of the unreachable chain
+	require.Equal(t, []MessageSafetyLabel{Invalid, Finalized, Invalid}, labels)
+	require.ErrorContains(t, err, "message 0: unable to request logs: connection refused")
+	require.ErrorContains(t, err, "message 2: unable to request logs: connection refused")
+	require.NotContains(t, err.Error(), "message 1")
+}
+
+func TestMessageSafetyBatchSplit(t *testing.T) {
+	origin := common.Address{0xaa}
+	peer := newStubRPC()
+	var ids []MessageIdentifier
+	var payloads []hexutil.Bytes
+	for num := uint64(10); num < 15; num++ {
+		peer.addBlock(num, 100+num, testLog(origin, []byte{byte(num)}))
+		id, payload := testMessage(900, peer, num, 0)
+		ids = append(ids, id)
+		payloads = append(payloads, payload)
+	}
+
+	b := newTestBackend(t, map[ChainID]client.RPC{ChainIDFromUInt64(900): peer})
+	b.maxBatchSize = 4
+	setHeads(b, ChainIDFromUInt64(900), &eth.L1BlockRef{Number: 12, Time: 112}, nil, &eth.L1BlockRef{Number: 14, Time: 114})
+
+	labels, err := b.MessageSafetyBatch(context.Background(), ids, payloads)
+	require.NoError(t, err)
+	require.Equal(t, []MessageSafetyLabel{Finalized, Finalized, Finalized, Unsafe, Unsafe}, labels)
+	// the header and logs requests of the 5 blocks are split into batches of at most 4 requests
+	require.Equal(t, []int{4, 4, 2}, peer.batchSizes)
+}
+
+func BenchmarkMessageSafetyBatch(b *testing.B) {
+	origin := common.Address{0xaa}
+	peers := make(map[ChainID]client.RPC)
+	var ids []MessageIdentifier
+	var payloads []hexutil.Bytes
+	for chainId := uint64(900); chainId < 903; chainId++ {
+		peer := newStubRPC()
+		peer.delay = time.Millisecond * 5
+		peer.addBlock(10, 100, testLog(origin, []byte{0x01}), testLog(origin, []byte{0x02}))
+		peers[ChainIDFromUInt64(chainId)] = peer
+		for logIndex := uint64(0); logIndex < 2; logIndex++ {
+			id, payload := testMessage(chainId, peer, 10, logIndex)
+			ids = append(ids, id)
+			payloads = append(payloads, payload)
+		}
+	}
+
+	for _, bench := range []struct {
+		name        string
+		concurrency int
+	}{{"serial", 1}, {"parallel", 3}} {
+		b.Run(bench.name, func(b *testing.B) {
+			backend := newTestBackend(b, peers)
+			backend.log = testlog.Logger(b, log.LevelError)
+			backend.batchConcurrency = bench.concurrency
+			for chainId := range peers {
+				// unsafe messages are not cached
+				setHeads(backend, chainId, nil, nil, &eth.L1BlockRef{Number: 10, Time: 100})
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				labels, err := backend.MessageSafetyBatch(context.Background(), ids, payloads)
+				if err != nil || labels[0] != Unsafe {
+					b.Fatalf("unexpected result: %v, %v", labels, err)
+				}
+			}
+		})
+	}
+}
+
+// serialRPC serves a single request at a time, like a connection to a provider that does not process the
+// requests of a connection concurrently.
+type serialRPC struct {
+	client.RPC
+	mu sync.Mutex
+}
+
+func (s *serialRPC) BatchCallContext(ctx context.Context, b []rpc.BatchElem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.RPC.BatchCallContext(ctx, b)
+}
+
+func BenchmarkMessageSafetyConnPool(b *testing.B) {
+	peer := newStubRPC()
+	peer.delay = time.Millisecond
+	peer.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x01}))
+	id, payload := testMessage(900, peer, 10, 0)
+	dial := func(ctx context.Context, addr string) (client.RPC, error) {
+		return &serialRPC{RPC: peer}, nil
+	}
+
+	for _, size := range []int{1, 4} {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			pool, err := pooledDialer(dial, size)(context.Background(), "ws://peer")
+			require.NoError(b, err)
+			chainId := ChainIDFromUInt64(900)
+			backend := newTestBackend(b, map[ChainID]client.RPC{chainId: pool})
+			backend.log = testlog.Logger(b, log.LevelError)
+			// unsafe messages are not cached
+			setHeads(backend, chainId, nil, nil, &eth.L1BlockRef{Number: 10, Time: 100})
+			b.SetParallelism(4)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					label, err := backend.MessageSafety(context.Background(), id, payload)
+					if err != nil || label != Unsafe {
+						b.Errorf("unexpected result: %v, %v", label, err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestClose(t *testing.T) {
+	peer := newStubRPC()
+	peer.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x01}))
+	l2Node := newStubRPC()
+	b := newTestBackend(t, map[ChainID]client.RPC{ChainIDFromUInt64(900): peer})
+	b.l2Node = l2Node
+	setHeads(b, ChainIDFromUInt64(900), &eth.L1BlockRef{Time: 100}, nil, nil)
+	id, payload := testMessage(900, peer, 10, 0)
+
+	var wg sync.WaitGroup
+	labels := make([]MessageSafetyLabel, 10)
+	errs := make([]error, 10)
+	closeErrs := make([]error, 10)
+	for i := 0; i < 10; i++ {
+		i := i
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			labels[i], errs[i] = b.MessageSafety(context.Background(), id, payload)
+		}()
+		go func() {
+			defer wg.Done()
+			closeErrs[i] = b.Close()
+		}()
+	}
+	wg.Wait()
+	for i := range labels {
+		require.NoError(t, closeErrs[i])
+		if errs[i] != nil {
+			require.ErrorIs(t, errs[i], ErrBackendClosed)
+		} else {
+			require.Equal(t, Finalized, labels[i])
+		}
+	}
+
+	require.Equal(t, 1, peer.closed)
+	require.Equal(t, 1, l2Node.closed)
+	_, err := b.MessageSafety(context.Background(), id, payload)
+	require.ErrorIs(t, err, ErrBackendClosed)
+}
+
+func TestMessageSafetyLargeChainId(t *testing.T) {
+	// the large chain id truncates to the chain id of the other peer
+	largeChainId := new(big.Int).Add(new(big.Int).Lsh(big.NewInt(1), 64), big.NewInt(900))
+	require.Equal(t, uint64(900), largeChainId.Uint64())
+	largeId, ok := ChainIDFromBig(largeChainId)
+	require.True(t, ok)
+
+	peer := newStubRPC()
+	peer.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x01}))
+	other := newStubRPC()
+	b := newTestBackend(t, map[ChainID]client.RPC{largeId: peer, ChainIDFromUInt64(900): other})
+	setHeads(b, largeId, &eth.L1BlockRef{Time: 100}, nil, nil)
+
+	id, payload := testMessage(900, peer, 10, 0)
+	id.ChainId = largeChainId
+	label, err := b.MessageSafety(context.Background(), id, payload)
+	require.NoError(t, err)
+	require.Equal(t, Finalized, label)
+	require.Equal(t, 0, other.batchCalls)
+
+	id.ChainId = new(big.Int).Add(largeChainId, big.NewInt(1))
+	_, err = b.MessageSafety(context.Background(), id, payload)
+	require.ErrorContains(t, err, "not configured")
+
+	id.ChainId = new(big.Int).Lsh(big.NewInt(1), 256)
+	_, err = b.MessageSafety(context.Background(), id, payload)
+	require.ErrorContains(t, err, "invalid chain id")
+}
+
+func TestMessageSafetyRPCTimeout(t *testing.T) {
+	peer := newStubRPC()
+	peer.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x01}))
+	b := newTestBackend(t, map[ChainID]client.RPC{ChainIDFromUInt64(900): peer})
+	setHeads(b, ChainIDFromUInt64(900), &eth.L1BlockRef{Time: 100}, nil, nil)
+	b.rpcTimeout = 10 * time.Millisecond
+	id, payload := testMessage(900, peer, 10, 0)
+
+	peer.delay = time.Second
+	label, err := b.MessageSafety(context.Background(), id, payload)
+	require.ErrorIs(t, err, ErrRPCTimeout)
+	require.Equal(t, Invalid, label)
+
+	// validation failures are not timeouts
+	peer.delay = 0
+	_, err = b.MessageSafety(context.Background(), id, hexutil.Bytes{0xff})
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrRPCTimeout)
+
+	label, err = b.MessageSafety(context.Background(), id, payload)
+	require.NoError(t, err)
+	require.Equal(t, Finalized, label)
+}
+
+func TestMessageSafetyPeerTimeout(t *testing.T) {
+	slow, fast := newStubRPC(), newStubRPC()
+	slow.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x01}))
+	fast.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x01}))
+	slow.delay, fast.delay = 50*time.Millisecond, 50*time.Millisecond
+	b := newTestBackend(t, map[ChainID]client.RPC{ChainIDFromUInt64(900): slow, ChainIDFromUInt64(901): fast})
+	setHeads(b, ChainIDFromUInt64(900), &eth.L1BlockRef{Time: 100}, nil, nil)
+	setHeads(b, ChainIDFromUInt64(901), &eth.L1BlockRef{Time: 100}, nil, nil)
+	b.rpcTimeout = 10 * time.Millisecond
+	// the slow peer gets a longer budget, the other peer keeps the default
+	b.peerTimeouts = map[ChainID]time.Duration{ChainIDFromUInt64(900): 5 * time.Second}
+	require.Equal(t, 5*time.Second, b.pollTimeoutOf(ChainIDFromUInt64(900)))
+	require.Equal(t, defaultPollTimeout, b.pollTimeoutOf(ChainIDFromUInt64(901)))
+
+	id, payload := testMessage(900, slow, 10, 0)
+	label, err := b.MessageSafety(context.Background(), id, payload)
+	require.NoError(t, err)
+	require.Equal(t, Finalized, label)
+
+	id, payload = testMessage(901, fast, 10, 0)
+	label, err = b.MessageSafety(context.Background(), id, payload)
+	require.ErrorIs(t, err, ErrRPCTimeout)
+	require.ErrorContains(t, err, "after 10ms")
+	require.Equal(t, Invalid, label)
+}
+
+func TestMessageSafetyMaxConcurrentRPCs(t *testing.T) {
+	// both chains are served by the same peer, to observe the combined requests
+	peer := newStubRPC()
+	peer.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x01}))
+	peer.delay = time.Millisecond * 5
+	chainA, chainB := ChainIDFromUInt64(900), ChainIDFromUInt64(901)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainA: peer, chainB: peer})
+	for _, chainId := range []ChainID{chainA, chainB} {
+		// unsafe results are not cached
+		setHeads(b, chainId, nil, nil, &eth.L1BlockRef{Number: 10, Time: 100})
+	}
+	b.rpcSlots = semaphore.NewWeighted(2)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		for _, chainId := range []uint64{900, 901} {
+			id, payload := testMessage(chainId, peer, 10, 0)
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				_, err := b.MessageSafety(context.Background(), id, payload)
+				require.NoError(t, err)
+			}()
+			go func() {
+				defer wg.Done()
+				_, err := b.MessageSafetyBatch(context.Background(), []MessageIdentifier{id}, []hexutil.Bytes{payload})
+				require.NoError(t, err)
+			}()
+		}
+	}
+	wg.Wait()
+	require.Equal(t, 40, peer.batchCalls)
+	require.Equal(t, 2, peer.maxInFlight)
+
+	// waiting callers give up with their context, without error of the peer
+	require.NoError(t, b.rpcSlots.Acquire(context.Background(), 2))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	id, payload := testMessage(900, peer, 10, 0)
+	_, err := b.MessageSafety(ctx, id, payload)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.ErrorContains(t, err, "waiting for an in-flight rpc slot")
+	require.Equal(t, 40, peer.batchCalls)
+}
+
+func TestMessageSafetyRetry(t *testing.T) {
+	origin := common.Address{0xaa}
+	peer := newStubRPC()
+	peer.addBlock(10, 100, testLog(origin, []byte{0x01}))
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	b.rpcRetries = 2
+	b.rpcRetryDelay = time.Millisecond
+	setHeads(b, chainId, &eth.L1BlockRef{Number: 10, Time: 100}, nil, nil)
+	id, payload := testMessage(900, peer, 10, 0)
+
+	t.Run("SucceedsAfterRetries", func(t *testing.T) {
+		peer.batchCalls = 0
+		tooManyRequests := &rpc.HTTPError{StatusCode: 429, Status: "429 Too Many Requests"}
+		peer.failNext = []error{tooManyRequests, tooManyRequests}
+		label, err := b.MessageSafety(context.Background(), id, payload)
+		require.NoError(t, err)
+		require.Equal(t, Finalized, label)
+		require.Equal(t, 3, peer.batchCalls)
+	})
+
+	t.Run("ExhaustsRetries", func(t *testing.T) {
+		peer.batchCalls = 0
+		peer.err = testRPCError{}
+		defer func() { peer.err = nil }()
+		id, payload := testMessage(900, peer, 10, 0)
+		id.Timestamp++ // not cached
+		label, err := b.MessageSafety(context.Background(), id, payload)
+		require.ErrorContains(t, err, "internal error")
+		require.Equal(t, Invalid, label)
+		require.Equal(t, 3, peer.batchCalls)
+	})
+
+	t.Run("MismatchNotRetried", func(t *testing.T) {
+		peer.batchCalls = 0
+		label, err := b.MessageSafety(context.Background(), id, hexutil.Bytes{0xff})
+		require.ErrorContains(t, err, "payload bytes mismatch")
+		require.Equal(t, Invalid, label)
+		require.Equal(t, 1, peer.batchCalls)
+	})
+}
+
+func TestMessageSafetyCrossSafe(t *testing.T) {
+	origin := common.Address{0xaa}
+	peerA := newStubRPC()
+	peerA.addBlock(10, 100, testLog(origin, []byte{0x01}))
+	peerA.addBlock(11, 102, testLog(origin, []byte{0x02}))
+	peerB := newStubRPC()
+
+	chainA, chainB := ChainIDFromUInt64(900), ChainIDFromUInt64(901)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainA: peerA, chainB: peerB})
+	setHeads(b, chainA, &eth.L1BlockRef{Time: 98}, &eth.L1BlockRef{Time: 102}, &eth.L1BlockRef{Time: 104})
+	setHeads(b, chainB, &eth.L1BlockRef{Time: 98}, &eth.L1BlockRef{Time: 100}, &eth.L1BlockRef{Time: 104})
+
+	check := func(blockNum uint64, expected MessageSafetyLabel) {
+		t.Helper()
+		id, payload := testMessage(900, peerA, blockNum, 0)
+		label, err := b.MessageSafety(context.Background(), id, payload)
+		require.NoError(t, err)
+		require.Equal(t, expected, label)
+	}
+
+	// the safe head of the other chain is at the timestamp of the message
+	check(10, CrossSafe)
+	// the safe head of the other chain is behind the timestamp of the message
+	check(11, Safe)
+
+	t.Run("UnknownSafeHead", func(t *testing.T) {
+		setHeads(b, chainB, nil, nil, &eth.L1BlockRef{Time: 104})
+		defer setHeads(b, chainB, &eth.L1BlockRef{Time: 98}, &eth.L1BlockRef{Time: 100}, &eth.L1BlockRef{Time: 104})
+		check(10, Safe)
+	})
+
+	t.Run("OutsideDependencySet", func(t *testing.T) {
+		b.mu.Lock()
+		b.dependencySet = map[ChainID]bool{chainA: true}
+		b.mu.Unlock()
+		defer func() {
+			b.mu.Lock()
+			b.dependencySet = nil
+			b.mu.Unlock()
+		}()
+		// chains outside of the dependency set are not considered
+		check(11, CrossSafe)
+	})
+}
+
+func TestMessageSafetyPerChainHeads(t *testing.T) {
+	origin := common.Address{0xaa}
+	peerA := newStubRPC()
+	peerA.addBlock(10, 100, testLog(origin, []byte{0x01}))
+	peerB := newStubRPC()
+	peerB.addBlock(20, 100, testLog(origin, []byte{0x02}))
+
+	b := newTestBackend(t, map[ChainID]client.RPC{ChainIDFromUInt64(900): peerA, ChainIDFromUInt64(901): peerB})
+	setHeads(b, ChainIDFromUInt64(900), &eth.L1BlockRef{Time: 100}, &eth.L1BlockRef{Time: 100}, &eth.L1BlockRef{Time: 100})
+	setHeads(b, ChainIDFromUInt64(901), &eth.L1BlockRef{Time: 96}, &eth.L1BlockRef{Time: 98}, &eth.L1BlockRef{Time: 100})
+
+	// the messages have the same timestamp, but are compared against the heads of their own chain
+	id, payload := testMessage(900, peerA, 10, 0)
+	label, err := b.MessageSafety(context.Background(), id, payload)
+	require.NoError(t, err)
+	require.Equal(t, Finalized, label)
+
+	id, payload = testMessage(901, peerB, 20, 0)
+	label, err = b.MessageSafety(context.Background(), id, payload)
+	require.NoError(t, err)
+	require.Equal(t, Unsafe, label)
+}
+
+func FuzzMessageSafety(f *testing.F) {
+	chain := newStubRPC()
+	header := chain.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x01}), testLog(common.Address{0xbb}, nil))
+	valid, validPayload := testMessage(900, chain, 10, 0)
+	for _, id := range []MessageIdentifier{valid, {}, {ChainId: big.NewInt(900)}, {BlockNumber: big.NewInt(-1), ChainId: big.NewInt(900)}} {
+		data, err := json.Marshal(id)
+		require.NoError(f, err)
+		f.Add(data, []byte(validPayload))
+	}
+	f.Add([]byte(`{"blockNumber":10,"chainId":900,"logIndex":18446744073709551615}`), []byte{})
+	f.Add([]byte(`{"blockNumber":"0x`+strings.Repeat("f", 80)+`","chainId":-900,"timestamp":100}`), []byte{0x01})
+	// no block number
+	f.Add([]byte(`{"chainId":900,"timestamp":100,"origin":"0xaa00000000000000000000000000000000000000"}`), []byte{0x01})
+
+	fetcher := NewFakeLogFetcher()
+	fetcher.SetBlock(ChainIDFromUInt64(900), header, chain.logs[10]...)
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(f, map[ChainID]client.RPC{chainId: newStubRPC()})
+	b.l2PeerNodes[chainId].logFetcher = fetcher
+	b.maxPayloadSize = 64
+	// every message is logged, which would dominate the fuzzing time
+	b.log = log.NewLogger(log.DiscardHandler())
+	setHeads(b, chainId, &eth.L1BlockRef{Number: 11, Time: 110}, &eth.L1BlockRef{Number: 10, Time: 100}, nil)
+
+	f.Fuzz(func(t *testing.T, data []byte, payload []byte) {
+		var id MessageIdentifier
+		if err := json.Unmarshal(data, &id); err != nil {
+			return
+		}
+		// the identifier must not be modified, as it is cached and logged by the backend
+		original, err := json.Marshal(id)
+		require.NoError(t, err)
+
+		label, err := b.MessageSafety(context.Background(), id, payload)
+		requireLabelOrError(t, label, err)
+		res, err := b.MessageSafetyDetails(context.Background(), id, payload)
+		requireLabelOrError(t, res.Label, err)
+		labels, err := b.MessageSafetyBatch(context.Background(), []MessageIdentifier{id, valid}, []hexutil.Bytes{payload, validPayload})
+		require.Len(t, labels, 2)
+		requireLabelOrError(t, labels[0], err)
+		require.Equal(t, Finalized, labels[1])
+		_, err = b.MessageSafetyExplain(context.Background(), id, payload)
+		if res.Label == Invalid {
+			require.Error(t, err)
+		}
+
+		after, err := json.Marshal(id)
+		require.NoError(t, err)
+		require.Equal(t, original, after)
+	})
+}
+
+// requireLabelOrError checks the label is a known label, and that Invalid labels come with an error.
+func requireLabelOrError(t *testing.T, label MessageSafetyLabel, err error) {
+	switch label {
+	case Invalid:
+		require.Error(t, err)
+	case Unsafe, Safe, CrossSafe, Finalized:
+	default:
+		t.Fatalf("unknown label %q, err: %v", label, err)
+	}
+}
+
+func TestMessageSafetyLogSpan(t *testing.T) {
+	peer := newStubRPC()
+	peer.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x01}), testLog(common.Address{0xaa}, []byte{0x02}),
+		testLog(common.Address{0xbb}, []byte{0x03}))
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	setHeads(b, chainId, &eth.L1BlockRef{Number: 10, Time: 100}, nil, nil)
+
+	id, first := testMessage(900, peer, 10, 0)
+	_, second := testMessage(900, peer, 10, 1)
+	span := id
+	span.LogCount = 2
+	payload := append(append(hexutil.Bytes{}, first...), second...)
+
+	res, err := b.MessageSafetyDetails(context.Background(), span, payload)
+	require.NoError(t, err)
+	require.Equal(t, Finalized, res.Label)
+	require.Equal(t, uint(0), res.Log.Index)
+
+	// the payload of the span is not the payload of its first log
+	res, err = b.MessageSafetyDetails(context.Background(), span, first)
+	require.ErrorIs(t, err, ErrIntegrityMismatch)
+	require.Equal(t, ReasonPayloadMismatch, res.Reason)
+
+	// a single log of the span matches its own payload only
+	label, err := b.MessageSafety(context.Background(), id, first)
+	require.NoError(t, err)
+	require.Equal(t, Finalized, label)
+
+	// every log of the span is emitted by the origin
+	wide := span
+	wide.LogCount = 3
+	res, err = b.MessageSafetyDetails(context.Background(), wide, append(payload, MessagePayloadBytes(&peer.logs[10][2])...))
+	require.ErrorIs(t, err, ErrIntegrityMismatch)
+	require.ErrorContains(t, err, "no log with index 2 emitted by 0xaa00000000000000000000000000000000000000")
+	require.Equal(t, ReasonLogIndexOutOfRange, res.Reason)
+
+	overflow := span
+	overflow.LogIndex, overflow.LogCount = maxLogIndex, 2
+	res, err = b.MessageSafetyDetails(context.Background(), overflow, payload)
+	require.ErrorContains(t, err, "invalid log count")
+	require.Equal(t, ReasonLogIndexOutOfRange, res.Reason)
+}
+
+func TestMessageSafetyUnknownFinality(t *testing.T) {
+	peer := newStubRPC()
+	peer.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x01}))
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	// the heads of the peer chain are not tracked
+	delete(b.l2Heads, chainId)
+	id, payload := testMessage(900, peer, 10, 0)
+
+	res, err := b.MessageSafetyDetails(context.Background(), id, payload)
+	require.NoError(t, err)
+	require.Equal(t, Unsafe, res.Label)
+	require.Equal(t, ReasonNone, res.Reason)
+
+	// Invalid is reserved for the messages that failed a check, and comes with the error of the check
+	label, err := b.MessageSafety(context.Background(), id, hexutil.Bytes{0x02})
+	require.ErrorIs(t, err, ErrIntegrityMismatch)
+	requireLabelOrError(t, label, err)
+	require.Equal(t, Invalid, label)
+}
+
+func TestMessageSafetyLabelJSON(t *testing.T) {
+	for _, label := range []MessageSafetyLabel{Invalid, Unsafe, Safe, CrossSafe, Finalized} {
+		t.Run(label.String(), func(t *testing.T) {
+			data, err := json.Marshal(label)
+			require.NoError(t, err)
+			require.Equal(t, `"`+label.String()+`"`, string(data))
+			var decoded MessageSafetyLabel
+			require.NoError(t, json.Unmarshal(data, &decoded))
+			require.Equal(t, label, decoded)
+		})
+	}
+	require.Equal(t, "cross_safe", CrossSafe.String())
+
+	var label MessageSafetyLabel
+	require.ErrorContains(t, json.Unmarshal([]byte(`"pending"`), &label), `unknown message safety label "pending"`)
+	require.ErrorContains(t, json.Unmarshal([]byte(`""`), &label), "unknown message safety label")
+	require.Error(t, json.Unmarshal([]byte(`1`), &label))
+}
+
+func TestMessageIntegrity(t *testing.T) {
+	peer := newStubRPC()
+	peer.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x01}))
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	b.futureBlockTolerance = 5
+	// the block of the message is not finalized yet
+	setHeads(b, chainId, &eth.L1BlockRef{Number: 9, Time: 90}, nil, &eth.L1BlockRef{Number: 10, Time: 100})
+	id, payload := testMessage(900, peer, 10, 0)
+
+	label, err := b.MessageSafety(context.Background(), id, payload)
+	require.NoError(t, err)
+	require.Equal(t, Unsafe, label)
+	valid, err := b.MessageIntegrity(context.Background(), id, payload)
+	require.NoError(t, err)
+	require.True(t, valid)
+
+	valid, err = b.MessageIntegrity(context.Background(), id, hexutil.Bytes{0x02})
+	require.ErrorIs(t, err, ErrIntegrityMismatch)
+	require.False(t, valid)
+
+	// a block not yet available to the peer is not valid yet
+	ahead := id
+	ahead.BlockNumber = big.NewInt(12)
+	label, err = b.MessageSafety(context.Background(), ahead, payload)
+	require.NoError(t, err)
+	require.Equal(t, Unsafe, label)
+	valid, err = b.MessageIntegrity(context.Background(), ahead, payload)
+	require.ErrorContains(t, err, "block 12 is ahead of the latest head")
+	require.False(t, valid)
+
+	// the heads of the peer chain are not needed
+	delete(b.l2Heads, chainId)
+	valid, err = b.MessageIntegrity(context.Background(), id, payload)
+	require.NoError(t, err)
+	require.True(t, valid)
+}
+
+func TestMessageSafetyBatchPreconditions(t *testing.T) {
+	peer := newStubRPC()
+	peer.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x01}))
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	setHeads(b, chainId, &eth.L1BlockRef{Number: 10, Time: 100}, nil, nil)
+	valid, payload := testMessage(900, peer, 10, 0)
+
+	emptyPayload := valid
+	invalidChain := valid
+	invalidChain.ChainId = big.NewInt(-1)
+	unknownChain := valid
+	unknownChain.ChainId = big.NewInt(901)
+	logIndex := valid
+	logIndex.LogIndex = maxLogIndex + 1
+	ids := []MessageIdentifier{emptyPayload, invalidChain, unknownChain, logIndex, valid}
+	payloads := []hexutil.Bytes{nil, payload, payload, payload, payload}
+
+	// the batch fails every message with the error of the single check of the message
+	labels, err := b.MessageSafetyBatch(context.Background(), ids, payloads)
+	require.Error(t, err)
+	for i, id := range ids {
+		label, singleErr := b.MessageSafety(context.Background(), id, payloads[i])
+		require.Equal(t, label, labels[i], "message %d", i)
+		if singleErr == nil {
+			require.NotContains(t, err.Error(), fmt.Sprintf("message %d:", i))
+			continue
+		}
+		require.ErrorContains(t, err, fmt.Sprintf("message %d: %s", i, singleErr))
+	}
+}
+
+func TestMessageSafetyInvalidBlockNumber(t *testing.T) {
+	peer := newStubRPC()
+	peer.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x01}))
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	setHeads(b, chainId, &eth.L1BlockRef{Number: 10, Time: 100}, nil, nil)
+	valid, payload := testMessage(900, peer, 10, 0)
+
+	var missing MessageIdentifier
+	require.NoError(t, json.Unmarshal([]byte(`{"chainId":900,"timestamp":100,"origin":"0xaa00000000000000000000000000000000000000"}`), &missing))
+	negative := valid
+	negative.BlockNumber = big.NewInt(-1)
+	for _, test := range []struct {
+		id  MessageIdentifier
+		err string
+	}{
+		{missing, "invalid block number: missing"},
+		{negative, "invalid block number -1: negative"},
+	} {
+		res, err := b.MessageSafetyDetails(context.Background(), test.id, payload)
+		require.ErrorContains(t, err, test.err)
+		require.Equal(t, Invalid, res.Label)
+		require.Equal(t, ReasonInvalidBlockNumber, res.Reason)
+
+		labels, err := b.MessageSafetyBatch(context.Background(), []MessageIdentifier{test.id, valid}, []hexutil.Bytes{payload, payload})
+		require.ErrorContains(t, err, "message 0: "+test.err)
+		require.Equal(t, []MessageSafetyLabel{Invalid, Finalized}, labels)
+	}
+	// only the valid message is fetched, and then served from the cache
+	require.Equal(t, 1, peer.batchCalls)
+}
@@ -0,0 +1,141 @@
+package superchain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrPeerUnavailable is returned without a request to the peer, while the circuit breaker of the peer is open.
+var ErrPeerUnavailable = errors.New("peer unavailable")
+
+// defaultBreakerCooldown is the period a circuit breaker stays open before a request probes the peer again.
+const defaultBreakerCooldown = time.Second * 30
+
+type breakerState string
+
+const (
+	// breakerClosed passes all requests to the peer
+	breakerClosed breakerState = "closed"
+	// breakerOpen rejects all requests to the peer until the cooldown passed
+	breakerOpen breakerState = "open"
+	// breakerHalfOpen passes a single request to probe the peer
+	breakerHalfOpen breakerState = "half_open"
+)
+
+// circuitBreaker short-circuits the requests to a peer after a number of consecutive failed requests.
+// After the cooldown, a single request probes the peer: the breaker closes if the probe succeeds, and
+// opens for another cooldown if it fails. A nil breaker passes all requests.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	// onChange is called with the new state on every transition, while holding the lock
+	onChange func(state breakerState)
+	now      func() time.Time
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration, onChange func(state breakerState)) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		onChange:  onChange,
+		now:       time.Now,
+		state:     breakerClosed,
+	}
+}
+
+// newPeerBreaker returns the circuit breaker of the peer, logging and recording every transition.
+func (b *backend) newPeerBreaker(chainId ChainID, threshold int, cooldown time.Duration) *circuitBreaker {
+	return newCircuitBreaker(threshold, cooldown, func(state breakerState) {
+		if state == breakerOpen {
+			b.log.Warn("peer circuit breaker opened", "chain_id", chainId, "cooldown", cooldown)
+		} else {
+			b.log.Info("peer circuit breaker state changed", "chain_id", chainId, "state", state)
+		}
+		b.metrics.RecordBreakerTransition(chainId.String(), state)
+	})
+}
+
+// allow returns an ErrPeerUnavailable error if the request must not be sent to the peer, and otherwise whether the
+// request is the single probe of the half-open breaker. Every allowed request must be followed by a call to done,
+// with whether the request is the probe.
+func (cb *circuitBreaker) allow() (probe bool, err error) {
+	if cb == nil {
+		return false, nil
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch cb.state {
+	case breakerOpen:
+		if remaining := cb.cooldown - cb.now().Sub(cb.openedAt); remaining > 0 {
+			return false, fmt.Errorf("%w: circuit breaker open for another %s", ErrPeerUnavailable, remaining)
+		}
+		cb.setState(breakerHalfOpen)
+	case breakerHalfOpen:
+		if cb.probing {
+			return false, fmt.Errorf("%w: circuit breaker is probing the peer", ErrPeerUnavailable)
+		}
+	default:
+		return false, nil
+	}
+	cb.probing = true
+	return true, nil
+}
+
+// done records the outcome of an allowed request. Requests abandoned by the caller, with a done
+// context, are not attributed to the peer. Only the probe closes or re-opens a half-open breaker: the outcomes
+// of the requests allowed before the breaker opened are not attributed to the peer once it opened.
+func (cb *circuitBreaker) done(ctx context.Context, probe bool, err error) {
+	if cb == nil {
+		return
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if probe {
+		cb.probing = false
+		switch {
+		case err == nil:
+			cb.failures = 0
+			cb.setState(breakerClosed)
+		case ctx.Err() != nil:
+			// the breaker stays half-open, and the next request probes the peer
+		default:
+			cb.open()
+		}
+		return
+	}
+	if cb.state != breakerClosed {
+		return
+	}
+	switch {
+	case err == nil:
+		cb.failures = 0
+	case ctx.Err() != nil:
+	default:
+		cb.failures++
+		if cb.failures >= cb.threshold {
+			cb.open()
+		}
+	}
+}
+
+func (cb *circuitBreaker) open() {
+	cb.failures = 0
+	cb.openedAt = cb.now()
+	cb.setState(breakerOpen)
+}
+
+func (cb *circuitBreaker) setState(state breakerState) {
+	cb.state = state
+	if cb.onChange != nil {
+		cb.onChange(state)
+	}
+}
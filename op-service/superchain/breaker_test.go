@@ -0,0 +1,191 @@
+package superchain
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	"github.com/ethereum-optimism/optimism/op-service/client"
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+	"github.com/ethereum-optimism/optimism/op-service/metrics"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var states []breakerState
+	cb := newCircuitBreaker(3, time.Minute, func(state breakerState) { states = append(states, state) })
+	now := time.Unix(1000, 0)
+	cb.now = func() time.Time { return now }
+	ctx := context.Background()
+	failure := errors.New("failure")
+
+	allow := func(expectedProbe bool) bool {
+		t.Helper()
+		probe, err := cb.allow()
+		require.NoError(t, err)
+		require.Equal(t, expectedProbe, probe)
+		return probe
+	}
+	fail := func() {
+		t.Helper()
+		cb.done(ctx, allow(false), failure)
+	}
+
+	// a success resets the consecutive failures
+	fail()
+	fail()
+	cb.done(ctx, allow(false), nil)
+	fail()
+	fail()
+	require.Equal(t, breakerClosed, cb.state)
+
+	// failures of abandoned requests are not counted
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	cb.done(canceled, allow(false), context.Canceled)
+	require.Equal(t, breakerClosed, cb.state)
+
+	fail()
+	require.Equal(t, breakerOpen, cb.state)
+	_, err := cb.allow()
+	require.ErrorIs(t, err, ErrPeerUnavailable)
+
+	// after the cooldown, a single request probes the peer
+	now = now.Add(time.Minute)
+	probe := allow(true)
+	require.Equal(t, breakerHalfOpen, cb.state)
+	_, err = cb.allow()
+	require.ErrorIs(t, err, ErrPeerUnavailable)
+
+	// a failed probe opens the breaker for another cooldown
+	cb.done(ctx, probe, failure)
+	require.Equal(t, breakerOpen, cb.state)
+	now = now.Add(time.Second * 59)
+	_, err = cb.allow()
+	require.ErrorIs(t, err, ErrPeerUnavailable)
+
+	// an abandoned probe leaves the breaker half-open, for the next request to probe the peer
+	now = now.Add(time.Second)
+	cb.done(canceled, allow(true), context.Canceled)
+	require.Equal(t, breakerHalfOpen, cb.state)
+
+	// a successful probe closes the breaker
+	cb.done(ctx, allow(true), nil)
+	require.Equal(t, breakerClosed, cb.state)
+	allow(false)
+
+	require.Equal(t, []breakerState{breakerOpen, breakerHalfOpen, breakerOpen, breakerHalfOpen, breakerClosed}, states)
+}
+
+func TestCircuitBreakerConcurrentProbe(t *testing.T) {
+	failure := errors.New("failure")
+	for _, probeErr := range []error{nil, failure} {
+		cb := newCircuitBreaker(1, time.Minute, nil)
+		now := time.Unix(1000, 0)
+		cb.now = func() time.Time { return now }
+		ctx := context.Background()
+
+		// requests allowed while the breaker is closed, still in flight once it opened
+		var stragglers []bool
+		for i := 0; i < 4; i++ {
+			probe, err := cb.allow()
+			require.NoError(t, err)
+			stragglers = append(stragglers, probe)
+		}
+		cb.done(ctx, stragglers[0], failure)
+		require.Equal(t, breakerOpen, cb.state)
+		now = now.Add(time.Minute)
+		probe, err := cb.allow()
+		require.NoError(t, err)
+		require.True(t, probe)
+
+		// the stragglers complete while the probe is in flight, without changing the state of the breaker
+		var wg sync.WaitGroup
+		for i, straggler := range stragglers[1:] {
+			var err error
+			if i%2 == 0 {
+				err = failure
+			}
+			wg.Add(1)
+			go func(straggler bool, err error) {
+				defer wg.Done()
+				cb.done(ctx, straggler, err)
+			}(straggler, err)
+		}
+		wg.Wait()
+		cb.mu.Lock()
+		require.Equal(t, breakerHalfOpen, cb.state)
+		cb.mu.Unlock()
+		_, err = cb.allow()
+		require.ErrorIs(t, err, ErrPeerUnavailable)
+
+		// only the outcome of the probe closes or re-opens the breaker
+		cb.done(ctx, probe, probeErr)
+		if probeErr == nil {
+			require.Equal(t, breakerClosed, cb.state)
+		} else {
+			require.Equal(t, breakerOpen, cb.state)
+		}
+	}
+}
+
+func TestCircuitBreakerNil(t *testing.T) {
+	var cb *circuitBreaker
+	probe, err := cb.allow()
+	require.NoError(t, err)
+	require.False(t, probe)
+	cb.done(context.Background(), probe, errors.New("failure"))
+	_, err = cb.allow()
+	require.NoError(t, err)
+}
+
+func TestMessageSafetyPeerBreaker(t *testing.T) {
+	origin := common.Address{0xaa}
+	stub := newStubRPC()
+	stub.addBlock(10, 100, testLog(origin, []byte{0x01}))
+
+	chainId := ChainIDFromUInt64(900)
+	registry := prometheus.NewRegistry()
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: stub})
+	b.metrics = NewMetrics(metrics.With(registry))
+	setHeads(b, chainId, nil, nil, &eth.L1BlockRef{Number: 10, Time: 100})
+	breaker := b.newPeerBreaker(chainId, 2, time.Minute)
+	now := time.Unix(1000, 0)
+	breaker.now = func() time.Time { return now }
+	b.l2PeerNodes[chainId].breaker = breaker
+
+	id, payload := testMessage(900, stub, 10, 0)
+	stub.err = errors.New("peer is down")
+	for i := 0; i < 2; i++ {
+		res, err := b.MessageSafetyDetails(context.Background(), id, payload)
+		require.ErrorContains(t, err, "peer is down")
+		require.Equal(t, ReasonFetchFailed, res.Reason)
+	}
+	require.Equal(t, 2, stub.batchCalls)
+
+	// the open breaker fails fast, without a request to the peer
+	res, err := b.MessageSafetyDetails(context.Background(), id, payload)
+	require.ErrorIs(t, err, ErrPeerUnavailable)
+	require.Equal(t, invalidResult(ReasonPeerUnavailable), res)
+	labels, err := b.MessageSafetyBatch(context.Background(), []MessageIdentifier{id}, []hexutil.Bytes{payload})
+	require.ErrorIs(t, err, ErrPeerUnavailable)
+	require.Equal(t, []MessageSafetyLabel{Invalid}, labels)
+	require.Equal(t, 2, stub.batchCalls)
+	require.Equal(t, 1.0, testutil.ToFloat64(b.metrics.BreakerTransitionsTotal.WithLabelValues("900", string(breakerOpen))))
+
+	// the recovered peer closes the breaker after the cooldown
+	stub.err = nil
+	now = now.Add(time.Minute)
+	label, err := b.MessageSafety(context.Background(), id, payload)
+	require.NoError(t, err)
+	require.Equal(t, Unsafe, label)
+	require.Equal(t, 1.0, testutil.ToFloat64(b.metrics.BreakerTransitionsTotal.WithLabelValues("900", string(breakerClosed))))
+}
@@ -0,0 +1,168 @@
+package superchain
+
+import (
+	"slices"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+)
+
+const (
+	defaultMessageCacheSize = 1000
+	defaultHeaderCacheSize  = 100
+	defaultBlockCacheSize   = 256
+)
+
+// messageKey identifies a message, and the payload it was checked against.
+type messageKey struct {
+	chainId     ChainID
+	blockNumber uint64
+	logIndex    uint64
+	logCount    uint64
+	origin      common.Address
+	timestamp   uint64
+	blockHash   common.Hash // zero if the message is not pinned to a block
+	payloadHash common.Hash
+}
+
+// messageResult is the terminal outcome of a message safety check.
+type messageResult struct {
+	result MessageSafetyResult
+	err    error
+}
+
+// newMessageKey returns the cache key of the message, or false if the message cannot be cached.
+func newMessageKey(chainId ChainID, id MessageIdentifier, payload []byte) (messageKey, bool) {
+	if id.BlockNumber == nil || !id.BlockNumber.IsUint64() {
+		return messageKey{}, false
+	}
+	var blockHash common.Hash
+	if id.BlockHash != nil {
+		blockHash = *id.BlockHash
+	}
+	return messageKey{
+		chainId:     chainId,
+		blockNumber: id.BlockNumber.Uint64(),
+		logIndex:    id.LogIndex,
+		logCount:    id.logCount(),
+		origin:      id.Origin,
+		timestamp:   id.Timestamp,
+		blockHash:   blockHash,
+		payloadHash: crypto.Keccak256Hash(payload),
+	}, true
+}
+
+// cachedResult returns the cached result of the message, with its own copy of the matched log.
+func (b *backend) cachedResult(chainId ChainID, id MessageIdentifier, payload []byte) (messageResult, bool) {
+	key, ok := newMessageKey(chainId, id, payload)
+	if !ok {
+		return messageResult{}, false
+	}
+	res, ok := b.messageCache.Get(key)
+	res.result.Log = copyLog(res.result.Log)
+	return res, ok
+}
+
+// cacheResult caches a result that can no longer change: Finalized messages,
+// and Invalid messages that mismatch a finalized block.
+func (b *backend) cacheResult(chainId ChainID, id MessageIdentifier, payload []byte, res messageResult) {
+	if key, ok := newMessageKey(chainId, id, payload); ok {
+		res.result.Log = copyLog(res.result.Log)
+		b.messageCache.Add(key, res)
+	}
+}
+
+// headerKey identifies a block of a peer chain by number.
+type headerKey struct {
+	chainId ChainID
+	number  uint64
+}
+
+// cachedHeaders sets the cached headers of the queried blocks. Unlike the caches of the source client,
+// headers are cached by number, as only the headers of finalized blocks are cached.
+func (b *backend) cachedHeaders(chainId ChainID, queries []blockQuery) {
+	for i := range queries {
+		if !queries[i].number.IsUint64() {
+			continue
+		}
+		if header, ok := b.headerCache.Get(headerKey{chainId, queries[i].number.Uint64()}); ok {
+			queries[i].header = header
+		}
+	}
+}
+
+// cacheFinalizedHeaders caches the fetched headers of the blocks at or below the finalized head of the peer
+// chain, which can no longer be reorged out.
+func (b *backend) cacheFinalizedHeaders(chainId ChainID, blocks []blockData) {
+	b.mu.Lock()
+	var finalized *eth.L1BlockRef
+	if heads, ok := b.l2Heads[chainId]; ok {
+		finalized = heads.finalized
+	}
+	b.mu.Unlock()
+	if finalized == nil {
+		return
+	}
+	for _, block := range blocks {
+		if block.header != nil && block.header.Number.IsUint64() && block.header.Number.Uint64() <= finalized.Number {
+			b.headerCache.Add(headerKey{chainId, block.header.Number.Uint64()}, block.header)
+		}
+	}
+}
+
+// cachedBlocks returns the cached block of every query with a prefetched block, with the logs of the origins of
+// the query, and the indices of the queries that must be fetched.
+func (b *backend) cachedBlocks(chainId ChainID, queries []blockQuery) ([]blockData, []int) {
+	blocks := make([]blockData, len(queries))
+	var missing []int
+	for i := range queries {
+		if !queries[i].number.IsUint64() {
+			missing = append(missing, i)
+			continue
+		}
+		block, ok := b.blockCache.Get(headerKey{chainId, queries[i].number.Uint64()})
+		if !ok {
+			missing = append(missing, i)
+			continue
+		}
+		blocks[i] = blockData{header: block.header, logs: originLogs(block.logs, queries[i].origins)}
+	}
+	return blocks, missing
+}
+
+// cacheFinalizedBlocks caches the prefetched blocks at or below the finalized head of the peer chain, with the
+// logs of all origins.
+func (b *backend) cacheFinalizedBlocks(chainId ChainID, blocks []blockData) {
+	b.mu.Lock()
+	var finalized *eth.L1BlockRef
+	if heads, ok := b.l2Heads[chainId]; ok {
+		finalized = heads.finalized
+	}
+	b.mu.Unlock()
+	if finalized == nil {
+		return
+	}
+	for _, block := range blocks {
+		if block.err == nil && block.header != nil && block.header.Number.IsUint64() && block.header.Number.Uint64() <= finalized.Number {
+			b.blockCache.Add(headerKey{chainId, block.header.Number.Uint64()}, block)
+		}
+	}
+}
+
+// originLogs returns the logs emitted by any of the origins, or all the logs if there are no origins,
+// as eth_getLogs would have filtered them.
+func originLogs(logs []types.Log, origins []common.Address) []types.Log {
+	if len(origins) == 0 {
+		return logs
+	}
+	var filtered []types.Log
+	for _, log := range logs {
+		if slices.Contains(origins, log.Address) {
+			filtered = append(filtered, log)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,243 @@
+package superchain
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/ethereum-optimism/optimism/op-service/client"
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+	"github.com/ethereum-optimism/optimism/op-service/metrics"
+	"github.com/ethereum-optimism/optimism/op-service/sources/caching"
+	"github.com/ethereum-optimism/optimism/op-service/testlog"
+)
+
+func TestMessageCache(t *testing.T) {
+	origin := common.Address{0xaa}
+	peer := newStubRPC()
+	peer.addBlock(10, 100, testLog(origin, []byte{0x01}))
+	peer.addBlock(11, 102, testLog(origin, []byte{0x02}))
+
+	cacheMetrics := metrics.NewCacheMetrics(metrics.With(prometheus.NewRegistry()), metricsNamespace, "message_cache", "Message safety cache")
+	b := newTestBackend(t, map[ChainID]client.RPC{ChainIDFromUInt64(900): peer})
+	b.messageCache = caching.NewLRUCache[messageKey, messageResult](cacheMetrics, "messages", 10)
+	setHeads(b, ChainIDFromUInt64(900), &eth.L1BlockRef{Time: 100}, nil, &eth.L1BlockRef{Time: 102})
+
+	check := func(id MessageIdentifier, payload hexutil.Bytes, expected MessageSafetyLabel, expectedBatchCalls int) {
+		label, _ := b.MessageSafety(context.Background(), id, payload)
+		require.Equal(t, expected, label)
+		require.Equal(t, expectedBatchCalls, peer.batchCalls)
+	}
+
+	t.Run("Finalized", func(t *testing.T) {
+		peer.batchCalls = 0
+		id, payload := testMessage(900, peer, 10, 0)
+		check(id, payload, Finalized, 1)
+		check(id, payload, Finalized, 1)
+	})
+
+	t.Run("Unsafe", func(t *testing.T) {
+		peer.batchCalls = 0
+		id, payload := testMessage(900, peer, 11, 0)
+		check(id, payload, Unsafe, 1)
+		check(id, payload, Unsafe, 2)
+	})
+
+	t.Run("InvalidFinalizedBlock", func(t *testing.T) {
+		peer.batchCalls = 0
+		id, _ := testMessage(900, peer, 10, 0)
+		check(id, hexutil.Bytes{0xff}, Invalid, 1)
+		check(id, hexutil.Bytes{0xff}, Invalid, 1)
+		_, err := b.MessageSafety(context.Background(), id, hexutil.Bytes{0xff})
+		require.ErrorContains(t, err, "payload bytes mismatch")
+	})
+
+	t.Run("InvalidUnsafeBlock", func(t *testing.T) {
+		peer.batchCalls = 0
+		id, _ := testMessage(900, peer, 11, 0)
+		check(id, hexutil.Bytes{0xff}, Invalid, 1)
+		check(id, hexutil.Bytes{0xff}, Invalid, 2)
+	})
+
+	t.Run("PinnedBlockHash", func(t *testing.T) {
+		// the cached finalized result is not served when pinned to another block
+		peer.batchCalls = 0
+		id, payload := testMessage(900, peer, 10, 0)
+		id.BlockHash = &common.Hash{0xde}
+		check(id, payload, Invalid, 1)
+		check(id, payload, Invalid, 2)
+	})
+
+	t.Run("Batch", func(t *testing.T) {
+		peer.batchCalls = 0
+		id, payload := testMessage(900, peer, 10, 0)
+		labels, err := b.MessageSafetyBatch(context.Background(), []MessageIdentifier{id}, []hexutil.Bytes{payload})
+		require.NoError(t, err)
+		require.Equal(t, []MessageSafetyLabel{Finalized}, labels)
+		require.Equal(t, 0, peer.batchCalls)
+	})
+
+	require.Equal(t, 4.0, testutil.ToFloat64(cacheMetrics.GetVec.WithLabelValues("messages", "true")))
+}
+
+// TestMessageCacheFinalizedOnly checks no result of a message that a reorg can still relabel is cached, by any of
+// the check methods, so a regressed safe head never serves a stale Safe or CrossSafe result.
+func TestMessageCacheFinalizedOnly(t *testing.T) {
+	origin := common.Address{0xaa}
+	peer := newStubRPC()
+	peer.addBlock(10, 100, testLog(origin, []byte{0x01}))
+	peer.addBlock(11, 110, testLog(origin, []byte{0x02}))
+	peer.addBlock(12, 120, testLog(origin, []byte{0x03}))
+	peer.addBlock(13, 130, testLog(origin, []byte{0x04}))
+
+	chainId, other := ChainIDFromUInt64(900), ChainIDFromUInt64(901)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer, other: newStubRPC()})
+	setHeads(b, chainId, &eth.L1BlockRef{Number: 10, Time: 100}, &eth.L1BlockRef{Number: 12, Time: 120}, &eth.L1BlockRef{Number: 13, Time: 130})
+	// the other chain is safe up to block 11 of the peer chain only
+	setHeads(b, other, nil, &eth.L1BlockRef{Number: 5, Time: 110}, nil)
+
+	check := func(blockNum uint64, mismatch bool, expected MessageSafetyLabel, cached bool) {
+		t.Helper()
+		id, payload := testMessage(900, peer, blockNum, 0)
+		if mismatch {
+			payload = hexutil.Bytes{0xff}
+		}
+		res, _ := b.MessageSafetyDetails(context.Background(), id, payload)
+		require.Equal(t, expected, res.Label)
+		labels, _ := b.MessageSafetyBatch(context.Background(), []MessageIdentifier{id}, []hexutil.Bytes{payload})
+		require.Equal(t, []MessageSafetyLabel{expected}, labels)
+		_, ok := b.cachedResult(chainId, id, payload)
+		require.Equal(t, cached, ok)
+	}
+
+	check(10, false, Finalized, true)
+	check(11, false, CrossSafe, false)
+	check(12, false, Safe, false)
+	check(13, false, Unsafe, false)
+	check(12, true, Invalid, false)
+
+	// after a reorg regressing the safe head, the messages are labeled against the regressed head
+	b.onSafeHead(chainId, eth.L1BlockRef{Hash: common.Hash{0x10}, Number: 10, Time: 100})
+	check(11, false, Unsafe, false)
+	check(12, false, Unsafe, false)
+}
+
+func TestMessageSafetyBatchDuplicates(t *testing.T) {
+	origin := common.Address{0xaa}
+	peer := newStubRPC()
+	peer.addBlock(10, 100, testLog(origin, []byte{0x01}), testLog(origin, []byte{0x02}))
+
+	cacheMetrics := metrics.NewCacheMetrics(metrics.With(prometheus.NewRegistry()), metricsNamespace, "message_cache", "Message safety cache")
+	b := newTestBackend(t, map[ChainID]client.RPC{ChainIDFromUInt64(900): peer})
+	b.messageCache = caching.NewLRUCache[messageKey, messageResult](cacheMetrics, "messages", 10)
+	setHeads(b, ChainIDFromUInt64(900), nil, nil, &eth.L1BlockRef{Time: 100})
+
+	id, payload := testMessage(900, peer, 10, 0)
+	other, otherPayload := testMessage(900, peer, 10, 1)
+	ids := []MessageIdentifier{id, id, other, id}
+	payloads := []hexutil.Bytes{payload, payload, otherPayload, payload}
+	labels, err := b.MessageSafetyBatch(context.Background(), ids, payloads)
+	require.NoError(t, err)
+	require.Equal(t, []MessageSafetyLabel{Unsafe, Unsafe, Unsafe, Unsafe}, labels)
+	require.Equal(t, 1, peer.batchCalls)
+	// every unique message is looked up in the cache, and validated, once
+	require.Equal(t, 2.0, testutil.ToFloat64(cacheMetrics.GetVec.WithLabelValues("messages", "false")))
+
+	// a copy with another payload is a distinct message
+	labels, err = b.MessageSafetyBatch(context.Background(), []MessageIdentifier{id, id}, []hexutil.Bytes{payload, {0xff}})
+	require.ErrorContains(t, err, "message 1: payload bytes mismatch")
+	require.Equal(t, []MessageSafetyLabel{Unsafe, Invalid}, labels)
+}
+
+func TestHeaderCache(t *testing.T) {
+	origin := common.Address{0xaa}
+	peer := newStubRPC()
+	peer.addBlock(10, 100, testLog(origin, []byte{0x01}), testLog(origin, []byte{0x02}))
+	peer.addBlock(11, 102, testLog(origin, []byte{0x03}), testLog(origin, []byte{0x04}))
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	setHeads(b, chainId, &eth.L1BlockRef{Number: 10, Time: 100}, nil, &eth.L1BlockRef{Number: 11, Time: 102})
+
+	check := func(blockNum uint64, logIndex uint64, expected MessageSafetyLabel, expectedBatchSize int) {
+		t.Helper()
+		id, payload := testMessage(900, peer, blockNum, logIndex)
+		label, err := b.MessageSafety(context.Background(), id, payload)
+		require.NoError(t, err)
+		require.Equal(t, expected, label)
+		require.Equal(t, expectedBatchSize, peer.batchSizes[len(peer.batchSizes)-1])
+	}
+
+	// the header of the finalized block is only fetched for the first message of the block
+	check(10, 0, Finalized, 2)
+	check(10, 1, Finalized, 1)
+	// the header of the unsafe block can still change, and is fetched for every message
+	check(11, 0, Unsafe, 2)
+	check(11, 1, Unsafe, 2)
+
+	// the cached header is used by batches
+	id, payload := testMessage(900, peer, 10, 1)
+	b.messageCache = caching.NewLRUCache[messageKey, messageResult](nil, "messages", defaultMessageCacheSize)
+	labels, err := b.MessageSafetyBatch(context.Background(), []MessageIdentifier{id}, []hexutil.Bytes{payload})
+	require.NoError(t, err)
+	require.Equal(t, []MessageSafetyLabel{Finalized}, labels)
+	require.Equal(t, 1, peer.batchSizes[len(peer.batchSizes)-1])
+}
+
+// countingCacheMetrics counts the hits and misses of a cache.
+type countingCacheMetrics struct {
+	hits, misses int
+}
+
+func (m *countingCacheMetrics) CacheAdd(label string, cacheSize int, evicted bool) {}
+
+func (m *countingCacheMetrics) CacheGet(label string, hit bool) {
+	if hit {
+		m.hits++
+	} else {
+		m.misses++
+	}
+}
+
+// BenchmarkHeaderCache validates distinct messages of the same finalized block, reporting the hit rate of the
+// header cache. The message cache only holds a single message, so that every message is validated again.
+func BenchmarkHeaderCache(b *testing.B) {
+	origin := common.Address{0xaa}
+	peer := newStubRPC()
+	logs := make([]types.Log, 100)
+	for i := range logs {
+		logs[i] = testLog(origin, []byte{byte(i)})
+	}
+	peer.addBlock(10, 100, logs...)
+
+	chainId := ChainIDFromUInt64(900)
+	backend := newTestBackend(b, map[ChainID]client.RPC{chainId: peer})
+	backend.log = testlog.Logger(b, log.LevelError)
+	cacheMetrics := &countingCacheMetrics{}
+	backend.messageCache = caching.NewLRUCache[messageKey, messageResult](nil, "messages", 1)
+	backend.headerCache = caching.NewLRUCache[headerKey, *types.Header](cacheMetrics, "headers", defaultHeaderCacheSize)
+	setHeads(backend, chainId, &eth.L1BlockRef{Number: 10, Time: 100}, nil, nil)
+
+	ids := make([]MessageIdentifier, len(logs))
+	payloads := make([]hexutil.Bytes, len(logs))
+	for i := range logs {
+		ids[i], payloads[i] = testMessage(900, peer, 10, uint64(i))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		label, err := backend.MessageSafety(context.Background(), ids[i%len(ids)], payloads[i%len(ids)])
+		if err != nil || label != Finalized {
+			b.Fatalf("unexpected result: %v, %v", label, err)
+		}
+	}
+	b.ReportMetric(float64(cacheMetrics.hits)/float64(cacheMetrics.hits+cacheMetrics.misses), "hit-rate")
+	b.ReportMetric(float64(peer.batchCalls)/float64(b.N), "requests/op")
+}
@@ -0,0 +1,57 @@
+package superchain
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/holiman/uint256"
+)
+
+// ChainID is the full 256 bit chain id of a chain in the superchain.
+// Unlike a *big.Int it is comparable, and is used to key the peers of the backend.
+type ChainID uint256.Int
+
+func ChainIDFromUInt64(i uint64) ChainID {
+	return ChainID(*uint256.NewInt(i))
+}
+
+// ChainIDFromBig converts the chain id, returning false if it is nil, negative or exceeds 256 bits.
+func ChainIDFromBig(i *big.Int) (ChainID, bool) {
+	if i == nil || i.Sign() < 0 {
+		return ChainID{}, false
+	}
+	v, overflow := uint256.FromBig(i)
+	if overflow {
+		return ChainID{}, false
+	}
+	return ChainID(*v), true
+}
+
+func (id ChainID) ToBig() *big.Int {
+	return (*uint256.Int)(&id).ToBig()
+}
+
+func (id ChainID) IsZero() bool {
+	return (*uint256.Int)(&id).IsZero()
+}
+
+func (id ChainID) Cmp(other ChainID) int {
+	return (*uint256.Int)(&id).Cmp((*uint256.Int)(&other))
+}
+
+// String returns the decimal representation of the chain id.
+func (id ChainID) String() string {
+	return (*uint256.Int)(&id).Dec()
+}
+
+func (id ChainID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// UnmarshalText decodes a decimal, or 0x-prefixed hexadecimal chain id.
+func (id *ChainID) UnmarshalText(data []byte) error {
+	if err := (*uint256.Int)(id).UnmarshalText(data); err != nil {
+		return fmt.Errorf("invalid chain id %q: %w", data, err)
+	}
+	return nil
+}
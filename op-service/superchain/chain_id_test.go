@@ -0,0 +1,40 @@
+package superchain
+
+import (
+	"encoding/json"
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChainID(t *testing.T) {
+	large := new(big.Int).Add(new(big.Int).SetUint64(math.MaxUint64), big.NewInt(1))
+	id, ok := ChainIDFromBig(large)
+	require.True(t, ok)
+	require.Equal(t, large, id.ToBig())
+	require.Equal(t, "18446744073709551616", id.String())
+	require.NotEqual(t, ChainIDFromUInt64(0), id)
+	require.Equal(t, 1, id.Cmp(ChainIDFromUInt64(math.MaxUint64)))
+
+	_, ok = ChainIDFromBig(nil)
+	require.False(t, ok)
+	_, ok = ChainIDFromBig(big.NewInt(-1))
+	require.False(t, ok)
+	_, ok = ChainIDFromBig(new(big.Int).Lsh(big.NewInt(1), 256))
+	require.False(t, ok)
+}
+
+func TestChainIDMapKey(t *testing.T) {
+	addrs := map[ChainID]string{ChainIDFromUInt64(900): "a", ChainIDFromUInt64(0x385): "b"}
+	data, err := json.Marshal(addrs)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"900":"a","901":"b"}`, string(data))
+
+	var decoded map[ChainID]string
+	require.NoError(t, json.Unmarshal([]byte(`{"900":"a","0x385":"b"}`), &decoded))
+	require.Equal(t, addrs, decoded)
+
+	require.Error(t, json.Unmarshal([]byte(`{"abc":"a"}`), &decoded))
+}
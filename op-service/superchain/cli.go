@@ -0,0 +1,107 @@
+package superchain
+
+import (
+	"fmt"
+	"maps"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+
+	opservice "github.com/ethereum-optimism/optimism/op-service"
+)
+
+const (
+	L2NodeAddrFlagName            = "superchain.l2-node-addr"
+	PeersFlagName                 = "superchain.peers"
+	FinalizedPollIntervalFlagName = "superchain.finalized-poll-interval"
+	RPCTimeoutFlagName            = "superchain.rpc-timeout"
+	PollTimeoutFlagName           = "superchain.poll-timeout"
+)
+
+func CLIFlags(envPrefix string) []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    L2NodeAddrFlagName,
+			Usage:   "Address of the L2 node of the local chain",
+			EnvVars: opservice.PrefixEnvVar(envPrefix, "SUPERCHAIN_L2_NODE_ADDR"),
+		},
+		&cli.GenericFlag{
+			Name:    PeersFlagName,
+			Usage:   "Comma-separated peer chains, each as <chain id>=<address of an L2 node of the peer chain>",
+			Value:   new(peersFlag),
+			EnvVars: opservice.PrefixEnvVar(envPrefix, "SUPERCHAIN_PEERS"),
+		},
+		&cli.DurationFlag{
+			Name:    FinalizedPollIntervalFlagName,
+			Usage:   "Interval at which the finalized heads of the peer chains are polled",
+			Value:   defaultFinalizedPollInterval,
+			EnvVars: opservice.PrefixEnvVar(envPrefix, "SUPERCHAIN_FINALIZED_POLL_INTERVAL"),
+		},
+		&cli.DurationFlag{
+			Name:    RPCTimeoutFlagName,
+			Usage:   "Timeout of every block and logs request to the peers",
+			Value:   defaultRPCTimeout,
+			EnvVars: opservice.PrefixEnvVar(envPrefix, "SUPERCHAIN_RPC_TIMEOUT"),
+		},
+		&cli.DurationFlag{
+			Name:    PollTimeoutFlagName,
+			Usage:   "Timeout of every poll of the heads of the peer chains",
+			Value:   defaultPollTimeout,
+			EnvVars: opservice.PrefixEnvVar(envPrefix, "SUPERCHAIN_POLL_TIMEOUT"),
+		},
+	}
+}
+
+// ReadCLIConfig reads the config from the flags. The peers are parsed as the flags are, and the config is checked
+// to be complete with SuperchainConfig.Check.
+func ReadCLIConfig(ctx *cli.Context) *SuperchainConfig {
+	cfg := NewSuperchainConfig(ctx.String(L2NodeAddrFlagName), ctx.Generic(PeersFlagName).(*peersFlag).peers)
+	cfg.FinalizedPollInterval = ctx.Duration(FinalizedPollIntervalFlagName)
+	cfg.RPCTimeout = ctx.Duration(RPCTimeoutFlagName)
+	cfg.PollTimeout = ctx.Duration(PollTimeoutFlagName)
+	return cfg
+}
+
+// peersFlag is the value of the peers flag, parsing the comma-separated <chain id>=<address> entries of every
+// occurrence of the flag.
+type peersFlag struct {
+	peers map[ChainID]string
+}
+
+func (f *peersFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	entries := make([]string, 0, len(f.peers))
+	for chainId, addr := range f.peers {
+		entries = append(entries, chainId.String()+"="+addr)
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ",")
+}
+
+func (f *peersFlag) Set(value string) error {
+	if f.peers == nil {
+		f.peers = make(map[ChainID]string)
+	}
+	for _, entry := range strings.Split(value, ",") {
+		id, addr, ok := strings.Cut(entry, "=")
+		if !ok {
+			return fmt.Errorf("peer %q is not of the form <chain id>=<address>", entry)
+		}
+		var chainId ChainID
+		if err := chainId.UnmarshalText([]byte(strings.TrimSpace(id))); err != nil {
+			return err
+		}
+		if _, ok := f.peers[chainId]; ok {
+			return fmt.Errorf("duplicate peer with chain id %s", chainId)
+		}
+		f.peers[chainId] = strings.TrimSpace(addr)
+	}
+	return nil
+}
+
+func (f *peersFlag) Clone() any {
+	return &peersFlag{peers: maps.Clone(f.peers)}
+}
@@ -0,0 +1,96 @@
+package superchain
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+
+	"github.com/ethereum-optimism/optimism/op-service/cliapp"
+)
+
+func TestReadCLIConfig(t *testing.T) {
+	cfg, err := configForArgs(t,
+		"--superchain.l2-node-addr", "http://localhost:8545",
+		"--superchain.peers", "900=http://localhost:9545,0x385=ws://localhost:9546",
+		"--superchain.rpc-timeout", "3s",
+		"--superchain.poll-timeout", "4s")
+	require.NoError(t, err)
+	expected := NewSuperchainConfig("http://localhost:8545", map[ChainID]string{
+		ChainIDFromUInt64(900): "http://localhost:9545",
+		ChainIDFromUInt64(901): "ws://localhost:9546",
+	})
+	expected.FinalizedPollInterval = defaultFinalizedPollInterval
+	expected.RPCTimeout = 3 * time.Second
+	expected.PollTimeout = 4 * time.Second
+	require.Equal(t, expected, cfg)
+	require.NoError(t, cfg.Check())
+
+	t.Run("EnvVars", func(t *testing.T) {
+		t.Setenv("TEST_SUPERCHAIN_L2_NODE_ADDR", "http://localhost:8545")
+		t.Setenv("TEST_SUPERCHAIN_PEERS", "900=http://localhost:9545")
+		t.Setenv("TEST_SUPERCHAIN_FINALIZED_POLL_INTERVAL", "1m")
+		t.Setenv("TEST_SUPERCHAIN_POLL_TIMEOUT", "5s")
+		cfg, err := configForArgs(t)
+		require.NoError(t, err)
+		require.Equal(t, map[ChainID]string{ChainIDFromUInt64(900): "http://localhost:9545"}, cfg.PeerL2NodeAddrs)
+		require.Equal(t, time.Minute, cfg.FinalizedPollInterval)
+		require.Equal(t, 5*time.Second, cfg.PollTimeout)
+	})
+
+	t.Run("RepeatedPeers", func(t *testing.T) {
+		cfg, err := configForArgs(t, "--superchain.l2-node-addr", "http://localhost:8545",
+			"--superchain.peers", "900=http://localhost:9545", "--superchain.peers", "901=http://localhost:9546")
+		require.NoError(t, err)
+		require.Equal(t, map[ChainID]string{
+			ChainIDFromUInt64(900): "http://localhost:9545",
+			ChainIDFromUInt64(901): "http://localhost:9546",
+		}, cfg.PeerL2NodeAddrs)
+	})
+
+	for _, test := range []struct {
+		name string
+		args []string
+		err  string
+	}{
+		{"MissingL2NodeAddr", []string{"--superchain.peers", "900=http://localhost:9545"}, "missing l2 node address"},
+		{"MalformedPeer", []string{"--superchain.l2-node-addr", "http://localhost:8545", "--superchain.peers", "http://localhost:9545"},
+			`peer "http://localhost:9545" is not of the form <chain id>=<address>`},
+		{"InvalidChainID", []string{"--superchain.l2-node-addr", "http://localhost:8545", "--superchain.peers", "op=http://localhost:9545"},
+			`invalid chain id "op"`},
+		{"DuplicatePeer", []string{"--superchain.l2-node-addr", "http://localhost:8545", "--superchain.peers", "900=http://localhost:9545,0x384=http://localhost:9546"},
+			"duplicate peer with chain id 900"},
+		{"InvalidPeerAddr", []string{"--superchain.l2-node-addr", "http://localhost:8545", "--superchain.peers", "900=localhost"},
+			"chain id 900"},
+		{"NegativeRPCTimeout", []string{"--superchain.l2-node-addr", "http://localhost:8545", "--superchain.rpc-timeout", "-1s"},
+			"invalid rpc timeout"},
+		{"NegativePollTimeout", []string{"--superchain.l2-node-addr", "http://localhost:8545", "--superchain.poll-timeout", "-1s"},
+			"invalid poll timeout"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := configForArgs(t, test.args...)
+			require.ErrorContains(t, err, test.err)
+		})
+	}
+}
+
+// configForArgs reads the config from the arguments, and returns the error of parsing the arguments, or else of
+// checking the config.
+func configForArgs(t *testing.T, args ...string) (*SuperchainConfig, error) {
+	app := cli.NewApp()
+	app.Flags = cliapp.ProtectFlags(CLIFlags("TEST"))
+	app.Name = "test"
+	// the usage printed on malformed flags is not of interest
+	app.Writer, app.ErrWriter = io.Discard, io.Discard
+	var cfg *SuperchainConfig
+	app.Action = func(ctx *cli.Context) error {
+		cfg = ReadCLIConfig(ctx)
+		return nil
+	}
+	if err := app.Run(append([]string{"test"}, args...)); err != nil {
+		return nil, err
+	}
+	return cfg, cfg.Check()
+}
@@ -0,0 +1,541 @@
+package superchain
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+	"github.com/ethereum-optimism/optimism/op-service/sources"
+)
+
+type SuperchainConfig struct {
+	// L2NodeAddr is the RPC address of the L2 node of this chain
+	L2NodeAddr string
+
+	// PeerL2NodeAddrs maps the chain id of every peer chain to the RPC address of an L2 node of that chain
+	PeerL2NodeAddrs map[ChainID]string
+
+	// PeerFallbackL2NodeAddrs optionally maps the chain id of a peer to the RPC addresses of further endpoints
+	// of the peer, e.g. of other providers. When the connection to the current endpoint breaks, the peer fails
+	// over to the next endpoint in order, after the address in PeerL2NodeAddrs, and back again round-robin.
+	PeerFallbackL2NodeAddrs map[ChainID][]string
+
+	// PeerHTTPHeaders optionally maps the chain id of a peer to the HTTP headers attached to every
+	// request to the peer, e.g. to authenticate with a hosted RPC provider. Header values are never logged.
+	PeerHTTPHeaders map[ChainID]http.Header
+
+	// PeerTLS optionally maps the chain id of a peer to the TLS config of the connections to the peer, e.g. to
+	// pin the certificate authority of an RPC provider or to authenticate with a client certificate.
+	PeerTLS map[ChainID]PeerTLSConfig
+
+	// PeerOriginAllowlists optionally maps the chain id of a peer to the addresses permitted to emit messages on
+	// the peer chain, e.g. the messenger predeploys. Messages of any other origin are Invalid. Any origin is
+	// permitted on a peer chain without allowlist, or with an empty allowlist.
+	PeerOriginAllowlists map[ChainID][]common.Address
+
+	// PeerOriginProxies optionally maps the chain id of a peer to the proxies on the peer chain, each mapped to
+	// its implementation. As the logs of an implementation are emitted by its proxy, a message with either the
+	// proxy or the implementation as origin matches the logs of the proxy, and the origin allowlist of the peer
+	// applies to the proxy. Origins of peer chains without proxies must match the logs exactly.
+	PeerOriginProxies map[ChainID]map[common.Address]common.Address
+
+	// PeerBlockTimes optionally maps the chain id of a peer to the block time of the peer chain, to check that the
+	// timestamp of every fetched header is plausible for its block number. Messages in a block with an implausible
+	// timestamp are Invalid, as the peer serves inconsistent headers. Headers of other peers are not checked.
+	PeerBlockTimes map[ChainID]BlockTimeConfig
+
+	// PeerRPCKinds optionally maps the chain id of a peer to the kind of RPC provider serving the
+	// peer, to adapt the requests of the source client to the provider. The accepted values are the
+	// sources.RPCProviderKinds: alchemy, quicknode, infura, parity, nethermind, debug_geth, erigon,
+	// basic, any and standard. Peers without a kind default to any.
+	PeerRPCKinds map[ChainID]sources.RPCProviderKind
+
+	// PeerLogFetchers optionally maps the chain id of a peer to a custom LogFetcher of the blocks of the peer chain,
+	// instead of fetching them from the L2 node of the peer. The L2 node is still dialed, to poll the heads of the
+	// peer chain. The blocks are fetched one at a time, and the rpc timeout and retries apply to every fetch.
+	PeerLogFetchers map[ChainID]LogFetcher
+
+	// BlockReceiptsRPCKinds optionally lists the kinds of RPC providers, see PeerRPCKinds, of which the logs of a
+	// block are fetched with eth_getBlockReceipts instead of eth_getLogs, e.g. for providers that throttle
+	// eth_getLogs. The logs are then filtered by origin and topic locally, from the receipts of the whole block.
+	BlockReceiptsRPCKinds []sources.RPCProviderKind
+
+	// SourceCaches sizes the caches of the source client of every peer, which polls the heads of the peer.
+	// The messages are checked with direct requests to the peer, as the source client cannot filter the
+	// logs of a block by origin, and are cached separately, see MessageCacheSize.
+	SourceCaches SourceCacheConfig
+
+	// MessageCacheSize is the number of message safety results to cache. Only results that can no longer
+	// change are cached: Finalized messages, and Invalid messages that mismatch a finalized block.
+	// Defaults to 1000 when zero.
+	MessageCacheSize int
+
+	// HeaderCacheSize is the number of headers of finalized peer blocks to cache, to not fetch the header
+	// again when checking other messages of the block. Defaults to 100 when zero.
+	HeaderCacheSize int
+
+	// BlockCacheSize is the number of finalized peer blocks, with their logs, that can be cached by Prefetch,
+	// and the maximum number of blocks of a prefetched range. Defaults to 256 when zero.
+	BlockCacheSize int
+
+	// DependencySetAddr is the address of the dependency set registry on this chain. When set, messages
+	// are only accepted from the configured peers that the registry permits. When unset, every configured
+	// peer is permitted.
+	DependencySetAddr common.Address
+
+	// DependencySetRefreshInterval is the interval to refresh the dependency set from the registry.
+	// Defaults to 1m when zero.
+	DependencySetRefreshInterval time.Duration
+
+	// SkipChainIDVerification disables checking every peer serves the chain it is configured for, when creating
+	// the backend. The chain ids are verified by default.
+	SkipChainIDVerification bool
+
+	// RPCTimeout bounds the batched block and logs request to a peer. Defaults to 10s when zero.
+	RPCTimeout time.Duration
+
+	// PeerTimeouts optionally maps the chain id of a peer to the timeout of the requests to the peer, overriding
+	// both RPCTimeout and PollTimeout for the peer, e.g. to give a slow archival peer a longer budget.
+	PeerTimeouts map[ChainID]time.Duration
+
+	// PeerConnPoolSizes optionally maps the chain id of a peer to the number of connections to the peer, across which
+	// the requests to the peer are round-robined, e.g. for a hot chain with heavy concurrent validation. The pool is
+	// re-dialed as a whole when a connection breaks. A peer is served by a single connection by default.
+	PeerConnPoolSizes map[ChainID]int
+
+	// RPCRetries is the number of times a failed batched block and logs request to a peer is retried.
+	// Only connection and RPC errors are retried. Requests are not retried when zero.
+	RPCRetries int
+
+	// RPCRetryDelay is the delay before the first retry, doubling with every retry. Defaults to 100ms when zero.
+	RPCRetryDelay time.Duration
+
+	// PeerBreakerThreshold is the number of consecutive failed requests to a peer after which the requests
+	// of messages of the peer chain fail fast with ErrPeerUnavailable, without reaching the peer.
+	// The circuit breaker is disabled when zero.
+	PeerBreakerThreshold int
+
+	// PeerBreakerCooldown is the period requests to a failing peer fail fast, before a single request
+	// probes the peer again. Defaults to 30s when zero.
+	PeerBreakerCooldown time.Duration
+
+	// MaxConcurrentRPCs is the maximum number of in-flight block and logs requests to all the peers combined,
+	// across all the message checks and batches. Further requests wait for an in-flight request to complete.
+	// The polls of the heads of the peers are not limited. Requests are not limited when zero.
+	MaxConcurrentRPCs int
+
+	// ConservativeMode starts the backend in conservative mode, in which every message that would be labeled
+	// Safe, CrossSafe or Finalized is labeled Unsafe instead, see SetConservativeMode. The mode can be toggled
+	// at runtime, e.g. as a kill switch during a suspected cross-chain attack.
+	ConservativeMode bool
+
+	// MaxPayloadSize is the maximum size in bytes of the payload of a message. Messages with a larger payload
+	// are Invalid, before any request to the peers. The payload size is not limited when zero.
+	MaxPayloadSize int
+
+	// BatchConcurrency is the maximum number of peer chains fetched concurrently when checking a batch
+	// of messages. Defaults to 8 when zero.
+	BatchConcurrency int
+
+	// MaxBatchSize is the maximum number of requests in a batch request to a peer. Larger batches
+	// are split into multiple batch requests. Defaults to 10 when zero.
+	MaxBatchSize int
+
+	// MessageTopic is the optional first topic, the event signature, of the logs of the messages. When set,
+	// only the logs with the topic are fetched from the peers, and a message referencing a log with another
+	// first topic is Invalid. Logs of any topic are fetched when unset.
+	MessageTopic common.Hash
+
+	// ExpiryWindow is the period after which a message expires. A message older than the latest
+	// head of its peer chain minus the window is Invalid, even if it is finalized.
+	// The expiry check is disabled when zero.
+	ExpiryWindow time.Duration
+
+	// FutureBlockTolerance is the number of blocks ahead of the latest head of a peer chain within which a message
+	// of a block that the peer does not serve yet is Unsafe, pending the propagation of the block, instead of
+	// Invalid. Messages of blocks beyond the tolerance are Invalid. Messages of blocks ahead of the latest head
+	// are Invalid when zero.
+	FutureBlockTolerance uint64
+
+	// FinalizedPollInterval is the interval to poll the finalized head of every peer chain.
+	// Defaults to 6m24s, the duration of an L1 epoch, when zero.
+	FinalizedPollInterval time.Duration
+
+	// DevnetFinalityLabels optionally maps the chain id of a peer to the label of the block tracked as the
+	// finalized head of the peer, instead of the finalized block: eth.Safe or eth.Unsafe. This is only meant
+	// for devnets that are slow to finalize blocks, as messages are then labeled Finalized although they can
+	// still be reorged out. Never set this for production chains.
+	DevnetFinalityLabels map[ChainID]eth.BlockLabel
+
+	// FinalizedPollJitter is the fraction of the finalized poll interval by which every poll is randomly
+	// advanced or delayed, so that the polls of the peers do not synchronize. E.g. 0.1 polls every 6m24s ±10%.
+	// It must be less than 1. Polls are not jittered when zero.
+	FinalizedPollJitter float64
+
+	// FinalityByBlockNumber labels messages by comparing the block number of the message against the numbers of
+	// the heads of the peer chain, instead of comparing the timestamp of the message against the timestamps of
+	// the heads. The cross-safety of a message is still determined by timestamp, as the block numbers of
+	// different chains are not comparable, and so is the finalized timestamp of MessageSafetyAt.
+	FinalityByBlockNumber bool
+
+	// SafetyPolicy optionally labels valid messages from the facts of their blocks against the tracked heads, to
+	// customize the labels for the risk tolerance of the deployment. Defaults to DefaultSafetyPolicy when nil.
+	// The policy does not apply to MessageSafetyAt, which labels against the supplied finalized timestamp only.
+	SafetyPolicy SafetyPolicy
+
+	// RejectFinalizedReorgs drops a finalized head reported by a peer that does not chain to the tracked
+	// finalized head, instead of only logging it. Finalized blocks cannot reorg, so such a head is the sign
+	// of a faulty or malicious peer.
+	RejectFinalizedReorgs bool
+
+	// VerifyLogBlockHashes checks the block hash of the log of every message matches the hash of the fetched header
+	// of the block, as the peer may serve the header and the logs of a batch request from different states, e.g.
+	// across a reorg. The timestamp of the message is then tied to the block the log was emitted in. A message of
+	// which the log and the header disagree is Invalid.
+	VerifyLogBlockHashes bool
+
+	// HeadStore optionally persists the finalized head of every peer chain, to start labeling messages against
+	// the finalized heads of the previous run, instead of none, until the finalized heads are polled again.
+	// See NewFileHeadStore.
+	HeadStore HeadStore
+
+	// MaxFinalizedWatches is the maximum number of pending watches of messages to be finalized, registered
+	// with WatchFinalized. Defaults to 10000 when zero.
+	MaxFinalizedWatches int
+
+	// PollTimeout bounds every poll of the heads of a peer chain. Defaults to 10s when zero.
+	PollTimeout time.Duration
+
+	// BatchedHeadPollInterval optionally polls the unsafe, safe and finalized heads of every peer chain together,
+	// with a single batch request to the peer at the interval, instead of polling every head separately at its own
+	// interval. FinalizedPollInterval and FinalizedPollJitter do not apply to batched polls. Every peer is polled with
+	// its own batch requests, even if the peers share a connection. Heads are polled separately when zero.
+	BatchedHeadPollInterval time.Duration
+
+	// Tracer optionally records a span of every message check, with child spans of the requests to the peers.
+	// No spans are recorded when nil.
+	Tracer trace.Tracer
+
+	// UseSubscriptions refreshes the finalized head of every peer chain on each new head notification,
+	// instead of only polling it. The peer addresses must be websocket addresses. Polling continues
+	// alongside, as a fallback while a subscription is down.
+	UseSubscriptions bool
+
+	// ValidateSelfChain registers the chain of the L2 node as a peer chain served by the L2 node, to check messages
+	// emitted on the same chain as the messages executing them. The chain id is queried from the L2 node when
+	// creating the backend, and must not be the chain id of a configured peer.
+	ValidateSelfChain bool
+}
+
+// PeerTLSConfig is the TLS config of the HTTPS and WSS connections to a peer.
+type PeerTLSConfig struct {
+	// Config is the TLS config of the connections, e.g. with client certificates. The peer is verified against
+	// the system roots when nil.
+	Config *tls.Config
+
+	// CAFile is the optional path of a PEM bundle of the certificate authorities trusted to verify the peer,
+	// replacing the root CAs of Config. The bundle is read when creating the backend.
+	CAFile string
+}
+
+// clientConfig returns the TLS config of the connections to the peer, loading the CA bundle if set, nil if not
+// configured.
+func (c PeerTLSConfig) clientConfig() (*tls.Config, error) {
+	if c.Config == nil && c.CAFile == "" {
+		return nil, nil
+	}
+	config := &tls.Config{}
+	if c.Config != nil {
+		config = c.Config.Clone()
+	}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in CA bundle %s", c.CAFile)
+		}
+		config.RootCAs = roots
+	}
+	return config, nil
+}
+
+// BlockTimeConfig is the schedule of the blocks of a peer chain, produced at a fixed interval from an anchor block.
+type BlockTimeConfig struct {
+	// AnchorNumber and AnchorTime are the number and timestamp of any block of the peer chain, e.g. the genesis block.
+	AnchorNumber uint64
+	AnchorTime   uint64
+	// BlockTime is the interval between consecutive blocks of the peer chain, in seconds.
+	BlockTime uint64
+	// Tolerance is the maximum drift of the timestamp of a header from the timestamp expected for its block number.
+	Tolerance time.Duration
+}
+
+// expectedTime returns the timestamp expected for the block number, which may precede the anchor.
+func (c BlockTimeConfig) expectedTime(number uint64) int64 {
+	return int64(c.AnchorTime) + (int64(number)-int64(c.AnchorNumber))*int64(c.BlockTime)
+}
+
+// defaultSourceCacheSize is the default size of every cache of the source client of a peer.
+const defaultSourceCacheSize = 10
+
+// SourceCacheConfig is the number of entries of every cache of the source client of a peer.
+// Every cache defaults to 10 entries when zero.
+type SourceCacheConfig struct {
+	Receipts     int
+	Transactions int
+	Headers      int
+	Payloads     int
+	BlockRefs    int
+}
+
+// Check verifies none of the cache sizes is negative.
+func (c SourceCacheConfig) Check() error {
+	for _, cache := range []struct {
+		name string
+		size int
+	}{
+		{"receipts", c.Receipts},
+		{"transactions", c.Transactions},
+		{"headers", c.Headers},
+		{"payloads", c.Payloads},
+		{"block refs", c.BlockRefs},
+	} {
+		if cache.size < 0 {
+			return fmt.Errorf("invalid %s cache size: %d", cache.name, cache.size)
+		}
+	}
+	return nil
+}
+
+// withDefaults returns the cache sizes, with the default size for every unset cache.
+func (c SourceCacheConfig) withDefaults() SourceCacheConfig {
+	for _, size := range []*int{&c.Receipts, &c.Transactions, &c.Headers, &c.Payloads, &c.BlockRefs} {
+		if *size == 0 {
+			*size = defaultSourceCacheSize
+		}
+	}
+	return c
+}
+
+// NewSuperchainConfig returns the config of a backend of the L2 node with the given peers, with all
+// other options at their defaults.
+func NewSuperchainConfig(l2NodeAddr string, peerL2NodeAddrs map[ChainID]string) *SuperchainConfig {
+	return &SuperchainConfig{
+		L2NodeAddr:      l2NodeAddr,
+		PeerL2NodeAddrs: peerL2NodeAddrs,
+	}
+}
+
+// Check verifies the config is complete before any of the addresses are dialed.
+// Chain ids are unique by construction, as the peers are keyed by chain id.
+func (c *SuperchainConfig) Check() error {
+	if c.L2NodeAddr == "" {
+		return errors.New("missing l2 node address")
+	}
+	if err := checkAddr(c.L2NodeAddr); err != nil {
+		return fmt.Errorf("invalid l2 node address: %w", err)
+	}
+	if c.RPCTimeout < 0 {
+		return fmt.Errorf("invalid rpc timeout: %s", c.RPCTimeout)
+	}
+	if c.FinalizedPollInterval < 0 {
+		return fmt.Errorf("invalid finalized poll interval: %s", c.FinalizedPollInterval)
+	}
+	if c.FinalizedPollJitter < 0 || c.FinalizedPollJitter >= 1 {
+		return fmt.Errorf("invalid finalized poll jitter: %v", c.FinalizedPollJitter)
+	}
+	if c.PollTimeout < 0 {
+		return fmt.Errorf("invalid poll timeout: %s", c.PollTimeout)
+	}
+	if c.BatchedHeadPollInterval < 0 {
+		return fmt.Errorf("invalid batched head poll interval: %s", c.BatchedHeadPollInterval)
+	}
+	for chainId, timeout := range c.PeerTimeouts {
+		if _, ok := c.PeerL2NodeAddrs[chainId]; !ok {
+			return fmt.Errorf("timeout of unknown peer with chain id %s", chainId)
+		}
+		if timeout <= 0 {
+			return fmt.Errorf("invalid timeout %s of peer with chain id %s", timeout, chainId)
+		}
+	}
+	for chainId, size := range c.PeerConnPoolSizes {
+		if _, ok := c.PeerL2NodeAddrs[chainId]; !ok {
+			return fmt.Errorf("connection pool size of unknown peer with chain id %s", chainId)
+		}
+		if size <= 0 {
+			return fmt.Errorf("invalid connection pool size %d of peer with chain id %s", size, chainId)
+		}
+	}
+	if c.RPCRetries < 0 {
+		return fmt.Errorf("invalid rpc retries: %d", c.RPCRetries)
+	}
+	if c.RPCRetryDelay < 0 {
+		return fmt.Errorf("invalid rpc retry delay: %s", c.RPCRetryDelay)
+	}
+	if c.DependencySetRefreshInterval < 0 {
+		return fmt.Errorf("invalid dependency set refresh interval: %s", c.DependencySetRefreshInterval)
+	}
+	if c.PeerBreakerThreshold < 0 {
+		return fmt.Errorf("invalid peer breaker threshold: %d", c.PeerBreakerThreshold)
+	}
+	if c.PeerBreakerCooldown < 0 {
+		return fmt.Errorf("invalid peer breaker cooldown: %s", c.PeerBreakerCooldown)
+	}
+	if c.MaxPayloadSize < 0 {
+		return fmt.Errorf("invalid max payload size: %d", c.MaxPayloadSize)
+	}
+	if c.MaxConcurrentRPCs < 0 {
+		return fmt.Errorf("invalid max concurrent rpcs: %d", c.MaxConcurrentRPCs)
+	}
+	if c.BatchConcurrency < 0 {
+		return fmt.Errorf("invalid batch concurrency: %d", c.BatchConcurrency)
+	}
+	if c.MaxBatchSize < 0 {
+		return fmt.Errorf("invalid max batch size: %d", c.MaxBatchSize)
+	}
+	if c.ExpiryWindow < 0 {
+		return fmt.Errorf("invalid expiry window: %s", c.ExpiryWindow)
+	}
+	if err := c.SourceCaches.Check(); err != nil {
+		return fmt.Errorf("invalid source caches: %w", err)
+	}
+	if c.MessageCacheSize < 0 {
+		return fmt.Errorf("invalid message cache size: %d", c.MessageCacheSize)
+	}
+	if c.MaxFinalizedWatches < 0 {
+		return fmt.Errorf("invalid max finalized watches: %d", c.MaxFinalizedWatches)
+	}
+	if c.BlockCacheSize < 0 {
+		return fmt.Errorf("invalid block cache size: %d", c.BlockCacheSize)
+	}
+	if c.HeaderCacheSize < 0 {
+		return fmt.Errorf("invalid header cache size: %d", c.HeaderCacheSize)
+	}
+	for chainId := range c.PeerHTTPHeaders {
+		if _, ok := c.PeerL2NodeAddrs[chainId]; !ok {
+			return fmt.Errorf("http headers of unknown peer with chain id %s", chainId)
+		}
+	}
+	for chainId := range c.PeerTLS {
+		if _, ok := c.PeerL2NodeAddrs[chainId]; !ok {
+			return fmt.Errorf("tls config of unknown peer with chain id %s", chainId)
+		}
+	}
+	for chainId, label := range c.DevnetFinalityLabels {
+		if _, ok := c.PeerL2NodeAddrs[chainId]; !ok {
+			return fmt.Errorf("devnet finality label of unknown peer with chain id %s", chainId)
+		}
+		if label != eth.Unsafe && label != eth.Safe && label != eth.Finalized {
+			return fmt.Errorf("invalid devnet finality label %q of peer with chain id %s", label, chainId)
+		}
+	}
+	for chainId := range c.PeerOriginAllowlists {
+		if _, ok := c.PeerL2NodeAddrs[chainId]; !ok {
+			return fmt.Errorf("origin allowlist of unknown peer with chain id %s", chainId)
+		}
+	}
+	for chainId, proxies := range c.PeerOriginProxies {
+		if _, ok := c.PeerL2NodeAddrs[chainId]; !ok {
+			return fmt.Errorf("origin proxies of unknown peer with chain id %s", chainId)
+		}
+		impls := make(map[common.Address]common.Address, len(proxies))
+		for proxy, impl := range proxies {
+			if other, ok := impls[impl]; ok {
+				return fmt.Errorf("implementation %s of both proxies %s and %s on chain id %s", impl, other, proxy, chainId)
+			}
+			impls[impl] = proxy
+		}
+	}
+	for chainId, blockTime := range c.PeerBlockTimes {
+		if _, ok := c.PeerL2NodeAddrs[chainId]; !ok {
+			return fmt.Errorf("block time of unknown peer with chain id %s", chainId)
+		}
+		if blockTime.BlockTime == 0 {
+			return fmt.Errorf("missing block time of peer with chain id %s", chainId)
+		}
+		if blockTime.Tolerance < 0 {
+			return fmt.Errorf("invalid block time tolerance %s of peer with chain id %s", blockTime.Tolerance, chainId)
+		}
+	}
+	for chainId, kind := range c.PeerRPCKinds {
+		if _, ok := c.PeerL2NodeAddrs[chainId]; !ok {
+			return fmt.Errorf("rpc kind of unknown peer with chain id %s", chainId)
+		}
+		if !sources.ValidRPCProviderKind(kind) {
+			return fmt.Errorf("invalid rpc kind %q of peer with chain id %s", kind, chainId)
+		}
+	}
+	for chainId, fetcher := range c.PeerLogFetchers {
+		if _, ok := c.PeerL2NodeAddrs[chainId]; !ok {
+			return fmt.Errorf("log fetcher of unknown peer with chain id %s", chainId)
+		}
+		if fetcher == nil {
+			return fmt.Errorf("missing log fetcher of peer with chain id %s", chainId)
+		}
+	}
+	for _, kind := range c.BlockReceiptsRPCKinds {
+		if !sources.ValidRPCProviderKind(kind) {
+			return fmt.Errorf("invalid block receipts rpc kind %q", kind)
+		}
+	}
+	for chainId := range c.PeerFallbackL2NodeAddrs {
+		if _, ok := c.PeerL2NodeAddrs[chainId]; !ok {
+			return fmt.Errorf("fallback addresses of unknown peer with chain id %s", chainId)
+		}
+	}
+	for chainId, addr := range c.PeerL2NodeAddrs {
+		if chainId.IsZero() {
+			return fmt.Errorf("invalid peer chain id 0 for address %q", addr)
+		}
+		for _, addr := range c.peerAddrs(chainId) {
+			if err := checkAddr(addr); err != nil {
+				return fmt.Errorf("invalid address of peer with chain id %s: %w", chainId, err)
+			}
+			if c.UseSubscriptions && !isWebsocketAddr(addr) {
+				return fmt.Errorf("subscriptions require a websocket address of peer with chain id %s: %q", chainId, addr)
+			}
+		}
+	}
+	return nil
+}
+
+// peerAddrs returns the addresses of all the endpoints of the peer, starting with the primary endpoint.
+func (c *SuperchainConfig) peerAddrs(chainId ChainID) []string {
+	return append([]string{c.PeerL2NodeAddrs[chainId]}, c.PeerFallbackL2NodeAddrs[chainId]...)
+}
+
+// peerRPCKind returns the kind of RPC provider serving the peer.
+func (c *SuperchainConfig) peerRPCKind(chainId ChainID) sources.RPCProviderKind {
+	if kind, ok := c.PeerRPCKinds[chainId]; ok {
+		return kind
+	}
+	return sources.RPCKindAny
+}
+
+func checkAddr(addr string) error {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("address %q must include a scheme and host", addr)
+	}
+	return nil
+}
+
+func isWebsocketAddr(addr string) bool {
+	u, err := url.Parse(addr)
+	return err == nil && (u.Scheme == "ws" || u.Scheme == "wss")
+}
@@ -0,0 +1,321 @@
+package superchain
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+	"github.com/ethereum-optimism/optimism/op-service/sources"
+)
+
+func validConfig() *SuperchainConfig {
+	return &SuperchainConfig{
+		L2NodeAddr: "http://localhost:9545",
+		PeerL2NodeAddrs: map[ChainID]string{
+			ChainIDFromUInt64(900): "ws://localhost:8546",
+			ChainIDFromUInt64(901): "https://rpc.example.com",
+		},
+	}
+}
+
+func TestConfigCheck(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		require.NoError(t, validConfig().Check())
+	})
+
+	t.Run("Defaults", func(t *testing.T) {
+		cfg := NewSuperchainConfig("http://localhost:9545", nil)
+		require.False(t, cfg.SkipChainIDVerification)
+		require.NoError(t, cfg.Check())
+	})
+
+	t.Run("NoPeers", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.PeerL2NodeAddrs = nil
+		require.NoError(t, cfg.Check())
+	})
+
+	t.Run("MissingL2NodeAddr", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.L2NodeAddr = ""
+		require.ErrorContains(t, cfg.Check(), "missing l2 node address")
+	})
+
+	t.Run("MalformedL2NodeAddr", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.L2NodeAddr = "localhost"
+		require.ErrorContains(t, cfg.Check(), "invalid l2 node address")
+	})
+
+	t.Run("MalformedPeerAddr", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.PeerL2NodeAddrs[ChainIDFromUInt64(902)] = "http://%zz"
+		require.ErrorContains(t, cfg.Check(), "invalid address of peer with chain id 902")
+	})
+
+	t.Run("FallbackPeerAddrs", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.PeerFallbackL2NodeAddrs = map[ChainID][]string{ChainIDFromUInt64(901): {"http://fallback:8545"}}
+		require.NoError(t, cfg.Check())
+		require.Equal(t, []string{cfg.PeerL2NodeAddrs[ChainIDFromUInt64(901)], "http://fallback:8545"}, cfg.peerAddrs(ChainIDFromUInt64(901)))
+		cfg.PeerFallbackL2NodeAddrs[ChainIDFromUInt64(901)] = []string{"fallback"}
+		require.ErrorContains(t, cfg.Check(), "invalid address of peer with chain id 901")
+		cfg.PeerFallbackL2NodeAddrs = map[ChainID][]string{ChainIDFromUInt64(999): {"http://fallback:8545"}}
+		require.ErrorContains(t, cfg.Check(), "fallback addresses of unknown peer with chain id 999")
+	})
+
+	t.Run("NegativeMessageCacheSize", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.MessageCacheSize = -1
+		require.ErrorContains(t, cfg.Check(), "invalid message cache size")
+	})
+
+	t.Run("NegativeRPCTimeout", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.RPCTimeout = -time.Second
+		require.ErrorContains(t, cfg.Check(), "invalid rpc timeout")
+	})
+
+	t.Run("NegativeFinalizedPollInterval", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.FinalizedPollInterval = -time.Second
+		require.ErrorContains(t, cfg.Check(), "invalid finalized poll interval")
+	})
+
+	t.Run("NegativeBatchedHeadPollInterval", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.BatchedHeadPollInterval = -time.Second
+		require.ErrorContains(t, cfg.Check(), "invalid batched head poll interval")
+	})
+
+	t.Run("InvalidFinalizedPollJitter", func(t *testing.T) {
+		for _, jitter := range []float64{-0.1, 1, 1.5} {
+			cfg := validConfig()
+			cfg.FinalizedPollJitter = jitter
+			require.ErrorContains(t, cfg.Check(), "invalid finalized poll jitter")
+		}
+		cfg := validConfig()
+		cfg.FinalizedPollJitter = 0.1
+		require.NoError(t, cfg.Check())
+	})
+
+	t.Run("NegativePeerBreakerThreshold", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.PeerBreakerThreshold = -1
+		require.ErrorContains(t, cfg.Check(), "invalid peer breaker threshold")
+	})
+
+	t.Run("NegativePeerBreakerCooldown", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.PeerBreakerCooldown = -time.Second
+		require.ErrorContains(t, cfg.Check(), "invalid peer breaker cooldown")
+	})
+
+	t.Run("NegativePollTimeout", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.PollTimeout = -time.Second
+		require.ErrorContains(t, cfg.Check(), "invalid poll timeout")
+	})
+
+	t.Run("NegativeRPCRetries", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.RPCRetries = -1
+		require.ErrorContains(t, cfg.Check(), "invalid rpc retries")
+	})
+
+	t.Run("NegativeRPCRetryDelay", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.RPCRetryDelay = -time.Second
+		require.ErrorContains(t, cfg.Check(), "invalid rpc retry delay")
+	})
+
+	t.Run("NegativeDependencySetRefreshInterval", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.DependencySetRefreshInterval = -time.Second
+		require.ErrorContains(t, cfg.Check(), "invalid dependency set refresh interval")
+	})
+
+	t.Run("NegativeMaxPayloadSize", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.MaxPayloadSize = -1
+		require.ErrorContains(t, cfg.Check(), "invalid max payload size")
+	})
+
+	t.Run("NegativeMaxConcurrentRPCs", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.MaxConcurrentRPCs = -1
+		require.ErrorContains(t, cfg.Check(), "invalid max concurrent rpcs")
+	})
+
+	t.Run("NegativeBatchConcurrency", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.BatchConcurrency = -1
+		require.ErrorContains(t, cfg.Check(), "invalid batch concurrency")
+	})
+
+	t.Run("NegativeMaxBatchSize", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.MaxBatchSize = -1
+		require.ErrorContains(t, cfg.Check(), "invalid max batch size")
+	})
+
+	t.Run("NegativeExpiryWindow", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.ExpiryWindow = -time.Hour
+		require.ErrorContains(t, cfg.Check(), "invalid expiry window")
+	})
+
+	t.Run("PeerRPCKinds", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.PeerRPCKinds = map[ChainID]sources.RPCProviderKind{ChainIDFromUInt64(901): sources.RPCKindAlchemy}
+		require.NoError(t, cfg.Check())
+		require.Equal(t, sources.RPCKindAlchemy, cfg.peerRPCKind(ChainIDFromUInt64(901)))
+		require.Equal(t, sources.RPCKindAny, cfg.peerRPCKind(ChainIDFromUInt64(900)))
+	})
+
+	t.Run("InvalidPeerRPCKind", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.PeerRPCKinds = map[ChainID]sources.RPCProviderKind{ChainIDFromUInt64(901): "geth"}
+		require.ErrorContains(t, cfg.Check(), `invalid rpc kind "geth" of peer with chain id 901`)
+	})
+
+	t.Run("BlockReceiptsRPCKinds", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.BlockReceiptsRPCKinds = []sources.RPCProviderKind{sources.RPCKindAlchemy}
+		require.NoError(t, cfg.Check())
+		cfg.BlockReceiptsRPCKinds = []sources.RPCProviderKind{"geth"}
+		require.ErrorContains(t, cfg.Check(), `invalid block receipts rpc kind "geth"`)
+	})
+
+	t.Run("RPCKindOfUnknownPeer", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.PeerRPCKinds = map[ChainID]sources.RPCProviderKind{ChainIDFromUInt64(902): sources.RPCKindBasic}
+		require.ErrorContains(t, cfg.Check(), "rpc kind of unknown peer with chain id 902")
+	})
+
+	t.Run("OriginAllowlistOfUnknownPeer", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.PeerOriginAllowlists = map[ChainID][]common.Address{ChainIDFromUInt64(902): {{0xaa}}}
+		require.ErrorContains(t, cfg.Check(), "origin allowlist of unknown peer with chain id 902")
+	})
+
+	t.Run("PeerTimeouts", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.PeerTimeouts = map[ChainID]time.Duration{ChainIDFromUInt64(901): time.Minute}
+		require.NoError(t, cfg.Check())
+		cfg.PeerTimeouts[ChainIDFromUInt64(901)] = 0
+		require.ErrorContains(t, cfg.Check(), "invalid timeout 0s of peer with chain id 901")
+		cfg.PeerTimeouts = map[ChainID]time.Duration{ChainIDFromUInt64(902): time.Minute}
+		require.ErrorContains(t, cfg.Check(), "timeout of unknown peer with chain id 902")
+	})
+
+	t.Run("PeerConnPoolSizes", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.PeerConnPoolSizes = map[ChainID]int{ChainIDFromUInt64(901): 4}
+		require.NoError(t, cfg.Check())
+		cfg.PeerConnPoolSizes[ChainIDFromUInt64(901)] = 0
+		require.ErrorContains(t, cfg.Check(), "invalid connection pool size 0 of peer with chain id 901")
+		cfg.PeerConnPoolSizes = map[ChainID]int{ChainIDFromUInt64(902): 4}
+		require.ErrorContains(t, cfg.Check(), "connection pool size of unknown peer with chain id 902")
+	})
+
+	t.Run("LogFetchers", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.PeerLogFetchers = map[ChainID]LogFetcher{ChainIDFromUInt64(901): NewFakeLogFetcher()}
+		require.NoError(t, cfg.Check())
+		cfg.PeerLogFetchers[ChainIDFromUInt64(901)] = nil
+		require.ErrorContains(t, cfg.Check(), "missing log fetcher of peer with chain id 901")
+		cfg.PeerLogFetchers = map[ChainID]LogFetcher{ChainIDFromUInt64(902): NewFakeLogFetcher()}
+		require.ErrorContains(t, cfg.Check(), "log fetcher of unknown peer with chain id 902")
+	})
+
+	t.Run("OriginProxies", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.PeerOriginProxies = map[ChainID]map[common.Address]common.Address{ChainIDFromUInt64(901): {{0xaa}: {0xa1}}}
+		require.NoError(t, cfg.Check())
+		cfg.PeerOriginProxies[ChainIDFromUInt64(901)][common.Address{0xbb}] = common.Address{0xa1}
+		require.ErrorContains(t, cfg.Check(), "implementation 0xA100000000000000000000000000000000000000 of both proxies")
+		cfg.PeerOriginProxies = map[ChainID]map[common.Address]common.Address{ChainIDFromUInt64(902): {{0xaa}: {0xa1}}}
+		require.ErrorContains(t, cfg.Check(), "origin proxies of unknown peer with chain id 902")
+	})
+
+	t.Run("BlockTimes", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.PeerBlockTimes = map[ChainID]BlockTimeConfig{ChainIDFromUInt64(901): {BlockTime: 2, Tolerance: time.Minute}}
+		require.NoError(t, cfg.Check())
+		cfg.PeerBlockTimes[ChainIDFromUInt64(901)] = BlockTimeConfig{Tolerance: time.Minute}
+		require.ErrorContains(t, cfg.Check(), "missing block time of peer with chain id 901")
+		cfg.PeerBlockTimes[ChainIDFromUInt64(901)] = BlockTimeConfig{BlockTime: 2, Tolerance: -time.Second}
+		require.ErrorContains(t, cfg.Check(), "invalid block time tolerance -1s of peer with chain id 901")
+		cfg.PeerBlockTimes = map[ChainID]BlockTimeConfig{ChainIDFromUInt64(902): {BlockTime: 2}}
+		require.ErrorContains(t, cfg.Check(), "block time of unknown peer with chain id 902")
+	})
+
+	t.Run("HTTPHeadersOfUnknownPeer", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.PeerHTTPHeaders = map[ChainID]http.Header{ChainIDFromUInt64(902): {"Authorization": {"Bearer secret"}}}
+		require.ErrorContains(t, cfg.Check(), "http headers of unknown peer with chain id 902")
+	})
+
+	t.Run("TLSOfUnknownPeer", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.PeerTLS = map[ChainID]PeerTLSConfig{ChainIDFromUInt64(902): {CAFile: "ca.pem"}}
+		require.ErrorContains(t, cfg.Check(), "tls config of unknown peer with chain id 902")
+	})
+
+	t.Run("DevnetFinalityLabels", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.DevnetFinalityLabels = map[ChainID]eth.BlockLabel{ChainIDFromUInt64(901): eth.Safe}
+		require.NoError(t, cfg.Check())
+		cfg.DevnetFinalityLabels = map[ChainID]eth.BlockLabel{ChainIDFromUInt64(901): "pending"}
+		require.ErrorContains(t, cfg.Check(), "invalid devnet finality label")
+		cfg.DevnetFinalityLabels = map[ChainID]eth.BlockLabel{ChainIDFromUInt64(902): eth.Safe}
+		require.ErrorContains(t, cfg.Check(), "devnet finality label of unknown peer with chain id 902")
+	})
+
+	t.Run("NegativeHeaderCacheSize", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.HeaderCacheSize = -1
+		require.ErrorContains(t, cfg.Check(), "invalid header cache size")
+	})
+
+	t.Run("NegativeSourceCacheSize", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.SourceCaches.Headers = -1
+		require.ErrorContains(t, cfg.Check(), "invalid source caches: invalid headers cache size: -1")
+	})
+
+	t.Run("ZeroChainId", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.PeerL2NodeAddrs[ChainID{}] = "http://localhost:8545"
+		require.ErrorContains(t, cfg.Check(), "invalid peer chain id 0")
+	})
+
+	t.Run("SubscriptionsWithWebsocketPeers", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.UseSubscriptions = true
+		cfg.PeerL2NodeAddrs[ChainIDFromUInt64(901)] = "wss://rpc.example.com"
+		require.NoError(t, cfg.Check())
+	})
+
+	t.Run("SubscriptionsWithHTTPPeer", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.UseSubscriptions = true
+		require.ErrorContains(t, cfg.Check(), "subscriptions require a websocket address of peer with chain id 901")
+	})
+}
+
+func TestSourceCacheConfigDefaults(t *testing.T) {
+	require.Equal(t, SourceCacheConfig{
+		Receipts:     defaultSourceCacheSize,
+		Transactions: defaultSourceCacheSize,
+		Headers:      1000,
+		Payloads:     defaultSourceCacheSize,
+		BlockRefs:    defaultSourceCacheSize,
+	}, SourceCacheConfig{Headers: 1000}.withDefaults())
+}
@@ -0,0 +1,33 @@
+package superchain
+
+// SetConservativeMode enables or disables the conservative mode. In conservative mode, every message that
+// would be labeled Safe, CrossSafe or Finalized is labeled Unsafe instead, regardless of the heads of the
+// peer chain, as a kill switch during incidents. Invalid messages remain Invalid, and finalized watches
+// still fire once the message is finalized.
+func (b *backend) SetConservativeMode(enabled bool) {
+	if b.conservativeMode.Swap(enabled) == enabled {
+		return
+	}
+	if enabled {
+		b.log.Error("conservative mode enabled, messages are labeled at most unsafe regardless of finality")
+	} else {
+		b.log.Warn("conservative mode disabled, messages are labeled against the heads of the peer chains")
+	}
+	b.metrics.RecordConservativeMode(enabled)
+}
+
+// capLabel caps the label of the message at Unsafe in conservative mode.
+func (b *backend) capLabel(id MessageIdentifier, label MessageSafetyLabel) MessageSafetyLabel {
+	if !b.conservativeMode.Load() {
+		return label
+	}
+	switch label {
+	case Safe, CrossSafe, Finalized:
+		b.log.Warn("message label capped by conservative mode", "chain_id", id.ChainId, "block_number", id.BlockNumber,
+			"log_index", id.LogIndex, "label", label)
+		b.metrics.RecordConservativeCap(b.chainIdLabel(id), label)
+		return Unsafe
+	default:
+		return label
+	}
+}
@@ -0,0 +1,74 @@
+package superchain
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	"github.com/ethereum-optimism/optimism/op-service/client"
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+)
+
+func TestConservativeMode(t *testing.T) {
+	peer := newStubRPC()
+	peer.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x01}))
+	peer.addBlock(11, 102, testLog(common.Address{0xaa}, []byte{0x02}))
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	setHeads(b, chainId, &eth.L1BlockRef{Number: 10, Time: 100}, nil, &eth.L1BlockRef{Number: 11, Time: 102})
+	finalized, finalizedPayload := testMessage(900, peer, 10, 0)
+	unsafe, unsafePayload := testMessage(900, peer, 11, 0)
+
+	// the finalized result is cached before the mode is enabled
+	label, err := b.MessageSafety(context.Background(), finalized, finalizedPayload)
+	require.NoError(t, err)
+	require.Equal(t, Finalized, label)
+
+	b.SetConservativeMode(true)
+	require.Equal(t, 1.0, testutil.ToFloat64(b.metrics.ConservativeMode))
+
+	label, err = b.MessageSafety(context.Background(), finalized, finalizedPayload)
+	require.NoError(t, err)
+	require.Equal(t, Unsafe, label)
+
+	res, err := b.MessageSafetyDetails(context.Background(), finalized, finalizedPayload)
+	require.NoError(t, err)
+	require.Equal(t, Unsafe, res.Label)
+
+	labels, err := b.MessageSafetyBatch(context.Background(), []MessageIdentifier{finalized, unsafe}, []hexutil.Bytes{finalizedPayload, unsafePayload})
+	require.NoError(t, err)
+	require.Equal(t, []MessageSafetyLabel{Unsafe, Unsafe}, labels)
+
+	label, err = b.MessageSafetyAt(context.Background(), finalized, finalizedPayload, 100)
+	require.NoError(t, err)
+	require.Equal(t, Unsafe, label)
+
+	log := peer.logs[10][0]
+	label, err = b.MessageSafetyFromLog(context.Background(), finalized, finalizedPayload, &log, 100)
+	require.NoError(t, err)
+	require.Equal(t, Unsafe, label)
+
+	trace, err := b.MessageSafetyExplain(context.Background(), finalized, finalizedPayload)
+	require.NoError(t, err)
+	require.Equal(t, Unsafe, trace.Result.Label)
+
+	// invalid messages remain invalid
+	label, err = b.MessageSafety(context.Background(), finalized, hexutil.Bytes{0xff})
+	require.Error(t, err)
+	require.Equal(t, Invalid, label)
+
+	require.Equal(t, 6.0, testutil.ToFloat64(b.metrics.ConservativeCapsTotal.WithLabelValues("900", string(Finalized))))
+	require.Equal(t, 6.0, testutil.ToFloat64(b.metrics.MessageSafetyTotal.WithLabelValues("900", string(Unsafe))))
+
+	b.SetConservativeMode(false)
+	require.Zero(t, testutil.ToFloat64(b.metrics.ConservativeMode))
+	label, err = b.MessageSafety(context.Background(), finalized, finalizedPayload)
+	require.NoError(t, err)
+	require.Equal(t, Finalized, label)
+}
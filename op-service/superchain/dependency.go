@@ -0,0 +1,118 @@
+package superchain
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"github.com/ethereum-optimism/optimism/op-service/client"
+)
+
+const defaultDependencySetRefreshInterval = time.Minute
+
+// isInDependencySetSelector is the selector of isInDependencySet(uint256), the getter of the
+// dependency set registry that returns whether the chain id is permitted to send messages.
+var isInDependencySetSelector = crypto.Keccak256([]byte("isInDependencySet(uint256)"))[:4]
+
+// fetchDependencySet queries the registry, through the L2 node, for which of the chain ids are in the
+// dependency set of this chain. All the chain ids are queried with a single batch request.
+func fetchDependencySet(ctx context.Context, l2Node client.RPC, registry common.Address, chainIds []ChainID) (map[ChainID]bool, error) {
+	results := make([]hexutil.Bytes, len(chainIds))
+	batchElems := make([]rpc.BatchElem, len(chainIds))
+	for i, chainId := range chainIds {
+		id := chainId.ToBig()
+		data := append(append([]byte{}, isInDependencySetSelector...), common.LeftPadBytes(id.Bytes(), 32)...)
+		callArgs := map[string]interface{}{"to": registry, "data": hexutil.Bytes(data)}
+		batchElems[i] = rpc.BatchElem{Method: "eth_call", Args: []interface{}{callArgs, "latest"}, Result: &results[i]}
+	}
+	if err := l2Node.BatchCallContext(ctx, batchElems); err != nil {
+		return nil, fmt.Errorf("unable to query dependency set: %w", err)
+	}
+	set := make(map[ChainID]bool, len(chainIds))
+	for i, chainId := range chainIds {
+		if err := batchElems[i].Error; err != nil {
+			return nil, fmt.Errorf("unable to query dependency set for chain id %s: %w", chainId, err)
+		}
+		if len(results[i]) != 32 {
+			return nil, fmt.Errorf("invalid dependency set result for chain id %s: %s", chainId, results[i])
+		}
+		set[chainId] = results[i][31] == 1
+	}
+	return set, nil
+}
+
+// refreshDependencySet updates the dependency set from the registry.
+// The previous dependency set is kept if the registry cannot be queried.
+func (b *backend) refreshDependencySet(ctx context.Context) error {
+	rpcCtx, cancel := context.WithTimeout(ctx, b.rpcTimeout)
+	defer cancel()
+	set, err := fetchDependencySet(rpcCtx, b.l2Node, b.dependencySetAddr, b.peerChainIDs())
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.dependencySet = set
+	return nil
+}
+
+// pollDependencySet refreshes the dependency set on every interval.
+func (b *backend) pollDependencySet(interval time.Duration) ethereum.Subscription {
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-quit:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := b.refreshDependencySet(ctx); err != nil {
+					b.log.Warn("failed to refresh dependency set", "registry", b.dependencySetAddr, "err", err)
+				}
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	})
+}
+
+// DependencySet returns the sorted chain ids of the configured peers that are permitted to send messages to this
+// chain. Every configured peer is permitted if the dependency set is not queried from a registry.
+func (b *backend) DependencySet() []ChainID {
+	chainIds := b.peerChainIDs()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.dependencySet == nil {
+		return chainIds
+	}
+	return slices.DeleteFunc(chainIds, func(chainId ChainID) bool {
+		return !b.dependencySet[chainId]
+	})
+}
+
+// checkDependencySet checks the peer chain is permitted to send messages to this chain.
+// Every configured peer is permitted if the dependency set is not queried from a registry.
+func (b *backend) checkDependencySet(chainId ChainID) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.dependencySet != nil && !b.dependencySet[chainId] {
+		return fmt.Errorf("chain id %s is not in the dependency set", chainId)
+	}
+	return nil
+}
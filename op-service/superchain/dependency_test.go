@@ -0,0 +1,86 @@
+package superchain
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	"github.com/ethereum-optimism/optimism/op-service/client"
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+)
+
+func TestDependencySet(t *testing.T) {
+	origin := common.Address{0xaa}
+	peerA, peerB := newStubRPC(), newStubRPC()
+	peerA.addBlock(10, 100, testLog(origin, []byte{0x01}))
+	peerB.addBlock(10, 100, testLog(origin, []byte{0x02}))
+	chainA, chainB := ChainIDFromUInt64(900), ChainIDFromUInt64(901)
+
+	b := newTestBackend(t, map[ChainID]client.RPC{chainA: peerA, chainB: peerB})
+	for _, chainId := range []ChainID{chainA, chainB} {
+		// unsafe results are not cached
+		setHeads(b, chainId, nil, nil, &eth.L1BlockRef{Number: 10, Time: 100})
+	}
+
+	check := func(chainId uint64, peer *stubRPC) (MessageSafetyResult, error) {
+		id, payload := testMessage(chainId, peer, 10, 0)
+		return b.MessageSafetyDetails(context.Background(), id, payload)
+	}
+
+	t.Run("Static", func(t *testing.T) {
+		res, err := check(901, peerB)
+		require.NoError(t, err)
+		require.Equal(t, Unsafe, res.Label)
+		require.Equal(t, []ChainID{chainA, chainB}, b.DependencySet())
+	})
+
+	registry := &stubRPC{dependencySet: []uint64{900}}
+	b.l2Node = registry
+	b.dependencySetAddr = common.Address{0x42}
+	require.NoError(t, b.refreshDependencySet(context.Background()))
+
+	t.Run("Permitted", func(t *testing.T) {
+		res, err := check(900, peerA)
+		require.NoError(t, err)
+		require.Equal(t, Unsafe, res.Label)
+		require.Equal(t, []ChainID{chainA}, b.DependencySet())
+	})
+
+	t.Run("NotPermitted", func(t *testing.T) {
+		res, err := check(901, peerB)
+		require.ErrorContains(t, err, "chain id 901 is not in the dependency set")
+		require.Equal(t, ReasonNotInDependencySet, res.Reason)
+
+		id, payload := testMessage(901, peerB, 10, 0)
+		labels, err := b.MessageSafetyBatch(context.Background(), []MessageIdentifier{id}, []hexutil.Bytes{payload})
+		require.ErrorContains(t, err, "message 0: chain id 901 is not in the dependency set")
+		require.Equal(t, []MessageSafetyLabel{Invalid}, labels)
+	})
+
+	t.Run("FailedRefreshKeepsSet", func(t *testing.T) {
+		registry.err = errors.New("connection refused")
+		require.ErrorContains(t, b.refreshDependencySet(context.Background()), "connection refused")
+		registry.err = nil
+		_, err := check(901, peerB)
+		require.ErrorContains(t, err, "not in the dependency set")
+	})
+
+	t.Run("Refresh", func(t *testing.T) {
+		registry.mu.Lock()
+		registry.dependencySet = []uint64{900, 901}
+		registry.mu.Unlock()
+		sub := b.pollDependencySet(time.Millisecond * 10)
+		defer sub.Unsubscribe()
+		require.Eventually(t, func() bool {
+			_, err := check(901, peerB)
+			return err == nil
+		}, time.Second, time.Millisecond*10)
+		require.Equal(t, []ChainID{chainA, chainB}, b.DependencySet())
+	})
+}
@@ -0,0 +1,56 @@
+package superchain
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrPeerNotConfigured is returned for a message of a chain without configured peer, a configuration
+	// problem rather than a property of the message. The chain id is available with errors.As on a
+	// *PeerNotConfiguredError.
+	ErrPeerNotConfigured = errors.New("peer not configured")
+
+	// ErrFetchFailed categorizes the errors of messages whose block or logs could not be fetched from the
+	// peer, including ErrRPCTimeout and ErrPeerUnavailable. The check of the message can be retried.
+	ErrFetchFailed = errors.New("fetch failed")
+
+	// ErrIntegrityMismatch categorizes the errors of messages that do not match the block or the log they
+	// reference on the peer chain.
+	ErrIntegrityMismatch = errors.New("integrity mismatch")
+)
+
+// PeerNotConfiguredError is the error of a message of a chain without configured peer, see ErrPeerNotConfigured.
+type PeerNotConfiguredError struct {
+	ChainID ChainID
+}
+
+func (e *PeerNotConfiguredError) Error() string {
+	return fmt.Sprintf("peer with chain id %s is not configured", e.ChainID)
+}
+
+func (e *PeerNotConfiguredError) Is(target error) bool {
+	return target == ErrPeerNotConfigured
+}
+
+// categorizedError is an error of a category, matched with errors.Is, without changing the message of the error.
+type categorizedError struct {
+	category error
+	err      error
+}
+
+func (e *categorizedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *categorizedError) Unwrap() []error {
+	return []error{e.category, e.err}
+}
+
+// categorize returns the error as an error of the category, or nil if the error is nil.
+func categorize(category error, err error) error {
+	if err == nil || errors.Is(err, category) {
+		return err
+	}
+	return &categorizedError{category: category, err: err}
+}
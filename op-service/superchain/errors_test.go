@@ -0,0 +1,101 @@
+package superchain
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	"github.com/ethereum-optimism/optimism/op-service/client"
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+)
+
+func TestMessageSafetyErrors(t *testing.T) {
+	peer := newStubRPC()
+	peer.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x01}))
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	setHeads(b, chainId, &eth.L1BlockRef{Number: 10, Time: 100}, nil, &eth.L1BlockRef{Number: 10, Time: 100})
+
+	t.Run("PeerNotConfigured", func(t *testing.T) {
+		id, payload := testMessage(900, peer, 10, 0)
+		id.ChainId = big.NewInt(901)
+		_, err := b.MessageSafety(context.Background(), id, payload)
+		require.ErrorIs(t, err, ErrPeerNotConfigured)
+		require.EqualError(t, err, "peer with chain id 901 is not configured")
+		var notConfigured *PeerNotConfiguredError
+		require.ErrorAs(t, err, &notConfigured)
+		require.Equal(t, ChainIDFromUInt64(901), notConfigured.ChainID)
+		require.NotErrorIs(t, err, ErrFetchFailed)
+		require.NotErrorIs(t, err, ErrIntegrityMismatch)
+
+		_, err = b.MessageSafetyBatch(context.Background(), []MessageIdentifier{id}, []hexutil.Bytes{payload})
+		require.ErrorIs(t, err, ErrPeerNotConfigured)
+		_, err = b.WatchFinalized(id, func(eth.L1BlockRef) {})
+		require.ErrorIs(t, err, ErrPeerNotConfigured)
+	})
+
+	t.Run("FetchFailed", func(t *testing.T) {
+		peer.err = errors.New("connection refused")
+		defer func() { peer.err = nil }()
+		id, payload := testMessage(900, peer, 10, 0)
+		_, err := b.MessageSafety(context.Background(), id, payload)
+		require.ErrorIs(t, err, ErrFetchFailed)
+		require.ErrorIs(t, err, peer.err)
+		require.EqualError(t, err, "unable to request logs: connection refused")
+		require.NotErrorIs(t, err, ErrIntegrityMismatch)
+
+		_, err = b.MessageSafetyBatch(context.Background(), []MessageIdentifier{id}, []hexutil.Bytes{payload})
+		require.ErrorIs(t, err, ErrFetchFailed)
+	})
+
+	t.Run("FetchFailedBlock", func(t *testing.T) {
+		id, payload := testMessage(900, peer, 10, 0)
+		id.BlockNumber = new(big.Int).Lsh(big.NewInt(1), 64)
+		_, err := b.MessageSafety(context.Background(), id, payload)
+		require.ErrorIs(t, err, ErrFetchFailed)
+	})
+
+	t.Run("PeerUnavailable", func(t *testing.T) {
+		p := b.l2PeerNodes[chainId]
+		p.breaker = b.newPeerBreaker(chainId, 1, defaultBreakerCooldown)
+		defer func() { p.breaker = nil }()
+		peer.err = errors.New("connection refused")
+		id, payload := testMessage(900, peer, 10, 0)
+		_, err := b.MessageSafety(context.Background(), id, payload)
+		require.ErrorIs(t, err, ErrFetchFailed)
+		peer.err = nil
+		_, err = b.MessageSafety(context.Background(), id, payload)
+		require.ErrorIs(t, err, ErrPeerUnavailable)
+		require.ErrorIs(t, err, ErrFetchFailed)
+	})
+
+	for _, test := range []struct {
+		name   string
+		modify func(id *MessageIdentifier, payload *hexutil.Bytes)
+	}{
+		{"PayloadMismatch", func(_ *MessageIdentifier, payload *hexutil.Bytes) { *payload = hexutil.Bytes{0xff} }},
+		{"TimestampMismatch", func(id *MessageIdentifier, _ *hexutil.Bytes) { id.Timestamp++ }},
+		{"NoLogs", func(id *MessageIdentifier, _ *hexutil.Bytes) { id.Origin = common.Address{0xcc} }},
+		{"BlockHashMismatch", func(id *MessageIdentifier, _ *hexutil.Bytes) { id.BlockHash = &common.Hash{0xde} }},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			id, payload := testMessage(900, peer, 10, 0)
+			test.modify(&id, &payload)
+			_, err := b.MessageSafety(context.Background(), id, payload)
+			require.ErrorIs(t, err, ErrIntegrityMismatch)
+			require.NotErrorIs(t, err, ErrFetchFailed)
+			require.NotErrorIs(t, err, ErrPeerNotConfigured)
+
+			log := peer.logs[10][0]
+			_, err = b.MessageSafetyFromLog(context.Background(), id, payload, &log, 100)
+			require.ErrorIs(t, err, ErrIntegrityMismatch)
+		})
+	}
+}
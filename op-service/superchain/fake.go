@@ -0,0 +1,379 @@
+package superchain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+)
+
+// FakeBackend is a SuperchainBackend serving registered results, for testing consumers of the
+// backend without RPC. Messages that are not registered are Invalid, and return an error.
+type FakeBackend struct {
+	mu      sync.Mutex
+	results map[string]fakeResult
+	// message identifiers of the transaction identifiers, see SetTxMessage
+	txMessages map[string]MessageIdentifier
+	queried    []MessageIdentifier
+	closed     bool
+	// registered labels are capped at unsafe
+	conservative bool
+
+	// HealthErr is returned by HealthCheck
+	HealthErr error
+
+	finalizedHeads    map[ChainID]eth.L1BlockRef
+	dependencySet     []ChainID
+	finalizedHeadSubs headSubscribers
+	finalizedWatches  finalizedWatches
+}
+
+type fakeResult struct {
+	label MessageSafetyLabel
+	err   error
+}
+
+var _ SuperchainBackend = (*FakeBackend)(nil)
+
+func NewFakeBackend() *FakeBackend {
+	return &FakeBackend{
+		results:          make(map[string]fakeResult),
+		txMessages:       make(map[string]MessageIdentifier),
+		finalizedHeads:   make(map[ChainID]eth.L1BlockRef),
+		finalizedWatches: finalizedWatches{max: defaultMaxFinalizedWatches},
+	}
+}
+
+// fakeKey identifies the message, or the transaction identifier of the message, by all its fields.
+func fakeKey(id any) string {
+	data, err := json.Marshal(id)
+	if err != nil {
+		panic(fmt.Errorf("failed to encode message identifier: %w", err))
+	}
+	return string(data)
+}
+
+// SetMessageSafety registers the label of the message.
+func (f *FakeBackend) SetMessageSafety(id MessageIdentifier, label MessageSafetyLabel) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.results[fakeKey(id)] = fakeResult{label: label}
+}
+
+// SetMessageError registers the error of the message, which is then labeled Invalid.
+func (f *FakeBackend) SetMessageError(id MessageIdentifier, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.results[fakeKey(id)] = fakeResult{label: Invalid, err: err}
+}
+
+// SetTxMessage registers the message of the transaction identifier, as located by the receipt of the transaction.
+func (f *FakeBackend) SetTxMessage(txId TxMessageIdentifier, id MessageIdentifier) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.txMessages[fakeKey(txId)] = id
+}
+
+// Queried returns the identifiers of all the checked messages, in the order they were checked.
+func (f *FakeBackend) Queried() []MessageIdentifier {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]MessageIdentifier(nil), f.queried...)
+}
+
+// QueriedCount returns the number of times the message was checked.
+func (f *FakeBackend) QueriedCount(id MessageIdentifier) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key, count := fakeKey(id), 0
+	for _, queried := range f.queried {
+		if fakeKey(queried) == key {
+			count++
+		}
+	}
+	return count
+}
+
+// SetFinalizedHead publishes the finalized head to the subscribers of the chain, fires the finalized
+// watches of the messages up to the timestamp of the head, and reports it as the tracked finalized head of the chain.
+func (f *FakeBackend) SetFinalizedHead(chainId ChainID, head eth.L1BlockRef) {
+	f.mu.Lock()
+	f.finalizedHeads[chainId] = head
+	f.mu.Unlock()
+	f.finalizedHeadSubs.publish(chainId, head)
+	for _, watch := range f.finalizedWatches.drain(chainId, head.Time) {
+		watch.fn(head)
+	}
+}
+
+// SetDependencySet sets the chain ids returned by DependencySet.
+func (f *FakeBackend) SetDependencySet(chainIds ...ChainID) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.dependencySet = slices.Clone(chainIds)
+	slices.SortFunc(f.dependencySet, ChainID.Cmp)
+}
+
+func (f *FakeBackend) lookup(id MessageIdentifier) (MessageSafetyLabel, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return Invalid, ErrBackendClosed
+	}
+	f.queried = append(f.queried, id)
+	res, ok := f.results[fakeKey(id)]
+	if !ok {
+		return Invalid, fmt.Errorf("unknown message %s", fakeKey(id))
+	}
+	if f.conservative && res.label != Invalid {
+		return Unsafe, res.err
+	}
+	return res.label, res.err
+}
+
+func (f *FakeBackend) MessageSafety(ctx context.Context, id MessageIdentifier, payload hexutil.Bytes) (MessageSafetyLabel, error) {
+	return f.lookup(id)
+}
+
+func (f *FakeBackend) MessageSafetyDetails(ctx context.Context, id MessageIdentifier, payload hexutil.Bytes) (MessageSafetyResult, error) {
+	label, err := f.lookup(id)
+	return MessageSafetyResult{Label: label}, err
+}
+
+// MessageSafetyAt returns the registered label of the message, regardless of the finalized timestamp.
+func (f *FakeBackend) MessageSafetyAt(ctx context.Context, id MessageIdentifier, payload hexutil.Bytes, finalizedTimestamp uint64) (MessageSafetyLabel, error) {
+	return f.lookup(id)
+}
+
+// MessageIntegrity returns whether the registered label of the message is not Invalid.
+func (f *FakeBackend) MessageIntegrity(ctx context.Context, id MessageIdentifier, payload hexutil.Bytes) (bool, error) {
+	label, err := f.lookup(id)
+	return err == nil && label != Invalid, err
+}
+
+// MessageSafetyFromLog returns the registered label of the message, regardless of the log.
+func (f *FakeBackend) MessageSafetyFromLog(ctx context.Context, id MessageIdentifier, payload hexutil.Bytes, log *types.Log, blockTime uint64) (MessageSafetyLabel, error) {
+	return f.lookup(id)
+}
+
+// MessageSafetyVia returns the registered label of the message, regardless of the endpoint.
+func (f *FakeBackend) MessageSafetyVia(ctx context.Context, id MessageIdentifier, payload hexutil.Bytes, endpoint string) (MessageSafetyLabel, error) {
+	return f.lookup(id)
+}
+
+// MessageSafetyByTx returns the registered label of the message registered for the transaction identifier.
+func (f *FakeBackend) MessageSafetyByTx(ctx context.Context, id TxMessageIdentifier, payload hexutil.Bytes) (MessageSafetyLabel, error) {
+	f.mu.Lock()
+	msg, ok := f.txMessages[fakeKey(id)]
+	f.mu.Unlock()
+	if !ok {
+		return Invalid, fmt.Errorf("unknown transaction message %s", fakeKey(id))
+	}
+	return f.lookup(msg)
+}
+
+func (f *FakeBackend) MessageSafetyExplain(ctx context.Context, id MessageIdentifier, payload hexutil.Bytes) (*ValidationTrace, error) {
+	label, err := f.lookup(id)
+	return &ValidationTrace{Result: MessageSafetyResult{Label: label}}, err
+}
+
+// MessageSafetyBatch labels every message like MessageSafety, joining the errors of the messages into the error.
+func (f *FakeBackend) MessageSafetyBatch(ctx context.Context, ids []MessageIdentifier, payloads []hexutil.Bytes) ([]MessageSafetyLabel, error) {
+	if len(ids) != len(payloads) {
+		return nil, fmt.Errorf("mismatched number of identifiers (%d) and payloads (%d)", len(ids), len(payloads))
+	}
+	labels := make([]MessageSafetyLabel, len(ids))
+	errs := make([]error, len(ids))
+	for i, id := range ids {
+		label, err := f.lookup(id)
+		if errors.Is(err, ErrBackendClosed) {
+			return nil, err
+		}
+		labels[i], errs[i] = label, err
+	}
+	return labels, joinMessageErrors(errs)
+}
+
+// Prefetch does not fetch any block, as the labels of the messages are registered.
+func (f *FakeBackend) Prefetch(ctx context.Context, chainId ChainID, fromBlock, toBlock *big.Int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return ErrBackendClosed
+	}
+	return nil
+}
+
+// FinalizingHead returns the finalized head set for the chain if it includes the timestamp, as the fake
+// does not know any earlier block.
+func (f *FakeBackend) FinalizingHead(ctx context.Context, chainId ChainID, timestamp uint64) (eth.L1BlockRef, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return eth.L1BlockRef{}, ErrBackendClosed
+	}
+	head, ok := f.finalizedHeads[chainId]
+	if !ok || timestamp > head.Time {
+		return eth.L1BlockRef{}, fmt.Errorf("timestamp %d is not finalized yet on chain id %s", timestamp, chainId)
+	}
+	return head, nil
+}
+
+// SetConservativeMode caps the registered labels at Unsafe while enabled.
+func (f *FakeBackend) SetConservativeMode(enabled bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.conservative = enabled
+}
+
+func (f *FakeBackend) SubscribeFinalizedHead(chainId ChainID) (<-chan eth.L1BlockRef, ethereum.Subscription) {
+	sub := f.finalizedHeadSubs.subscribe(chainId)
+	return sub.ch, sub
+}
+
+// DependencySet returns the chain ids set with SetDependencySet.
+func (f *FakeBackend) DependencySet() []ChainID {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.dependencySet)
+}
+
+// WatchFinalized fires the callback once a finalized head set with SetFinalizedHead is at or beyond the timestamp
+// of the message, regardless of the registered result of the message.
+func (f *FakeBackend) WatchFinalized(id MessageIdentifier, fn func(head eth.L1BlockRef)) (func(), error) {
+	chainId, ok := ChainIDFromBig(id.ChainId)
+	if !ok {
+		return nil, fmt.Errorf("invalid chain id %v", id.ChainId)
+	}
+	watch := &finalizedWatch{chainId: chainId, key: id.Timestamp, fn: fn}
+	if err := f.finalizedWatches.add(watch); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	head, ok := f.finalizedHeads[chainId]
+	f.mu.Unlock()
+	if ok && id.Timestamp <= head.Time && f.finalizedWatches.remove(watch) {
+		fn(head)
+	}
+	return func() { f.finalizedWatches.remove(watch) }, nil
+}
+
+// RefreshFinalizedHead does not fetch any head, as the finalized heads are set with SetFinalizedHead.
+func (f *FakeBackend) RefreshFinalizedHead(ctx context.Context, chainId ChainID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return ErrBackendClosed
+	}
+	return nil
+}
+
+// TrackedHeads returns the finalized heads set with SetFinalizedHead.
+func (f *FakeBackend) TrackedHeads() map[ChainID]HeadSnapshot {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	snapshot := make(map[ChainID]HeadSnapshot, len(f.finalizedHeads))
+	for chainId, head := range f.finalizedHeads {
+		head := head
+		snapshot[chainId] = HeadSnapshot{Finalized: &head}
+	}
+	return snapshot
+}
+
+// MetricsRegistry returns nil, as the fake records no metrics.
+func (f *FakeBackend) MetricsRegistry() *prometheus.Registry {
+	return nil
+}
+
+// MetricsHandler responds with 404 Not Found, as the fake records no metrics.
+func (f *FakeBackend) MetricsHandler() http.Handler {
+	return http.NotFoundHandler()
+}
+
+func (f *FakeBackend) HealthCheck(ctx context.Context) error {
+	return f.HealthErr
+}
+
+func (f *FakeBackend) Close() error {
+	f.mu.Lock()
+	f.closed = true
+	f.mu.Unlock()
+	f.finalizedHeadSubs.unsubscribeAll()
+	f.finalizedWatches.clear()
+	return nil
+}
+
+// FakeLogFetcher is a LogFetcher serving registered blocks, for testing the backend without RPC.
+// Blocks that are not registered do not exist.
+type FakeLogFetcher struct {
+	mu      sync.Mutex
+	blocks  map[ChainID]map[uint64]fakeBlock
+	fetched int
+}
+
+type fakeBlock struct {
+	header *types.Header
+	logs   []types.Log
+	err    error
+}
+
+var _ LogFetcher = (*FakeLogFetcher)(nil)
+
+func NewFakeLogFetcher() *FakeLogFetcher {
+	return &FakeLogFetcher{blocks: make(map[ChainID]map[uint64]fakeBlock)}
+}
+
+func (f *FakeLogFetcher) set(chainId ChainID, number uint64, block fakeBlock) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.blocks[chainId] == nil {
+		f.blocks[chainId] = make(map[uint64]fakeBlock)
+	}
+	f.blocks[chainId][number] = block
+}
+
+// SetBlock registers the header and the logs of the block. The block number, block hash and index of every log
+// are set from the header and the position of the log, like the logs of a block served by a peer.
+func (f *FakeLogFetcher) SetBlock(chainId ChainID, header *types.Header, logs ...types.Log) {
+	logs = slices.Clone(logs)
+	for i := range logs {
+		logs[i].BlockNumber = header.Number.Uint64()
+		logs[i].BlockHash = header.Hash()
+		logs[i].Index = uint(i)
+	}
+	f.set(chainId, header.Number.Uint64(), fakeBlock{header: header, logs: logs})
+}
+
+// SetBlockError registers the error returned when fetching the block.
+func (f *FakeLogFetcher) SetBlockError(chainId ChainID, number uint64, err error) {
+	f.set(chainId, number, fakeBlock{err: err})
+}
+
+// Fetched returns the number of fetched blocks.
+func (f *FakeLogFetcher) Fetched() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.fetched
+}
+
+func (f *FakeLogFetcher) FetchBlockAndLogs(ctx context.Context, chainId ChainID, blockNumber *big.Int) (*types.Header, []types.Log, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fetched++
+	if !blockNumber.IsUint64() {
+		return nil, nil, nil
+	}
+	block := f.blocks[chainId][blockNumber.Uint64()]
+	return block.header, slices.Clone(block.logs), block.err
+}
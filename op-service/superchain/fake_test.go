@@ -0,0 +1,101 @@
+package superchain
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+)
+
+func TestFakeBackend(t *testing.T) {
+	fake := NewFakeBackend()
+	finalized := MessageIdentifier{Origin: common.Address{0xaa}, BlockNumber: big.NewInt(10), ChainId: big.NewInt(900)}
+	failing := MessageIdentifier{Origin: common.Address{0xaa}, BlockNumber: big.NewInt(11), ChainId: big.NewInt(900)}
+	unknown := MessageIdentifier{Origin: common.Address{0xbb}, BlockNumber: big.NewInt(10), ChainId: big.NewInt(900)}
+	fake.SetMessageSafety(finalized, Finalized)
+	fake.SetMessageError(failing, errors.New("peer unavailable"))
+
+	label, err := fake.MessageSafety(context.Background(), finalized, nil)
+	require.NoError(t, err)
+	require.Equal(t, Finalized, label)
+
+	// identifiers are matched by value
+	label, err = fake.MessageSafety(context.Background(), MessageIdentifier{Origin: common.Address{0xaa}, BlockNumber: big.NewInt(10), ChainId: big.NewInt(900)}, nil)
+	require.NoError(t, err)
+	require.Equal(t, Finalized, label)
+
+	label, err = fake.MessageSafety(context.Background(), failing, nil)
+	require.ErrorContains(t, err, "peer unavailable")
+	require.Equal(t, Invalid, label)
+
+	valid, err := fake.MessageIntegrity(context.Background(), finalized, nil)
+	require.NoError(t, err)
+	require.True(t, valid)
+
+	txId := TxMessageIdentifier{Origin: common.Address{0xaa}, TxHash: common.Hash{0x01}, ChainId: big.NewInt(900)}
+	_, err = fake.MessageSafetyByTx(context.Background(), txId, nil)
+	require.ErrorContains(t, err, "unknown transaction message")
+	fake.SetTxMessage(txId, finalized)
+	label, err = fake.MessageSafetyByTx(context.Background(), txId, nil)
+	require.NoError(t, err)
+	require.Equal(t, Finalized, label)
+
+	_, err = fake.MessageSafety(context.Background(), unknown, nil)
+	require.ErrorContains(t, err, "unknown message")
+
+	labels, err := fake.MessageSafetyBatch(context.Background(), []MessageIdentifier{unknown, finalized}, []hexutil.Bytes{nil, nil})
+	require.ErrorContains(t, err, "message 0: unknown message")
+	require.Equal(t, []MessageSafetyLabel{Invalid, Finalized}, labels)
+
+	fake.SetConservativeMode(true)
+	label, err = fake.MessageSafety(context.Background(), finalized, nil)
+	require.NoError(t, err)
+	require.Equal(t, Unsafe, label)
+	fake.SetConservativeMode(false)
+
+	require.Equal(t, []MessageIdentifier{finalized, finalized, failing, finalized, finalized, unknown, unknown, finalized, finalized}, fake.Queried())
+	require.Equal(t, 6, fake.QueriedCount(finalized))
+	require.Equal(t, 1, fake.QueriedCount(failing))
+
+	heads, sub := fake.SubscribeFinalizedHead(ChainIDFromUInt64(900))
+	head := eth.L1BlockRef{Hash: common.Hash{10}, Number: 10}
+	fake.SetFinalizedHead(ChainIDFromUInt64(900), head)
+	require.Equal(t, head, <-heads)
+	require.Equal(t, map[ChainID]HeadSnapshot{ChainIDFromUInt64(900): {Finalized: &head}}, fake.TrackedHeads())
+
+	fired := false
+	_, err = fake.WatchFinalized(MessageIdentifier{ChainId: big.NewInt(900), Timestamp: 12}, func(eth.L1BlockRef) { fired = true })
+	require.NoError(t, err)
+	fake.SetFinalizedHead(ChainIDFromUInt64(900), eth.L1BlockRef{Hash: common.Hash{12}, Number: 12, Time: 12})
+	require.True(t, fired)
+	<-heads
+
+	require.Empty(t, fake.DependencySet())
+	fake.SetDependencySet(ChainIDFromUInt64(901), ChainIDFromUInt64(900))
+	require.Equal(t, []ChainID{ChainIDFromUInt64(900), ChainIDFromUInt64(901)}, fake.DependencySet())
+
+	finalizing, err := fake.FinalizingHead(context.Background(), ChainIDFromUInt64(900), 12)
+	require.NoError(t, err)
+	require.Equal(t, uint64(12), finalizing.Number)
+	_, err = fake.FinalizingHead(context.Background(), ChainIDFromUInt64(900), 13)
+	require.ErrorContains(t, err, "not finalized yet")
+
+	require.NoError(t, fake.Prefetch(context.Background(), ChainIDFromUInt64(900), big.NewInt(1), big.NewInt(10)))
+	require.NoError(t, fake.RefreshFinalizedHead(context.Background(), ChainIDFromUInt64(900)))
+
+	require.NoError(t, fake.Close())
+	_, ok := <-heads
+	require.False(t, ok)
+	sub.Unsubscribe()
+	_, err = fake.MessageSafety(context.Background(), finalized, nil)
+	require.ErrorIs(t, err, ErrBackendClosed)
+	require.ErrorIs(t, fake.Prefetch(context.Background(), ChainIDFromUInt64(900), big.NewInt(1), big.NewInt(10)), ErrBackendClosed)
+	require.ErrorIs(t, fake.RefreshFinalizedHead(context.Background(), ChainIDFromUInt64(900)), ErrBackendClosed)
+}
@@ -0,0 +1,222 @@
+package superchain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/ethereum-optimism/optimism/op-service/client"
+)
+
+// blockQuery references a block, and the origins of the logs to fetch from it.
+type blockQuery struct {
+	number  *big.Int
+	origins []common.Address
+	// header of the block if cached, the header is fetched if nil
+	header *types.Header
+}
+
+func (q *blockQuery) addOrigin(origin common.Address) {
+	for _, o := range q.origins {
+		if o == origin {
+			return
+		}
+	}
+	q.origins = append(q.origins, origin)
+}
+
+// blockData is the header and the logs of a queried block.
+// The error is set if the block could not be fetched.
+type blockData struct {
+	header *types.Header
+	logs   []types.Log
+	err    error
+}
+
+// fetchBlocks fetches the queried blocks from the peer, unless the circuit breaker of the peer is open.
+// The outcome of the request, after all retries, is recorded by the breaker. Prefetched blocks are not fetched.
+// The errors of the request and of the blocks are ErrFetchFailed errors.
+func (b *backend) fetchBlocks(ctx context.Context, peer *peer, queries []blockQuery) (_ []blockData, err error) {
+	ctx, span := b.startSpan(ctx, spanFetchBlocks, func() []attribute.KeyValue {
+		return []attribute.KeyValue{attribute.String("superchain.chain_id", peer.chainId.String()), attribute.Int("superchain.blocks", len(queries))}
+	})
+	defer func() { span.end(err) }()
+	blocks, missing := b.cachedBlocks(peer.chainId, queries)
+	if len(missing) == 0 {
+		return blocks, nil
+	}
+	probe, err := peer.breaker.allow()
+	if err != nil {
+		return nil, categorize(ErrFetchFailed, fmt.Errorf("peer with chain id %s: %w", peer.chainId, err))
+	}
+	fetchQueries := make([]blockQuery, len(missing))
+	for i, q := range missing {
+		fetchQueries[i] = queries[q]
+	}
+	b.cachedHeaders(peer.chainId, fetchQueries)
+	fetched, err := b.fetchBlocksRetried(ctx, peer, fetchQueries)
+	fetchErr := err
+	if fetchErr == nil {
+		fetchErr = blocksError(fetched)
+	}
+	peer.breaker.done(ctx, probe, fetchErr)
+	if err != nil {
+		return nil, categorize(ErrFetchFailed, err)
+	}
+	b.cacheFinalizedHeaders(peer.chainId, fetched)
+	for i, q := range missing {
+		blocks[q] = fetched[i]
+		blocks[q].err = categorize(ErrFetchFailed, blocks[q].err)
+	}
+	return blocks, nil
+}
+
+// fetchBlocksRetried fetches the queried blocks from the peer, recording the latency of the request.
+// A request that failed as a whole, or failed for any of the blocks, is retried with exponential backoff.
+func (b *backend) fetchBlocksRetried(ctx context.Context, peer *peer, queries []blockQuery) ([]blockData, error) {
+	defer b.metrics.RecordFetch(peer.chainId.String())()
+	for attempt := 0; ; attempt++ {
+		blocks, err := b.fetchBlocksOnce(ctx, peer, queries)
+		fetchErr := err
+		if fetchErr == nil {
+			fetchErr = blocksError(blocks)
+		}
+		if fetchErr == nil || attempt >= b.rpcRetries || ctx.Err() != nil {
+			return blocks, err
+		}
+		delay := b.rpcRetryDelay << attempt
+		b.log.Warn("failed to fetch blocks, retrying", "chain_id", peer.chainId, "attempt", attempt+1, "delay", delay, "err", fetchErr)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return blocks, err
+		}
+	}
+}
+
+// fetchBlocksOnce fetches the queried blocks with the block fetcher of the peer, once an in-flight rpc slot is free.
+// The request is bounded by the rpc timeout of the peer, regardless of the deadline of the caller.
+func (b *backend) fetchBlocksOnce(ctx context.Context, peer *peer, queries []blockQuery) ([]blockData, error) {
+	// The rpc timeout starts once the request is sent, not while it waits for an in-flight slot
+	if b.rpcSlots != nil {
+		if err := b.rpcSlots.Acquire(ctx, 1); err != nil {
+			return nil, fmt.Errorf("unable to request logs: waiting for an in-flight rpc slot: %w", err)
+		}
+		defer b.rpcSlots.Release(1)
+	}
+	rpcTimeout := b.rpcTimeoutOf(peer.chainId)
+	rpcCtx, cancel := context.WithTimeout(ctx, rpcTimeout)
+	defer cancel()
+	blocks, err := b.blockFetcher(peer).fetchBlocks(rpcCtx, queries)
+	if err != nil && errors.Is(rpcCtx.Err(), context.DeadlineExceeded) {
+		return nil, fmt.Errorf("%w after %s: %w", ErrRPCTimeout, rpcTimeout, err)
+	}
+	return blocks, err
+}
+
+// blocksError returns the first error of the fetched blocks, if any.
+func blocksError(blocks []blockData) error {
+	for i := range blocks {
+		if blocks[i].err != nil {
+			return blocks[i].err
+		}
+	}
+	return nil
+}
+
+// fetchBlocks fetches the header and logs of every queried block in batch requests of at most maxBatchSize
+// requests each. Cached headers of the queries are not fetched again. If the topic is non-zero, only the logs
+// with the topic as first topic are fetched. An error is returned if a batch request failed as a whole, errors
+// of individual blocks are set on the block data.
+// If blockReceipts is set, the logs are reconstructed from the receipts of every block, fetched with
+// eth_getBlockReceipts, instead of being filtered by the peer with eth_getLogs.
+func fetchBlocks(ctx context.Context, l2Node client.RPC, queries []blockQuery, topic common.Hash, maxBatchSize int, blockReceipts bool) ([]blockData, error) {
+	blocks := make([]blockData, len(queries))
+	receipts := make([][]*types.Receipt, len(queries))
+	batchElems := make([]rpc.BatchElem, 0, 2*len(queries))
+	// indices of the header and logs requests of every block, the header index is -1 if the header is cached
+	headerElems, logsElems := make([]int, len(queries)), make([]int, len(queries))
+	for i, q := range queries {
+		blockNumber := hexutil.EncodeBig(q.number)
+
+		headerElems[i] = -1
+		if q.header != nil {
+			blocks[i].header = q.header
+		} else {
+			headerElems[i] = len(batchElems)
+			batchElems = append(batchElems,
+				rpc.BatchElem{Method: "eth_getBlockByNumber", Args: []interface{}{blockNumber, false}, Result: &blocks[i].header})
+		}
+		if blockReceipts {
+			logsElems[i] = len(batchElems)
+			batchElems = append(batchElems,
+				rpc.BatchElem{Method: "eth_getBlockReceipts", Args: []interface{}{blockNumber}, Result: &receipts[i]})
+			continue
+		}
+		// Filtering by address and topic does not change the log index semantics, the index of
+		// each returned log remains the block-global index.
+		filterArgs := map[string]interface{}{"fromBlock": blockNumber, "toBlock": blockNumber, "address": q.origins}
+		if topic != (common.Hash{}) {
+			filterArgs["topics"] = [][]common.Hash{{topic}}
+		}
+		logsElems[i] = len(batchElems)
+		batchElems = append(batchElems,
+			rpc.BatchElem{Method: "eth_getLogs", Args: []interface{}{filterArgs}, Result: &blocks[i].logs})
+	}
+	// The results are decoded into the block data, regardless of how the requests are split
+	for start := 0; start < len(batchElems); start += maxBatchSize {
+		end := min(start+maxBatchSize, len(batchElems))
+		if err := l2Node.BatchCallContext(ctx, batchElems[start:end]); err != nil {
+			return nil, fmt.Errorf("unable to request logs: %w", err)
+		}
+	}
+	for i := range blocks {
+		if headerElems[i] >= 0 && batchElems[headerElems[i]].Error != nil {
+			blocks[i].err = fmt.Errorf("unable to request header: %w", batchElems[headerElems[i]].Error)
+		} else if err := batchElems[logsElems[i]].Error; err != nil {
+			blocks[i].err = fmt.Errorf("unable to request logs: %w", err)
+		} else if blockReceipts {
+			blocks[i].logs = receiptsLogs(receipts[i], queries[i].origins, topic)
+		}
+	}
+	return blocks, nil
+}
+
+// receiptsLogs returns the logs of the receipts of a block emitted by any of the origins, and with the topic as
+// first topic if the topic is non-zero, as eth_getLogs would have filtered them.
+// The index of every log is its position in the logs of the whole block, regardless of the index served by
+// the peer, so that the logs are matched with the messages exactly like the logs of eth_getLogs.
+func receiptsLogs(receipts []*types.Receipt, origins []common.Address, topic common.Hash) []types.Log {
+	var logs []types.Log
+	index := uint(0)
+	for _, receipt := range receipts {
+		if receipt == nil {
+			continue
+		}
+		for _, log := range receipt.Logs {
+			if log == nil {
+				continue
+			}
+			l := *log
+			l.Index = index
+			index++
+			if len(origins) > 0 && !slices.Contains(origins, l.Address) {
+				continue
+			}
+			if topic != (common.Hash{}) && (len(l.Topics) == 0 || l.Topics[0] != topic) {
+				continue
+			}
+			logs = append(logs, l)
+		}
+	}
+	return logs
+}
@@ -0,0 +1,501 @@
+package superchain
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"github.com/ethereum-optimism/optimism/op-service/client"
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+)
+
+// resubscribeBackoff is the maximum delay between the attempts to re-establish a dropped new-head subscription.
+const resubscribeBackoff = time.Second * 10
+
+// headsSource is a source of the heads of a peer chain, that can also notify of new heads.
+type headsSource interface {
+	eth.L1BlockRefsSource
+	eth.NewHeadSource
+}
+
+// chainHeads are the tracked heads of a peer chain. Heads are nil until first polled.
+type chainHeads struct {
+	unsafe    *eth.L1BlockRef
+	safe      *eth.L1BlockRef
+	finalized *eth.L1BlockRef
+}
+
+// HeadSnapshot is a copy of the tracked heads of a peer chain. Heads are nil until first polled.
+type HeadSnapshot struct {
+	Unsafe    *eth.L1BlockRef `json:"unsafe"`
+	Safe      *eth.L1BlockRef `json:"safe"`
+	Finalized *eth.L1BlockRef `json:"finalized"`
+}
+
+// copyRef returns a copy of the head, so that snapshots are not affected by later updates.
+func copyRef(ref *eth.L1BlockRef) *eth.L1BlockRef {
+	if ref == nil {
+		return nil
+	}
+	cpy := *ref
+	return &cpy
+}
+
+func (b *backend) TrackedHeads() map[ChainID]HeadSnapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	snapshot := make(map[ChainID]HeadSnapshot, len(b.l2Heads))
+	for chainId, heads := range b.l2Heads {
+		snapshot[chainId] = HeadSnapshot{
+			Unsafe:    copyRef(heads.unsafe),
+			Safe:      copyRef(heads.safe),
+			Finalized: copyRef(heads.finalized),
+		}
+	}
+	return snapshot
+}
+
+// rpcTimeoutOf returns the timeout of the block and logs requests to the peer chain.
+func (b *backend) rpcTimeoutOf(chainId ChainID) time.Duration {
+	if timeout, ok := b.peerTimeouts[chainId]; ok {
+		return timeout
+	}
+	return b.rpcTimeout
+}
+
+// pollTimeoutOf returns the timeout of every poll of the heads of the peer chain.
+func (b *backend) pollTimeoutOf(chainId ChainID) time.Duration {
+	if timeout, ok := b.peerTimeouts[chainId]; ok {
+		return timeout
+	}
+	return b.pollTimeout
+}
+
+// finalityLabel returns the label of the block polled as the finalized head of the peer chain.
+func (b *backend) finalityLabel(chainId ChainID) eth.BlockLabel {
+	if label, ok := b.devnetFinalityLabels[chainId]; ok {
+		return label
+	}
+	return eth.Finalized
+}
+
+// trackHeads starts polling the unsafe, safe and finalized heads of the peer chain. The finalized head starts
+// out as the restored finalized head, if not nil, until a newer finalized head is polled.
+// The heads are polled separately at their own intervals, or together with a single batch request to the peer
+// every batched head poll interval, if set. The heads are polled per peer, even for peers sharing a connection:
+// a JSON-RPC request does not select the chain it is served by, so a batch of the heads of several chains would
+// return the heads of the same chain for every one of them.
+func (b *backend) trackHeads(chainId ChainID, src eth.L1BlockRefsSource, restored *eth.L1BlockRef) {
+	b.mu.Lock()
+	b.l2Heads[chainId] = &chainHeads{finalized: restored}
+	b.l2Sources[chainId] = src
+	b.mu.Unlock()
+
+	l2UnsafeHeadSignal := func(ctx context.Context, sig eth.L1BlockRef) {
+		b.onUnsafeHead(chainId, sig)
+	}
+	l2SafeHeadSignal := func(ctx context.Context, sig eth.L1BlockRef) {
+		b.onSafeHead(chainId, sig)
+	}
+	l2FinalizedHeadSignal := func(ctx context.Context, sig eth.L1BlockRef) {
+		b.onFinalizedHead(ctx, chainId, sig)
+	}
+
+	pollTimeout := b.pollTimeoutOf(chainId)
+	if interval := b.batchedHeadPollInterval; interval > 0 {
+		b.l2HeadSubs = append(b.l2HeadSubs, pollBatchedHeads(b.log, b.l2PeerNodes[chainId],
+			[]eth.BlockLabel{eth.Unsafe, eth.Safe, b.finalityLabel(chainId)},
+			[]eth.HeadSignalFn{
+				b.timedHeadSignal(chainId, eth.Unsafe, interval, l2UnsafeHeadSignal),
+				b.timedHeadSignal(chainId, eth.Safe, interval, l2SafeHeadSignal),
+				b.timedHeadSignal(chainId, eth.Finalized, interval, l2FinalizedHeadSignal),
+			}, interval, pollTimeout))
+		return
+	}
+	b.l2HeadSubs = append(b.l2HeadSubs,
+		eth.PollBlockChanges(b.log, src, b.timedHeadSignal(chainId, eth.Unsafe, unsafePollInterval, l2UnsafeHeadSignal),
+			eth.Unsafe, unsafePollInterval, pollTimeout),
+		eth.PollBlockChanges(b.log, src, b.timedHeadSignal(chainId, eth.Safe, safePollInterval, l2SafeHeadSignal),
+			eth.Safe, safePollInterval, pollTimeout),
+		pollJitteredBlockChanges(b.log, src, b.timedHeadSignal(chainId, eth.Finalized, b.finalizedPollInterval, l2FinalizedHeadSignal),
+			b.finalityLabel(chainId), b.finalizedPollInterval, b.finalizedPollJitter, pollTimeout),
+	)
+}
+
+// timedHeadSignal returns the handler of the polled heads with the label, recording the time every head was handled.
+// The heads are polled by the goroutine running the handler: the polls due while a head is handled, e.g. while the
+// handler waits for the lock held by a message check, are delayed or dropped, and are recorded as missed.
+func (b *backend) timedHeadSignal(chainId ChainID, label eth.BlockLabel, interval time.Duration, fn eth.HeadSignalFn) eth.HeadSignalFn {
+	return func(ctx context.Context, sig eth.L1BlockRef) {
+		start := b.now()
+		fn(ctx, sig)
+		end := b.now()
+		var missed int
+		if elapsed := end.Sub(start); interval > 0 && elapsed >= interval {
+			missed = int(elapsed / interval)
+			b.log.Warn("slow handling of polled head, polls were missed", "chain_id", chainId, "label", label,
+				"elapsed", elapsed, "interval", interval, "missed", missed)
+		}
+		b.metrics.RecordHeadUpdate(chainId.String(), label, end, missed)
+	}
+}
+
+// pollJitteredBlockChanges polls the block like eth.PollBlockChanges, but offsets every poll by a random
+// jitter of up to the fraction of the interval, so that the polls of the peers spread out. The offsets are
+// relative to a fixed schedule, and do not accumulate drift. Polling is not jittered if the fraction is zero.
+func pollJitteredBlockChanges(log log.Logger, src eth.L1BlockRefsSource, fn eth.HeadSignalFn,
+	label eth.BlockLabel, interval time.Duration, jitter float64, timeout time.Duration) ethereum.Subscription {
+	if jitter == 0 || interval <= 0 {
+		return eth.PollBlockChanges(log, src, fn, label, interval, timeout)
+	}
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		eventsCtx, eventsCancel := context.WithCancel(context.Background())
+		defer eventsCancel()
+		go func() {
+			select {
+			case <-quit:
+				eventsCancel()
+			case <-eventsCtx.Done():
+			}
+		}()
+
+		start := time.Now()
+		for n := 1; ; n++ {
+			timer := time.NewTimer(time.Until(jitteredPollTime(start, n, interval, jitter, rand.Float64())))
+			select {
+			case <-timer.C:
+				reqCtx, reqCancel := context.WithTimeout(eventsCtx, timeout)
+				ref, err := src.L1BlockRefByLabel(reqCtx, label)
+				reqCancel()
+				if err != nil {
+					log.Warn("failed to poll L2 block", "label", label, "err", err)
+				} else {
+					fn(eventsCtx, ref)
+				}
+			case <-eventsCtx.Done():
+				timer.Stop()
+				return nil
+			}
+		}
+	})
+}
+
+// pollBatchedHeads polls the blocks of the labels with a single batch request to the peer every interval, and
+// signals every polled block to the handler of its label. A block that fails to be polled is logged, and polled
+// again with the next batch.
+func pollBatchedHeads(log log.Logger, peer client.RPC, labels []eth.BlockLabel, fns []eth.HeadSignalFn,
+	interval time.Duration, timeout time.Duration) ethereum.Subscription {
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		eventsCtx, eventsCancel := context.WithCancel(context.Background())
+		defer eventsCancel()
+		go func() {
+			select {
+			case <-quit:
+				eventsCancel()
+			case <-eventsCtx.Done():
+			}
+		}()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				headers := make([]*types.Header, len(labels))
+				batchElems := make([]rpc.BatchElem, len(labels))
+				for i, label := range labels {
+					batchElems[i] = rpc.BatchElem{Method: "eth_getBlockByNumber", Args: []interface{}{label.Arg(), false}, Result: &headers[i]}
+				}
+				reqCtx, reqCancel := context.WithTimeout(eventsCtx, timeout)
+				err := peer.BatchCallContext(reqCtx, batchElems)
+				reqCancel()
+				if err != nil {
+					log.Warn("failed to poll L2 blocks", "labels", labels, "err", err)
+					continue
+				}
+				for i, elem := range batchElems {
+					switch {
+					case elem.Error != nil:
+						log.Warn("failed to poll L2 block", "label", labels[i], "err", elem.Error)
+					case headers[i] == nil:
+						log.Warn("failed to poll L2 block", "label", labels[i], "err", ethereum.NotFound)
+					default:
+						fns[i](eventsCtx, eth.InfoToL1BlockRef(eth.HeaderBlockInfo(headers[i])))
+					}
+				}
+			case <-eventsCtx.Done():
+				return nil
+			}
+		}
+	})
+}
+
+// jitteredPollTime returns the time of the n-th poll of the schedule from the start: the n-th interval,
+// offset by the jitter fraction of the interval scaled from the random value r in [0, 1) to [-1, 1).
+// A poll of which the time passed while the previous poll was running is polled immediately.
+func jitteredPollTime(start time.Time, n int, interval time.Duration, jitter float64, r float64) time.Time {
+	offset := time.Duration((2*r - 1) * jitter * float64(interval))
+	return start.Add(time.Duration(n)*interval + offset)
+}
+
+func (b *backend) onUnsafeHead(chainId ChainID, sig eth.L1BlockRef) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.l2Heads[chainId].unsafe = &sig
+}
+
+// onSafeHead updates the safe head of the peer chain. Unlike the finalized head, the safe head can regress on a
+// reorg of the peer chain, and the messages it no longer includes are labeled against the regressed head. No result
+// needs to be evicted, as only the results against finalized blocks are cached: TestMessageCacheFinalizedOnly
+// checks no Safe, CrossSafe or Unsafe result is cached, and none is served after a regression.
+func (b *backend) onSafeHead(chainId ChainID, sig eth.L1BlockRef) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	heads := b.l2Heads[chainId]
+	if heads.safe != nil && sig.Number < heads.safe.Number {
+		b.log.Warn("safe head of peer regressed", "chain_id", chainId, "head", sig, "previous", heads.safe)
+	}
+	heads.safe = &sig
+}
+
+// onFinalizedHead updates the finalized head of the peer chain. As signals of the polling and the
+// subscription can arrive out of order, a signal older than the tracked finalized head is ignored.
+// The signal is dropped if the context is done before the head is updated. A signal that reorgs the
+// tracked finalized head is logged, and dropped if finalized reorgs are rejected. The updated finalized head
+// is persisted, if a head store is configured, and fires the watches of the messages it includes.
+func (b *backend) onFinalizedHead(ctx context.Context, chainId ChainID, sig eth.L1BlockRef) {
+	if b.updateFinalizedHead(ctx, chainId, sig) {
+		b.storeFinalizedHead(chainId, sig)
+		b.fireFinalizedWatches(chainId, sig)
+	}
+	b.recordFinalizedHeadLag(chainId)
+}
+
+// recordFinalizedHeadLag records how far the tracked finalized head of the peer chain lags behind the current
+// time. The head is signaled on every poll, even if unchanged, so the lag of a stuck peer keeps growing.
+func (b *backend) recordFinalizedHeadLag(chainId ChainID) {
+	b.mu.Lock()
+	finalized := copyRef(b.l2Heads[chainId].finalized)
+	b.mu.Unlock()
+	if finalized == nil {
+		return
+	}
+	b.metrics.RecordFinalizedHeadLag(chainId.String(), b.now().Sub(time.Unix(int64(finalized.Time), 0)))
+}
+
+// updateFinalizedHead updates the tracked finalized head of the peer chain, and returns whether it was updated.
+func (b *backend) updateFinalizedHead(ctx context.Context, chainId ChainID, sig eth.L1BlockRef) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ctx.Err() != nil {
+		return false
+	}
+	heads := b.l2Heads[chainId]
+	if heads.finalized != nil && (heads.finalized.Hash == sig.Hash || sig.Number < heads.finalized.Number) {
+		return false
+	}
+	if err := checkFinalizedReorg(heads.finalized, sig); err != nil {
+		b.log.Error("peer reported a finalized head that reorgs the tracked finalized head",
+			"chain_id", chainId, "tracked", heads.finalized, "reported", sig, "rejected", b.rejectFinalizedReorgs, "err", err)
+		if b.rejectFinalizedReorgs {
+			return false
+		}
+	}
+	heads.finalized = &sig
+	b.finalizedHeadSubs.publish(chainId, sig)
+	return true
+}
+
+// checkFinalizedReorg checks the new finalized head is consistent with the tracked finalized head, which is nil
+// if not yet known. Only a direct child of the tracked head can be checked to link to it, as the intermediate
+// blocks of a further head are not known.
+func checkFinalizedReorg(tracked *eth.L1BlockRef, head eth.L1BlockRef) error {
+	switch {
+	case tracked == nil:
+		return nil
+	case head.Number == tracked.Number && head.Hash != tracked.Hash:
+		return fmt.Errorf("conflicting finalized block %s at the tracked number", head.Hash)
+	case head.Number == tracked.Number+1 && head.ParentHash != tracked.Hash:
+		return fmt.Errorf("parent %s of the finalized head does not match the tracked finalized head %s", head.ParentHash, tracked.Hash)
+	case head.Number > tracked.Number && head.Time < tracked.Time:
+		return fmt.Errorf("timestamp %d of the finalized head is before the timestamp %d of the tracked finalized head", head.Time, tracked.Time)
+	}
+	return nil
+}
+
+// RefreshFinalizedHead fetches the finalized head of the peer chain from the source the head is polled from, and
+// handles it like a polled head: the head is only updated if newer than the tracked finalized head.
+func (b *backend) RefreshFinalizedHead(ctx context.Context, chainId ChainID) error {
+	if b.closed.Load() {
+		return ErrBackendClosed
+	}
+	b.mu.Lock()
+	src, ok := b.l2Sources[chainId]
+	b.mu.Unlock()
+	if !ok {
+		return &PeerNotConfiguredError{ChainID: chainId}
+	}
+	if err := b.refreshFinalizedHead(ctx, chainId, src); err != nil {
+		return fmt.Errorf("failed to fetch finalized head of peer with chain id %s: %w", chainId, err)
+	}
+	return nil
+}
+
+// refreshFinalizedHead fetches the finalized head of the peer chain from the source, and updates the tracked head.
+func (b *backend) refreshFinalizedHead(ctx context.Context, chainId ChainID, src eth.L1BlockRefsSource) error {
+	reqCtx, reqCancel := context.WithTimeout(ctx, b.pollTimeoutOf(chainId))
+	defer reqCancel()
+	ref, err := src.L1BlockRefByLabel(reqCtx, b.finalityLabel(chainId))
+	if err != nil {
+		return err
+	}
+	b.onFinalizedHead(ctx, chainId, ref)
+	return nil
+}
+
+// watchFinalizedHead refreshes the finalized head of the peer chain on every new head notification of the peer.
+// A dropped subscription is re-established, re-dialing the peer if the connection broke. Polling of the
+// finalized head keeps running alongside, so the finalized head is still tracked while the subscription is down.
+func (b *backend) watchFinalizedHead(p *peer, src headsSource) ethereum.Subscription {
+	onNewHead := func(ctx context.Context, sig eth.L1BlockRef) {
+		if err := b.refreshFinalizedHead(ctx, p.chainId, src); err != nil {
+			b.log.Warn("failed to fetch finalized head of peer", "chain_id", p.chainId, "err", err)
+		}
+	}
+	return event.ResubscribeErr(resubscribeBackoff, func(ctx context.Context, err error) (event.Subscription, error) {
+		if err != nil {
+			b.log.Warn("resubscribing to new heads of peer", "chain_id", p.chainId, "err", err)
+		}
+		current := p.client()
+		sub, err := eth.WatchHeadChanges(ctx, src, onNewHead)
+		if isConnectionError(err) {
+			b.log.Warn("re-dialing peer after failed new heads subscription", "chain_id", p.chainId, "err", err)
+			if _, err := p.reconnect(ctx, current); err != nil {
+				return nil, err
+			}
+			return eth.WatchHeadChanges(ctx, src, onNewHead)
+		}
+		return sub, err
+	})
+}
+
+// safetyLabel labels a valid message of the peer chain, in the block with the given number and timestamp, against the
+// tracked heads of that chain with the safety policy. The finalized head the message was labeled against is returned
+// alongside, and is nil if not yet known.
+func (b *backend) safetyLabel(chainId ChainID, number, timestamp uint64) (MessageSafetyLabel, *eth.L1BlockRef) {
+	facts, ok := b.safetyFacts(chainId, number, timestamp)
+	if !ok {
+		// The message matched its log, it is only not known to be final: Invalid is reserved for integrity failures
+		return Unsafe, nil
+	}
+	// The policy is applied without holding the lock, as it is not expected to be cheap
+	return b.safetyPolicy(facts), facts.Heads.Finalized
+}
+
+// safetyFacts returns the facts of the block of the peer chain with the number and timestamp, against the tracked
+// heads of that chain, or false if the chain is not tracked.
+func (b *backend) safetyFacts(chainId ChainID, number, timestamp uint64) (SafetyFacts, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	heads, ok := b.l2Heads[chainId]
+	if !ok {
+		return SafetyFacts{}, false
+	}
+	return SafetyFacts{
+		ChainID:     chainId,
+		BlockNumber: number,
+		Timestamp:   timestamp,
+		Heads: HeadSnapshot{
+			Unsafe:    copyRef(heads.unsafe),
+			Safe:      copyRef(heads.safe),
+			Finalized: copyRef(heads.finalized),
+		},
+		IncludedByFinalized: b.includes(heads.finalized, number, timestamp),
+		IncludedBySafe:      b.includes(heads.safe, number, timestamp),
+		CrossSafe:           b.crossSafe(timestamp),
+	}, true
+}
+
+// includes returns whether the head, nil if unknown, includes the block with the number and timestamp. Blocks are
+// compared by timestamp, or by number if finality is compared by block number.
+func (b *backend) includes(head *eth.L1BlockRef, number, timestamp uint64) bool {
+	if head == nil {
+		return false
+	}
+	if b.finalityByBlockNumber {
+		return number <= head.Number
+	}
+	return timestamp <= head.Time
+}
+
+// latestHeadNumber returns the number of the latest tracked head of the peer chain, or nil if no head is known yet.
+func (b *backend) latestHeadNumber(chainId ChainID) *uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	heads, ok := b.l2Heads[chainId]
+	if !ok {
+		return nil
+	}
+	for _, head := range []*eth.L1BlockRef{heads.unsafe, heads.safe, heads.finalized} {
+		if head != nil {
+			return &head.Number
+		}
+	}
+	return nil
+}
+
+// checkExpiry checks the message, with the given timestamp, is within the expiry window before the latest
+// tracked head of the peer chain. Messages cannot expire until a head of the peer chain is known.
+func (b *backend) checkExpiry(chainId ChainID, timestamp uint64) error {
+	if b.expiryWindow == 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	heads, ok := b.l2Heads[chainId]
+	if !ok {
+		return nil
+	}
+	var latest uint64
+	for _, head := range []*eth.L1BlockRef{heads.unsafe, heads.safe, heads.finalized} {
+		if head != nil && head.Time > latest {
+			latest = head.Time
+		}
+	}
+	window := uint64(b.expiryWindow / time.Second)
+	if latest > window && timestamp < latest-window {
+		return fmt.Errorf("expired message: timestamp %d is older than %s before the latest head at %d", timestamp, b.expiryWindow, latest)
+	}
+	return nil
+}
+
+// crossSafe returns whether the safe heads of all the tracked chains in the dependency set are at or beyond
+// the timestamp. Chains without a known safe head are not safe up to any timestamp. The caller must hold the lock.
+func (b *backend) crossSafe(timestamp uint64) bool {
+	for chainId, heads := range b.l2Heads {
+		if b.dependencySet != nil && !b.dependencySet[chainId] {
+			continue
+		}
+		if heads.safe == nil || heads.safe.Time < timestamp {
+			return false
+		}
+	}
+	return true
+}
+
+func finalizedTime(finalized *eth.L1BlockRef) uint64 {
+	if finalized == nil {
+		return 0
+	}
+	return finalized.Time
+}
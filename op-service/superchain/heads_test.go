@@ -0,0 +1,470 @@
+package superchain
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/ethereum-optimism/optimism/op-service/client"
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+	"github.com/ethereum-optimism/optimism/op-service/testlog"
+)
+
+// stubHeadsSource serves a finalized head, and pushes new head notifications to the latest subscription.
+type stubHeadsSource struct {
+	mu         sync.Mutex
+	finalized  eth.L1BlockRef
+	subscribed int
+	heads      chan<- *types.Header
+	drop       chan error
+}
+
+func (s *stubHeadsSource) L1BlockRefByLabel(ctx context.Context, label eth.BlockLabel) (eth.L1BlockRef, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.finalized, nil
+}
+
+func (s *stubHeadsSource) SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscribed++
+	s.heads = ch
+	drop := make(chan error, 1)
+	s.drop = drop
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		select {
+		case <-quit:
+			return nil
+		case err := <-drop:
+			return err
+		}
+	}), nil
+}
+
+func (s *stubHeadsSource) subscriptions() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.subscribed
+}
+
+func (s *stubHeadsSource) push(finalized eth.L1BlockRef, num uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finalized = finalized
+	s.heads <- &types.Header{Number: new(big.Int).SetUint64(num), Difficulty: common.Big0}
+}
+
+func (s *stubHeadsSource) dropSubscription(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.drop <- err
+}
+
+func TestWatchFinalizedHead(t *testing.T) {
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: newStubRPC()})
+	setHeads(b, chainId, nil, nil, nil)
+	heads, headsSub := b.SubscribeFinalizedHead(chainId)
+	defer headsSub.Unsubscribe()
+
+	src := &stubHeadsSource{}
+	sub := b.watchFinalizedHead(b.l2PeerNodes[chainId], src)
+	defer sub.Unsubscribe()
+	require.Eventually(t, func() bool { return src.subscriptions() == 1 }, time.Second, 10*time.Millisecond)
+
+	first := eth.L1BlockRef{Hash: common.Hash{1}, Number: 1, Time: 100}
+	src.push(first, 10)
+	requireHead(t, first, heads)
+
+	// the subscription is re-established after it drops
+	src.dropSubscription(errors.New("connection dropped"))
+	require.Eventually(t, func() bool { return src.subscriptions() == 2 }, time.Second, 10*time.Millisecond)
+
+	second := eth.L1BlockRef{Hash: common.Hash{2}, Number: 2, Time: 102}
+	src.push(second, 11)
+	requireHead(t, second, heads)
+	label, _ := b.safetyLabel(chainId, 2, 102)
+	require.Equal(t, Finalized, label)
+}
+
+// countingRefsSource serves a new finalized head on every poll.
+type countingRefsSource struct {
+	mu             sync.Mutex
+	finalizedPolls uint64
+}
+
+func (s *countingRefsSource) L1BlockRefByLabel(ctx context.Context, label eth.BlockLabel) (eth.L1BlockRef, error) {
+	if label != eth.Finalized {
+		return eth.L1BlockRef{}, ethereum.NotFound
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finalizedPolls++
+	return eth.L1BlockRef{
+		Hash:       common.Hash{byte(s.finalizedPolls)},
+		Number:     s.finalizedPolls,
+		ParentHash: common.Hash{byte(s.finalizedPolls - 1)},
+	}, nil
+}
+
+func TestTrackHeadsFinalizedPollInterval(t *testing.T) {
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: newStubRPC()})
+	b.finalizedPollInterval = time.Millisecond * 10
+	heads, sub := b.SubscribeFinalizedHead(chainId)
+	defer sub.Unsubscribe()
+
+	b.trackHeads(chainId, &countingRefsSource{}, nil)
+	defer b.Close()
+	// the default interval is minutes, so advances are only observed with the custom interval
+	for i := 1; i <= 3; i++ {
+		select {
+		case head := <-heads:
+			require.Equal(t, uint64(i), head.Number)
+		case <-time.After(time.Second):
+			t.Fatal("finalized head was not polled at the custom interval")
+		}
+	}
+}
+
+func TestTrackHeadsFinalizedPollJitter(t *testing.T) {
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: newStubRPC()})
+	b.finalizedPollInterval = time.Millisecond * 10
+	b.finalizedPollJitter = 0.5
+	heads, sub := b.SubscribeFinalizedHead(chainId)
+	defer sub.Unsubscribe()
+
+	b.trackHeads(chainId, &countingRefsSource{}, nil)
+	defer b.Close()
+	for i := 1; i <= 3; i++ {
+		select {
+		case head := <-heads:
+			require.Equal(t, uint64(i), head.Number)
+		case <-time.After(time.Second):
+			t.Fatal("finalized head was not polled with jitter")
+		}
+	}
+}
+
+func TestTrackHeadsBatched(t *testing.T) {
+	chainA, chainB := ChainIDFromUInt64(900), ChainIDFromUInt64(901)
+	peerA, peerB := newStubRPC(), newStubRPC()
+	for i, peer := range []*stubRPC{peerA, peerB} {
+		offset := uint64(i) * 100
+		peer.addBlock(offset+10, 100)
+		peer.addBlock(offset+11, 102)
+		peer.addBlock(offset+12, 104)
+		peer.labels = map[string]uint64{"finalized": offset + 10, "safe": offset + 11, "latest": offset + 12}
+	}
+	b := newTestBackend(t, map[ChainID]client.RPC{chainA: peerA, chainB: peerB})
+	b.batchedHeadPollInterval = time.Millisecond * 10
+	headsA, subA := b.SubscribeFinalizedHead(chainA)
+	defer subA.Unsubscribe()
+	headsB, subB := b.SubscribeFinalizedHead(chainB)
+	defer subB.Unsubscribe()
+
+	srcA, srcB := &countingRefsSource{}, &countingRefsSource{}
+	b.trackHeads(chainA, srcA, nil)
+	b.trackHeads(chainB, srcB, nil)
+	for _, heads := range []<-chan eth.L1BlockRef{headsA, headsB} {
+		select {
+		case <-heads:
+		case <-time.After(time.Second):
+			t.Fatal("finalized head was not polled")
+		}
+	}
+	b.Close()
+
+	tracked := b.TrackedHeads()
+	for i, peer := range []*stubRPC{peerA, peerB} {
+		offset := uint64(i) * 100
+		heads := tracked[ChainIDFromUInt64(900+uint64(i))]
+		require.Equal(t, offset+12, heads.Unsafe.Number)
+		require.Equal(t, offset+11, heads.Safe.Number)
+		require.Equal(t, peer.headers[offset+10].Hash(), heads.Finalized.Hash)
+	}
+	// the heads are polled from the peers, not the sources of the separate polls
+	require.Zero(t, srcA.finalizedPolls)
+	require.Zero(t, srcB.finalizedPolls)
+	// every peer is polled with its own batches of all the heads
+	for _, peer := range []*stubRPC{peerA, peerB} {
+		peer.mu.Lock()
+		require.NotZero(t, peer.batchCalls)
+		for _, size := range peer.batchSizes {
+			require.Equal(t, 3, size)
+		}
+		peer.mu.Unlock()
+	}
+}
+
+func TestPollBatchedHeads(t *testing.T) {
+	peer := newStubRPC()
+	peer.addBlock(10, 100)
+	peer.addBlock(12, 104)
+	// the safe head is not served
+	peer.labels = map[string]uint64{"finalized": 10, "latest": 12}
+
+	var mu sync.Mutex
+	signals := make(map[eth.BlockLabel][]uint64)
+	signal := func(label eth.BlockLabel) eth.HeadSignalFn {
+		return func(ctx context.Context, sig eth.L1BlockRef) {
+			mu.Lock()
+			defer mu.Unlock()
+			signals[label] = append(signals[label], sig.Number)
+		}
+	}
+	labels := []eth.BlockLabel{eth.Unsafe, eth.Safe, eth.Finalized}
+	sub := pollBatchedHeads(testlog.Logger(t, log.LevelError), peer, labels,
+		[]eth.HeadSignalFn{signal(eth.Unsafe), signal(eth.Safe), signal(eth.Finalized)}, time.Millisecond*10, time.Second)
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(signals[eth.Unsafe]) >= 3
+	}, time.Second, time.Millisecond)
+	sub.Unsubscribe()
+
+	mu.Lock()
+	defer mu.Unlock()
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	// one batch request of all the heads per tick
+	require.Equal(t, len(signals[eth.Unsafe]), peer.batchCalls)
+	for _, size := range peer.batchSizes {
+		require.Equal(t, len(labels), size)
+	}
+	require.Len(t, signals[eth.Finalized], peer.batchCalls)
+	for i := range signals[eth.Unsafe] {
+		require.Equal(t, uint64(12), signals[eth.Unsafe][i])
+		require.Equal(t, uint64(10), signals[eth.Finalized][i])
+	}
+	// a head that is not served is not signaled, and does not stop the other heads
+	require.Empty(t, signals[eth.Safe])
+}
+
+func TestJitteredPollTime(t *testing.T) {
+	start := time.Unix(1000, 0)
+	interval := time.Minute
+
+	// the jitter of every poll is bounded by the fraction of the interval
+	require.Equal(t, start.Add(interval-6*time.Second), jitteredPollTime(start, 1, interval, 0.1, 0))
+	require.Equal(t, start.Add(interval), jitteredPollTime(start, 1, interval, 0.1, 0.5))
+	require.Equal(t, start.Add(interval+3*time.Second), jitteredPollTime(start, 1, interval, 0.1, 0.75))
+
+	// the jitter does not accumulate over the polls
+	require.Equal(t, start.Add(1000*interval-6*time.Second), jitteredPollTime(start, 1000, interval, 0.1, 0))
+	require.Equal(t, start.Add(1000*interval), jitteredPollTime(start, 1000, interval, 0, 0))
+}
+
+// labelRefsSource serves a fixed block of every label.
+type labelRefsSource map[eth.BlockLabel]eth.L1BlockRef
+
+func (s labelRefsSource) L1BlockRefByLabel(ctx context.Context, label eth.BlockLabel) (eth.L1BlockRef, error) {
+	ref, ok := s[label]
+	if !ok {
+		return eth.L1BlockRef{}, ethereum.NotFound
+	}
+	return ref, nil
+}
+
+func TestTrackHeadsDevnetFinalityLabel(t *testing.T) {
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: newStubRPC()})
+	b.finalizedPollInterval = time.Millisecond * 10
+	b.devnetFinalityLabels = map[ChainID]eth.BlockLabel{chainId: eth.Safe}
+	heads, sub := b.SubscribeFinalizedHead(chainId)
+	defer sub.Unsubscribe()
+
+	safe := eth.L1BlockRef{Hash: common.Hash{0x02}, Number: 2, Time: 102}
+	b.trackHeads(chainId, labelRefsSource{
+		eth.Finalized: {Hash: common.Hash{0x01}, Number: 1, Time: 100},
+		eth.Safe:      safe,
+	}, nil)
+	defer b.Close()
+
+	// the safe head is tracked as the finalized head
+	requireHead(t, safe, heads)
+	label, finalized := b.safetyLabel(chainId, 2, 102)
+	require.Equal(t, Finalized, label)
+	require.Equal(t, safe, *finalized)
+}
+
+func TestOnFinalizedHead(t *testing.T) {
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: newStubRPC()})
+	heads, sub := b.SubscribeFinalizedHead(chainId)
+	defer sub.Unsubscribe()
+	finalized := func() *eth.L1BlockRef {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return b.l2Heads[chainId].finalized
+	}
+
+	head := eth.L1BlockRef{Hash: common.Hash{10}, Number: 10, Time: 120}
+	b.onFinalizedHead(context.Background(), chainId, head)
+	requireHead(t, head, heads)
+
+	t.Run("OutOfOrder", func(t *testing.T) {
+		b.onFinalizedHead(context.Background(), chainId, eth.L1BlockRef{Hash: common.Hash{9}, Number: 9, Time: 118})
+		require.Equal(t, head, *finalized())
+		require.Empty(t, heads)
+	})
+
+	t.Run("Duplicate", func(t *testing.T) {
+		b.onFinalizedHead(context.Background(), chainId, head)
+		require.Empty(t, heads)
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		b.onFinalizedHead(ctx, chainId, eth.L1BlockRef{Hash: common.Hash{11}, Number: 11, Time: 122})
+		require.Equal(t, head, *finalized())
+	})
+
+	t.Run("Advance", func(t *testing.T) {
+		next := eth.L1BlockRef{Hash: common.Hash{11}, Number: 11, ParentHash: common.Hash{10}, Time: 122}
+		b.onFinalizedHead(context.Background(), chainId, next)
+		require.Equal(t, next, *finalized())
+		requireHead(t, next, heads)
+	})
+}
+
+func TestRefreshFinalizedHead(t *testing.T) {
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: newStubRPC(), ChainIDFromUInt64(901): newStubRPC()})
+	// the finalized head is not polled, only refreshed
+	src := &stubHeadsSource{finalized: eth.L1BlockRef{Hash: common.Hash{10}, Number: 10, Time: 120}}
+	b.l2Sources[chainId] = src
+	b.l2Sources[ChainIDFromUInt64(901)] = labelRefsSource{}
+	heads, sub := b.SubscribeFinalizedHead(chainId)
+	defer sub.Unsubscribe()
+
+	require.NoError(t, b.RefreshFinalizedHead(context.Background(), chainId))
+	requireHead(t, src.finalized, heads)
+	require.Equal(t, src.finalized, *b.TrackedHeads()[chainId].Finalized)
+
+	next := eth.L1BlockRef{Hash: common.Hash{11}, Number: 11, ParentHash: common.Hash{10}, Time: 122}
+	src.mu.Lock()
+	src.finalized = next
+	src.mu.Unlock()
+	require.NoError(t, b.RefreshFinalizedHead(context.Background(), chainId))
+	requireHead(t, next, heads)
+	require.Equal(t, next, *b.TrackedHeads()[chainId].Finalized)
+
+	err := b.RefreshFinalizedHead(context.Background(), ChainIDFromUInt64(901))
+	require.ErrorIs(t, err, ethereum.NotFound)
+	require.ErrorContains(t, err, "failed to fetch finalized head of peer with chain id 901")
+	require.ErrorIs(t, b.RefreshFinalizedHead(context.Background(), ChainIDFromUInt64(902)), ErrPeerNotConfigured)
+
+	require.NoError(t, b.Close())
+	require.ErrorIs(t, b.RefreshFinalizedHead(context.Background(), chainId), ErrBackendClosed)
+}
+
+func TestOnFinalizedHeadReorg(t *testing.T) {
+	chainId := ChainIDFromUInt64(900)
+	head := eth.L1BlockRef{Hash: common.Hash{10}, Number: 10, Time: 120}
+	reorgs := map[string]eth.L1BlockRef{
+		"ConflictingBlock": {Hash: common.Hash{0xff}, Number: 10, Time: 120},
+		"UnlinkedChild":    {Hash: common.Hash{11}, Number: 11, ParentHash: common.Hash{0xff}, Time: 122},
+		"TimeRegression":   {Hash: common.Hash{12}, Number: 12, Time: 118},
+	}
+	for name, reorg := range reorgs {
+		reorg := reorg
+		t.Run(name, func(t *testing.T) {
+			for _, reject := range []bool{false, true} {
+				logger, logs := testlog.CaptureLogger(t, log.LevelInfo)
+				b := newTestBackend(t, map[ChainID]client.RPC{chainId: newStubRPC()})
+				b.log = logger
+				b.rejectFinalizedReorgs = reject
+				setHeads(b, chainId, &head, nil, nil)
+
+				b.onFinalizedHead(context.Background(), chainId, reorg)
+				require.NotNil(t, logs.FindLog(testlog.NewMessageFilter("peer reported a finalized head that reorgs the tracked finalized head")))
+				expected := reorg
+				if reject {
+					expected = head
+				}
+				require.Equal(t, expected, *b.TrackedHeads()[chainId].Finalized)
+			}
+		})
+	}
+
+	t.Run("UncheckedGap", func(t *testing.T) {
+		// the parent of a head further than a block ahead is not known
+		b := newTestBackend(t, map[ChainID]client.RPC{chainId: newStubRPC()})
+		b.rejectFinalizedReorgs = true
+		setHeads(b, chainId, &head, nil, nil)
+		next := eth.L1BlockRef{Hash: common.Hash{20}, Number: 20, ParentHash: common.Hash{0xff}, Time: 140}
+		b.onFinalizedHead(context.Background(), chainId, next)
+		require.Equal(t, next, *b.TrackedHeads()[chainId].Finalized)
+	})
+}
+
+func TestOnSafeHeadRegression(t *testing.T) {
+	peer := newStubRPC()
+	peer.addBlock(11, 110, testLog(common.Address{0xaa}, []byte{0x01}))
+	chainId := ChainIDFromUInt64(900)
+	logger, logs := testlog.CaptureLogger(t, log.LevelInfo)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	b.log = logger
+	setHeads(b, chainId, &eth.L1BlockRef{Number: 10, Time: 100}, nil, &eth.L1BlockRef{Number: 12, Time: 120})
+	b.onSafeHead(chainId, eth.L1BlockRef{Hash: common.Hash{11}, Number: 11, Time: 110})
+
+	id, payload := testMessage(900, peer, 11, 0)
+	label, err := b.MessageSafety(context.Background(), id, payload)
+	require.NoError(t, err)
+	require.Equal(t, CrossSafe, label)
+
+	// a reorg of the peer chain regresses the safe head, the safe label of the message is not served again
+	b.onSafeHead(chainId, eth.L1BlockRef{Hash: common.Hash{0x10}, Number: 10, Time: 100})
+	require.NotNil(t, logs.FindLog(testlog.NewMessageFilter("safe head of peer regressed")))
+	label, err = b.MessageSafety(context.Background(), id, payload)
+	require.NoError(t, err)
+	require.Equal(t, Unsafe, label)
+	require.Equal(t, 2, peer.batchCalls)
+}
+
+func TestTrackedHeads(t *testing.T) {
+	chainA, chainB := ChainIDFromUInt64(900), ChainIDFromUInt64(901)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainA: newStubRPC(), chainB: newStubRPC()})
+	finalized := &eth.L1BlockRef{Hash: common.Hash{0x01}, Number: 1, Time: 98}
+	safe := &eth.L1BlockRef{Hash: common.Hash{0x02}, Number: 2, Time: 100}
+	setHeads(b, chainA, finalized, safe, nil)
+	setHeads(b, chainB, nil, nil, nil)
+
+	heads := b.TrackedHeads()
+	require.Equal(t, map[ChainID]HeadSnapshot{
+		chainA: {Safe: safe, Finalized: finalized},
+		chainB: {},
+	}, heads)
+
+	// the snapshot is not affected by later updates of the heads
+	b.onSafeHead(chainA, eth.L1BlockRef{Hash: common.Hash{0x03}, Number: 3, Time: 102})
+	b.onFinalizedHead(context.Background(), chainA, eth.L1BlockRef{Hash: common.Hash{0x02}, Number: 2, Time: 100})
+	require.Equal(t, *finalized, *heads[chainA].Finalized)
+	require.Equal(t, *safe, *heads[chainA].Safe)
+	require.Equal(t, uint64(3), b.TrackedHeads()[chainA].Safe.Number)
+}
+
+func requireHead(t *testing.T, expected eth.L1BlockRef, heads <-chan eth.L1BlockRef) {
+	t.Helper()
+	select {
+	case head := <-heads:
+		require.Equal(t, expected, head)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for head")
+	}
+}
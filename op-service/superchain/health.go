@@ -0,0 +1,73 @@
+package superchain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	"github.com/ethereum-optimism/optimism/op-service/client"
+)
+
+// HealthCheck checks the L2 node and every peer are reachable, and that every peer serves the chain
+// it is configured for. The returned error lists all the unhealthy nodes.
+func (b *backend) HealthCheck(ctx context.Context) error {
+	if b.closed.Load() {
+		return ErrBackendClosed
+	}
+	var errs []error
+	if b.l2Node != nil {
+		if _, err := fetchChainID(ctx, b.l2Node, b.rpcTimeout); err != nil {
+			errs = append(errs, fmt.Errorf("l2 node: %w", err))
+		}
+	}
+	for _, chainId := range b.peerChainIDs() {
+		if err := checkChainID(ctx, b.l2PeerNodes[chainId], chainId, b.rpcTimeoutOf(chainId)); err != nil {
+			errs = append(errs, fmt.Errorf("peer with chain id %s: %w", chainId, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("unhealthy nodes: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// checkChainID checks the node serves the chain with the expected chain id.
+func checkChainID(ctx context.Context, node client.RPC, chainId ChainID, timeout time.Duration) error {
+	actual, err := fetchChainID(ctx, node, timeout)
+	if err != nil {
+		return err
+	}
+	if actual != chainId {
+		return fmt.Errorf("chain id mismatch: node serves chain id %s", actual)
+	}
+	return nil
+}
+
+// fetchChainID fetches the chain id served by the node, bounded by the timeout.
+func fetchChainID(ctx context.Context, node client.RPC, timeout time.Duration) (ChainID, error) {
+	rpcCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var result hexutil.Big
+	if err := node.CallContext(rpcCtx, &result, "eth_chainId"); err != nil {
+		return ChainID{}, fmt.Errorf("failed to fetch chain id: %w", err)
+	}
+	chainId, ok := ChainIDFromBig(result.ToInt())
+	if !ok {
+		return ChainID{}, fmt.Errorf("invalid chain id %s", &result)
+	}
+	return chainId, nil
+}
+
+// peerChainIDs returns the chain ids of all the peers, in ascending order.
+func (b *backend) peerChainIDs() []ChainID {
+	chainIds := make([]ChainID, 0, len(b.l2PeerNodes))
+	for chainId := range b.l2PeerNodes {
+		chainIds = append(chainIds, chainId)
+	}
+	slices.SortFunc(chainIds, ChainID.Cmp)
+	return chainIds
+}
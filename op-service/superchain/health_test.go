@@ -0,0 +1,49 @@
+package superchain
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum-optimism/optimism/op-service/client"
+)
+
+func TestHealthCheck(t *testing.T) {
+	primary := &stubRPC{chainId: 10}
+	healthy := &stubRPC{chainId: 900}
+	misconfigured := &stubRPC{chainId: 902}
+	unreachable := &stubRPC{chainId: 903, err: errors.New("connection refused")}
+
+	t.Run("Healthy", func(t *testing.T) {
+		b := newTestBackend(t, map[ChainID]client.RPC{ChainIDFromUInt64(900): healthy})
+		b.l2Node = primary
+		require.NoError(t, b.HealthCheck(context.Background()))
+	})
+
+	t.Run("Unhealthy", func(t *testing.T) {
+		b := newTestBackend(t, map[ChainID]client.RPC{
+			ChainIDFromUInt64(900): healthy,
+			ChainIDFromUInt64(901): misconfigured,
+			ChainIDFromUInt64(903): unreachable,
+		})
+		b.l2Node = primary
+		err := b.HealthCheck(context.Background())
+		require.ErrorContains(t, err, "peer with chain id 901: chain id mismatch: node serves chain id 902")
+		require.ErrorContains(t, err, "peer with chain id 903: failed to fetch chain id: connection refused")
+		require.NotContains(t, err.Error(), "peer with chain id 900")
+	})
+
+	t.Run("UnreachableL2Node", func(t *testing.T) {
+		b := newTestBackend(t, map[ChainID]client.RPC{ChainIDFromUInt64(900): healthy})
+		b.l2Node = unreachable
+		require.ErrorContains(t, b.HealthCheck(context.Background()), "l2 node: failed to fetch chain id")
+	})
+
+	t.Run("Closed", func(t *testing.T) {
+		b := newTestBackend(t, nil)
+		require.NoError(t, b.Close())
+		require.ErrorIs(t, b.HealthCheck(context.Background()), ErrBackendClosed)
+	})
+}
@@ -0,0 +1,80 @@
+package superchain
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+)
+
+// FinalizingHead returns the earliest block of the peer chain that, as finalized head, includes a message with
+// the timestamp: the first block with a timestamp at or after the timestamp of the message. The block is
+// searched by number between genesis and the tracked finalized head, so the message must be finalized already.
+// It is meant for audits and diagnostics, and does not affect how messages are labeled.
+func (b *backend) FinalizingHead(ctx context.Context, chainId ChainID, timestamp uint64) (eth.L1BlockRef, error) {
+	if b.closed.Load() {
+		return eth.L1BlockRef{}, ErrBackendClosed
+	}
+	peer, ok := b.l2PeerNodes[chainId]
+	if !ok {
+		return eth.L1BlockRef{}, &PeerNotConfiguredError{ChainID: chainId}
+	}
+	b.mu.Lock()
+	finalized := copyRef(b.l2Heads[chainId].finalized)
+	b.mu.Unlock()
+	if finalized == nil {
+		return eth.L1BlockRef{}, fmt.Errorf("finalized head of peer with chain id %s is unknown", chainId)
+	}
+	if timestamp > finalized.Time {
+		return eth.L1BlockRef{}, fmt.Errorf("timestamp %d is not finalized yet, the finalized head of chain id %s has timestamp %d",
+			timestamp, chainId, finalized.Time)
+	}
+
+	// The finalized head includes the timestamp, search the first block that does
+	lo, hi := uint64(0), finalized.Number
+	header, err := b.finalizedHeader(ctx, peer, hi)
+	if err != nil {
+		return eth.L1BlockRef{}, err
+	}
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		midHeader, err := b.finalizedHeader(ctx, peer, mid)
+		if err != nil {
+			return eth.L1BlockRef{}, err
+		}
+		if midHeader.Time >= timestamp {
+			hi, header = mid, midHeader
+		} else {
+			lo = mid + 1
+		}
+	}
+	return eth.InfoToL1BlockRef(eth.HeaderBlockInfo(header)), nil
+}
+
+// finalizedHeader returns the header of the finalized block of the peer with the number, from the header cache
+// if cached. Fetched headers are cached, as finalized blocks can no longer be reorged out, once checked to be the
+// headers of the requested blocks.
+func (b *backend) finalizedHeader(ctx context.Context, peer *peer, number uint64) (*types.Header, error) {
+	key := headerKey{peer.chainId, number}
+	if header, ok := b.headerCache.Get(key); ok {
+		return header, nil
+	}
+	rpcCtx, cancel := context.WithTimeout(ctx, b.rpcTimeoutOf(peer.chainId))
+	defer cancel()
+	var header *types.Header
+	if err := peer.CallContext(rpcCtx, &header, "eth_getBlockByNumber", hexutil.EncodeUint64(number), false); err != nil {
+		return nil, categorize(ErrFetchFailed, fmt.Errorf("unable to request header %d of chain id %s: %w", number, peer.chainId, err))
+	}
+	if header == nil {
+		return nil, fmt.Errorf("finalized block %d of chain id %s not found", number, peer.chainId)
+	}
+	if header.Number == nil || !header.Number.IsUint64() || header.Number.Uint64() != number {
+		return nil, categorize(ErrFetchFailed, fmt.Errorf("peer served header of block %v for finalized block %d of chain id %s",
+			header.Number, number, peer.chainId))
+	}
+	b.headerCache.Add(key, header)
+	return header, nil
+}
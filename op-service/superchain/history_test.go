@@ -0,0 +1,91 @@
+package superchain
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum-optimism/optimism/op-service/client"
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+)
+
+func TestFinalizingHead(t *testing.T) {
+	peer := newStubRPC()
+	// a block every 2 seconds, block 0 at timestamp 1000
+	for number := uint64(0); number <= 100; number++ {
+		peer.addBlock(number, 1000+2*number)
+	}
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	finalizedHeader := peer.headers[100]
+	setHeads(b, chainId, &eth.L1BlockRef{Hash: finalizedHeader.Hash(), Number: 100, Time: 1200}, nil, nil)
+
+	for _, test := range []struct {
+		name      string
+		timestamp uint64
+		number    uint64
+	}{
+		{"Genesis", 1000, 0},
+		{"BeforeGenesis", 1, 0},
+		{"BlockTimestamp", 1052, 26},
+		{"BetweenBlocks", 1051, 26},
+		{"FinalizedHead", 1200, 100},
+		{"BeforeFinalizedHead", 1199, 100},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			head, err := b.FinalizingHead(context.Background(), chainId, test.timestamp)
+			require.NoError(t, err)
+			require.Equal(t, eth.InfoToL1BlockRef(eth.HeaderBlockInfo(peer.headers[test.number])), head)
+		})
+	}
+
+	t.Run("CachedHeaders", func(t *testing.T) {
+		// the searched headers are cached, and not requested again
+		peer.err = errors.New("connection refused")
+		defer func() { peer.err = nil }()
+		head, err := b.FinalizingHead(context.Background(), chainId, 1052)
+		require.NoError(t, err)
+		require.Equal(t, uint64(26), head.Number)
+	})
+
+	t.Run("NotFinalized", func(t *testing.T) {
+		_, err := b.FinalizingHead(context.Background(), chainId, 1201)
+		require.ErrorContains(t, err, "timestamp 1201 is not finalized yet, the finalized head of chain id 900 has timestamp 1200")
+	})
+
+	t.Run("UnknownPeer", func(t *testing.T) {
+		_, err := b.FinalizingHead(context.Background(), ChainIDFromUInt64(901), 1000)
+		require.ErrorContains(t, err, "peer with chain id 901 is not configured")
+	})
+
+	t.Run("PeerError", func(t *testing.T) {
+		b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+		setHeads(b, chainId, &eth.L1BlockRef{Hash: finalizedHeader.Hash(), Number: 100, Time: 1200}, nil, nil)
+		peer.err = errors.New("connection refused")
+		defer func() { peer.err = nil }()
+		_, err := b.FinalizingHead(context.Background(), chainId, 1000)
+		require.ErrorContains(t, err, "unable to request header 100 of chain id 900: connection refused")
+	})
+
+	t.Run("HeaderNumberMismatch", func(t *testing.T) {
+		// a lagging peer serves the header of another block, which is neither used nor cached
+		b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+		setHeads(b, chainId, &eth.L1BlockRef{Hash: finalizedHeader.Hash(), Number: 100, Time: 1200}, nil, nil)
+		original := peer.headers[50]
+		peer.headers[50] = peer.headers[49]
+		defer func() { peer.headers[50] = original }()
+		_, err := b.FinalizingHead(context.Background(), chainId, 1051)
+		require.ErrorIs(t, err, ErrFetchFailed)
+		require.ErrorContains(t, err, "peer served header of block 49 for finalized block 50 of chain id 900")
+		_, ok := b.headerCache.Get(headerKey{chainId, 50})
+		require.False(t, ok)
+	})
+
+	t.Run("UnknownFinalizedHead", func(t *testing.T) {
+		setHeads(b, chainId, nil, nil, nil)
+		_, err := b.FinalizingHead(context.Background(), chainId, 1000)
+		require.ErrorContains(t, err, "finalized head of peer with chain id 900 is unknown")
+	})
+}
@@ -0,0 +1,105 @@
+package superchain
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// UnmarshalJSON decodes the identifier, accepting every number field as a JSON number, a decimal string or a
+// 0x-prefixed hexadecimal string, as encoded by the various clients. Identifiers are encoded with JSON numbers.
+func (id *MessageIdentifier) UnmarshalJSON(data []byte) error {
+	var dec struct {
+		Origin      common.Address `json:"origin"`
+		BlockNumber *jsonNumber    `json:"blockNumber"`
+		LogIndex    *jsonNumber    `json:"logIndex"`
+		Timestamp   *jsonNumber    `json:"timestamp"`
+		ChainId     *jsonNumber    `json:"chainId"`
+		BlockHash   *common.Hash   `json:"blockHash,omitempty"`
+		LogCount    *jsonNumber    `json:"logCount,omitempty"`
+	}
+	if err := json.Unmarshal(data, &dec); err != nil {
+		return err
+	}
+	logIndex, err := dec.LogIndex.uint64()
+	if err != nil {
+		return fmt.Errorf("invalid log index: %w", err)
+	}
+	timestamp, err := dec.Timestamp.uint64()
+	if err != nil {
+		return fmt.Errorf("invalid timestamp: %w", err)
+	}
+	logCount, err := dec.LogCount.uint64()
+	if err != nil {
+		return fmt.Errorf("invalid log count: %w", err)
+	}
+	*id = MessageIdentifier{
+		Origin:      dec.Origin,
+		BlockNumber: dec.BlockNumber.big(),
+		LogIndex:    logIndex,
+		Timestamp:   timestamp,
+		ChainId:     dec.ChainId.big(),
+		BlockHash:   dec.BlockHash,
+		LogCount:    logCount,
+	}
+	return nil
+}
+
+// jsonNumber is an integer encoded as a JSON number, a decimal string or a 0x-prefixed hexadecimal string.
+type jsonNumber big.Int
+
+func (n *jsonNumber) UnmarshalJSON(data []byte) error {
+	text := string(data)
+	if len(data) > 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &text); err != nil {
+			return err
+		}
+		if text == "" {
+			return fmt.Errorf("empty number string")
+		}
+	} else if isJSONNumber(data) && bytes.ContainsAny(data, ".eE") {
+		return fmt.Errorf("number %s is not an integer", data)
+	}
+	base, digits := 10, text
+	if hex, ok := strings.CutPrefix(text, "0x"); ok {
+		base, digits = 16, hex
+	} else if hex, ok := strings.CutPrefix(text, "0X"); ok {
+		base, digits = 16, hex
+	}
+	// Signs are only accepted in decimal, as big.Int would also accept them after the prefix
+	if base == 16 && strings.ContainsAny(digits, "+-") {
+		return fmt.Errorf("invalid number %q", text)
+	}
+	if _, ok := (*big.Int)(n).SetString(digits, base); !ok {
+		return fmt.Errorf("invalid number %q", text)
+	}
+	return nil
+}
+
+// isJSONNumber returns whether the data is a JSON number literal, rather than another JSON value.
+func isJSONNumber(data []byte) bool {
+	return len(data) > 0 && (data[0] == '-' || (data[0] >= '0' && data[0] <= '9'))
+}
+
+// big returns the number, nil if the number is nil.
+func (n *jsonNumber) big() *big.Int {
+	if n == nil {
+		return nil
+	}
+	return (*big.Int)(n)
+}
+
+// uint64 returns the number, zero if the number is nil, or an error if it does not fit 64 bits.
+func (n *jsonNumber) uint64() (uint64, error) {
+	if n == nil {
+		return 0, nil
+	}
+	if !n.big().IsUint64() {
+		return 0, fmt.Errorf("number %s does not fit 64 bits", n.big())
+	}
+	return n.big().Uint64(), nil
+}
@@ -0,0 +1,77 @@
+package superchain
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestMessageIdentifierUnmarshalJSON(t *testing.T) {
+	hash := common.Hash{0x01}
+	expected := MessageIdentifier{
+		Origin:      common.Address{0xaa},
+		BlockNumber: big.NewInt(10),
+		LogIndex:    2,
+		Timestamp:   100,
+		ChainId:     big.NewInt(900),
+		BlockHash:   &hash,
+	}
+	origin := `"origin":"0xaa00000000000000000000000000000000000000","blockHash":"` + hash.Hex() + `"`
+	for name, data := range map[string]string{
+		"Numbers":        `{` + origin + `,"blockNumber":10,"logIndex":2,"timestamp":100,"chainId":900}`,
+		"DecimalStrings": `{` + origin + `,"blockNumber":"10","logIndex":"2","timestamp":"100","chainId":"900"}`,
+		"HexStrings":     `{` + origin + `,"blockNumber":"0xa","logIndex":"0x2","timestamp":"0x64","chainId":"0x384"}`,
+		"Mixed":          `{` + origin + `,"blockNumber":"0XA","logIndex":2,"timestamp":"100","chainId":"0x384"}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			var id MessageIdentifier
+			require.NoError(t, json.Unmarshal([]byte(data), &id))
+			require.Equal(t, expected, id)
+		})
+	}
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		data, err := json.Marshal(expected)
+		require.NoError(t, err)
+		require.Contains(t, string(data), `"chainId":900`)
+		var id MessageIdentifier
+		require.NoError(t, json.Unmarshal(data, &id))
+		require.Equal(t, expected, id)
+	})
+
+	t.Run("LargeChainId", func(t *testing.T) {
+		var id MessageIdentifier
+		require.NoError(t, json.Unmarshal([]byte(`{"chainId":"0x10000000000000000"}`), &id))
+		require.Equal(t, new(big.Int).Lsh(big.NewInt(1), 64), id.ChainId)
+		require.Nil(t, id.BlockNumber)
+	})
+
+	t.Run("LogCount", func(t *testing.T) {
+		var id MessageIdentifier
+		require.NoError(t, json.Unmarshal([]byte(`{"logIndex":2,"logCount":"0x2"}`), &id))
+		require.Equal(t, uint64(2), id.LogCount)
+	})
+
+	for name, test := range map[string]struct {
+		data string
+		err  string
+	}{
+		"Fraction":          {`{"chainId":900.5}`, "number 900.5 is not an integer"},
+		"Exponent":          {`{"chainId":9e2}`, "number 9e2 is not an integer"},
+		"EmptyString":       {`{"chainId":""}`, "empty number string"},
+		"InvalidHex":        {`{"chainId":"0xzz"}`, `invalid number "0xzz"`},
+		"SignedHex":         {`{"chainId":"0x-1"}`, `invalid number "0x-1"`},
+		"NegativeLogIndex":  {`{"logIndex":-1}`, "invalid log index: number -1 does not fit 64 bits"},
+		"TimestampOverflow": {`{"timestamp":"0x10000000000000000"}`, "invalid timestamp: number 18446744073709551616 does not fit 64 bits"},
+		"NotANumber":        {`{"blockNumber":true}`, `invalid number "true"`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var id MessageIdentifier
+			require.ErrorContains(t, json.Unmarshal([]byte(test.data), &id), test.err)
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package superchain
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"slices"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// LogFetcher fetches the header and the logs of a block of a peer chain, to check the messages of the block.
+// The index of every log must be its index in the block, and the header is nil if the block does not exist.
+// Logs of any origin and topic may be returned, the logs of the messages are selected by the backend.
+type LogFetcher interface {
+	FetchBlockAndLogs(ctx context.Context, chainId ChainID, blockNumber *big.Int) (*types.Header, []types.Log, error)
+}
+
+// blockFetcher fetches the queried blocks of a peer chain. An error is returned if the request failed as a whole,
+// errors of individual blocks are set on the block data.
+type blockFetcher interface {
+	fetchBlocks(ctx context.Context, queries []blockQuery) ([]blockData, error)
+}
+
+// blockFetcher returns the fetcher of the blocks of the peer: its custom log fetcher if configured, or RPC.
+func (b *backend) blockFetcher(peer *peer) blockFetcher {
+	if peer.logFetcher != nil {
+		return &customBlockFetcher{chainId: peer.chainId, fetcher: peer.logFetcher, topic: b.messageTopic}
+	}
+	return &rpcLogFetcher{peer: peer, log: b.log, topic: b.messageTopic, maxBatchSize: b.maxBatchSize}
+}
+
+// rpcLogFetcher is the default LogFetcher, fetching the blocks from the L2 node of the peer in batch requests,
+// with eth_getLogs or eth_getBlockReceipts. If the connection to the peer is broken, the peer fails over to its
+// next endpoint and the request is retried, once for every endpoint of the peer.
+type rpcLogFetcher struct {
+	peer         *peer
+	log          log.Logger
+	topic        common.Hash
+	maxBatchSize int
+}
+
+var _ LogFetcher = (*rpcLogFetcher)(nil)
+
+func (f *rpcLogFetcher) fetchBlocks(ctx context.Context, queries []blockQuery) ([]blockData, error) {
+	l2Node := f.peer.client()
+	blocks, err := fetchBlocks(ctx, l2Node, queries, f.topic, f.maxBatchSize, f.peer.blockReceipts)
+	for attempt := 0; isConnectionError(err) && attempt < f.peer.endpoints(); attempt++ {
+		f.log.Warn("peer connection failed, reconnecting", "chain_id", f.peer.chainId, "err", err)
+		var dialErr error
+		if l2Node, dialErr = f.peer.reconnect(ctx, l2Node); dialErr != nil {
+			f.log.Warn("failed to reconnect peer", "chain_id", f.peer.chainId, "err", dialErr)
+			break
+		}
+		blocks, err = fetchBlocks(ctx, l2Node, queries, f.topic, f.maxBatchSize, f.peer.blockReceipts)
+	}
+	return blocks, err
+}
+
+// FetchBlockAndLogs fetches the block with the logs of any origin, with the message topic if configured.
+func (f *rpcLogFetcher) FetchBlockAndLogs(ctx context.Context, chainId ChainID, blockNumber *big.Int) (*types.Header, []types.Log, error) {
+	if chainId != f.peer.chainId {
+		return nil, nil, &PeerNotConfiguredError{ChainID: chainId}
+	}
+	blocks, err := f.fetchBlocks(ctx, []blockQuery{{number: blockNumber}})
+	if err != nil {
+		return nil, nil, err
+	}
+	return blocks[0].header, blocks[0].logs, blocks[0].err
+}
+
+// customBlockFetcher fetches the queried blocks one at a time with a custom LogFetcher, and selects the logs
+// of the queried origins with the message topic, as filtered by the peer for the rpc fetcher.
+type customBlockFetcher struct {
+	chainId ChainID
+	fetcher LogFetcher
+	topic   common.Hash
+}
+
+func (f *customBlockFetcher) fetchBlocks(ctx context.Context, queries []blockQuery) ([]blockData, error) {
+	blocks := make([]blockData, len(queries))
+	for i, q := range queries {
+		header, logs, err := f.fetcher.FetchBlockAndLogs(ctx, f.chainId, q.number)
+		if err != nil {
+			// The request fails as a whole once the caller gave up, like a batch request would
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("unable to fetch block %d: %w", q.number, err)
+			}
+			blocks[i].err = fmt.Errorf("unable to fetch block %d: %w", q.number, err)
+			continue
+		}
+		if header != nil && (header.Number == nil || header.Number.Cmp(q.number) != 0) {
+			blocks[i].err = fmt.Errorf("fetched block %v instead of block %d", header.Number, q.number)
+			continue
+		}
+		blocks[i].header = header
+		for _, log := range logs {
+			if len(q.origins) > 0 && !slices.Contains(q.origins, log.Address) {
+				continue
+			}
+			if f.topic != (common.Hash{}) && (len(log.Topics) == 0 || log.Topics[0] != f.topic) {
+				continue
+			}
+			blocks[i].logs = append(blocks[i].logs, log)
+		}
+	}
+	return blocks, nil
+}
@@ -0,0 +1,94 @@
+package superchain
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	"github.com/ethereum-optimism/optimism/op-service/client"
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+)
+
+func TestMessageSafetyLogFetcher(t *testing.T) {
+	// the blocks are served by the fetcher, the peer only serves the heads
+	chain := newStubRPC()
+	header := chain.addBlock(10, 100, testLog(common.Address{0xbb}, []byte{0x02}), testLog(common.Address{0xaa}, []byte{0x01}))
+	fetcher := NewFakeLogFetcher()
+	fetcher.SetBlock(ChainIDFromUInt64(900), header, chain.logs[10]...)
+	fetcher.SetBlockError(ChainIDFromUInt64(900), 11, errors.New("boom"))
+
+	peer := newStubRPC()
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	b.l2PeerNodes[chainId].logFetcher = fetcher
+	setHeads(b, chainId, &eth.L1BlockRef{Number: 12, Time: 120}, nil, nil)
+
+	id, payload := testMessage(900, chain, 10, 1)
+	label, err := b.MessageSafety(context.Background(), id, payload)
+	require.NoError(t, err)
+	require.Equal(t, Finalized, label)
+
+	// the logs of other origins are not matched
+	other, otherPayload := testMessage(900, chain, 10, 1)
+	other.Origin = common.Address{0xcc}
+	res, err := b.MessageSafetyDetails(context.Background(), other, otherPayload)
+	require.ErrorContains(t, err, "no logs emitted by 0xCc00000000000000000000000000000000000000 in block 10")
+	require.Equal(t, ReasonNoLogs, res.Reason)
+
+	failing := MessageIdentifier{Origin: common.Address{0xaa}, BlockNumber: big.NewInt(11), Timestamp: 110, ChainId: big.NewInt(900)}
+	res, err = b.MessageSafetyDetails(context.Background(), failing, hexutil.Bytes{0x01})
+	require.ErrorIs(t, err, ErrFetchFailed)
+	require.ErrorContains(t, err, "unable to fetch block 11: boom")
+	require.Equal(t, ReasonFetchFailed, res.Reason)
+
+	missing := MessageIdentifier{Origin: common.Address{0xaa}, BlockNumber: big.NewInt(12), Timestamp: 120, ChainId: big.NewInt(900)}
+	res, err = b.MessageSafetyDetails(context.Background(), missing, hexutil.Bytes{0x01})
+	require.ErrorContains(t, err, "block 12 does not exist")
+	require.Equal(t, ReasonBlockNotFound, res.Reason)
+
+	labels, err := b.MessageSafetyBatch(context.Background(), []MessageIdentifier{id, failing}, []hexutil.Bytes{payload, {0x01}})
+	require.ErrorContains(t, err, "message 1: unable to fetch block 11")
+	require.Equal(t, []MessageSafetyLabel{Finalized, Invalid}, labels)
+
+	require.Equal(t, 5, fetcher.Fetched())
+	require.Zero(t, peer.batchCalls)
+}
+
+func TestCustomBlockFetcherBlockNumber(t *testing.T) {
+	chain := newStubRPC()
+	header := chain.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x01}))
+	fetcher := NewFakeLogFetcher()
+	// the fetcher serves block 10 for block 11
+	fetcher.SetBlock(ChainIDFromUInt64(900), header, chain.logs[10]...)
+	fetcher.blocks[ChainIDFromUInt64(900)][11] = fetcher.blocks[ChainIDFromUInt64(900)][10]
+
+	f := &customBlockFetcher{chainId: ChainIDFromUInt64(900), fetcher: fetcher}
+	blocks, err := f.fetchBlocks(context.Background(), []blockQuery{{number: big.NewInt(10)}, {number: big.NewInt(11)}})
+	require.NoError(t, err)
+	require.NoError(t, blocks[0].err)
+	require.Equal(t, header.Hash(), blocks[0].header.Hash())
+	require.ErrorContains(t, blocks[1].err, "fetched block 10 instead of block 11")
+}
+
+func TestRPCLogFetcher(t *testing.T) {
+	peer := newStubRPC()
+	header := peer.addBlock(10, 100, testLog(common.Address{0xbb}, []byte{0x02}), testLog(common.Address{0xaa}, []byte{0x01}))
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	fetcher := b.blockFetcher(b.l2PeerNodes[chainId]).(LogFetcher)
+
+	fetched, logs, err := fetcher.FetchBlockAndLogs(context.Background(), chainId, big.NewInt(10))
+	require.NoError(t, err)
+	require.Equal(t, header.Hash(), fetched.Hash())
+	require.Equal(t, peer.logs[10], logs)
+
+	_, _, err = fetcher.FetchBlockAndLogs(context.Background(), ChainIDFromUInt64(901), big.NewInt(10))
+	require.ErrorIs(t, err, ErrPeerNotConfigured)
+}
@@ -0,0 +1,200 @@
+package superchain
+
+import (
+	"context"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+)
+
+// LoggingBackend is a SuperchainBackend logging every call to the wrapped backend, with the message, the outcome
+// and the duration of the call, for debugging the integrations of the backend. Payloads are only logged as keccak
+// hash. Calls are logged at info level, and failed calls at warn level.
+type LoggingBackend struct {
+	backend SuperchainBackend
+	log     log.Logger
+}
+
+var _ SuperchainBackend = (*LoggingBackend)(nil)
+
+// NewLoggingBackend returns the backend logging every call to the wrapped backend to the logger.
+func NewLoggingBackend(backend SuperchainBackend, logger log.Logger) *LoggingBackend {
+	return &LoggingBackend{backend: backend, log: logger}
+}
+
+// logCall logs the call of the method, started at the given time, with the error of the call and the context.
+func (l *LoggingBackend) logCall(method string, start time.Time, err error, ctx ...any) {
+	ctx = append(append([]any{"method", method}, ctx...), "duration", time.Since(start))
+	if err != nil {
+		l.log.Warn("superchain backend call failed", append(ctx, "err", err)...)
+		return
+	}
+	l.log.Info("superchain backend call", ctx...)
+}
+
+// messageContext returns the log context of the message, with the hash of the payload.
+func messageContext(id MessageIdentifier, payload []byte) []any {
+	return []any{"chain_id", id.ChainId, "block_number", id.BlockNumber, "log_index", id.LogIndex, "origin", id.Origin,
+		"timestamp", id.Timestamp, "payload_hash", crypto.Keccak256Hash(payload)}
+}
+
+func (l *LoggingBackend) MessageSafety(ctx context.Context, id MessageIdentifier, payload hexutil.Bytes) (MessageSafetyLabel, error) {
+	start := time.Now()
+	label, err := l.backend.MessageSafety(ctx, id, payload)
+	l.logCall("MessageSafety", start, err, append(messageContext(id, payload), "label", label)...)
+	return label, err
+}
+
+func (l *LoggingBackend) MessageSafetyDetails(ctx context.Context, id MessageIdentifier, payload hexutil.Bytes) (MessageSafetyResult, error) {
+	start := time.Now()
+	res, err := l.backend.MessageSafetyDetails(ctx, id, payload)
+	l.logCall("MessageSafetyDetails", start, err, append(messageContext(id, payload), "label", res.Label, "reason", res.Reason)...)
+	return res, err
+}
+
+func (l *LoggingBackend) MessageSafetyBatch(ctx context.Context, ids []MessageIdentifier, payloads []hexutil.Bytes) ([]MessageSafetyLabel, error) {
+	start := time.Now()
+	labels, err := l.backend.MessageSafetyBatch(ctx, ids, payloads)
+	l.logCall("MessageSafetyBatch", start, err, "messages", len(ids), "labels", labels)
+	return labels, err
+}
+
+func (l *LoggingBackend) SubscribeFinalizedHead(chainId ChainID) (<-chan eth.L1BlockRef, ethereum.Subscription) {
+	start := time.Now()
+	heads, sub := l.backend.SubscribeFinalizedHead(chainId)
+	l.logCall("SubscribeFinalizedHead", start, nil, "chain_id", chainId)
+	return heads, sub
+}
+
+func (l *LoggingBackend) MessageSafetyAt(ctx context.Context, id MessageIdentifier, payload hexutil.Bytes, finalizedTimestamp uint64) (MessageSafetyLabel, error) {
+	start := time.Now()
+	label, err := l.backend.MessageSafetyAt(ctx, id, payload, finalizedTimestamp)
+	l.logCall("MessageSafetyAt", start, err, append(messageContext(id, payload), "finalized_timestamp", finalizedTimestamp, "label", label)...)
+	return label, err
+}
+
+func (l *LoggingBackend) MessageIntegrity(ctx context.Context, id MessageIdentifier, payload hexutil.Bytes) (bool, error) {
+	start := time.Now()
+	valid, err := l.backend.MessageIntegrity(ctx, id, payload)
+	l.logCall("MessageIntegrity", start, err, append(messageContext(id, payload), "valid", valid)...)
+	return valid, err
+}
+
+func (l *LoggingBackend) MessageSafetyFromLog(ctx context.Context, id MessageIdentifier, payload hexutil.Bytes, log *types.Log, blockTime uint64) (MessageSafetyLabel, error) {
+	start := time.Now()
+	label, err := l.backend.MessageSafetyFromLog(ctx, id, payload, log, blockTime)
+	l.logCall("MessageSafetyFromLog", start, err, append(messageContext(id, payload), "block_time", blockTime, "label", label)...)
+	return label, err
+}
+
+func (l *LoggingBackend) WatchFinalized(id MessageIdentifier, fn func(head eth.L1BlockRef)) (func(), error) {
+	start := time.Now()
+	cancel, err := l.backend.WatchFinalized(id, fn)
+	l.logCall("WatchFinalized", start, err, "chain_id", id.ChainId, "block_number", id.BlockNumber, "timestamp", id.Timestamp)
+	return cancel, err
+}
+
+// MessageSafetyVia logs the call without the endpoint, as it may carry credentials.
+func (l *LoggingBackend) MessageSafetyVia(ctx context.Context, id MessageIdentifier, payload hexutil.Bytes, endpoint string) (MessageSafetyLabel, error) {
+	start := time.Now()
+	label, err := l.backend.MessageSafetyVia(ctx, id, payload, endpoint)
+	l.logCall("MessageSafetyVia", start, err, append(messageContext(id, payload), "label", label)...)
+	return label, err
+}
+
+func (l *LoggingBackend) MessageSafetyByTx(ctx context.Context, id TxMessageIdentifier, payload hexutil.Bytes) (MessageSafetyLabel, error) {
+	start := time.Now()
+	label, err := l.backend.MessageSafetyByTx(ctx, id, payload)
+	l.logCall("MessageSafetyByTx", start, err, "chain_id", id.ChainId, "tx_hash", id.TxHash, "tx_log_index", id.TxLogIndex,
+		"origin", id.Origin, "timestamp", id.Timestamp, "payload_hash", crypto.Keccak256Hash(payload), "label", label)
+	return label, err
+}
+
+func (l *LoggingBackend) MessageSafetyExplain(ctx context.Context, id MessageIdentifier, payload hexutil.Bytes) (*ValidationTrace, error) {
+	start := time.Now()
+	trace, err := l.backend.MessageSafetyExplain(ctx, id, payload)
+	var label MessageSafetyLabel
+	if trace != nil {
+		label = trace.Result.Label
+	}
+	l.logCall("MessageSafetyExplain", start, err, append(messageContext(id, payload), "label", label)...)
+	return trace, err
+}
+
+func (l *LoggingBackend) Prefetch(ctx context.Context, chainId ChainID, fromBlock, toBlock *big.Int) error {
+	start := time.Now()
+	err := l.backend.Prefetch(ctx, chainId, fromBlock, toBlock)
+	l.logCall("Prefetch", start, err, "chain_id", chainId, "from_block", fromBlock, "to_block", toBlock)
+	return err
+}
+
+func (l *LoggingBackend) FinalizingHead(ctx context.Context, chainId ChainID, timestamp uint64) (eth.L1BlockRef, error) {
+	start := time.Now()
+	head, err := l.backend.FinalizingHead(ctx, chainId, timestamp)
+	l.logCall("FinalizingHead", start, err, "chain_id", chainId, "timestamp", timestamp, "head", head.ID())
+	return head, err
+}
+
+func (l *LoggingBackend) DependencySet() []ChainID {
+	start := time.Now()
+	chainIds := l.backend.DependencySet()
+	l.logCall("DependencySet", start, nil, "chain_ids", chainIds)
+	return chainIds
+}
+
+func (l *LoggingBackend) RefreshFinalizedHead(ctx context.Context, chainId ChainID) error {
+	start := time.Now()
+	err := l.backend.RefreshFinalizedHead(ctx, chainId)
+	l.logCall("RefreshFinalizedHead", start, err, "chain_id", chainId)
+	return err
+}
+
+func (l *LoggingBackend) TrackedHeads() map[ChainID]HeadSnapshot {
+	start := time.Now()
+	heads := l.backend.TrackedHeads()
+	l.logCall("TrackedHeads", start, nil, "chains", len(heads))
+	return heads
+}
+
+func (l *LoggingBackend) HealthCheck(ctx context.Context) error {
+	start := time.Now()
+	err := l.backend.HealthCheck(ctx)
+	l.logCall("HealthCheck", start, err)
+	return err
+}
+
+func (l *LoggingBackend) MetricsRegistry() *prometheus.Registry {
+	start := time.Now()
+	registry := l.backend.MetricsRegistry()
+	l.logCall("MetricsRegistry", start, nil)
+	return registry
+}
+
+func (l *LoggingBackend) MetricsHandler() http.Handler {
+	start := time.Now()
+	handler := l.backend.MetricsHandler()
+	l.logCall("MetricsHandler", start, nil)
+	return handler
+}
+
+func (l *LoggingBackend) SetConservativeMode(enabled bool) {
+	start := time.Now()
+	l.backend.SetConservativeMode(enabled)
+	l.logCall("SetConservativeMode", start, nil, "enabled", enabled)
+}
+
+func (l *LoggingBackend) Close() error {
+	start := time.Now()
+	err := l.backend.Close()
+	l.logCall("Close", start, err)
+	return err
+}
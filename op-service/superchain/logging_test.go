@@ -0,0 +1,60 @@
+package superchain
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/ethereum-optimism/optimism/op-service/testlog"
+)
+
+func TestLoggingBackend(t *testing.T) {
+	fake := NewFakeBackend()
+	finalized := MessageIdentifier{Origin: common.Address{0xaa}, BlockNumber: big.NewInt(10), ChainId: big.NewInt(900)}
+	failing := MessageIdentifier{Origin: common.Address{0xaa}, BlockNumber: big.NewInt(11), ChainId: big.NewInt(900)}
+	fake.SetMessageSafety(finalized, Finalized)
+	fake.SetMessageError(failing, errors.New("peer unavailable"))
+	logger, logs := testlog.CaptureLogger(t, log.LevelInfo)
+	b := NewLoggingBackend(fake, logger)
+
+	payload := hexutil.Bytes{0x01, 0x02}
+	label, err := b.MessageSafety(context.Background(), finalized, payload)
+	require.NoError(t, err)
+	require.Equal(t, Finalized, label)
+	require.Equal(t, []MessageIdentifier{finalized}, fake.Queried())
+	record := logs.FindLog(testlog.NewLevelFilter(log.LevelInfo), testlog.NewAttributesFilter("method", "MessageSafety"))
+	require.NotNil(t, record)
+	require.Equal(t, finalized.BlockNumber, record.AttrValue("block_number"))
+	require.Equal(t, crypto.Keccak256Hash(payload), record.AttrValue("payload_hash"))
+	require.Equal(t, Finalized, record.AttrValue("label"))
+	require.NotNil(t, record.AttrValue("duration"))
+
+	logs.Clear()
+	label, err = b.MessageSafety(context.Background(), failing, payload)
+	require.ErrorContains(t, err, "peer unavailable")
+	require.Equal(t, Invalid, label)
+	record = logs.FindLog(testlog.NewLevelFilter(log.LevelWarn), testlog.NewMessageFilter("superchain backend call failed"))
+	require.NotNil(t, record)
+	require.Equal(t, Invalid, record.AttrValue("label"))
+	require.ErrorContains(t, record.AttrValue("err").(error), "peer unavailable")
+
+	labels, err := b.MessageSafetyBatch(context.Background(), []MessageIdentifier{finalized}, []hexutil.Bytes{payload})
+	require.NoError(t, err)
+	require.Equal(t, []MessageSafetyLabel{Finalized}, labels)
+	require.NotNil(t, logs.FindLog(testlog.NewAttributesFilter("method", "MessageSafetyBatch")))
+
+	fake.SetDependencySet(ChainIDFromUInt64(900))
+	require.Equal(t, []ChainID{ChainIDFromUInt64(900)}, b.DependencySet())
+	require.NoError(t, b.Close())
+	require.NotNil(t, logs.FindLog(testlog.NewAttributesFilter("method", "Close")))
+	_, err = b.MessageSafety(context.Background(), finalized, payload)
+	require.ErrorIs(t, err, ErrBackendClosed)
+}
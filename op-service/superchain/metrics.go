@@ -0,0 +1,157 @@
+package superchain
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+	"github.com/ethereum-optimism/optimism/op-service/metrics"
+)
+
+// Metrics tracks the outcomes of the message safety checks, and the latency of the requests to the peers.
+type Metrics struct {
+	MessageSafetyTotal      *prometheus.CounterVec
+	FetchDurationSeconds    *prometheus.HistogramVec
+	BreakerTransitionsTotal *prometheus.CounterVec
+	FinalizedHeadLagSeconds *prometheus.GaugeVec
+	ConservativeMode        prometheus.Gauge
+	ConservativeCapsTotal   *prometheus.CounterVec
+	HeadUpdateTimestamp     *prometheus.GaugeVec
+	HeadPollsMissedTotal    *prometheus.CounterVec
+}
+
+func NewMetrics(factory metrics.Factory) *Metrics {
+	return &Metrics{
+		MessageSafetyTotal: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "message_safety_total",
+			Help:      "Number of message safety checks, by chain id of the message and returned label",
+		}, []string{
+			"chain_id",
+			"label",
+		}),
+		FetchDurationSeconds: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "fetch_duration_seconds",
+			Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+			Help:      "Histogram of the durations of the batched block and logs requests to the peers",
+		}, []string{
+			"chain_id",
+		}),
+		BreakerTransitionsTotal: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "breaker_transitions_total",
+			Help:      "Number of transitions of the circuit breakers of the peers, by chain id of the peer and new state",
+		}, []string{
+			"chain_id",
+			"state",
+		}),
+		FinalizedHeadLagSeconds: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "finalized_head_lag_seconds",
+			Help:      "Seconds between the current time and the timestamp of the tracked finalized head, by chain id of the peer, as of the last poll",
+		}, []string{
+			"chain_id",
+		}),
+		ConservativeMode: factory.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "conservative_mode",
+			Help:      "1 if the messages are labeled at most unsafe by the conservative mode, 0 otherwise",
+		}),
+		ConservativeCapsTotal: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "conservative_caps_total",
+			Help:      "Number of message labels capped at unsafe by the conservative mode, by chain id of the message and capped label",
+		}, []string{
+			"chain_id",
+			"label",
+		}),
+		HeadUpdateTimestamp: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "head_update_timestamp_seconds",
+			Help:      "Unix time of the last polled head handled, by chain id of the peer and head label. The time since the last update is the current time minus the value",
+		}, []string{
+			"chain_id",
+			"label",
+		}),
+		HeadPollsMissedTotal: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "head_polls_missed_total",
+			Help:      "Number of polls of the heads that were due while the previous polled head was still handled, and were delayed or dropped, by chain id of the peer and head label",
+		}, []string{
+			"chain_id",
+			"label",
+		}),
+	}
+}
+
+func (m *Metrics) RecordMessageSafety(chainId string, label MessageSafetyLabel) {
+	m.MessageSafetyTotal.WithLabelValues(chainId, string(label)).Inc()
+}
+
+// RecordFetch starts timing a request to the peer, the returned function records the duration.
+func (m *Metrics) RecordFetch(chainId string) func() {
+	timer := prometheus.NewTimer(m.FetchDurationSeconds.WithLabelValues(chainId))
+	return func() {
+		timer.ObserveDuration()
+	}
+}
+
+func (m *Metrics) RecordBreakerTransition(chainId string, state breakerState) {
+	m.BreakerTransitionsTotal.WithLabelValues(chainId, string(state)).Inc()
+}
+
+func (m *Metrics) RecordFinalizedHeadLag(chainId string, lag time.Duration) {
+	m.FinalizedHeadLagSeconds.WithLabelValues(chainId).Set(lag.Seconds())
+}
+
+func (m *Metrics) RecordConservativeMode(enabled bool) {
+	if enabled {
+		m.ConservativeMode.Set(1)
+	} else {
+		m.ConservativeMode.Set(0)
+	}
+}
+
+func (m *Metrics) RecordConservativeCap(chainId string, label MessageSafetyLabel) {
+	m.ConservativeCapsTotal.WithLabelValues(chainId, string(label)).Inc()
+}
+
+// RecordHeadUpdate records a polled head handled at the time, with the number of polls missed while handling it.
+func (m *Metrics) RecordHeadUpdate(chainId string, label eth.BlockLabel, at time.Time, missed int) {
+	m.HeadUpdateTimestamp.WithLabelValues(chainId, string(label)).Set(float64(at.Unix()))
+	if missed > 0 {
+		m.HeadPollsMissedTotal.WithLabelValues(chainId, string(label)).Add(float64(missed))
+	}
+}
+
+// unknownChainIdLabel is the metric label of the chain ids of messages that are not configured peer chains.
+const unknownChainIdLabel = "unknown"
+
+// chainIdLabel returns the metric label of the chain id of a message. Chain ids are chosen by the callers of the
+// backend, e.g. the clients of the RPC server, so only the chain ids of the configured peers are labeled as is,
+// bounding the number of series.
+func (b *backend) chainIdLabel(id MessageIdentifier) string {
+	if chainId, ok := ChainIDFromBig(id.ChainId); ok {
+		if _, ok := b.l2PeerNodes[chainId]; ok {
+			return chainId.String()
+		}
+	}
+	return unknownChainIdLabel
+}
+
+func (b *backend) MetricsRegistry() *prometheus.Registry {
+	return b.registry
+}
+
+// MetricsHandler serves the metrics of the registry of the backend, recording the requests to the handler like the
+// metrics server of op-service.
+func (b *backend) MetricsHandler() http.Handler {
+	if b.registry == nil {
+		return http.NotFoundHandler()
+	}
+	return promhttp.InstrumentMetricHandler(b.registry, promhttp.HandlerFor(b.registry, promhttp.HandlerOpts{}))
+}
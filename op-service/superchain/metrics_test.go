@@ -0,0 +1,147 @@
+package superchain
+
+import (
+	"context"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/ethereum-optimism/optimism/op-service/client"
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+	"github.com/ethereum-optimism/optimism/op-service/metrics"
+	"github.com/ethereum-optimism/optimism/op-service/testlog"
+)
+
+func TestMessageSafetyMetrics(t *testing.T) {
+	peer := newStubRPC()
+	peer.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x01}))
+	registry := prometheus.NewRegistry()
+	b := newTestBackend(t, map[ChainID]client.RPC{ChainIDFromUInt64(900): peer})
+	b.metrics = NewMetrics(metrics.With(registry))
+	setHeads(b, ChainIDFromUInt64(900), &eth.L1BlockRef{Time: 100}, nil, nil)
+
+	id, payload := testMessage(900, peer, 10, 0)
+	_, err := b.MessageSafety(context.Background(), id, payload)
+	require.NoError(t, err)
+	_, err = b.MessageSafety(context.Background(), id, hexutil.Bytes{0xff})
+	require.Error(t, err)
+	unknown := id
+	unknown.ChainId = big.NewInt(901)
+	_, err = b.MessageSafety(context.Background(), unknown, payload)
+	require.Error(t, err)
+	_, err = b.MessageSafetyBatch(context.Background(), []MessageIdentifier{id, unknown}, []hexutil.Bytes{payload, payload})
+	require.ErrorContains(t, err, "message 1: peer with chain id 901 is not configured")
+
+	require.Equal(t, 2.0, testutil.ToFloat64(b.metrics.MessageSafetyTotal.WithLabelValues("900", string(Finalized))))
+	require.Equal(t, 1.0, testutil.ToFloat64(b.metrics.MessageSafetyTotal.WithLabelValues("900", string(Invalid))))
+	// the chain ids of unconfigured peers do not create series
+	require.Equal(t, 2.0, testutil.ToFloat64(b.metrics.MessageSafetyTotal.WithLabelValues(unknownChainIdLabel, string(Invalid))))
+	require.Zero(t, testutil.ToFloat64(b.metrics.MessageSafetyTotal.WithLabelValues("901", string(Invalid))))
+
+	// the finalized message of the batch is served from the cache
+	count, err := testutil.GatherAndCount(registry, metricsNamespace+"_fetch_duration_seconds")
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+	require.Equal(t, 2, peer.batchCalls)
+}
+
+func TestFinalizedHeadLagMetric(t *testing.T) {
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: newStubRPC()})
+	b.metrics = NewMetrics(metrics.With(prometheus.NewRegistry()))
+	now := time.Unix(1000, 0)
+	b.now = func() time.Time { return now }
+	lag := func() float64 {
+		return testutil.ToFloat64(b.metrics.FinalizedHeadLagSeconds.WithLabelValues("900"))
+	}
+
+	head := eth.L1BlockRef{Hash: common.Hash{10}, Number: 10, Time: 900}
+	b.onFinalizedHead(context.Background(), chainId, head)
+	require.Equal(t, 100.0, lag())
+
+	// an unchanged head keeps lagging further behind
+	now = now.Add(12 * time.Second)
+	b.onFinalizedHead(context.Background(), chainId, head)
+	require.Equal(t, 112.0, lag())
+
+	b.onFinalizedHead(context.Background(), chainId, eth.L1BlockRef{Hash: common.Hash{11}, Number: 11, ParentHash: common.Hash{10}, Time: 1010})
+	require.Equal(t, 2.0, lag())
+}
+
+func TestHeadPollMetrics(t *testing.T) {
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: newStubRPC()})
+	b.metrics = NewMetrics(metrics.With(prometheus.NewRegistry()))
+	now := time.Unix(1000, 0)
+	b.now = func() time.Time { return now }
+	missed := func() float64 {
+		return testutil.ToFloat64(b.metrics.HeadPollsMissedTotal.WithLabelValues("900", string(eth.Finalized)))
+	}
+	updated := func() float64 {
+		return testutil.ToFloat64(b.metrics.HeadUpdateTimestamp.WithLabelValues("900", string(eth.Finalized)))
+	}
+
+	signal := b.timedHeadSignal(chainId, eth.Finalized, 12*time.Second, func(ctx context.Context, sig eth.L1BlockRef) {
+		b.onFinalizedHead(ctx, chainId, sig)
+	})
+	signal(context.Background(), eth.L1BlockRef{Hash: common.Hash{10}, Number: 10, Time: 900})
+	require.Equal(t, 1000.0, updated())
+	require.Zero(t, missed())
+
+	// the handler is slow, e.g. waiting for the lock held by a long message check, and the next polls are missed
+	slow := b.timedHeadSignal(chainId, eth.Finalized, 12*time.Second, func(ctx context.Context, sig eth.L1BlockRef) {
+		now = now.Add(30 * time.Second)
+		b.onFinalizedHead(ctx, chainId, sig)
+	})
+	slow(context.Background(), eth.L1BlockRef{Hash: common.Hash{11}, Number: 11, ParentHash: common.Hash{10}, Time: 1010})
+	require.Equal(t, 1030.0, updated())
+	require.Equal(t, 2.0, missed())
+	require.Equal(t, uint64(11), b.TrackedHeads()[chainId].Finalized.Number)
+}
+
+func TestMetricsRegistry(t *testing.T) {
+	l2Node := newChainIdServer(t, 10)
+	peers := map[ChainID]string{ChainIDFromUInt64(900): newChainIdServer(t, 900)}
+	logger := testlog.Logger(t, log.LevelInfo)
+
+	b, err := NewSuperchainBackend(context.Background(), logger, nil, NewSuperchainConfig(l2Node, peers))
+	require.NoError(t, err)
+	defer b.Close()
+	unknown := MessageIdentifier{Origin: common.Address{0xaa}, BlockNumber: big.NewInt(10), Timestamp: 100, ChainId: big.NewInt(901)}
+	_, err = b.MessageSafety(context.Background(), unknown, hexutil.Bytes{0x01})
+	require.ErrorIs(t, err, ErrPeerNotConfigured)
+
+	families, err := b.MetricsRegistry().Gather()
+	require.NoError(t, err)
+	names := make(map[string]bool)
+	for _, family := range families {
+		names[family.GetName()] = true
+	}
+	require.True(t, names[metricsNamespace+"_message_safety_total"])
+	require.True(t, names[metricsNamespace+"_conservative_mode"])
+	require.True(t, names["go_goroutines"], "the Go metrics of a standalone backend are registered")
+
+	rec := httptest.NewRecorder()
+	b.MetricsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), metricsNamespace+`_message_safety_total{chain_id="unknown",label="invalid"} 1`)
+
+	// the metrics recorded with the factory of the caller are served by the caller
+	withFactory, err := NewSuperchainBackend(context.Background(), logger, metrics.With(prometheus.NewRegistry()), NewSuperchainConfig(l2Node, peers))
+	require.NoError(t, err)
+	defer withFactory.Close()
+	require.Nil(t, withFactory.MetricsRegistry())
+	rec = httptest.NewRecorder()
+	withFactory.MetricsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,314 @@
+package superchain
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/gorilla/websocket"
+
+	"github.com/ethereum-optimism/optimism/op-service/client"
+)
+
+type dialFn func(ctx context.Context, addr string) (client.RPC, error)
+
+// redactedHeaderValue replaces the values of HTTP headers in logs, as headers may carry credentials.
+const redactedHeaderValue = "<redacted>"
+
+// newPeerDialer returns a dialer of a peer, attaching the HTTP headers to every request of the peer, and
+// securing the HTTPS and WSS connections with the TLS config if non-nil.
+func newPeerDialer(log log.Logger, headers http.Header, tlsConfig *tls.Config) dialFn {
+	return func(ctx context.Context, addr string) (client.RPC, error) {
+		opts := []client.RPCOption{client.WithDialBackoff(10)}
+		if len(headers) > 0 {
+			opts = append(opts, client.WithGethRPCOptions(rpc.WithHeaders(headers)))
+		}
+		if tlsConfig != nil {
+			transport := http.DefaultTransport.(*http.Transport).Clone()
+			transport.TLSClientConfig = tlsConfig
+			// The buffer sizes and proxy of the default websocket dialer of geth
+			wsDialer := websocket.Dialer{ReadBufferSize: 1024, WriteBufferSize: 1024, Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig}
+			opts = append(opts, client.WithGethRPCOptions(rpc.WithHTTPClient(&http.Client{Transport: transport}), rpc.WithWebsocketDialer(wsDialer)))
+		}
+		return client.NewRPC(ctx, log, addr, opts...)
+	}
+}
+
+// pooledDialer returns a dialer dialing a pool of the given size of connections to the address, or dial if the
+// pool holds a single connection.
+func pooledDialer(dial dialFn, size int) dialFn {
+	if size <= 1 {
+		return dial
+	}
+	return func(ctx context.Context, addr string) (client.RPC, error) {
+		conns := make([]client.RPC, 0, size)
+		for i := 0; i < size; i++ {
+			rpc, err := dial(ctx, addr)
+			if err != nil {
+				for _, conn := range conns {
+					conn.Close()
+				}
+				return nil, fmt.Errorf("connection %d of pool: %w", i, err)
+			}
+			conns = append(conns, rpc)
+		}
+		return &pooledRPC{conns: conns}, nil
+	}
+}
+
+// pooledRPC round-robins the requests across a pool of connections to the same address, so that the requests of
+// concurrent message checks are spread over the connections instead of queuing on a single connection.
+type pooledRPC struct {
+	conns []client.RPC
+	next  atomic.Uint64
+}
+
+var _ client.RPC = (*pooledRPC)(nil)
+
+func (p *pooledRPC) conn() client.RPC {
+	return p.conns[(p.next.Add(1)-1)%uint64(len(p.conns))]
+}
+
+func (p *pooledRPC) Close() {
+	for _, conn := range p.conns {
+		conn.Close()
+	}
+}
+
+func (p *pooledRPC) CallContext(ctx context.Context, result any, method string, args ...any) error {
+	return p.conn().CallContext(ctx, result, method, args...)
+}
+
+func (p *pooledRPC) BatchCallContext(ctx context.Context, b []rpc.BatchElem) error {
+	return p.conn().BatchCallContext(ctx, b)
+}
+
+func (p *pooledRPC) EthSubscribe(ctx context.Context, channel any, args ...any) (ethereum.Subscription, error) {
+	return p.conn().EthSubscribe(ctx, channel, args...)
+}
+
+// redactHeaders returns the headers to log, with all values redacted.
+func redactHeaders(headers http.Header) map[string]string {
+	redacted := make(map[string]string, len(headers))
+	for name := range headers {
+		redacted[name] = redactedHeaderValue
+	}
+	return redacted
+}
+
+// sharedConns shares the connections of the peers dialed at the same address with the same HTTP headers, TLS config
+// and connection pool size, e.g. a gateway configured for several chain ids, so that the address is dialed once.
+// The requests of the peers sharing a connection cannot select a chain, and are all served by the same chain: the
+// chain id is verified per peer. Only the initial connections of the peers are shared: a broken connection is
+// re-dialed by every peer for itself.
+type sharedConns struct {
+	log   log.Logger
+	conns map[sharedConnKey]*sharedConn
+}
+
+type sharedConnKey struct {
+	addr     string
+	headers  string
+	tls      *tls.Config
+	poolSize int
+}
+
+// sharedConn is a connection shared by the peers holding a handle, closed once all the handles are closed.
+type sharedConn struct {
+	rpc  client.RPC
+	refs atomic.Int32
+}
+
+func newSharedConns(log log.Logger) *sharedConns {
+	return &sharedConns{log: log, conns: make(map[sharedConnKey]*sharedConn)}
+}
+
+// dialer returns a dialer of a peer with the HTTP headers, TLS config and connection pool size, returning a handle of
+// the connection to the address if already dialed, and dialing the address with dial otherwise. TLS configs are
+// compared by identity. The dialer must not be used concurrently.
+func (s *sharedConns) dialer(headers http.Header, tlsConfig *tls.Config, poolSize int, dial dialFn) dialFn {
+	var encoded strings.Builder
+	// Header.Write sorts the headers, the encoding is canonical
+	_ = headers.Write(&encoded)
+	return func(ctx context.Context, addr string) (client.RPC, error) {
+		key := sharedConnKey{addr: addr, headers: encoded.String(), tls: tlsConfig, poolSize: poolSize}
+		conn, ok := s.conns[key]
+		if ok {
+			s.log.Info("sharing peer connection", "addr", addr)
+		} else {
+			rpc, err := dial(ctx, addr)
+			if err != nil {
+				return nil, err
+			}
+			conn = &sharedConn{rpc: rpc}
+			s.conns[key] = conn
+		}
+		conn.refs.Add(1)
+		return &sharedRPC{RPC: conn.rpc, conn: conn}, nil
+	}
+}
+
+// sharedRPC is a handle of a shared connection. Closing the handle closes the connection once no other handle
+// of the connection remains open.
+type sharedRPC struct {
+	client.RPC
+	conn *sharedConn
+	once sync.Once
+}
+
+func (s *sharedRPC) Close() {
+	s.once.Do(func() {
+		if s.conn.refs.Add(-1) == 0 {
+			s.conn.rpc.Close()
+		}
+	})
+}
+
+// peer is the connection to the L2 node of a peer chain. The addresses of the endpoints of the node are kept
+// alongside the client, to re-dial the node when the connection breaks. The first address is the primary
+// endpoint, and every broken connection fails over to the next endpoint, round-robin.
+// The peer itself is a client.RPC, serving requests with the current connection.
+type peer struct {
+	chainId ChainID
+	addrs   []string
+	dial    dialFn
+	// breaker of the message requests to the peer, nil if disabled
+	breaker *circuitBreaker
+	// whether the logs of blocks are fetched from the block receipts, instead of with eth_getLogs
+	blockReceipts bool
+	// custom fetcher of the blocks of the peer, nil if the blocks are fetched with RPC
+	logFetcher LogFetcher
+
+	mu sync.Mutex
+	// index of the address of the current connection
+	current int
+	rpc     client.RPC
+	closed  bool
+}
+
+var _ client.RPC = (*peer)(nil)
+
+func newPeer(chainId ChainID, addrs []string, rpc client.RPC, dial dialFn) *peer {
+	return &peer{chainId: chainId, addrs: addrs, rpc: rpc, dial: dial}
+}
+
+// dialPeer dials the endpoints of the peer in order, and returns the peer connected to the first endpoint
+// that could be dialed.
+func dialPeer(ctx context.Context, chainId ChainID, addrs []string, dial dialFn) (*peer, error) {
+	var errs []error
+	for i, addr := range addrs {
+		rpc, err := dial(ctx, addr)
+		if err == nil {
+			p := newPeer(chainId, addrs, rpc, dial)
+			p.current = i
+			return p, nil
+		}
+		errs = append(errs, fmt.Errorf("endpoint %d: %w", i, err))
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (p *peer) client() client.RPC {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.rpc
+}
+
+// endpoints returns the number of endpoints of the peer.
+func (p *peer) endpoints() int {
+	return max(len(p.addrs), 1)
+}
+
+// reconnect re-dials the peer, replacing the broken client with a connection to the next endpoint that can
+// be dialed. A peer with a single endpoint re-dials the same endpoint. If the client was already replaced by
+// a concurrent reconnect, the replacement is returned. The endpoints are dialed without holding the lock of the
+// peer, so requests with the current client are not blocked by the dial backoff, and the new connection is closed
+// if the client was replaced meanwhile.
+func (p *peer) reconnect(ctx context.Context, broken client.RPC) (client.RPC, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrBackendClosed
+	}
+	if p.rpc != broken {
+		replaced := p.rpc
+		p.mu.Unlock()
+		return replaced, nil
+	}
+	current := p.current
+	p.mu.Unlock()
+	if p.dial == nil || len(p.addrs) == 0 {
+		return nil, errors.New("peer cannot be re-dialed")
+	}
+	var errs []error
+	for i := 1; i <= len(p.addrs); i++ {
+		next := (current + i) % len(p.addrs)
+		rpc, err := p.dial(ctx, p.addrs[next])
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return p.replace(broken, rpc, next)
+	}
+	return nil, fmt.Errorf("failed to re-dial peer with chain id %s: %w", p.chainId, errors.Join(errs...))
+}
+
+// replace replaces the broken client with the new connection to the endpoint with the given index, unless the
+// peer was closed or the client was already replaced, in which case the new connection is closed.
+func (p *peer) replace(broken, rpc client.RPC, index int) (client.RPC, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		rpc.Close()
+		return nil, ErrBackendClosed
+	}
+	if p.rpc != broken {
+		rpc.Close()
+		return p.rpc, nil
+	}
+	p.rpc.Close()
+	p.rpc = rpc
+	p.current = index
+	return rpc, nil
+}
+
+// Close closes the current connection, and prevents the peer from being re-dialed.
+func (p *peer) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.closed {
+		p.closed = true
+		p.rpc.Close()
+	}
+}
+
+func (p *peer) CallContext(ctx context.Context, result any, method string, args ...any) error {
+	return p.client().CallContext(ctx, result, method, args...)
+}
+
+func (p *peer) BatchCallContext(ctx context.Context, b []rpc.BatchElem) error {
+	return p.client().BatchCallContext(ctx, b)
+}
+
+func (p *peer) EthSubscribe(ctx context.Context, channel any, args ...any) (ethereum.Subscription, error) {
+	return p.client().EthSubscribe(ctx, channel, args...)
+}
+
+// isConnectionError returns true if the request failed before the peer could respond,
+// as opposed to an error response of the peer or the deadline of the request.
+func isConnectionError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var rpcErr rpc.Error
+	var httpErr rpc.HTTPError
+	return !errors.As(err, &rpcErr) && !errors.As(err, &httpErr)
+}
@@ -0,0 +1,391 @@
+package superchain
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"github.com/ethereum-optimism/optimism/op-service/client"
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+	"github.com/ethereum-optimism/optimism/op-service/testlog"
+)
+
+type testRPCError struct{}
+
+func (testRPCError) Error() string  { return "internal error" }
+func (testRPCError) ErrorCode() int { return -32603 }
+
+var _ rpc.Error = testRPCError{}
+
+func TestPeerReconnect(t *testing.T) {
+	chainId := ChainIDFromUInt64(900)
+	broken := newStubRPC()
+	broken.err = errors.New("connection reset by peer")
+	restarted := newStubRPC()
+	restarted.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x01}))
+
+	b := newTestBackend(t, nil)
+	dials := 0
+	b.l2PeerNodes = map[ChainID]*peer{chainId: newPeer(chainId, []string{"ws://peer"}, broken, func(ctx context.Context, addr string) (client.RPC, error) {
+		require.Equal(t, "ws://peer", addr)
+		dials++
+		return restarted, nil
+	})}
+	setHeads(b, ChainIDFromUInt64(900), &eth.L1BlockRef{Time: 100}, nil, nil)
+
+	id, payload := testMessage(900, restarted, 10, 0)
+	label, err := b.MessageSafety(context.Background(), id, payload)
+	require.NoError(t, err)
+	require.Equal(t, Finalized, label)
+	require.Equal(t, 1, dials)
+	require.Equal(t, 1, broken.closed)
+
+	// subsequent requests use the new connection
+	id.LogIndex = 1
+	_, err = b.MessageSafety(context.Background(), id, payload)
+	require.ErrorContains(t, err, "invalid log index")
+	require.Equal(t, 1, broken.batchCalls)
+	require.Equal(t, 2, restarted.batchCalls)
+	require.Equal(t, 1, dials)
+}
+
+func TestPeerReconnectFailure(t *testing.T) {
+	chainId := ChainIDFromUInt64(900)
+	broken := newStubRPC()
+	broken.err = errors.New("connection refused")
+	b := newTestBackend(t, nil)
+	b.l2PeerNodes = map[ChainID]*peer{chainId: newPeer(chainId, []string{"ws://peer"}, broken, func(ctx context.Context, addr string) (client.RPC, error) {
+		return nil, errors.New("still down")
+	})}
+
+	other := newStubRPC()
+	other.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x01}))
+	id, payload := testMessage(900, other, 10, 0)
+	_, err := b.MessageSafety(context.Background(), id, payload)
+	require.ErrorContains(t, err, "connection refused")
+	require.Equal(t, 0, broken.closed)
+}
+
+func TestPeerReconnectConcurrent(t *testing.T) {
+	chainId := ChainIDFromUInt64(900)
+	broken := newStubRPC()
+	first, second := newStubRPC(), newStubRPC()
+	var peerNode *peer
+	dials := 0
+	peerNode = newPeer(chainId, []string{"ws://peer"}, broken, func(ctx context.Context, addr string) (client.RPC, error) {
+		dials++
+		if dials > 1 {
+			return second, nil
+		}
+		// the peer is not locked while dialing: requests are served, and a concurrent reconnect replaces the client
+		require.Equal(t, broken, peerNode.client())
+		replaced, err := peerNode.reconnect(ctx, broken)
+		require.NoError(t, err)
+		require.Equal(t, second, replaced)
+		return first, nil
+	})
+
+	rpc, err := peerNode.reconnect(context.Background(), broken)
+	require.NoError(t, err)
+	require.Equal(t, second, rpc)
+	require.Equal(t, second, peerNode.client())
+	require.Equal(t, 2, dials)
+	require.Equal(t, 1, broken.closed)
+	// the connection of the reconnect that lost the race is closed
+	require.Equal(t, 1, first.closed)
+	require.Equal(t, 0, second.closed)
+}
+
+func TestPeerFailover(t *testing.T) {
+	chainId := ChainIDFromUInt64(900)
+	primary := newStubRPC()
+	primary.err = errors.New("connection refused")
+	secondary := newStubRPC()
+	secondary.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x01}))
+
+	b := newTestBackend(t, nil)
+	var dialed []string
+	peerNode, err := dialPeer(context.Background(), chainId, []string{"ws://primary", "ws://secondary"}, func(ctx context.Context, addr string) (client.RPC, error) {
+		dialed = append(dialed, addr)
+		if addr == "ws://primary" {
+			return primary, nil
+		}
+		return secondary, nil
+	})
+	require.NoError(t, err)
+	b.l2PeerNodes = map[ChainID]*peer{chainId: peerNode}
+	setHeads(b, chainId, &eth.L1BlockRef{Time: 100}, nil, nil)
+
+	id, payload := testMessage(900, secondary, 10, 0)
+	label, err := b.MessageSafety(context.Background(), id, payload)
+	require.NoError(t, err)
+	require.Equal(t, Finalized, label)
+	require.Equal(t, []string{"ws://primary", "ws://secondary"}, dialed)
+	require.Equal(t, 1, primary.closed)
+
+	// subsequent requests stay on the secondary endpoint
+	id.LogIndex = 1
+	_, err = b.MessageSafety(context.Background(), id, payload)
+	require.ErrorContains(t, err, "invalid log index")
+	require.Equal(t, 1, primary.batchCalls)
+	require.Equal(t, 2, secondary.batchCalls)
+
+	// a broken secondary fails over back to the primary, round-robin
+	_, err = peerNode.reconnect(context.Background(), secondary)
+	require.NoError(t, err)
+	require.Equal(t, primary, peerNode.client())
+}
+
+func TestPeerFailoverAllDown(t *testing.T) {
+	chainId := ChainIDFromUInt64(900)
+	down := newStubRPC()
+	down.err = errors.New("connection refused")
+	b := newTestBackend(t, nil)
+	dials := 0
+	b.l2PeerNodes = map[ChainID]*peer{chainId: newPeer(chainId, []string{"ws://primary", "ws://secondary"}, down, func(ctx context.Context, addr string) (client.RPC, error) {
+		dials++
+		return down, nil
+	})}
+
+	other := newStubRPC()
+	other.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x01}))
+	id, payload := testMessage(900, other, 10, 0)
+	_, err := b.MessageSafety(context.Background(), id, payload)
+	require.ErrorContains(t, err, "connection refused")
+	// the request is retried once on every endpoint
+	require.Equal(t, 2, dials)
+	require.Equal(t, 3, down.batchCalls)
+}
+
+func TestDialPeer(t *testing.T) {
+	chainId := ChainIDFromUInt64(900)
+	up := newStubRPC()
+	peerNode, err := dialPeer(context.Background(), chainId, []string{"ws://primary", "ws://secondary"}, func(ctx context.Context, addr string) (client.RPC, error) {
+		if addr == "ws://primary" {
+			return nil, errors.New("primary down")
+		}
+		return up, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, up, peerNode.client())
+	require.Equal(t, 1, peerNode.current)
+
+	_, err = dialPeer(context.Background(), chainId, []string{"ws://primary"}, func(ctx context.Context, addr string) (client.RPC, error) {
+		return nil, errors.New("primary down")
+	})
+	require.ErrorContains(t, err, "endpoint 0: primary down")
+}
+
+func TestIsConnectionError(t *testing.T) {
+	require.False(t, isConnectionError(nil))
+	require.True(t, isConnectionError(errors.New("connection reset by peer")))
+	require.True(t, isConnectionError(rpc.ErrClientQuit))
+	require.False(t, isConnectionError(fmt.Errorf("request failed: %w", testRPCError{})))
+	require.False(t, isConnectionError(rpc.HTTPError{StatusCode: 429}))
+	require.False(t, isConnectionError(context.DeadlineExceeded))
+}
+
+func TestPeerDialerHeaders(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	srv := rpc.NewServer()
+	require.NoError(t, srv.RegisterName("eth", &chainIdService{chainId: 900}))
+	httpSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case headers <- r.Header.Clone():
+		default:
+		}
+		srv.ServeHTTP(w, r)
+	}))
+	t.Cleanup(httpSrv.Close)
+	t.Cleanup(srv.Stop)
+
+	logger, logs := testlog.CaptureLogger(t, log.LevelInfo)
+	dial := newPeerDialer(logger, http.Header{"Authorization": {"Bearer secret"}}, nil)
+	peerNode, err := dial(context.Background(), httpSrv.URL)
+	require.NoError(t, err)
+	defer peerNode.Close()
+	_, err = fetchChainID(context.Background(), peerNode, time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "Bearer secret", (<-headers).Get("Authorization"))
+	require.Nil(t, logs.FindLog(testlog.NewMessageContainsFilter("secret")))
+}
+
+// newTLSChainIdServer serves the eth_chainId method of a node of the chain over HTTPS and WSS, with a certificate
+// of a test CA, and returns the HTTPS server and the path of the PEM bundle of the CA.
+func newTLSChainIdServer(t *testing.T, chainId uint64) (*httptest.Server, string) {
+	srv := rpc.NewServer()
+	require.NoError(t, srv.RegisterName("eth", &chainIdService{chainId: chainId}))
+	ws := srv.WebsocketHandler([]string{"*"})
+	httpSrv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") == "websocket" {
+			ws.ServeHTTP(w, r)
+			return
+		}
+		srv.ServeHTTP(w, r)
+	}))
+	t.Cleanup(httpSrv.Close)
+	t.Cleanup(srv.Stop)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	bundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: httpSrv.Certificate().Raw})
+	require.NoError(t, os.WriteFile(caFile, bundle, 0o600))
+	return httpSrv, caFile
+}
+
+func TestPeerDialerTLS(t *testing.T) {
+	httpSrv, caFile := newTLSChainIdServer(t, 900)
+	wsAddr := "wss" + strings.TrimPrefix(httpSrv.URL, "https")
+	logger := testlog.Logger(t, log.LevelInfo)
+
+	fetch := func(t *testing.T, tlsConfig *tls.Config, addr string) error {
+		peerNode, err := newPeerDialer(logger, nil, tlsConfig)(context.Background(), addr)
+		if err != nil {
+			return err
+		}
+		defer peerNode.Close()
+		_, err = fetchChainID(context.Background(), peerNode, time.Second)
+		return err
+	}
+
+	t.Run("UnknownCA", func(t *testing.T) {
+		require.ErrorContains(t, fetch(t, nil, httpSrv.URL), "certificate signed by unknown authority")
+	})
+
+	t.Run("CAFile", func(t *testing.T) {
+		tlsConfig, err := PeerTLSConfig{CAFile: caFile}.clientConfig()
+		require.NoError(t, err)
+		require.NoError(t, fetch(t, tlsConfig, httpSrv.URL))
+		require.NoError(t, fetch(t, tlsConfig, wsAddr))
+	})
+
+	t.Run("Config", func(t *testing.T) {
+		roots := x509.NewCertPool()
+		roots.AddCert(httpSrv.Certificate())
+		config := &tls.Config{RootCAs: roots}
+		tlsConfig, err := PeerTLSConfig{Config: config}.clientConfig()
+		require.NoError(t, err)
+		require.NotSame(t, config, tlsConfig)
+		require.NoError(t, fetch(t, tlsConfig, httpSrv.URL))
+	})
+}
+
+func TestPeerTLSConfig(t *testing.T) {
+	tlsConfig, err := PeerTLSConfig{}.clientConfig()
+	require.NoError(t, err)
+	require.Nil(t, tlsConfig)
+
+	// the CA bundle replaces the root CAs of the config
+	_, caFile := newTLSChainIdServer(t, 900)
+	config := &tls.Config{ServerName: "peer", RootCAs: x509.NewCertPool()}
+	tlsConfig, err = PeerTLSConfig{Config: config, CAFile: caFile}.clientConfig()
+	require.NoError(t, err)
+	require.Equal(t, "peer", tlsConfig.ServerName)
+	require.NotSame(t, config.RootCAs, tlsConfig.RootCAs)
+
+	_, err = PeerTLSConfig{CAFile: filepath.Join(t.TempDir(), "missing.pem")}.clientConfig()
+	require.ErrorContains(t, err, "failed to read CA bundle")
+
+	invalid := filepath.Join(t.TempDir(), "invalid.pem")
+	require.NoError(t, os.WriteFile(invalid, []byte("not a certificate"), 0o600))
+	_, err = PeerTLSConfig{CAFile: invalid}.clientConfig()
+	require.ErrorContains(t, err, "no certificates in CA bundle")
+}
+
+func TestPooledDialer(t *testing.T) {
+	var dialed []*stubRPC
+	dial := func(ctx context.Context, addr string) (client.RPC, error) {
+		if addr == "ws://flaky" && len(dialed) == 1 {
+			return nil, errors.New("connection refused")
+		}
+		rpc := newStubRPC()
+		dialed = append(dialed, rpc)
+		return rpc, nil
+	}
+
+	pool, err := pooledDialer(dial, 3)(context.Background(), "ws://peer")
+	require.NoError(t, err)
+	require.Len(t, dialed, 3)
+	// the requests are round-robined across the connections
+	for i := 0; i < 6; i++ {
+		require.NoError(t, pool.BatchCallContext(context.Background(), nil))
+	}
+	for _, conn := range dialed {
+		require.Equal(t, 2, conn.batchCalls)
+	}
+	pool.Close()
+	for _, conn := range dialed {
+		require.Equal(t, 1, conn.closed)
+	}
+
+	// a pool of a single connection is the connection
+	dialed = nil
+	single, err := pooledDialer(dial, 1)(context.Background(), "ws://peer")
+	require.NoError(t, err)
+	require.Same(t, dialed[0], single)
+
+	// the dialed connections are closed if the pool cannot be dialed completely
+	dialed = nil
+	_, err = pooledDialer(dial, 3)(context.Background(), "ws://flaky")
+	require.ErrorContains(t, err, "connection 1 of pool: connection refused")
+	require.Equal(t, 1, dialed[0].closed)
+}
+
+func TestSharedConns(t *testing.T) {
+	var dialed []*stubRPC
+	dial := func(ctx context.Context, addr string) (client.RPC, error) {
+		if addr == "ws://down" {
+			return nil, errors.New("connection refused")
+		}
+		rpc := newStubRPC()
+		dialed = append(dialed, rpc)
+		return rpc, nil
+	}
+	conns := newSharedConns(testlog.Logger(t, log.LevelInfo))
+	auth := http.Header{"Authorization": {"Bearer secret"}}
+
+	first, err := conns.dialer(auth, nil, 1, dial)(context.Background(), "ws://gateway")
+	require.NoError(t, err)
+	second, err := conns.dialer(auth.Clone(), nil, 1, dial)(context.Background(), "ws://gateway")
+	require.NoError(t, err)
+	require.Len(t, dialed, 1)
+	// other addresses, headers, TLS configs and pool sizes are dialed separately
+	_, err = conns.dialer(nil, nil, 1, dial)(context.Background(), "ws://gateway")
+	require.NoError(t, err)
+	_, err = conns.dialer(auth, nil, 1, dial)(context.Background(), "ws://other")
+	require.NoError(t, err)
+	_, err = conns.dialer(auth, &tls.Config{}, 1, dial)(context.Background(), "ws://gateway")
+	require.NoError(t, err)
+	_, err = conns.dialer(auth, nil, 2, dial)(context.Background(), "ws://gateway")
+	require.NoError(t, err)
+	require.Len(t, dialed, 5)
+	_, err = conns.dialer(auth, nil, 1, dial)(context.Background(), "ws://down")
+	require.ErrorContains(t, err, "connection refused")
+
+	// the connection is closed once every handle is closed, each handle counting once
+	first.Close()
+	first.Close()
+	require.Zero(t, dialed[0].closed)
+	second.Close()
+	require.Equal(t, 1, dialed[0].closed)
+}
+
+func TestRedactHeaders(t *testing.T) {
+	redacted := redactHeaders(http.Header{"Authorization": {"Bearer secret"}, "X-Api-Key": {"key"}})
+	require.Equal(t, map[string]string{"Authorization": redactedHeaderValue, "X-Api-Key": redactedHeaderValue}, redacted)
+	require.Empty(t, redactHeaders(nil))
+}
@@ -0,0 +1,38 @@
+package superchain
+
+// SafetyFacts are the facts a valid message of a peer chain is labeled from: the block of the message, the tracked
+// heads of the peer chain, and which heads include the block.
+type SafetyFacts struct {
+	ChainID     ChainID
+	BlockNumber uint64
+	Timestamp   uint64
+	// Heads are the tracked heads of the peer chain, nil if not yet known.
+	Heads HeadSnapshot
+	// IncludedByFinalized and IncludedBySafe report whether the finalized and safe heads include the block, by
+	// timestamp, or by number if finality is compared by block number.
+	IncludedByFinalized bool
+	IncludedBySafe      bool
+	// CrossSafe reports whether the safe heads of all the chains in the dependency set are at or beyond the
+	// timestamp of the message.
+	CrossSafe bool
+}
+
+// SafetyPolicy labels a valid message from the facts of its block. It must return one of Unsafe, Safe, CrossSafe
+// or Finalized: integrity failures are labeled Invalid before the policy is applied. Finalized labels are cached,
+// so a policy must only return Finalized once the label can no longer change.
+type SafetyPolicy func(facts SafetyFacts) MessageSafetyLabel
+
+// DefaultSafetyPolicy labels the message with the highest label of the heads including its block.
+func DefaultSafetyPolicy(facts SafetyFacts) MessageSafetyLabel {
+	switch {
+	case facts.IncludedByFinalized:
+		return Finalized
+	case facts.IncludedBySafe && facts.CrossSafe:
+		return CrossSafe
+	case facts.IncludedBySafe:
+		return Safe
+	default:
+		// The message is included by the unsafe head, or by a block the peer served after the unsafe head was polled
+		return Unsafe
+	}
+}
@@ -0,0 +1,67 @@
+package superchain
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	"github.com/ethereum-optimism/optimism/op-service/client"
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+)
+
+func TestDefaultSafetyPolicy(t *testing.T) {
+	require.Equal(t, Finalized, DefaultSafetyPolicy(SafetyFacts{IncludedByFinalized: true, IncludedBySafe: true, CrossSafe: true}))
+	require.Equal(t, CrossSafe, DefaultSafetyPolicy(SafetyFacts{IncludedBySafe: true, CrossSafe: true}))
+	require.Equal(t, Safe, DefaultSafetyPolicy(SafetyFacts{IncludedBySafe: true}))
+	// later heads of the peer chains do not make an unsafe message cross-safe
+	require.Equal(t, Unsafe, DefaultSafetyPolicy(SafetyFacts{CrossSafe: true}))
+}
+
+func TestMessageSafetyPolicy(t *testing.T) {
+	peer := newStubRPC()
+	peer.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x01}))
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	setHeads(b, chainId, &eth.L1BlockRef{Number: 10, Time: 100}, &eth.L1BlockRef{Number: 11, Time: 110}, &eth.L1BlockRef{Number: 12, Time: 120})
+	var facts []SafetyFacts
+	b.safetyPolicy = func(f SafetyFacts) MessageSafetyLabel {
+		facts = append(facts, f)
+		// finalized messages are not trusted beyond safe
+		if label := DefaultSafetyPolicy(f); label != Finalized {
+			return label
+		}
+		return Safe
+	}
+	id, payload := testMessage(900, peer, 10, 0)
+
+	res, err := b.MessageSafetyDetails(context.Background(), id, payload)
+	require.NoError(t, err)
+	require.Equal(t, Safe, res.Label)
+	// the message is still labeled against the finalized head
+	require.Equal(t, &FinalizedHead{Number: 10, Timestamp: 100}, res.Finalized)
+	require.Len(t, facts, 1)
+	require.Equal(t, SafetyFacts{
+		ChainID:     chainId,
+		BlockNumber: 10,
+		Timestamp:   100,
+		Heads: HeadSnapshot{
+			Unsafe:    &eth.L1BlockRef{Number: 12, Time: 120},
+			Safe:      &eth.L1BlockRef{Number: 11, Time: 110},
+			Finalized: &eth.L1BlockRef{Number: 10, Time: 100},
+		},
+		IncludedByFinalized: true,
+		IncludedBySafe:      true,
+		CrossSafe:           true,
+	}, facts[0])
+
+	// downgraded labels are not cached
+	labels, err := b.MessageSafetyBatch(context.Background(), []MessageIdentifier{id}, []hexutil.Bytes{payload})
+	require.NoError(t, err)
+	require.Equal(t, []MessageSafetyLabel{Safe}, labels)
+	require.Len(t, facts, 2)
+}
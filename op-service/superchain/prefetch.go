@@ -0,0 +1,59 @@
+package superchain
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+)
+
+// Prefetch fetches the blocks of the peer chain from fromBlock up to and including toBlock, with the logs of all
+// origins, and caches them, so that checking the messages of the blocks does not reach the peer. The range is
+// bounded by the size of the block cache. Only the blocks at or below the finalized head of the peer chain are
+// cached, as later blocks can still be reorged out, so the range is clamped to the finalized head.
+func (b *backend) Prefetch(ctx context.Context, chainId ChainID, fromBlock, toBlock *big.Int) error {
+	if b.closed.Load() {
+		return ErrBackendClosed
+	}
+	if fromBlock == nil || toBlock == nil || fromBlock.Sign() < 0 || !toBlock.IsUint64() || fromBlock.Cmp(toBlock) > 0 {
+		return fmt.Errorf("invalid prefetch range [%v, %v]", fromBlock, toBlock)
+	}
+	if size := new(big.Int).Sub(toBlock, fromBlock).Uint64() + 1; size > uint64(b.blockCacheSize) {
+		return fmt.Errorf("prefetch range [%v, %v] of %d blocks exceeds the maximum of %d", fromBlock, toBlock, size, b.blockCacheSize)
+	}
+	peer, ok := b.l2PeerNodes[chainId]
+	if !ok {
+		return &PeerNotConfiguredError{ChainID: chainId}
+	}
+	b.mu.Lock()
+	finalized := copyRef(b.l2Heads[chainId].finalized)
+	b.mu.Unlock()
+	if finalized == nil {
+		return fmt.Errorf("finalized head of peer with chain id %s is unknown", chainId)
+	}
+	from, to := fromBlock.Uint64(), min(toBlock.Uint64(), finalized.Number)
+	if from > to {
+		b.log.Debug("prefetch range is not finalized", "chain_id", chainId, "from", fromBlock, "to", toBlock, "finalized", finalized.Number)
+		return nil
+	}
+
+	queries := make([]blockQuery, 0, to-from+1)
+	for number := from; number <= to; number++ {
+		// queries without origins fetch the logs of all origins
+		queries = append(queries, blockQuery{number: new(big.Int).SetUint64(number)})
+	}
+	blocks, err := b.fetchBlocks(ctx, peer, queries)
+	if err != nil {
+		return fmt.Errorf("failed to prefetch blocks [%d, %d] of chain id %s: %w", from, to, chainId, err)
+	}
+	if err := blocksError(blocks); err != nil {
+		return fmt.Errorf("failed to prefetch blocks [%d, %d] of chain id %s: %w", from, to, chainId, err)
+	}
+	for i := range blocks {
+		if blocks[i].header == nil {
+			return fmt.Errorf("failed to prefetch block %d of chain id %s: block not found", from+uint64(i), chainId)
+		}
+	}
+	b.cacheFinalizedBlocks(chainId, blocks)
+	b.log.Info("prefetched blocks", "chain_id", chainId, "from", from, "to", to)
+	return nil
+}
@@ -0,0 +1,103 @@
+package superchain
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	"github.com/ethereum-optimism/optimism/op-service/client"
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+)
+
+func TestPrefetch(t *testing.T) {
+	originA, originB := common.Address{0xaa}, common.Address{0xbb}
+	peer := newStubRPC()
+	for number := uint64(10); number <= 14; number++ {
+		peer.addBlock(number, 100+2*number, testLog(originB, []byte{byte(number)}), testLog(originA, []byte{byte(number)}))
+	}
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	b.maxBatchSize = 4
+	setHeads(b, chainId, &eth.L1BlockRef{Number: 13, Time: 126}, nil, &eth.L1BlockRef{Number: 14, Time: 128})
+
+	require.NoError(t, b.Prefetch(context.Background(), chainId, big.NewInt(10), big.NewInt(14)))
+	// the headers and logs of the finalized blocks 10 to 13 are fetched with two batch requests
+	require.Equal(t, 2, peer.batchCalls)
+	calls := peer.batchCalls
+
+	for number := uint64(10); number <= 13; number++ {
+		id, payload := testMessage(900, peer, number, 1)
+		label, err := b.MessageSafety(context.Background(), id, payload)
+		require.NoError(t, err)
+		require.Equal(t, Finalized, label)
+	}
+	// the logs of all origins are prefetched
+	id, payload := testMessage(900, peer, 11, 0)
+	label, err := b.MessageSafety(context.Background(), id, payload)
+	require.NoError(t, err)
+	require.Equal(t, Finalized, label)
+	ids, payloads := make([]MessageIdentifier, 0, 2), make([]hexutil.Bytes, 0, 2)
+	for _, number := range []uint64{12, 13} {
+		id, payload := testMessage(900, peer, number, 0)
+		ids, payloads = append(ids, id), append(payloads, payload)
+	}
+	labels, err := b.MessageSafetyBatch(context.Background(), ids, payloads)
+	require.NoError(t, err)
+	require.Equal(t, []MessageSafetyLabel{Finalized, Finalized}, labels)
+	require.Equal(t, calls, peer.batchCalls, "prefetched blocks must not be fetched again")
+
+	t.Run("OriginFilter", func(t *testing.T) {
+		// the cached logs of other origins are not matched
+		id, payload := testMessage(900, peer, 10, 1)
+		id.Origin = originB
+		res, err := b.MessageSafetyDetails(context.Background(), id, payload)
+		require.Error(t, err)
+		require.Equal(t, ReasonLogIndexOutOfRange, res.Reason)
+		require.Equal(t, calls, peer.batchCalls)
+	})
+
+	t.Run("UnfinalizedBlocksNotCached", func(t *testing.T) {
+		id, payload := testMessage(900, peer, 14, 1)
+		label, err := b.MessageSafety(context.Background(), id, payload)
+		require.NoError(t, err)
+		require.Equal(t, Unsafe, label)
+		require.Equal(t, calls+1, peer.batchCalls)
+		calls = peer.batchCalls
+
+		// a range past the finalized head is not fetched
+		require.NoError(t, b.Prefetch(context.Background(), chainId, big.NewInt(14), big.NewInt(20)))
+		require.Equal(t, calls, peer.batchCalls)
+	})
+
+	for _, test := range []struct {
+		name     string
+		chainId  ChainID
+		from, to *big.Int
+		err      string
+	}{
+		{"EmptyRange", chainId, big.NewInt(11), big.NewInt(10), "invalid prefetch range [11, 10]"},
+		{"NilBound", chainId, nil, big.NewInt(10), "invalid prefetch range"},
+		{"RangeTooLarge", chainId, big.NewInt(0), big.NewInt(defaultBlockCacheSize), "prefetch range [0, 256] of 257 blocks exceeds the maximum of 256"},
+		{"UnknownPeer", ChainIDFromUInt64(901), big.NewInt(10), big.NewInt(11), "peer with chain id 901 is not configured"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			require.ErrorContains(t, b.Prefetch(context.Background(), test.chainId, test.from, test.to), test.err)
+		})
+	}
+
+	t.Run("UnknownFinalizedHead", func(t *testing.T) {
+		setHeads(b, chainId, nil, nil, nil)
+		require.ErrorContains(t, b.Prefetch(context.Background(), chainId, big.NewInt(10), big.NewInt(11)), "finalized head of peer with chain id 900 is unknown")
+	})
+
+	t.Run("MissingBlock", func(t *testing.T) {
+		setHeads(b, chainId, &eth.L1BlockRef{Number: 20, Time: 140}, nil, nil)
+		require.ErrorContains(t, b.Prefetch(context.Background(), chainId, big.NewInt(14), big.NewInt(15)), "failed to prefetch block 15 of chain id 900: block not found")
+	})
+}
@@ -0,0 +1,86 @@
+package superchain
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// ReplayMessage is a message of a replay file, see ReplayFile.
+type ReplayMessage struct {
+	Identifier MessageIdentifier `json:"identifier"`
+	Payload    hexutil.Bytes     `json:"payload"`
+}
+
+// ReplayResult is the result of a message of a replay file, with the error of the check of the message, if any.
+type ReplayResult struct {
+	MessageSafetyResult
+	Err error `json:"-"`
+}
+
+// ReplayFile checks the messages of the file with the details method of the backend, to audit the messages
+// offline. The file holds one JSON encoded ReplayMessage per line, and blank lines are ignored. The results
+// are in the order of the messages of the file, and every result holds the details of the check of the message,
+// with the reason an Invalid message failed, and the error of the check. If any line is malformed, no message is
+// checked and the error lists every malformed line. The messages are checked one by one, and the replay stops at
+// the first message that cannot be checked as the backend is closed or the context is done.
+func ReplayFile(ctx context.Context, backend SuperchainBackend, path string) ([]ReplayResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open replay file: %w", err)
+	}
+	defer f.Close()
+	msgs, err := readReplayMessages(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read replay file %s: %w", path, err)
+	}
+
+	results := make([]ReplayResult, len(msgs))
+	for i, msg := range msgs {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("failed to replay message %d: %w", i, err)
+		}
+		res, err := backend.MessageSafetyDetails(ctx, msg.Identifier, msg.Payload)
+		if errors.Is(err, ErrBackendClosed) {
+			return nil, fmt.Errorf("failed to replay message %d: %w", i, err)
+		}
+		results[i] = ReplayResult{MessageSafetyResult: res, Err: err}
+	}
+	return results, nil
+}
+
+// readReplayMessages decodes the messages of every non-blank line, or returns the errors of all the malformed lines.
+func readReplayMessages(r io.Reader) ([]ReplayMessage, error) {
+	var msgs []ReplayMessage
+	var errs []error
+	reader := bufio.NewReader(r)
+	for lineNum := 1; ; lineNum++ {
+		// Lines are not bounded in size, as the payloads may be large
+		line, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			var msg ReplayMessage
+			if decodeErr := json.Unmarshal(line, &msg); decodeErr != nil {
+				errs = append(errs, fmt.Errorf("line %d: %w", lineNum, decodeErr))
+			} else {
+				msgs = append(msgs, msg)
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return msgs, nil
+}
@@ -0,0 +1,90 @@
+package superchain
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	"github.com/ethereum-optimism/optimism/op-service/client"
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+)
+
+func TestReplayFile(t *testing.T) {
+	fake := NewFakeBackend()
+	finalized := MessageIdentifier{Origin: common.Address{0xaa}, BlockNumber: big.NewInt(10), Timestamp: 100, ChainId: big.NewInt(900)}
+	unsafe := MessageIdentifier{Origin: common.Address{0xaa}, BlockNumber: big.NewInt(11), LogIndex: 1, Timestamp: 102, ChainId: big.NewInt(900)}
+	fake.SetMessageSafety(finalized, Finalized)
+	fake.SetMessageSafety(unsafe, Unsafe)
+
+	results, err := ReplayFile(context.Background(), fake, filepath.Join("testdata", "replay.jsonl"))
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	require.Equal(t, ReplayResult{MessageSafetyResult: MessageSafetyResult{Label: Finalized}}, results[0])
+	require.Equal(t, ReplayResult{MessageSafetyResult: MessageSafetyResult{Label: Unsafe}}, results[1])
+	// the last message is not registered, and keeps its error
+	require.Equal(t, Invalid, results[2].Label)
+	require.ErrorContains(t, results[2].Err, "unknown message")
+	require.Len(t, fake.Queried(), 3)
+
+	t.Run("Malformed", func(t *testing.T) {
+		_, err := ReplayFile(context.Background(), fake, filepath.Join("testdata", "replay_malformed.jsonl"))
+		require.ErrorContains(t, err, "line 2: unexpected end of JSON input")
+		require.ErrorContains(t, err, "line 3: ")
+		require.NotContains(t, err.Error(), "line 1:")
+		// no message is checked
+		require.Len(t, fake.Queried(), 3)
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		_, err := ReplayFile(context.Background(), fake, filepath.Join("testdata", "missing.jsonl"))
+		require.ErrorContains(t, err, "failed to open replay file")
+	})
+
+	t.Run("Closed", func(t *testing.T) {
+		require.NoError(t, fake.Close())
+		results, err := ReplayFile(context.Background(), fake, filepath.Join("testdata", "replay.jsonl"))
+		require.ErrorIs(t, err, ErrBackendClosed)
+		require.Nil(t, results)
+	})
+}
+
+func TestReplayFileDetails(t *testing.T) {
+	origin := common.Address{0xaa}
+	peer := newStubRPC()
+	peer.addBlock(10, 100, testLog(origin, []byte{0x01}))
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	setHeads(b, chainId, &eth.L1BlockRef{Number: 10, Time: 100}, nil, nil)
+
+	id, payload := testMessage(900, peer, 10, 0)
+	var file bytes.Buffer
+	for _, msg := range []ReplayMessage{{Identifier: id, Payload: payload}, {Identifier: id, Payload: hexutil.Bytes{0xff}}} {
+		line, err := json.Marshal(msg)
+		require.NoError(t, err)
+		file.Write(append(line, '\n'))
+	}
+	path := filepath.Join(t.TempDir(), "replay.jsonl")
+	require.NoError(t, os.WriteFile(path, file.Bytes(), 0o600))
+
+	results, err := ReplayFile(context.Background(), b, path)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	require.Equal(t, Finalized, results[0].Label)
+	require.Equal(t, peer.headers[10].Hash(), results[0].BlockHash)
+	require.NoError(t, results[0].Err)
+	// the replayed Invalid message keeps the reason and the error of its check
+	require.Equal(t, Invalid, results[1].Label)
+	require.Equal(t, ReasonPayloadMismatch, results[1].Reason)
+	require.Equal(t, peer.headers[10].Hash(), results[1].BlockHash)
+	require.ErrorIs(t, results[1].Err, ErrIntegrityMismatch)
+	require.ErrorContains(t, results[1].Err, "payload bytes mismatch")
+}
@@ -0,0 +1,100 @@
+package superchain
+
+import (
+	"slices"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+)
+
+// MessageFailureReason identifies the invariant that a message failed to satisfy.
+type MessageFailureReason string
+
+const (
+	// ReasonNone is the reason of a message that satisfied all the invariants.
+	ReasonNone MessageFailureReason = ""
+
+	ReasonEmptyPayload               MessageFailureReason = "empty_payload"
+	ReasonPayloadTooLarge            MessageFailureReason = "payload_too_large"
+	ReasonInvalidChainId             MessageFailureReason = "invalid_chain_id"
+	ReasonPeerNotConfigured          MessageFailureReason = "peer_not_configured"
+	ReasonNotInDependencySet         MessageFailureReason = "not_in_dependency_set"
+	ReasonOriginNotAllowed           MessageFailureReason = "origin_not_allowlisted"
+	ReasonExpired                    MessageFailureReason = "expired"
+	ReasonPeerUnavailable            MessageFailureReason = "peer_unavailable"
+	ReasonFetchFailed                MessageFailureReason = "fetch_failed"
+	ReasonFutureBlock                MessageFailureReason = "future_block"
+	ReasonBlockNotFound              MessageFailureReason = "block_not_found"
+	ReasonTxNotFound                 MessageFailureReason = "tx_not_found"
+	ReasonBlockHashMismatch          MessageFailureReason = "block_hash_mismatch"
+	ReasonImplausibleHeaderTimestamp MessageFailureReason = "implausible_header_timestamp"
+	ReasonNoLogs                     MessageFailureReason = "no_logs"
+	ReasonLogIndexOutOfRange         MessageFailureReason = "log_index_out_of_range"
+	ReasonInvalidTimestamp           MessageFailureReason = "invalid_timestamp"
+	ReasonInvalidBlockNumber         MessageFailureReason = "invalid_block_number"
+	ReasonLogBlockMismatch           MessageFailureReason = "log_block_mismatch"
+	ReasonLogRemoved                 MessageFailureReason = "log_removed"
+	ReasonOriginMismatch             MessageFailureReason = "origin_mismatch"
+	ReasonTimestampMismatch          MessageFailureReason = "timestamp_mismatch"
+	ReasonMalformedLog               MessageFailureReason = "malformed_log"
+	ReasonPayloadMismatch            MessageFailureReason = "payload_mismatch"
+	ReasonLogBlockHashMismatch       MessageFailureReason = "log_block_hash_mismatch"
+)
+
+// MessageSafetyResult is the outcome of a message safety check.
+type MessageSafetyResult struct {
+	Label MessageSafetyLabel `json:"label"`
+
+	// BlockHash is the hash of the block the message was checked against. It is
+	// zero if the block could not be fetched.
+	BlockHash common.Hash `json:"blockHash"`
+
+	// FinalizedTimestamp is the timestamp of the finalized head of the peer chain
+	// the message was labeled against. It is zero if the finalized head is not yet known.
+	FinalizedTimestamp uint64 `json:"finalizedTimestamp"`
+
+	// Finalized is the finalized head of the peer chain the message was labeled against, as read when the
+	// label was decided, to audit the decision. It is nil if the finalized head is not yet known. Only the
+	// timestamp is set if the message was labeled against a finalized timestamp, see MessageSafetyAt.
+	Finalized *FinalizedHead `json:"finalized,omitempty"`
+
+	// Reason is the invariant that the message failed, if it is Invalid.
+	Reason MessageFailureReason `json:"reason,omitempty"`
+
+	// Log is the log of the peer chain the message matched, to audit the topics and data of the message without
+	// fetching the log again. It is the first log of the span of a message of multiple logs. It is nil if the
+	// message is Invalid. Every result holds its own copy of the log.
+	Log *types.Log `json:"log,omitempty"`
+}
+
+// copyLog returns a copy of the log, not sharing the topics and data of the log, nil if the log is nil.
+func copyLog(log *types.Log) *types.Log {
+	if log == nil {
+		return nil
+	}
+	c := *log
+	c.Topics = slices.Clone(log.Topics)
+	c.Data = slices.Clone(log.Data)
+	return &c
+}
+
+// FinalizedHead identifies the finalized head of a peer chain a message was labeled against.
+type FinalizedHead struct {
+	Number    uint64      `json:"number"`
+	Hash      common.Hash `json:"hash"`
+	Timestamp uint64      `json:"timestamp"`
+}
+
+// newFinalizedHead returns the finalized head of the ref, nil if unknown.
+func newFinalizedHead(ref *eth.L1BlockRef) *FinalizedHead {
+	if ref == nil {
+		return nil
+	}
+	return &FinalizedHead{Number: ref.Number, Hash: ref.Hash, Timestamp: ref.Time}
+}
+
+func invalidResult(reason MessageFailureReason) MessageSafetyResult {
+	return MessageSafetyResult{Label: Invalid, Reason: reason}
+}
@@ -0,0 +1,198 @@
+package superchain
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+	"golang.org/x/time/rate"
+
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+	"github.com/ethereum-optimism/optimism/op-service/sources/caching"
+)
+
+const DefaultRPCNamespace = "supervisor"
+
+// Version is the version reported by the RPC server, unless configured otherwise.
+// It is stamped at build time with -ldflags "-X github.com/ethereum-optimism/optimism/op-service/superchain.Version=...".
+var Version = "v0.0.0"
+
+type RPCConfig struct {
+	// Namespace the backend API is registered under. Defaults to DefaultRPCNamespace.
+	Namespace string
+
+	// Version is the version reported by the API. Defaults to Version.
+	Version string
+
+	// RateLimit optionally limits the message checks of every connection, in checks per second, to protect the
+	// peer nodes from a single client. Connections are identified by their remote address and port, so the clients
+	// behind a proxy or NAT do not share a limit, unless they share a connection. Zero disables the limit.
+	RateLimit float64
+
+	// RateBurst is the number of message checks a connection may burst beyond the rate limit. Defaults to the rate
+	// limit, rounded up.
+	RateBurst int
+}
+
+// rateLimitErrorCode is the JSON-RPC error code of exceeded limits, as specified by EIP-1474.
+const rateLimitErrorCode = -32005
+
+// maxRateLimitedConns bounds the number of connections of which the rate limit is tracked. The limit of the least
+// recently seen connection is reset when exceeded.
+const maxRateLimitedConns = 4096
+
+// rateLimitError is returned to the connections that exceeded their rate limit.
+type rateLimitError struct{}
+
+func (rateLimitError) Error() string {
+	return "rate limit exceeded"
+}
+
+func (rateLimitError) ErrorCode() int {
+	return rateLimitErrorCode
+}
+
+// connLimits is the token bucket of every connection to the RPC server.
+type connLimits struct {
+	limit rate.Limit
+	burst int
+
+	mu       sync.Mutex
+	limiters *caching.LRUCache[string, *rate.Limiter]
+}
+
+func newConnLimits(limit float64, burst int) *connLimits {
+	if burst == 0 {
+		burst = int(math.Ceil(limit))
+	}
+	return &connLimits{
+		limit:    rate.Limit(limit),
+		burst:    burst,
+		limiters: caching.NewLRUCache[string, *rate.Limiter](nil, "rate_limits", maxRateLimitedConns),
+	}
+}
+
+// allow consumes a token of the connection, or returns false if the connection exceeded its limit.
+func (l *connLimits) allow(conn string) bool {
+	l.mu.Lock()
+	limiter, ok := l.limiters.Get(conn)
+	if !ok {
+		limiter = rate.NewLimiter(l.limit, l.burst)
+		l.limiters.Add(conn, limiter)
+	}
+	l.mu.Unlock()
+	return limiter.Allow()
+}
+
+// rpcConn identifies the connection of the RPC request by its remote address and port: the websocket connection,
+// or the HTTP connection shared by the requests kept alive on it.
+func rpcConn(ctx context.Context) string {
+	return rpc.PeerInfoFromContext(ctx).RemoteAddr
+}
+
+// API exposes a SuperchainBackend over JSON-RPC.
+type API struct {
+	backend SuperchainBackend
+	version string
+	// nil if the connections are not rate limited
+	limits *connLimits
+}
+
+// NewAPI returns the API of the backend, reporting the version.
+func NewAPI(backend SuperchainBackend, version string) *API {
+	return &API{backend: backend, version: version}
+}
+
+// SyncStatus is the version of the backend, and the finalized heads of all the peer chains.
+type SyncStatus struct {
+	Version string                      `json:"version"`
+	Chains  map[ChainID]ChainSyncStatus `json:"chains"`
+}
+
+// ChainSyncStatus is the sync status of a peer chain. The finalized head is nil until first polled.
+type ChainSyncStatus struct {
+	Finalized *eth.BlockID `json:"finalized"`
+}
+
+// Version returns the version of the backend.
+func (api *API) Version() string {
+	return api.version
+}
+
+// SyncStatus returns the version of the backend and the finalized head of every peer chain.
+func (api *API) SyncStatus() SyncStatus {
+	heads := api.backend.TrackedHeads()
+	status := SyncStatus{Version: api.version, Chains: make(map[ChainID]ChainSyncStatus, len(heads))}
+	for chainId, head := range heads {
+		var chain ChainSyncStatus
+		if head.Finalized != nil {
+			id := head.Finalized.ID()
+			chain.Finalized = &id
+		}
+		status.Chains[chainId] = chain
+	}
+	return status
+}
+
+// CheckMessage returns the safety label of the message referenced by the identifier.
+func (api *API) CheckMessage(ctx context.Context, id MessageIdentifier, payload hexutil.Bytes) (MessageSafetyLabel, error) {
+	if err := api.checkRateLimit(ctx); err != nil {
+		return "", err
+	}
+	return api.backend.MessageSafety(ctx, id, payload)
+}
+
+// CheckMessageDetails returns the outcome of the check of the message referenced by the identifier, including
+// the reason the message is Invalid, and the finalized head the message was labeled against.
+func (api *API) CheckMessageDetails(ctx context.Context, id MessageIdentifier, payload hexutil.Bytes) (MessageSafetyResult, error) {
+	if err := api.checkRateLimit(ctx); err != nil {
+		return MessageSafetyResult{}, err
+	}
+	return api.backend.MessageSafetyDetails(ctx, id, payload)
+}
+
+// checkRateLimit consumes a message check of the connection of the request, and fails if the connection exceeded its
+// limit.
+func (api *API) checkRateLimit(ctx context.Context) error {
+	if api.limits != nil && !api.limits.allow(rpcConn(ctx)) {
+		return rateLimitError{}
+	}
+	return nil
+}
+
+// DependencySet returns the sorted chain ids of the peer chains that messages are validated of.
+func (api *API) DependencySet() []ChainID {
+	return api.backend.DependencySet()
+}
+
+// TrackedHeads returns the heads currently tracked for every peer chain, keyed by chain id.
+func (api *API) TrackedHeads() map[ChainID]HeadSnapshot {
+	return api.backend.TrackedHeads()
+}
+
+// NewRPCServer creates a go-ethereum RPC server serving the API of the backend.
+// The server can be mounted as an HTTP handler, or on a websocket listener with rpc.Server.WebsocketHandler.
+func NewRPCServer(backend SuperchainBackend, cfg *RPCConfig) (*rpc.Server, error) {
+	namespace, version := DefaultRPCNamespace, Version
+	if cfg != nil && cfg.Namespace != "" {
+		namespace = cfg.Namespace
+	}
+	if cfg != nil && cfg.Version != "" {
+		version = cfg.Version
+	}
+	api := NewAPI(backend, version)
+	if cfg != nil && (cfg.RateLimit < 0 || cfg.RateBurst < 0) {
+		return nil, fmt.Errorf("invalid rate limit %v with burst %d", cfg.RateLimit, cfg.RateBurst)
+	}
+	if cfg != nil && cfg.RateLimit > 0 {
+		api.limits = newConnLimits(cfg.RateLimit, cfg.RateBurst)
+	}
+	srv := rpc.NewServer()
+	if err := srv.RegisterName(namespace, api); err != nil {
+		return nil, fmt.Errorf("failed to register %s API: %w", namespace, err)
+	}
+	return srv, nil
+}
@@ -0,0 +1,286 @@
+package superchain
+
+import (
+	"context"
+	"math/big"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"github.com/ethereum-optimism/optimism/op-service/client"
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+	"github.com/ethereum-optimism/optimism/op-service/testlog"
+)
+
+type staticBackend struct {
+	SuperchainBackend
+
+	label  MessageSafetyLabel
+	result MessageSafetyResult
+	heads  map[ChainID]HeadSnapshot
+	deps   []ChainID
+
+	id      MessageIdentifier
+	payload hexutil.Bytes
+}
+
+func (b *staticBackend) MessageSafety(ctx context.Context, id MessageIdentifier, payload hexutil.Bytes) (MessageSafetyLabel, error) {
+	b.id, b.payload = id, payload
+	return b.label, nil
+}
+
+func (b *staticBackend) MessageSafetyDetails(ctx context.Context, id MessageIdentifier, payload hexutil.Bytes) (MessageSafetyResult, error) {
+	b.id, b.payload = id, payload
+	return b.result, nil
+}
+
+func (b *staticBackend) MessageSafetyBatch(ctx context.Context, ids []MessageIdentifier, payloads []hexutil.Bytes) ([]MessageSafetyLabel, error) {
+	labels := make([]MessageSafetyLabel, len(ids))
+	for i := range labels {
+		labels[i] = b.label
+	}
+	return labels, nil
+}
+
+func (b *staticBackend) TrackedHeads() map[ChainID]HeadSnapshot {
+	return b.heads
+}
+
+func (b *staticBackend) DependencySet() []ChainID {
+	return b.deps
+}
+
+func (b *staticBackend) Close() error {
+	return nil
+}
+
+func TestRPCServer(t *testing.T) {
+	for _, namespace := range []string{"", "custom"} {
+		namespace := namespace
+		t.Run("namespace="+namespace, func(t *testing.T) {
+			backend := &staticBackend{label: Finalized}
+			srv, err := NewRPCServer(backend, &RPCConfig{Namespace: namespace})
+			require.NoError(t, err)
+			httpSrv := httptest.NewServer(srv)
+			t.Cleanup(httpSrv.Close)
+			t.Cleanup(srv.Stop)
+
+			cl, err := client.NewRPC(context.Background(), testlog.Logger(t, log.LevelInfo), httpSrv.URL)
+			require.NoError(t, err)
+			t.Cleanup(cl.Close)
+
+			if namespace == "" {
+				namespace = DefaultRPCNamespace
+			}
+			id := MessageIdentifier{
+				Origin:      common.Address{0xaa},
+				BlockNumber: big.NewInt(10),
+				LogIndex:    1,
+				Timestamp:   100,
+				ChainId:     big.NewInt(900),
+			}
+			var label MessageSafetyLabel
+			err = cl.CallContext(context.Background(), &label, namespace+"_checkMessage", id, hexutil.Bytes{0x01, 0x02})
+			require.NoError(t, err)
+			require.Equal(t, Finalized, label)
+			require.Equal(t, id, backend.id)
+			require.Equal(t, hexutil.Bytes{0x01, 0x02}, backend.payload)
+		})
+	}
+}
+
+func TestRPCServerCheckMessageDetails(t *testing.T) {
+	backend := &staticBackend{result: MessageSafetyResult{
+		Label:              Finalized,
+		BlockHash:          common.Hash{0x0a},
+		FinalizedTimestamp: 100,
+		Finalized:          &FinalizedHead{Number: 10, Hash: common.Hash{0x0a}, Timestamp: 100},
+	}}
+	srv, err := NewRPCServer(backend, nil)
+	require.NoError(t, err)
+	httpSrv := httptest.NewServer(srv)
+	t.Cleanup(httpSrv.Close)
+	t.Cleanup(srv.Stop)
+
+	cl, err := client.NewRPC(context.Background(), testlog.Logger(t, log.LevelInfo), httpSrv.URL)
+	require.NoError(t, err)
+	t.Cleanup(cl.Close)
+
+	id := MessageIdentifier{Origin: common.Address{0xaa}, BlockNumber: big.NewInt(10), Timestamp: 100, ChainId: big.NewInt(900)}
+	var res MessageSafetyResult
+	require.NoError(t, cl.CallContext(context.Background(), &res, DefaultRPCNamespace+"_checkMessageDetails", id, hexutil.Bytes{0x01}))
+	require.Equal(t, backend.result, res)
+	require.Equal(t, id, backend.id)
+
+	// the finalized head is omitted until known
+	backend.result = MessageSafetyResult{Label: Unsafe}
+	var raw map[string]any
+	require.NoError(t, cl.CallContext(context.Background(), &raw, DefaultRPCNamespace+"_checkMessageDetails", id, hexutil.Bytes{0x01}))
+	require.NotContains(t, raw, "finalized")
+}
+
+func TestRPCServerTrackedHeads(t *testing.T) {
+	finalized := eth.L1BlockRef{Hash: common.Hash{0x01}, Number: 1, Time: 98}
+	backend := &staticBackend{heads: map[ChainID]HeadSnapshot{
+		ChainIDFromUInt64(900): {Finalized: &finalized},
+		ChainIDFromUInt64(901): {},
+	}}
+	srv, err := NewRPCServer(backend, nil)
+	require.NoError(t, err)
+	httpSrv := httptest.NewServer(srv)
+	t.Cleanup(httpSrv.Close)
+	t.Cleanup(srv.Stop)
+
+	cl, err := client.NewRPC(context.Background(), testlog.Logger(t, log.LevelInfo), httpSrv.URL)
+	require.NoError(t, err)
+	t.Cleanup(cl.Close)
+
+	var heads map[ChainID]HeadSnapshot
+	require.NoError(t, cl.CallContext(context.Background(), &heads, DefaultRPCNamespace+"_trackedHeads"))
+	require.Equal(t, backend.heads, heads)
+}
+
+func TestRPCServerDependencySet(t *testing.T) {
+	backend := &staticBackend{deps: []ChainID{ChainIDFromUInt64(900), ChainIDFromUInt64(901)}}
+	srv, err := NewRPCServer(backend, nil)
+	require.NoError(t, err)
+	httpSrv := httptest.NewServer(srv)
+	t.Cleanup(httpSrv.Close)
+	t.Cleanup(srv.Stop)
+
+	cl, err := client.NewRPC(context.Background(), testlog.Logger(t, log.LevelInfo), httpSrv.URL)
+	require.NoError(t, err)
+	t.Cleanup(cl.Close)
+
+	var chainIds []ChainID
+	require.NoError(t, cl.CallContext(context.Background(), &chainIds, DefaultRPCNamespace+"_dependencySet"))
+	require.Equal(t, backend.deps, chainIds)
+}
+
+func TestRPCServerSyncStatus(t *testing.T) {
+	finalized := eth.L1BlockRef{Hash: common.Hash{0x01}, Number: 1, Time: 98}
+	backend := &staticBackend{heads: map[ChainID]HeadSnapshot{
+		ChainIDFromUInt64(900): {Finalized: &finalized},
+		ChainIDFromUInt64(901): {},
+	}}
+	for _, version := range []string{"", "v1.2.3"} {
+		srv, err := NewRPCServer(backend, &RPCConfig{Version: version})
+		require.NoError(t, err)
+		httpSrv := httptest.NewServer(srv)
+		t.Cleanup(httpSrv.Close)
+		t.Cleanup(srv.Stop)
+
+		cl, err := client.NewRPC(context.Background(), testlog.Logger(t, log.LevelInfo), httpSrv.URL)
+		require.NoError(t, err)
+		t.Cleanup(cl.Close)
+
+		if version == "" {
+			version = Version
+		}
+		var reported string
+		require.NoError(t, cl.CallContext(context.Background(), &reported, DefaultRPCNamespace+"_version"))
+		require.Equal(t, version, reported)
+
+		var status SyncStatus
+		require.NoError(t, cl.CallContext(context.Background(), &status, DefaultRPCNamespace+"_syncStatus"))
+		require.Equal(t, SyncStatus{
+			Version: version,
+			Chains: map[ChainID]ChainSyncStatus{
+				ChainIDFromUInt64(900): {Finalized: &eth.BlockID{Hash: common.Hash{0x01}, Number: 1}},
+				ChainIDFromUInt64(901): {},
+			},
+		}, status)
+	}
+}
+
+func TestRPCServerRateLimit(t *testing.T) {
+	backend := &staticBackend{label: Finalized}
+	srv, err := NewRPCServer(backend, &RPCConfig{RateLimit: 0.001, RateBurst: 3})
+	require.NoError(t, err)
+	httpSrv := httptest.NewServer(srv)
+	t.Cleanup(httpSrv.Close)
+	t.Cleanup(srv.Stop)
+
+	cl, err := client.NewRPC(context.Background(), testlog.Logger(t, log.LevelInfo), httpSrv.URL)
+	require.NoError(t, err)
+	t.Cleanup(cl.Close)
+
+	id := MessageIdentifier{Origin: common.Address{0xaa}, BlockNumber: big.NewInt(10), Timestamp: 100, ChainId: big.NewInt(900)}
+	var label MessageSafetyLabel
+	for i := 0; i < 3; i++ {
+		require.NoError(t, cl.CallContext(context.Background(), &label, DefaultRPCNamespace+"_checkMessage", id, hexutil.Bytes{0x01}))
+		require.Equal(t, Finalized, label)
+	}
+	// the burst is spent, by checks of either method
+	err = cl.CallContext(context.Background(), &label, DefaultRPCNamespace+"_checkMessage", id, hexutil.Bytes{0x01})
+	var rpcErr rpc.Error
+	require.ErrorAs(t, err, &rpcErr)
+	require.Equal(t, rateLimitErrorCode, rpcErr.ErrorCode())
+	require.ErrorContains(t, err, "rate limit exceeded")
+	var res MessageSafetyResult
+	err = cl.CallContext(context.Background(), &res, DefaultRPCNamespace+"_checkMessageDetails", id, hexutil.Bytes{0x01})
+	require.ErrorContains(t, err, "rate limit exceeded")
+
+	// the requests of a batch are limited one by one
+	batch := []rpc.BatchElem{
+		{Method: DefaultRPCNamespace + "_checkMessage", Args: []any{id, hexutil.Bytes{0x01}}, Result: new(MessageSafetyLabel)},
+		{Method: DefaultRPCNamespace + "_checkMessage", Args: []any{id, hexutil.Bytes{0x01}}, Result: new(MessageSafetyLabel)},
+	}
+	require.NoError(t, cl.BatchCallContext(context.Background(), batch))
+	require.ErrorContains(t, batch[0].Error, "rate limit exceeded")
+	require.ErrorContains(t, batch[1].Error, "rate limit exceeded")
+
+	// the other methods are not limited
+	var version string
+	require.NoError(t, cl.CallContext(context.Background(), &version, DefaultRPCNamespace+"_version"))
+
+	_, err = NewRPCServer(backend, &RPCConfig{RateLimit: -1})
+	require.ErrorContains(t, err, "invalid rate limit")
+}
+
+func TestRPCServerRateLimitPerConnection(t *testing.T) {
+	backend := &staticBackend{label: Finalized}
+	srv, err := NewRPCServer(backend, &RPCConfig{RateLimit: 0.001, RateBurst: 2})
+	require.NoError(t, err)
+	wsSrv := httptest.NewServer(srv.WebsocketHandler([]string{"*"}))
+	t.Cleanup(wsSrv.Close)
+	t.Cleanup(srv.Stop)
+
+	// the clients connect from the same host, e.g. from behind a proxy
+	dial := func() client.RPC {
+		cl, err := client.NewRPC(context.Background(), testlog.Logger(t, log.LevelInfo), "ws"+strings.TrimPrefix(wsSrv.URL, "http"))
+		require.NoError(t, err)
+		t.Cleanup(cl.Close)
+		return cl
+	}
+	first, second := dial(), dial()
+	id := MessageIdentifier{Origin: common.Address{0xaa}, BlockNumber: big.NewInt(10), Timestamp: 100, ChainId: big.NewInt(900)}
+	check := func(cl client.RPC) error {
+		var label MessageSafetyLabel
+		return cl.CallContext(context.Background(), &label, DefaultRPCNamespace+"_checkMessage", id, hexutil.Bytes{0x01})
+	}
+	require.NoError(t, check(first))
+	require.NoError(t, check(first))
+	require.ErrorContains(t, check(first), "rate limit exceeded")
+	// the other connection has its own limit
+	require.NoError(t, check(second))
+	require.NoError(t, check(second))
+	require.ErrorContains(t, check(second), "rate limit exceeded")
+}
+
+func TestConnLimits(t *testing.T) {
+	limits := newConnLimits(0.001, 0)
+	// the burst defaults to the rate limit, rounded up
+	require.True(t, limits.allow("10.0.0.1:30000"))
+	require.False(t, limits.allow("10.0.0.1:30000"))
+	// connections have their own limits, even from the same host
+	require.True(t, limits.allow("10.0.0.1:30001"))
+	require.True(t, limits.allow("10.0.0.2:30000"))
+}
@@ -0,0 +1,106 @@
+package superchain
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+	"github.com/ethereum-optimism/optimism/op-service/jsonutil"
+)
+
+// HeadStore persists the finalized heads of the peer chains, so that a restarted backend labels messages
+// against the last known finalized heads until the finalized heads are polled again.
+type HeadStore interface {
+	// LoadFinalizedHeads returns the persisted finalized head of every peer chain.
+	LoadFinalizedHeads() (map[ChainID]eth.L1BlockRef, error)
+
+	// StoreFinalizedHead persists the finalized head of the peer chain, replacing the previous head.
+	StoreFinalizedHead(chainId ChainID, head eth.L1BlockRef) error
+}
+
+// FileHeadStore is a HeadStore persisting the finalized heads to a JSON file.
+type FileHeadStore struct {
+	path string
+
+	mu    sync.Mutex
+	heads map[ChainID]eth.L1BlockRef
+}
+
+var _ HeadStore = (*FileHeadStore)(nil)
+
+// NewFileHeadStore returns a store of the finalized heads in the file at the path. The file is created
+// when the first head is stored.
+func NewFileHeadStore(path string) *FileHeadStore {
+	return &FileHeadStore{path: path}
+}
+
+// LoadFinalizedHeads reads the finalized heads from the file. No heads are returned if the file does not exist yet.
+func (s *FileHeadStore) LoadFinalizedHeads() (map[ChainID]eth.L1BlockRef, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	heads := make(map[ChainID]eth.L1BlockRef, len(s.heads))
+	for chainId, head := range s.heads {
+		heads[chainId] = head
+	}
+	return heads, nil
+}
+
+// StoreFinalizedHead atomically rewrites the file with the finalized head of the peer chain,
+// alongside the persisted heads of the other peer chains.
+func (s *FileHeadStore) StoreFinalizedHead(chainId ChainID, head eth.L1BlockRef) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// The heads of the other chains are kept, even if not loaded before
+	if s.heads == nil {
+		if err := s.load(); err != nil {
+			return fmt.Errorf("failed to store finalized head of chain id %s: %w", chainId, err)
+		}
+	}
+	s.heads[chainId] = head
+	if err := jsonutil.WriteJSON(s.path, s.heads, 0o644); err != nil {
+		return fmt.Errorf("failed to store finalized head of chain id %s: %w", chainId, err)
+	}
+	return nil
+}
+
+// load reads the persisted heads from the file, while holding the lock.
+func (s *FileHeadStore) load() error {
+	heads, err := jsonutil.LoadJSON[map[ChainID]eth.L1BlockRef](s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		s.heads = make(map[ChainID]eth.L1BlockRef)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	s.heads = *heads
+	return nil
+}
+
+// restoreFinalizedHeads returns the persisted finalized heads of the store, if any. The backend starts
+// without finalized heads if the store cannot be read.
+func (b *backend) restoreFinalizedHeads() map[ChainID]eth.L1BlockRef {
+	if b.headStore == nil {
+		return nil
+	}
+	heads, err := b.headStore.LoadFinalizedHeads()
+	if err != nil {
+		b.log.Warn("failed to restore finalized heads", "err", err)
+		return nil
+	}
+	return heads
+}
+
+// storeFinalizedHead persists the finalized head of the peer chain, if a store is configured.
+func (b *backend) storeFinalizedHead(chainId ChainID, head eth.L1BlockRef) {
+	if b.headStore == nil {
+		return
+	}
+	if err := b.headStore.StoreFinalizedHead(chainId, head); err != nil {
+		b.log.Warn("failed to store finalized head", "chain_id", chainId, "err", err)
+	}
+}
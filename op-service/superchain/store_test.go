@@ -0,0 +1,108 @@
+package superchain
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/ethereum-optimism/optimism/op-service/client"
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+)
+
+// memHeadStore is a HeadStore keeping the finalized heads in memory.
+type memHeadStore struct {
+	mu    sync.Mutex
+	heads map[ChainID]eth.L1BlockRef
+}
+
+func (s *memHeadStore) LoadFinalizedHeads() (map[ChainID]eth.L1BlockRef, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	heads := make(map[ChainID]eth.L1BlockRef, len(s.heads))
+	for chainId, head := range s.heads {
+		heads[chainId] = head
+	}
+	return heads, nil
+}
+
+func (s *memHeadStore) StoreFinalizedHead(chainId ChainID, head eth.L1BlockRef) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.heads == nil {
+		s.heads = make(map[ChainID]eth.L1BlockRef)
+	}
+	s.heads[chainId] = head
+	return nil
+}
+
+func TestRestoreFinalizedHeads(t *testing.T) {
+	chainId := ChainIDFromUInt64(900)
+	store := &memHeadStore{}
+	head := eth.L1BlockRef{Hash: common.Hash{0x0a}, Number: 10, Time: 100}
+
+	// the first run persists every update of the finalized head
+	first := newTestBackend(t, map[ChainID]client.RPC{chainId: newStubRPC()})
+	first.headStore = store
+	first.onFinalizedHead(context.Background(), chainId, eth.L1BlockRef{Hash: common.Hash{0x09}, Number: 9, Time: 98})
+	first.onFinalizedHead(context.Background(), chainId, head)
+	first.onFinalizedHead(context.Background(), chainId, eth.L1BlockRef{Hash: common.Hash{0x08}, Number: 8, Time: 96})
+	require.Equal(t, map[ChainID]eth.L1BlockRef{chainId: head}, store.heads)
+
+	// the restarted backend labels messages against the restored head until the finalized head is polled
+	restarted := newTestBackend(t, map[ChainID]client.RPC{chainId: newStubRPC()})
+	restarted.headStore = store
+	restored := restarted.restoreFinalizedHeads()
+	require.Equal(t, head, restored[chainId])
+	restarted.finalizedPollInterval = time.Millisecond * 10
+	restarted.trackHeads(chainId, labelRefsSource{eth.Finalized: {Hash: common.Hash{0x0b}, Number: 11, Time: 102, ParentHash: head.Hash}}, &head)
+	defer restarted.Close()
+	label, finalized := restarted.safetyLabel(chainId, 10, 100)
+	require.Equal(t, Finalized, label)
+	require.Equal(t, head, *finalized)
+
+	require.Eventually(t, func() bool {
+		label, _ := restarted.safetyLabel(chainId, 11, 102)
+		return label == Finalized
+	}, time.Second, time.Millisecond*10)
+	require.Eventually(t, func() bool {
+		heads, _ := store.LoadFinalizedHeads()
+		return heads[chainId].Number == 11
+	}, time.Second, time.Millisecond*10)
+}
+
+func TestFileHeadStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "heads.json")
+	chainA, chainB := ChainIDFromUInt64(900), ChainIDFromUInt64(901)
+	headA := eth.L1BlockRef{Hash: common.Hash{0x0a}, Number: 10, Time: 100}
+	headB := eth.L1BlockRef{Hash: common.Hash{0x0b}, Number: 11, Time: 102}
+
+	store := NewFileHeadStore(path)
+	heads, err := store.LoadFinalizedHeads()
+	require.NoError(t, err)
+	require.Empty(t, heads)
+	require.NoError(t, store.StoreFinalizedHead(chainA, eth.L1BlockRef{Hash: common.Hash{0x09}, Number: 9, Time: 98}))
+	require.NoError(t, store.StoreFinalizedHead(chainA, headA))
+	require.NoError(t, store.StoreFinalizedHead(chainB, headB))
+
+	heads, err = NewFileHeadStore(path).LoadFinalizedHeads()
+	require.NoError(t, err)
+	require.Equal(t, map[ChainID]eth.L1BlockRef{chainA: headA, chainB: headB}, heads)
+
+	// the heads of the other chains are kept by a store that did not load the file
+	headB.Number++
+	require.NoError(t, NewFileHeadStore(path).StoreFinalizedHead(chainB, headB))
+	heads, err = NewFileHeadStore(path).LoadFinalizedHeads()
+	require.NoError(t, err)
+	require.Equal(t, map[ChainID]eth.L1BlockRef{chainA: headA, chainB: headB}, heads)
+
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0o644))
+	_, err = NewFileHeadStore(path).LoadFinalizedHeads()
+	require.ErrorContains(t, err, "failed to decode file")
+}
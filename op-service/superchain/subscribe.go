@@ -0,0 +1,102 @@
+package superchain
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum"
+
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+)
+
+// headSubscriptionBuffer is the number of head updates buffered per subscriber.
+// Updates are dropped for subscribers that do not keep up.
+const headSubscriptionBuffer = 10
+
+// headSubscription is a subscription to the head updates of a peer chain.
+type headSubscription struct {
+	ch        chan eth.L1BlockRef
+	err       chan error
+	unsubOnce sync.Once
+	unsub     func()
+}
+
+func (s *headSubscription) Unsubscribe() {
+	s.unsubOnce.Do(func() {
+		s.unsub()
+		close(s.err)
+	})
+}
+
+func (s *headSubscription) Err() <-chan error {
+	return s.err
+}
+
+// headSubscribers fans out the head updates of every peer chain to the subscribers.
+type headSubscribers struct {
+	mu   sync.Mutex
+	subs map[ChainID]map[*headSubscription]struct{}
+}
+
+func (h *headSubscribers) subscribe(chainId ChainID) *headSubscription {
+	sub := &headSubscription{
+		ch:  make(chan eth.L1BlockRef, headSubscriptionBuffer),
+		err: make(chan error, 1),
+	}
+	sub.unsub = func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.subs[chainId], sub)
+		close(sub.ch)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs == nil {
+		h.subs = make(map[ChainID]map[*headSubscription]struct{})
+	}
+	if h.subs[chainId] == nil {
+		h.subs[chainId] = make(map[*headSubscription]struct{})
+	}
+	h.subs[chainId][sub] = struct{}{}
+	return sub
+}
+
+// unsubscribeAll ends all subscriptions.
+func (h *headSubscribers) unsubscribeAll() {
+	h.mu.Lock()
+	var subs []*headSubscription
+	for _, chainSubs := range h.subs {
+		for sub := range chainSubs {
+			subs = append(subs, sub)
+		}
+	}
+	h.mu.Unlock()
+	for _, sub := range subs {
+		sub.Unsubscribe()
+	}
+}
+
+// publish sends the head to every subscriber of the chain, without blocking on slow subscribers.
+func (h *headSubscribers) publish(chainId ChainID, head eth.L1BlockRef) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs[chainId] {
+		select {
+		case sub.ch <- head:
+		default:
+		}
+	}
+}
+
+// SubscribeFinalizedHead subscribes to the updates of the finalized head of the peer chain.
+// Every subscriber has its own buffered channel, which is closed when unsubscribing or
+// when the backend is closed.
+// If the peer is not configured, the subscription fails immediately.
+func (b *backend) SubscribeFinalizedHead(chainId ChainID) (<-chan eth.L1BlockRef, ethereum.Subscription) {
+	sub := b.finalizedHeadSubs.subscribe(chainId)
+	if _, ok := b.l2PeerNodes[chainId]; !ok {
+		sub.err <- &PeerNotConfiguredError{ChainID: chainId}
+		sub.Unsubscribe()
+	}
+	return sub.ch, sub
+}
@@ -0,0 +1,67 @@
+package superchain
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/ethereum-optimism/optimism/op-service/client"
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+)
+
+func TestSubscribeFinalizedHead(t *testing.T) {
+	chainA, chainB := ChainIDFromUInt64(900), ChainIDFromUInt64(901)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainA: newStubRPC(), chainB: newStubRPC()})
+
+	ch1, sub1 := b.SubscribeFinalizedHead(chainA)
+	ch2, sub2 := b.SubscribeFinalizedHead(chainA)
+	chB, subB := b.SubscribeFinalizedHead(chainB)
+	defer subB.Unsubscribe()
+
+	head := eth.L1BlockRef{Hash: common.Hash{10}, Number: 10, Time: 100}
+	b.onFinalizedHead(context.Background(), chainA, head)
+	require.Equal(t, head, <-ch1)
+	require.Equal(t, head, <-ch2)
+	require.Empty(t, chB)
+
+	// unsubscribing does not affect the other subscriber
+	sub1.Unsubscribe()
+	sub1.Unsubscribe()
+	_, ok := <-ch1
+	require.False(t, ok)
+	_, ok = <-sub1.Err()
+	require.False(t, ok)
+
+	next := eth.L1BlockRef{Hash: common.Hash{11}, Number: 11, Time: 102}
+	b.onFinalizedHead(context.Background(), chainA, next)
+	require.Equal(t, next, <-ch2)
+	sub2.Unsubscribe()
+
+	// slow subscribers do not block the head updates
+	for i := 0; i < 2*headSubscriptionBuffer; i++ {
+		b.onFinalizedHead(context.Background(), chainB, eth.L1BlockRef{Hash: common.Hash{byte(i)}, Number: uint64(i)})
+	}
+	require.Len(t, chB, headSubscriptionBuffer)
+}
+
+func TestSubscribeFinalizedHeadClose(t *testing.T) {
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: newStubRPC()})
+	ch, sub := b.SubscribeFinalizedHead(chainId)
+	require.NoError(t, b.Close())
+	_, ok := <-ch
+	require.False(t, ok)
+	_, ok = <-sub.Err()
+	require.False(t, ok)
+}
+
+func TestSubscribeFinalizedHeadUnknownPeer(t *testing.T) {
+	b := newTestBackend(t, nil)
+	ch, sub := b.SubscribeFinalizedHead(ChainIDFromUInt64(900))
+	require.ErrorContains(t, <-sub.Err(), "not configured")
+	_, ok := <-ch
+	require.False(t, ok)
+}
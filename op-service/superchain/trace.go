@@ -0,0 +1,71 @@
+package superchain
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// ValidationCheckName names an invariant checked for a message.
+type ValidationCheckName string
+
+const (
+	CheckChainLookup     ValidationCheckName = "chain_lookup"
+	CheckDependencySet   ValidationCheckName = "dependency_set"
+	CheckOriginAllowed   ValidationCheckName = "origin_allowed"
+	CheckExpiry          ValidationCheckName = "expiry"
+	CheckBlockNumber     ValidationCheckName = "block_number"
+	CheckBlockFetch      ValidationCheckName = "block_fetch"
+	CheckBlockHash       ValidationCheckName = "block_hash"
+	CheckHeaderTimestamp ValidationCheckName = "header_timestamp"
+	CheckLogIndex        ValidationCheckName = "log_index"
+	CheckLogBlock        ValidationCheckName = "log_block"
+	CheckLogRemoved      ValidationCheckName = "log_removed"
+	CheckOrigin          ValidationCheckName = "origin"
+	CheckTimestamp       ValidationCheckName = "timestamp"
+	CheckLogStructure    ValidationCheckName = "log_structure"
+	CheckPayload         ValidationCheckName = "payload"
+	CheckLogBlockHash    ValidationCheckName = "log_block_hash"
+	CheckFinality        ValidationCheckName = "finality"
+)
+
+// ValidationCheck is the outcome of an invariant checked for a message,
+// with the values observed on the peer chain and the values expected by the message.
+type ValidationCheck struct {
+	Name     ValidationCheckName `json:"name"`
+	Passed   bool                `json:"passed"`
+	Expected string              `json:"expected,omitempty"`
+	Observed string              `json:"observed,omitempty"`
+}
+
+// ValidationTrace lists the checks performed for a message, in order, and the resulting outcome.
+// The checks stop at the first failure.
+type ValidationTrace struct {
+	Checks []ValidationCheck   `json:"checks"`
+	Result MessageSafetyResult `json:"result"`
+}
+
+// record appends the check to the trace. Recording to a nil trace is a no-op, so the checks can be
+// recorded unconditionally.
+func (t *ValidationTrace) record(name ValidationCheckName, passed bool, expected, observed any) {
+	if t == nil {
+		return
+	}
+	t.Checks = append(t.Checks, ValidationCheck{
+		Name:     name,
+		Passed:   passed,
+		Expected: fmt.Sprint(expected),
+		Observed: fmt.Sprint(observed),
+	})
+}
+
+// MessageSafetyExplain checks the message like MessageSafety, and returns every check performed.
+// The message is always checked against the peer chain, bypassing the cache of terminal results.
+func (b *backend) MessageSafetyExplain(ctx context.Context, id MessageIdentifier, payload hexutil.Bytes) (*ValidationTrace, error) {
+	trace := &ValidationTrace{}
+	res, err := b.messageSafety(ctx, id, payload, checkOptions{trace: trace})
+	res.Label = b.capLabel(id, res.Label)
+	trace.Result = res
+	return trace, err
+}
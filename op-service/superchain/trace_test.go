@@ -0,0 +1,78 @@
+package superchain
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	"github.com/ethereum-optimism/optimism/op-service/client"
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+)
+
+func TestMessageSafetyExplain(t *testing.T) {
+	origin := common.Address{0xaa}
+	peer := newStubRPC()
+	peer.addBlock(10, 100, testLog(origin, []byte{0x01}))
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	setHeads(b, chainId, &eth.L1BlockRef{Number: 10, Time: 100}, nil, nil)
+
+	checkNames := func(trace *ValidationTrace) []ValidationCheckName {
+		var names []ValidationCheckName
+		for _, check := range trace.Checks {
+			names = append(names, check.Name)
+		}
+		return names
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		id, payload := testMessage(900, peer, 10, 0)
+		hash := peer.headers[10].Hash()
+		id.BlockHash = &hash
+		// the cache of terminal results is bypassed, so every check is performed on every call
+		for i := 0; i < 2; i++ {
+			trace, err := b.MessageSafetyExplain(context.Background(), id, payload)
+			require.NoError(t, err)
+			require.Equal(t, Finalized, trace.Result.Label)
+			require.Equal(t, []ValidationCheckName{
+				CheckChainLookup, CheckDependencySet, CheckOriginAllowed, CheckExpiry, CheckBlockFetch, CheckBlockHash,
+				CheckLogIndex, CheckLogBlock, CheckLogRemoved, CheckOrigin, CheckTimestamp, CheckLogStructure, CheckPayload, CheckFinality,
+			}, checkNames(trace))
+			for _, check := range trace.Checks {
+				require.True(t, check.Passed, check.Name)
+			}
+		}
+		require.Equal(t, 2, peer.batchCalls)
+	})
+
+	t.Run("PayloadMismatch", func(t *testing.T) {
+		id, payload := testMessage(900, peer, 10, 0)
+		trace, err := b.MessageSafetyExplain(context.Background(), id, hexutil.Bytes{0xff})
+		require.ErrorContains(t, err, "payload bytes mismatch")
+		require.Equal(t, ReasonPayloadMismatch, trace.Result.Reason)
+		last := trace.Checks[len(trace.Checks)-1]
+		require.Equal(t, ValidationCheck{Name: CheckPayload, Passed: false, Expected: "0xff", Observed: payload.String()}, last)
+	})
+
+	t.Run("TimestampMismatch", func(t *testing.T) {
+		id, payload := testMessage(900, peer, 10, 0)
+		id.Timestamp = 99
+		trace, err := b.MessageSafetyExplain(context.Background(), id, payload)
+		require.ErrorContains(t, err, "timestamp mismatch")
+		last := trace.Checks[len(trace.Checks)-1]
+		require.Equal(t, ValidationCheck{Name: CheckTimestamp, Passed: false, Expected: "99", Observed: "100"}, last)
+	})
+
+	t.Run("UnknownPeer", func(t *testing.T) {
+		id, payload := testMessage(900, peer, 10, 0)
+		id.ChainId = ChainIDFromUInt64(901).ToBig()
+		trace, err := b.MessageSafetyExplain(context.Background(), id, payload)
+		require.ErrorContains(t, err, "not configured")
+		require.Equal(t, []ValidationCheck{{Name: CheckChainLookup, Passed: false, Expected: "configured peer", Observed: "901"}}, trace.Checks)
+	})
+}
@@ -0,0 +1,68 @@
+package superchain
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	spanMessageSafety      = "superchain.MessageSafety"
+	spanMessageSafetyBatch = "superchain.MessageSafetyBatch"
+	spanFetchBlocks        = "superchain.FetchBlocks"
+)
+
+// span is a span of the tracer of the backend, or nil if no tracer is configured,
+// in which case all the methods are no-ops.
+type span struct {
+	span trace.Span
+}
+
+// startSpan starts a span of the configured tracer as child of the span of the context, if any.
+// The attributes are only computed if a tracer is configured.
+func (b *backend) startSpan(ctx context.Context, name string, attrs func() []attribute.KeyValue) (context.Context, *span) {
+	if b.tracer == nil {
+		return ctx, nil
+	}
+	ctx, s := b.tracer.Start(ctx, name, trace.WithAttributes(attrs()...))
+	return ctx, &span{span: s}
+}
+
+// messageAttributes returns the attributes of a span checking the message with the options, with the supplied
+// finalized timestamp of a historical check.
+func (b *backend) messageAttributes(id MessageIdentifier, opts checkOptions) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{attribute.String("superchain.chain_id", b.chainIdLabel(id))}
+	if id.BlockNumber != nil && id.BlockNumber.IsInt64() {
+		attrs = append(attrs, attribute.Int64("superchain.block_number", id.BlockNumber.Int64()))
+	}
+	if opts.finalizedAt != nil {
+		attrs = append(attrs, attribute.Int64("superchain.finalized_timestamp", int64(*opts.finalizedAt)))
+	}
+	return attrs
+}
+
+// endMessage ends the span of a message check, with the label and failure reason of the message.
+func (s *span) endMessage(res MessageSafetyResult, err error) {
+	if s == nil {
+		return
+	}
+	s.span.SetAttributes(attribute.String("superchain.label", string(res.Label)))
+	if res.Reason != ReasonNone {
+		s.span.SetAttributes(attribute.String("superchain.reason", string(res.Reason)))
+	}
+	s.end(err)
+}
+
+// end ends the span, marking it failed with the error if non-nil.
+func (s *span) end(err error) {
+	if s == nil {
+		return
+	}
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
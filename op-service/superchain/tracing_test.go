@@ -0,0 +1,80 @@
+package superchain
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	"github.com/ethereum-optimism/optimism/op-service/client"
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+)
+
+func TestMessageSafetySpans(t *testing.T) {
+	peer := newStubRPC()
+	peer.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x01}))
+	peer.addBlock(11, 102, testLog(common.Address{0xaa}, []byte{0x02}))
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	setHeads(b, chainId, &eth.L1BlockRef{Number: 10, Time: 100}, nil, nil)
+	recorder := tracetest.NewSpanRecorder()
+	b.tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("superchain")
+
+	// ended spans are recorded children first
+	id, payload := testMessage(900, peer, 10, 0)
+	_, err := b.MessageSafety(context.Background(), id, payload)
+	require.NoError(t, err)
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	fetch, check := spans[0], spans[1]
+	require.Equal(t, spanFetchBlocks, fetch.Name())
+	require.Equal(t, check.SpanContext().SpanID(), fetch.Parent().SpanID())
+	require.Contains(t, fetch.Attributes(), attribute.String("superchain.chain_id", "900"))
+	require.Equal(t, spanMessageSafety, check.Name())
+	require.ElementsMatch(t, []attribute.KeyValue{
+		attribute.String("superchain.chain_id", "900"),
+		attribute.Int64("superchain.block_number", 10),
+		attribute.String("superchain.label", string(Finalized)),
+	}, check.Attributes())
+	require.Equal(t, codes.Unset, check.Status().Code)
+
+	// the spans of invalid messages record the reason and the error
+	_, err = b.MessageSafety(context.Background(), id, hexutil.Bytes{0xff})
+	require.Error(t, err)
+	check = recorder.Ended()[3]
+	require.Contains(t, check.Attributes(), attribute.String("superchain.reason", string(ReasonPayloadMismatch)))
+	require.Equal(t, codes.Error, check.Status().Code)
+	require.Len(t, check.Events(), 1)
+
+	// the finalized message is cached, only the other message is fetched
+	other, otherPayload := testMessage(900, peer, 11, 0)
+	_, err = b.MessageSafetyBatch(context.Background(), []MessageIdentifier{id, other}, []hexutil.Bytes{payload, otherPayload})
+	require.NoError(t, err)
+	spans = recorder.Ended()[4:]
+	require.Len(t, spans, 2)
+	require.Equal(t, spanFetchBlocks, spans[0].Name())
+	require.Equal(t, spanMessageSafetyBatch, spans[1].Name())
+	require.Equal(t, spans[1].SpanContext().SpanID(), spans[0].Parent().SpanID())
+	require.Contains(t, spans[1].Attributes(), attribute.Int("superchain.messages", 2))
+
+	// the spans of historical checks record the supplied finalized timestamp
+	_, err = b.MessageSafetyAt(context.Background(), other, otherPayload, 102)
+	require.NoError(t, err)
+	spans = recorder.Ended()[6:]
+	require.Len(t, spans, 2)
+	require.Equal(t, spanMessageSafety, spans[1].Name())
+	require.ElementsMatch(t, []attribute.KeyValue{
+		attribute.String("superchain.chain_id", "900"),
+		attribute.Int64("superchain.block_number", 11),
+		attribute.Int64("superchain.finalized_timestamp", 102),
+		attribute.String("superchain.label", string(Finalized)),
+	}, spans[1].Attributes())
+}
@@ -0,0 +1,95 @@
+package superchain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// TxMessageIdentifier identifies a message by the transaction that emitted it, and the index of the log of the
+// message among the logs of the transaction, instead of the block and the block-global index of the log.
+type TxMessageIdentifier struct {
+	Origin     common.Address `json:"origin"`
+	TxHash     common.Hash    `json:"txHash"`
+	TxLogIndex uint64         `json:"txLogIndex"`
+	Timestamp  uint64         `json:"timestamp"`
+	ChainId    *big.Int       `json:"chainId"`
+}
+
+// MessageSafetyByTx checks the message like MessageSafety, locating its log with the receipt of the transaction
+// fetched from the peer. The message is then checked as a message pinned to the block of the receipt, so a
+// receipt of a block that was reorged out does not validate the message.
+func (b *backend) MessageSafetyByTx(ctx context.Context, id TxMessageIdentifier, payload hexutil.Bytes) (MessageSafetyLabel, error) {
+	b.log.Info("resolving message of transaction", "chain_id", id.ChainId, "tx_hash", id.TxHash, "tx_log_index", id.TxLogIndex)
+	msg, err := b.resolveTxMessage(ctx, id, payload)
+	if err != nil {
+		b.metrics.RecordMessageSafety(b.chainIdLabel(msg), Invalid)
+		return Invalid, err
+	}
+	return b.MessageSafety(ctx, msg, payload)
+}
+
+// resolveTxMessage returns the identifier of the message of the transaction, referencing the block and the
+// block-global index of the log of the message in the receipt of the transaction. Until resolved, the identifier
+// holds the fields of the transaction identifier only. The preconditions of the message that do not depend on its
+// block are checked before the receipt is fetched, so an invalid message costs no request to the peer. Every
+// failure is logged.
+func (b *backend) resolveTxMessage(ctx context.Context, id TxMessageIdentifier, payload hexutil.Bytes) (MessageIdentifier, error) {
+	msg := MessageIdentifier{Origin: id.Origin, Timestamp: id.Timestamp, ChainId: id.ChainId}
+	if b.closed.Load() {
+		return msg, ErrBackendClosed
+	}
+	if _, err := b.checkPayloadPreconditions(msg, payload, nil); err != nil {
+		return msg, err
+	}
+	_, peer, _, err := b.checkChainPreconditions(msg, payload, nil)
+	if err != nil {
+		return msg, err
+	}
+	fail := func(reason MessageFailureReason, err error) (MessageIdentifier, error) {
+		b.logInvalidMessage(msg, payload, reason, err, "tx_hash", id.TxHash, "tx_log_index", id.TxLogIndex)
+		return msg, err
+	}
+	receipt, err := b.fetchReceipt(ctx, peer, id.TxHash)
+	if err != nil {
+		if errors.Is(err, ErrPeerUnavailable) {
+			return fail(ReasonPeerUnavailable, categorize(ErrFetchFailed, err))
+		}
+		return fail(ReasonFetchFailed, categorize(ErrFetchFailed, err))
+	}
+	// A pending transaction has no receipt yet, or a receipt without a block
+	if receipt == nil || receipt.BlockNumber == nil {
+		return fail(ReasonTxNotFound, fmt.Errorf("transaction %s is not included in a block", id.TxHash))
+	}
+	if id.TxLogIndex >= uint64(len(receipt.Logs)) {
+		return fail(ReasonLogIndexOutOfRange, categorize(ErrIntegrityMismatch, fmt.Errorf("invalid log index: no log with index %d emitted by transaction %s, of %d logs",
+			id.TxLogIndex, id.TxHash, len(receipt.Logs))))
+	}
+	blockHash := receipt.BlockHash
+	msg.BlockNumber = receipt.BlockNumber
+	msg.LogIndex = uint64(receipt.Logs[id.TxLogIndex].Index)
+	msg.BlockHash = &blockHash
+	return msg, nil
+}
+
+// fetchReceipt fetches the receipt of the transaction from the peer, nil if the transaction does not exist.
+func (b *backend) fetchReceipt(ctx context.Context, peer *peer, txHash common.Hash) (*types.Receipt, error) {
+	probe, err := peer.breaker.allow()
+	if err != nil {
+		return nil, fmt.Errorf("peer with chain id %s: %w", peer.chainId, err)
+	}
+	rpcCtx, cancel := context.WithTimeout(ctx, b.rpcTimeoutOf(peer.chainId))
+	defer cancel()
+	var receipt *types.Receipt
+	err = peer.client().CallContext(rpcCtx, &receipt, "eth_getTransactionReceipt", txHash)
+	peer.breaker.done(ctx, probe, err)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch receipt of transaction %s: %w", txHash, err)
+	}
+	return receipt, nil
+}
@@ -0,0 +1,83 @@
+package superchain
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/ethereum-optimism/optimism/op-service/client"
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+)
+
+func TestMessageSafetyByTx(t *testing.T) {
+	peer := newStubRPC()
+	txLog := func(origin common.Address, data []byte, txHash common.Hash, txIndex uint) types.Log {
+		log := testLog(origin, data)
+		log.TxHash, log.TxIndex = txHash, txIndex
+		return log
+	}
+	peer.addBlock(10, 100, txLog(common.Address{0xbb}, []byte{0x01}, common.Hash{0x0a}, 0),
+		txLog(common.Address{0xaa}, []byte{0x02}, common.Hash{0x0a}, 0), txLog(common.Address{0xaa}, []byte{0x03}, common.Hash{0x0b}, 1))
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	setHeads(b, chainId, &eth.L1BlockRef{Number: 10, Time: 100}, nil, nil)
+	_, first := testMessage(900, peer, 10, 1)
+	_, second := testMessage(900, peer, 10, 2)
+
+	// the log is located by its index among the logs of the transaction
+	label, err := b.MessageSafetyByTx(context.Background(), TxMessageIdentifier{Origin: common.Address{0xaa}, TxHash: common.Hash{0x0a},
+		TxLogIndex: 1, Timestamp: 100, ChainId: chainId.ToBig()}, first)
+	require.NoError(t, err)
+	require.Equal(t, Finalized, label)
+	id := TxMessageIdentifier{Origin: common.Address{0xaa}, TxHash: common.Hash{0x0b}, Timestamp: 100, ChainId: chainId.ToBig()}
+	label, err = b.MessageSafetyByTx(context.Background(), id, second)
+	require.NoError(t, err)
+	require.Equal(t, Finalized, label)
+
+	// the message is cross-checked against the log
+	label, err = b.MessageSafetyByTx(context.Background(), id, first)
+	require.ErrorIs(t, err, ErrIntegrityMismatch)
+	require.ErrorContains(t, err, "payload bytes mismatch")
+	require.Equal(t, Invalid, label)
+	other := id
+	other.Origin = common.Address{0xbb}
+	_, err = b.MessageSafetyByTx(context.Background(), other, second)
+	require.ErrorIs(t, err, ErrIntegrityMismatch)
+
+	outOfRange := id
+	outOfRange.TxLogIndex = 1
+	_, err = b.MessageSafetyByTx(context.Background(), outOfRange, second)
+	require.ErrorIs(t, err, ErrIntegrityMismatch)
+	require.ErrorContains(t, err, "no log with index 1 emitted by transaction "+common.Hash{0x0b}.Hex()+", of 1 logs")
+
+	unknown := id
+	unknown.TxHash = common.Hash{0x0c}
+	_, err = b.MessageSafetyByTx(context.Background(), unknown, second)
+	require.ErrorContains(t, err, "is not included in a block")
+
+	// the preconditions of the message are checked before the receipt is fetched
+	calls := peer.txReceiptCalls
+	notConfigured := id
+	notConfigured.ChainId = ChainIDFromUInt64(901).ToBig()
+	_, err = b.MessageSafetyByTx(context.Background(), notConfigured, second)
+	require.ErrorIs(t, err, ErrPeerNotConfigured)
+	_, err = b.MessageSafetyByTx(context.Background(), id, nil)
+	require.ErrorIs(t, err, ErrEmptyPayload)
+	b.maxPayloadSize = 1
+	label, err = b.MessageSafetyByTx(context.Background(), id, hexutil.Bytes{0x01, 0x02})
+	require.ErrorIs(t, err, ErrPayloadTooLarge)
+	require.Equal(t, Invalid, label)
+	b.maxPayloadSize = 0
+	require.Equal(t, calls, peer.txReceiptCalls)
+
+	// a receipt of a block that was reorged out does not validate the message
+	peer.logs[10][2].BlockHash = common.Hash{0xff}
+	_, err = b.MessageSafetyByTx(context.Background(), id, second)
+	require.ErrorContains(t, err, "block hash mismatch")
+}
@@ -0,0 +1,111 @@
+package superchain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// maxViaConns is the maximum number of pooled connections to the endpoints of MessageSafetyVia. Further endpoints
+// are dialed for every check.
+const maxViaConns = 16
+
+// viaConns pools the connections to the endpoints of MessageSafetyVia, by chain id and endpoint.
+type viaConns struct {
+	mu    sync.Mutex
+	conns map[viaKey]*peer
+}
+
+type viaKey struct {
+	chainId  ChainID
+	endpoint string
+}
+
+// MessageSafetyVia checks the message like MessageSafety, but fetches the block and logs of the message from the
+// endpoint instead of the configured peer of the chain, e.g. to compare the views of two nodes of the chain. The
+// endpoint must serve the chain of the message, and is dialed without the HTTP headers and TLS config of the peer.
+// The message is still labeled against the tracked heads of the chain. As the endpoint is not trusted like the
+// peer, the results and the blocks of the endpoint are neither served from nor stored in the caches.
+func (b *backend) MessageSafetyVia(ctx context.Context, id MessageIdentifier, payload hexutil.Bytes, endpoint string) (MessageSafetyLabel, error) {
+	if endpoint == "" {
+		return Invalid, errors.New("empty endpoint")
+	}
+	res, err := b.messageSafety(ctx, id, payload, checkOptions{via: endpoint})
+	return b.capLabel(id, res.Label), err
+}
+
+// fetchBlocksVia fetches the queried blocks of the peer chain from the endpoint, once, bypassing the caches and the
+// circuit breaker of the configured peer.
+func (b *backend) fetchBlocksVia(ctx context.Context, chainId ChainID, endpoint string, queries []blockQuery) ([]blockData, error) {
+	peer, release, err := b.viaPeer(ctx, chainId, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	blocks, err := b.fetchBlocksOnce(ctx, peer, queries)
+	if err != nil {
+		return nil, categorize(ErrFetchFailed, err)
+	}
+	for i := range blocks {
+		blocks[i].err = categorize(ErrFetchFailed, blocks[i].err)
+	}
+	return blocks, nil
+}
+
+// viaPeer returns the pooled connection to the endpoint, dialing the endpoint and checking it serves the chain if not
+// yet pooled. The release function must be called once the connection is no longer used, closing it if not pooled.
+// The endpoint is not part of the errors, as it may carry credentials.
+func (b *backend) viaPeer(ctx context.Context, chainId ChainID, endpoint string) (*peer, func(), error) {
+	key := viaKey{chainId: chainId, endpoint: endpoint}
+	b.via.mu.Lock()
+	pooled, ok := b.via.conns[key]
+	b.via.mu.Unlock()
+	if ok {
+		return pooled, func() {}, nil
+	}
+
+	dial := newPeerDialer(b.log, nil, nil)
+	rpc, err := dial(ctx, endpoint)
+	if err != nil {
+		return nil, nil, categorize(ErrFetchFailed, fmt.Errorf("failed to dial endpoint of chain id %s: %w", chainId, err))
+	}
+	if err := checkChainID(ctx, rpc, chainId, b.rpcTimeoutOf(chainId)); err != nil {
+		rpc.Close()
+		return nil, nil, categorize(ErrFetchFailed, fmt.Errorf("failed to verify endpoint of chain id %s: %w", chainId, err))
+	}
+	p := newPeer(chainId, []string{endpoint}, rpc, dial)
+
+	b.via.mu.Lock()
+	defer b.via.mu.Unlock()
+	// The pool is closed by Close after the backend is marked closed, a later connection is not pooled
+	if b.closed.Load() {
+		p.Close()
+		return nil, nil, ErrBackendClosed
+	}
+	if pooled, ok := b.via.conns[key]; ok {
+		// Dialed concurrently by another check
+		p.Close()
+		return pooled, func() {}, nil
+	}
+	if len(b.via.conns) >= maxViaConns {
+		return p, p.Close, nil
+	}
+	if b.via.conns == nil {
+		b.via.conns = make(map[viaKey]*peer)
+	}
+	b.via.conns[key] = p
+	return p, func() {}, nil
+}
+
+// close closes the pooled connections.
+func (v *viaConns) close() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for key, p := range v.conns {
+		p.Close()
+		delete(v.conns, key)
+	}
+}
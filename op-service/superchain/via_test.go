@@ -0,0 +1,74 @@
+package superchain
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/ethereum-optimism/optimism/op-service/client"
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+)
+
+func TestMessageSafetyVia(t *testing.T) {
+	// the configured peer and the alternate endpoint serve different logs of block 10
+	peer := newStubRPC()
+	peer.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x01}))
+	alt := newStubRPC()
+	alt.chainId = 900
+	alt.addBlock(10, 100, testLog(common.Address{0xaa}, []byte{0x02}))
+	endpoint := newStubServer(t, alt)
+
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: peer})
+	setHeads(b, chainId, &eth.L1BlockRef{Number: 10, Time: 100}, nil, nil)
+	peerMsg, peerPayload := testMessage(900, peer, 10, 0)
+	altMsg, altPayload := testMessage(900, alt, 10, 0)
+
+	label, err := b.MessageSafetyVia(context.Background(), altMsg, altPayload, endpoint)
+	require.NoError(t, err)
+	require.Equal(t, Finalized, label)
+	label, err = b.MessageSafetyVia(context.Background(), peerMsg, peerPayload, endpoint)
+	require.ErrorIs(t, err, ErrIntegrityMismatch)
+	require.Equal(t, Invalid, label)
+	require.Len(t, b.via.conns, 1, "the connection to the endpoint is pooled")
+
+	// the results of the endpoint are not cached, the peer keeps its own view
+	label, err = b.MessageSafety(context.Background(), altMsg, altPayload)
+	require.ErrorIs(t, err, ErrIntegrityMismatch)
+	require.Equal(t, Invalid, label)
+	label, err = b.MessageSafety(context.Background(), peerMsg, peerPayload)
+	require.NoError(t, err)
+	require.Equal(t, Finalized, label)
+	// nor are the cached results of the peer served for the endpoint
+	label, err = b.MessageSafetyVia(context.Background(), peerMsg, peerPayload, endpoint)
+	require.ErrorIs(t, err, ErrIntegrityMismatch)
+	require.Equal(t, Invalid, label)
+
+	t.Run("OtherChain", func(t *testing.T) {
+		other := newStubRPC()
+		other.chainId = 901
+		_, err := b.MessageSafetyVia(context.Background(), altMsg, altPayload, newStubServer(t, other))
+		require.ErrorIs(t, err, ErrFetchFailed)
+		require.ErrorContains(t, err, "failed to verify endpoint of chain id 900: chain id mismatch: node serves chain id 901")
+	})
+
+	t.Run("UnknownPeer", func(t *testing.T) {
+		id := altMsg
+		id.ChainId = ChainIDFromUInt64(901).ToBig()
+		_, err := b.MessageSafetyVia(context.Background(), id, altPayload, endpoint)
+		require.ErrorIs(t, err, ErrPeerNotConfigured)
+	})
+
+	t.Run("EmptyEndpoint", func(t *testing.T) {
+		_, err := b.MessageSafetyVia(context.Background(), altMsg, altPayload, "")
+		require.ErrorContains(t, err, "empty endpoint")
+	})
+
+	require.NoError(t, b.Close())
+	require.Empty(t, b.via.conns)
+	_, err = b.MessageSafetyVia(context.Background(), altMsg, altPayload, endpoint)
+	require.ErrorIs(t, err, ErrBackendClosed)
+}
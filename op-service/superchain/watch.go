@@ -0,0 +1,173 @@
+package superchain
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+)
+
+// defaultMaxFinalizedWatches is the default maximum number of pending finalized watches of a backend.
+const defaultMaxFinalizedWatches = 10_000
+
+// ErrTooManyWatches is returned when registering a finalized watch while the maximum number of watches is pending.
+var ErrTooManyWatches = errors.New("too many pending finalized watches")
+
+// finalizedWatch is a one-shot callback of a message, fired once the finalized head of the peer chain includes
+// the message.
+type finalizedWatch struct {
+	chainId ChainID
+	// timestamp of the message, or block number if finality is compared by block number
+	key uint64
+	fn  func(head eth.L1BlockRef)
+	// index of the watch in the heap of the chain, -1 once fired or cancelled
+	index int
+}
+
+// watchHeap is a min-heap of the pending watches of a peer chain, by key.
+type watchHeap []*finalizedWatch
+
+func (h watchHeap) Len() int           { return len(h) }
+func (h watchHeap) Less(i, j int) bool { return h[i].key < h[j].key }
+func (h watchHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *watchHeap) Push(x any) {
+	watch := x.(*finalizedWatch)
+	watch.index = len(*h)
+	*h = append(*h, watch)
+}
+
+func (h *watchHeap) Pop() any {
+	old := *h
+	watch := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	watch.index = -1
+	return watch
+}
+
+// finalizedWatches are the pending watches of all the peer chains, bounded in number.
+type finalizedWatches struct {
+	mu    sync.Mutex
+	max   int
+	count int
+	heaps map[ChainID]*watchHeap
+}
+
+func (w *finalizedWatches) add(watch *finalizedWatch) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.count >= w.max {
+		return fmt.Errorf("%w: %d", ErrTooManyWatches, w.max)
+	}
+	if w.heaps == nil {
+		w.heaps = make(map[ChainID]*watchHeap)
+	}
+	h, ok := w.heaps[watch.chainId]
+	if !ok {
+		h = &watchHeap{}
+		w.heaps[watch.chainId] = h
+	}
+	heap.Push(h, watch)
+	w.count++
+	return nil
+}
+
+// remove removes the watch, and returns false if it was already fired or removed.
+func (w *finalizedWatches) remove(watch *finalizedWatch) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if watch.index < 0 {
+		return false
+	}
+	heap.Remove(w.heaps[watch.chainId], watch.index)
+	w.count--
+	return true
+}
+
+// drain removes and returns all the watches of the peer chain with a key up to the given key, in order.
+func (w *finalizedWatches) drain(chainId ChainID, key uint64) []*finalizedWatch {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	h, ok := w.heaps[chainId]
+	if !ok {
+		return nil
+	}
+	var drained []*finalizedWatch
+	for h.Len() > 0 && (*h)[0].key <= key {
+		drained = append(drained, heap.Pop(h).(*finalizedWatch))
+	}
+	w.count -= len(drained)
+	return drained
+}
+
+// clear drops all the pending watches, without firing them.
+func (w *finalizedWatches) clear() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, h := range w.heaps {
+		for _, watch := range *h {
+			watch.index = -1
+		}
+	}
+	w.heaps = nil
+	w.count = 0
+}
+
+// WatchFinalized registers a one-shot callback, fired with the finalized head of the peer chain once the finalized
+// head includes the message, i.e. once a message checked Unsafe or Safe would be checked Finalized. Only the
+// finality of the message is watched: the integrity of the message must have been checked before. The callback is
+// fired immediately if the tracked finalized head already includes the message, and is otherwise fired by the
+// update of the finalized head, so it must not block. Pending watches are dropped when the backend is closed.
+// The returned function cancels the watch, if not fired yet.
+func (b *backend) WatchFinalized(id MessageIdentifier, fn func(head eth.L1BlockRef)) (func(), error) {
+	if b.closed.Load() {
+		return nil, ErrBackendClosed
+	}
+	chainId, ok := ChainIDFromBig(id.ChainId)
+	if !ok {
+		return nil, fmt.Errorf("invalid chain id %v", id.ChainId)
+	}
+	if _, ok := b.l2PeerNodes[chainId]; !ok {
+		return nil, &PeerNotConfiguredError{ChainID: chainId}
+	}
+	key := id.Timestamp
+	if b.finalityByBlockNumber {
+		if id.BlockNumber == nil || !id.BlockNumber.IsUint64() {
+			return nil, fmt.Errorf("invalid block number %v", id.BlockNumber)
+		}
+		key = id.BlockNumber.Uint64()
+	}
+	watch := &finalizedWatch{chainId: chainId, key: key, fn: fn}
+	// Registered before the tracked finalized head is checked, not to miss a concurrent update of the head
+	if err := b.finalizedWatches.add(watch); err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	var finalized *eth.L1BlockRef
+	if heads, ok := b.l2Heads[chainId]; ok && b.includes(heads.finalized, key, key) {
+		finalized = copyRef(heads.finalized)
+	}
+	b.mu.Unlock()
+	if finalized != nil && b.finalizedWatches.remove(watch) {
+		fn(*finalized)
+	}
+	return func() { b.finalizedWatches.remove(watch) }, nil
+}
+
+// fireFinalizedWatches fires the watches of the peer chain of the messages included by the finalized head.
+func (b *backend) fireFinalizedWatches(chainId ChainID, head eth.L1BlockRef) {
+	key := head.Time
+	if b.finalityByBlockNumber {
+		key = head.Number
+	}
+	for _, watch := range b.finalizedWatches.drain(chainId, key) {
+		watch.fn(head)
+	}
+}
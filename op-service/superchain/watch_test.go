@@ -0,0 +1,97 @@
+package superchain
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/ethereum-optimism/optimism/op-service/client"
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+)
+
+func TestWatchFinalized(t *testing.T) {
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: newStubRPC()})
+	b.onFinalizedHead(context.Background(), chainId, eth.L1BlockRef{Hash: common.Hash{0x0a}, Number: 10, Time: 100})
+
+	var fired []uint64
+	watch := func(timestamp uint64) func() {
+		id := MessageIdentifier{ChainId: big.NewInt(900), BlockNumber: big.NewInt(0), Timestamp: timestamp}
+		cancel, err := b.WatchFinalized(id, func(head eth.L1BlockRef) {
+			require.GreaterOrEqual(t, head.Time, timestamp)
+			fired = append(fired, timestamp)
+		})
+		require.NoError(t, err)
+		return cancel
+	}
+
+	// a message included by the tracked finalized head fires immediately
+	watch(100)
+	require.Equal(t, []uint64{100}, fired)
+
+	watch(106)
+	watch(102)
+	cancel := watch(104)
+	watch(110)
+	cancel()
+	require.Equal(t, 3, b.finalizedWatches.count)
+
+	// the watches fire in order of timestamp, once each
+	b.onFinalizedHead(context.Background(), chainId, eth.L1BlockRef{Hash: common.Hash{0x0d}, Number: 13, Time: 106})
+	require.Equal(t, []uint64{100, 102, 106}, fired)
+	b.onFinalizedHead(context.Background(), chainId, eth.L1BlockRef{Hash: common.Hash{0x0e}, Number: 14, Time: 108})
+	require.Equal(t, []uint64{100, 102, 106}, fired)
+	require.Equal(t, 1, b.finalizedWatches.count)
+
+	// closing the backend drops the pending watches
+	require.NoError(t, b.Close())
+	require.Zero(t, b.finalizedWatches.count)
+	_, err := b.WatchFinalized(MessageIdentifier{ChainId: big.NewInt(900), Timestamp: 110}, func(eth.L1BlockRef) {})
+	require.ErrorIs(t, err, ErrBackendClosed)
+}
+
+func TestWatchFinalizedLimit(t *testing.T) {
+	chainA, chainB := ChainIDFromUInt64(900), ChainIDFromUInt64(901)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainA: newStubRPC(), chainB: newStubRPC()})
+	b.finalizedWatches.max = 2
+
+	var cancels []func()
+	for _, chainId := range []int64{900, 901} {
+		cancel, err := b.WatchFinalized(MessageIdentifier{ChainId: big.NewInt(chainId), Timestamp: 100}, func(eth.L1BlockRef) {})
+		require.NoError(t, err)
+		cancels = append(cancels, cancel)
+	}
+	_, err := b.WatchFinalized(MessageIdentifier{ChainId: big.NewInt(900), Timestamp: 100}, func(eth.L1BlockRef) {})
+	require.ErrorIs(t, err, ErrTooManyWatches)
+
+	// a cancelled watch frees its slot, and is only freed once
+	cancels[0]()
+	cancels[0]()
+	_, err = b.WatchFinalized(MessageIdentifier{ChainId: big.NewInt(900), Timestamp: 100}, func(eth.L1BlockRef) {})
+	require.NoError(t, err)
+	_, err = b.WatchFinalized(MessageIdentifier{ChainId: big.NewInt(900), Timestamp: 100}, func(eth.L1BlockRef) {})
+	require.ErrorIs(t, err, ErrTooManyWatches)
+
+	_, err = b.WatchFinalized(MessageIdentifier{ChainId: big.NewInt(902), Timestamp: 100}, func(eth.L1BlockRef) {})
+	require.ErrorContains(t, err, "peer with chain id 902 is not configured")
+}
+
+func TestWatchFinalizedByBlockNumber(t *testing.T) {
+	chainId := ChainIDFromUInt64(900)
+	b := newTestBackend(t, map[ChainID]client.RPC{chainId: newStubRPC()})
+	b.finalityByBlockNumber = true
+
+	fired := false
+	_, err := b.WatchFinalized(MessageIdentifier{ChainId: big.NewInt(900), BlockNumber: big.NewInt(11), Timestamp: 100}, func(eth.L1BlockRef) {
+		fired = true
+	})
+	require.NoError(t, err)
+	b.onFinalizedHead(context.Background(), chainId, eth.L1BlockRef{Hash: common.Hash{0x0a}, Number: 10, Time: 200})
+	require.False(t, fired)
+	b.onFinalizedHead(context.Background(), chainId, eth.L1BlockRef{Hash: common.Hash{0x0b}, Number: 11, Time: 202})
+	require.True(t, fired)
+}